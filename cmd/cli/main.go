@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"charm.land/lipgloss/v2"
 	"go.aykhans.me/sarin/internal/config"
@@ -14,6 +15,15 @@ import (
 	utilsErr "go.aykhans.me/utils/errors"
 )
 
+const (
+	// exitCodeThresholdsFailed is the exit code of a run that completed but
+	// failed at least one threshold, so CI jobs can tell it apart from a
+	// broken run.
+	exitCodeThresholdsFailed = 2
+	// exitCodeAborted is the exit code of a run stopped by its abort policy.
+	exitCodeAborted = 3
+)
+
 func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	stopCtrl := sarin.NewStopController(cancel)
@@ -47,16 +57,128 @@ func main() {
 		}),
 	)
 
-	srn, err := sarin.NewSarin(
-		ctx,
-		combinedConfig.Methods, combinedConfig.URL, *combinedConfig.Timeout,
-		*combinedConfig.Concurrency, combinedConfig.Requests, combinedConfig.Duration,
-		*combinedConfig.Progress == config.ConfigProgressTypeBar, *combinedConfig.Insecure, combinedConfig.Params, combinedConfig.Headers,
-		combinedConfig.Cookies, combinedConfig.Bodies, combinedConfig.Proxies, combinedConfig.Values,
-		*combinedConfig.Output != config.ConfigOutputTypeNone,
-		*combinedConfig.DryRun, *combinedConfig.LogLevel, *combinedConfig.LogFile,
-		combinedConfig.Lua, combinedConfig.Js,
-	)
+	var timelineInterval time.Duration
+	if combinedConfig.TimelineInterval != nil {
+		timelineInterval = *combinedConfig.TimelineInterval
+	}
+	var abortWindow time.Duration
+	if combinedConfig.AbortWindow != nil {
+		abortWindow = *combinedConfig.AbortWindow
+	}
+	var abortErrors uint
+	if combinedConfig.AbortErrors != nil {
+		abortErrors = *combinedConfig.AbortErrors
+	}
+	var cookieJarReset uint
+	if combinedConfig.CookieJarReset != nil {
+		cookieJarReset = *combinedConfig.CookieJarReset
+	}
+	var maxConcurrency uint
+	if combinedConfig.MaxConcurrency != nil {
+		maxConcurrency = *combinedConfig.MaxConcurrency
+	}
+	var sendInterval time.Duration
+	if combinedConfig.SendInterval != nil {
+		sendInterval = *combinedConfig.SendInterval
+	}
+	var h2MaxStreams, h2Connections uint
+	if combinedConfig.H2MaxStreams != nil {
+		h2MaxStreams = *combinedConfig.H2MaxStreams
+	}
+	if combinedConfig.H2Connections != nil {
+		h2Connections = *combinedConfig.H2Connections
+	}
+	var grpcMethod string
+	if combinedConfig.GRPCMethod != nil {
+		grpcMethod = *combinedConfig.GRPCMethod
+	}
+	var streamHold time.Duration
+	if combinedConfig.StreamHold != nil {
+		streamHold = *combinedConfig.StreamHold
+	}
+	var tlsRotation sarin.TLSRotation
+	if combinedConfig.TLSRotate != nil {
+		tlsRotation = sarin.ParseTLSRotation(string(*combinedConfig.TLSRotate))
+	}
+	var tlsMinVersion, tlsMaxVersion, tlsServerName string
+	if combinedConfig.TLSMinVersion != nil {
+		tlsMinVersion = *combinedConfig.TLSMinVersion
+	}
+	if combinedConfig.TLSMaxVersion != nil {
+		tlsMaxVersion = *combinedConfig.TLSMaxVersion
+	}
+	if combinedConfig.TLSServerName != nil {
+		tlsServerName = *combinedConfig.TLSServerName
+	}
+	var dnsServer string
+	if combinedConfig.DNSServer != nil {
+		dnsServer = *combinedConfig.DNSServer
+	}
+
+	srn, err := sarin.NewSarin(ctx, sarin.Options{
+		Methods:         combinedConfig.Methods,
+		URL:             combinedConfig.URL,
+		Timeout:         *combinedConfig.Timeout,
+		Workers:         *combinedConfig.Concurrency,
+		Requests:        combinedConfig.Requests,
+		Duration:        combinedConfig.Duration,
+		Rate:            combinedConfig.Rate,
+		RateOverflow:    sarin.ParseRateOverflowPolicy(string(*combinedConfig.RateOverflow)),
+		MaxWorkers:      maxConcurrency,
+		Stages:          combinedConfig.Stages,
+		SendInterval:    sendInterval,
+		ShowProgress:    *combinedConfig.Progress == config.ConfigProgressTypeBar,
+		SkipCertVerify:  *combinedConfig.Insecure,
+		TLSCAFiles:      combinedConfig.TLSCA,
+		TLSClientCerts:  combinedConfig.TLSCerts,
+		TLSRotation:     tlsRotation,
+		TLSMinVersion:   tlsMinVersion,
+		TLSMaxVersion:   tlsMaxVersion,
+		TLSCipherSuites: combinedConfig.TLSCiphers,
+		TLSCurves:       combinedConfig.TLSCurves,
+		TLSServerName:   tlsServerName,
+		TLSALPN:         combinedConfig.TLSALPN,
+		TLSSessionReuse: *combinedConfig.TLSSessionReuse,
+		Protocol:        sarin.ParseProtocol(string(*combinedConfig.Protocol)),
+		H2MaxStreams:    h2MaxStreams,
+		H2Connections:   h2Connections,
+		WSMatch:         combinedConfig.WSMatch,
+		GRPCMethod:      grpcMethod,
+		GRPCProto:       combinedConfig.GRPCProto,
+		GRPCImportPaths: combinedConfig.GRPCImportPath,
+		Stream:          *combinedConfig.Stream,
+		StreamHold:      streamHold,
+		Params:          combinedConfig.Params,
+		Headers:         combinedConfig.Headers,
+		Cookies:         combinedConfig.Cookies,
+		Bodies:          combinedConfig.Bodies,
+		Scenarios:       combinedConfig.Scenarios,
+		Flow:            combinedConfig.Flow,
+		CookieJar:       *combinedConfig.CookieJar,
+		CookieJarReset:  cookieJarReset,
+		Proxies:         combinedConfig.Proxies,
+		Resolve:         combinedConfig.Resolve,
+		DNSServer:       dnsServer,
+		DNSCache:        *combinedConfig.DNSCache,
+		IPStrategy:      sarin.ParseIPStrategy(string(*combinedConfig.IPStrategy)),
+		LocalAddresses:  combinedConfig.LocalAddresses,
+		Values:          combinedConfig.Values,
+		CollectStats: *combinedConfig.Output != config.ConfigOutputTypeNone || *combinedConfig.TimelineFile != "" ||
+			len(combinedConfig.Thresholds) > 0 || len(combinedConfig.AbortOn) > 0,
+		Percentiles:      combinedConfig.Percentiles,
+		RemoteIPStats:    *combinedConfig.RemoteIPStats,
+		TimelineInterval: timelineInterval,
+		Thresholds:       combinedConfig.Thresholds,
+		Assertions:       combinedConfig.Assertions,
+		AbortOn:          combinedConfig.AbortOn,
+		AbortWindow:      abortWindow,
+		AbortErrors:      abortErrors,
+		DryRun:           *combinedConfig.DryRun,
+		LogLevel:         *combinedConfig.LogLevel,
+		LogFile:          *combinedConfig.LogFile,
+		LuaScripts:       combinedConfig.Lua,
+		JSScripts:        combinedConfig.Js,
+	})
 	_ = utilsErr.MustHandle(err,
 		utilsErr.OnType(func(err types.ProxyDialError) error {
 			fmt.Fprint(os.Stderr, lipgloss.Sprintln(config.StyleRed.Render("[PROXY] ")+err.Error()))
@@ -73,13 +195,22 @@ func main() {
 			os.Exit(1)
 			return nil
 		}),
+		utilsErr.OnType(func(err types.TLSLoadError) error {
+			fmt.Fprint(os.Stderr, lipgloss.Sprintln(config.StyleRed.Render("[TLS] ")+err.Error()))
+			os.Exit(1)
+			return nil
+		}),
+		utilsErr.OnType(func(err types.GRPCMethodResolveError) error {
+			fmt.Fprint(os.Stderr, lipgloss.Sprintln(config.StyleRed.Render("[GRPC] ")+err.Error()))
+			os.Exit(1)
+			return nil
+		}),
 	)
 
 	srn.Start(ctx, stopCtrl)
 
 	switch *combinedConfig.Output {
 	case config.ConfigOutputTypeNone:
-		return
 	case config.ConfigOutputTypeJSON:
 		srn.GetResponses().PrintJSON()
 	case config.ConfigOutputTypeYAML:
@@ -87,6 +218,28 @@ func main() {
 	default:
 		srn.GetResponses().PrintTable()
 	}
+
+	if *combinedConfig.TimelineFile != "" {
+		_ = utilsErr.MustHandle(srn.GetResponses().WriteTimeline(*combinedConfig.TimelineFile),
+			utilsErr.OnType(func(err types.FileWriteError) error {
+				fmt.Fprint(os.Stderr, lipgloss.Sprintln(config.StyleRed.Render("[TIMELINE] ")+err.Error()))
+				os.Exit(1)
+				return nil
+			}),
+		)
+	}
+
+	if reason := srn.AbortReason(); reason != "" {
+		fmt.Fprint(os.Stderr, lipgloss.Sprintln(config.StyleRed.Render("[ABORTED] ")+reason))
+		os.Exit(exitCodeAborted)
+	}
+
+	if failed := srn.GetResponses().FailedThresholds(); failed > 0 {
+		fmt.Fprint(os.Stderr, lipgloss.Sprintln(
+			config.StyleRed.Render("[THRESHOLDS] ")+fmt.Sprintf("%d of %d thresholds failed", failed, len(combinedConfig.Thresholds)),
+		))
+		os.Exit(exitCodeThresholdsFailed)
+	}
 }
 
 func listenForTermination(stop func()) {
@@ -3,8 +3,8 @@ package config
 import (
 	"flag"
 	"fmt"
-	"net/url"
 	"os"
+	"regexp"
 	"strings"
 	"time"
 
@@ -20,32 +20,71 @@ Simple usage:
 
 Flags:
   General Config:
-    -h, -help                     Help for sarin
-    -v, -version                  Version for sarin
-    -s, -show-config   bool       Show the final config after parsing all sources (default %v)
-    -f, -config-file   string     Path to the config file (local file / http URL)
-    -c, -concurrency   uint       Number of concurrent requests (default %d)
-    -r, -requests      uint       Number of total requests
-    -d, -duration      time       Maximum duration for the test (e.g. 30s, 1m, 5h)
-    -l, -log-level     string     Runtime log levels to emit, comma-separated (possible values: info, error) (default %s)
-    -w, -log-file      string     Write runtime logs to this file instead of the terminal/stderr
-    -p, -progress      string     Progress display (possible values: bar, none) (default '%v')
-    -o, -output        string     Output format (possible values: table, json, yaml, none) (default '%v')
-    -z, -dry-run       bool       Run without sending requests (default %v)
+    -h, -help                         Help for sarin
+    -v, -version                      Version for sarin
+    -s, -show-config       bool       Show the final config after parsing all sources (default %v)
+    -f, -config-file       string     Path to the config file (local file / http URL)
+    -c, -concurrency       uint       Number of concurrent requests (default %d)
+    -r, -requests          uint       Number of total requests
+    -d, -duration          time       Maximum duration for the test (e.g. 30s, 1m, 5h)
+    -R, -rate              uint       Target request rate per second (constant arrival rate)
+        -rate-overflow     string     What to do when no worker is free at a scheduled send (possible values: queue, drop, spawn) (default '%v')
+        -max-concurrency   uint       Most workers -rate-overflow spawn can grow the pool to (default %d with -rate-overflow spawn)
+        -send-interval     time       Minimum time between the requests of each worker (e.g. 500ms, 1s)
+    -l, -log-level         string     Runtime log levels to emit, comma-separated (possible values: info, error) (default %s)
+    -w, -log-file          string     Write runtime logs to this file instead of the terminal/stderr
+    -p, -progress          string     Progress display (possible values: bar, none) (default '%v')
+    -o, -output            string     Output format (possible values: table, json, yaml, none) (default '%v')
+        -percentiles       string     Latency percentiles to report, comma-separated (e.g. 50,99.9) (default %s)
+        -remote-ip-stats   bool       Break the responses down by the IP address they came from (default %v)
+        -timeline-interval time       Width of each timeline window (e.g. 1s, 10s) (default %v with -timeline-file)
+        -timeline-file     string     Write the timeline to this file (.csv, .ndjson or .jsonl)
+        -threshold         []string   Pass/fail condition on the results, exits with code 2 if it fails (e.g. "p95 < 300ms", "error_rate < 1%%")
+        -abort-on          []string   Stop the run with exit code 3 when this holds over the abort window (e.g. "error_rate > 50%%", "p99 > 2s")
+        -abort-window      time       Sliding window the abort conditions are checked over (default %v with -abort-on)
+        -abort-errors      uint       Stop the run with exit code 3 after this many requests in a row get no response
+    -z, -dry-run           bool       Run without sending requests (default %v)
 
   Request Config:
-    -U, -url           string     Target URL for the request
-    -M, -method        []string   HTTP method for the request (default %s)
-    -B, -body          []string   Body for the request (e.g. "body text")
-    -P, -param         []string   URL parameter for the request (e.g. "key1=value1")
-    -H, -header        []string   Header for the request (e.g. "key1: value1")
-    -C, -cookie        []string   Cookie for the request (e.g. "key1=value1")
-    -X, -proxy         []string   Proxy for the request (e.g. "http://proxy.example.com:8080")
-    -V, -values        []string   List of values for templating (e.g. "key1=value1")
-    -T, -timeout       time       Timeout for the request (e.g. 400ms, 3s, 1m10s) (default %v)
-    -I, -insecure      bool       Skip SSL/TLS certificate verification (default %v)
-        -lua           []string   Lua script for request transformation (inline or @file/@url)
-        -js            []string   JavaScript script for request transformation (inline or @file/@url)`
+    -U, -url               string     Target URL for the request
+    -M, -method            []string   HTTP method for the request (default %s)
+    -B, -body              []string   Body for the request (e.g. "body text")
+    -P, -param             []string   URL parameter for the request (e.g. "key1=value1")
+    -H, -header            []string   Header for the request (e.g. "key1: value1")
+    -C, -cookie            []string   Cookie for the request (e.g. "key1=value1")
+        -cookie-jar        bool       Keep the cookies each worker receives and send them back (default %v)
+        -cookie-jar-reset  uint       Empty each worker's cookie jar every this many iterations
+    -X, -proxy             []string   Proxy for the request (e.g. "http://proxy.example.com:8080")
+        -resolve           []string   Addresses to connect to for a host and port instead of looking them up (e.g. "example.com:443:127.0.0.1")
+        -dns-server        string     DNS server to look host names up with instead of the system resolver (e.g. "1.1.1.1", "10.0.0.2:5353")
+        -dns-cache         bool       Look each host name up once per run instead of on every new connection (default %v)
+        -ip-strategy       string     Which address of a host new connections go to (possible values: first, round-robin, random, worker) (default '%v')
+        -local-address     []string   Local address, CIDR range or network interface to open connections from, in turn (e.g. "10.0.0.5", "10.0.0.0/28", "eth1")
+    -V, -values            []string   List of values for templating (e.g. "key1=value1")
+    -T, -timeout           time       Timeout for the request (e.g. 400ms, 3s, 1m10s) (default %v)
+    -I, -insecure          bool       Skip SSL/TLS certificate verification (default %v)
+        -tls-cert          []string   Client certificate for mutual TLS, paired with the -tls-key at the same position (local file / http URL)
+        -tls-key           []string   Private key of the client certificate at the same position (local file / http URL)
+        -tls-ca            []string   CA bundle to verify the server certificate with instead of the system CAs (local file / http URL)
+        -tls-rotate        string     How workers take turns with the client certificates (possible values: worker, request) (default '%v' with -tls-cert)
+        -tls-min-version   string     Minimum TLS version to negotiate (possible values: 1.0, 1.1, 1.2, 1.3)
+        -tls-max-version   string     Maximum TLS version to negotiate (possible values: 1.0, 1.1, 1.2, 1.3)
+        -tls-cipher        []string   Cipher suite to offer for TLS 1.0-1.2, by IANA name (e.g. "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256")
+        -tls-curve         []string   Key exchange to offer, in order of preference (e.g. X25519, P-256)
+        -tls-server-name   string     Server name to send in SNI and verify the certificate against, instead of the URL host
+        -tls-alpn          []string   Application protocol to offer in ALPN, in order of preference (possible values: http/1.1, http/1.0)
+        -tls-session-reuse bool       Resume TLS sessions with session tickets on new connections (default %v)
+        -protocol          string     HTTP version to send requests with (possible values: h1, h2, h3) (default '%v')
+        -h2-max-streams    uint       Maximum requests in flight on each HTTP/2 connection (default %d with -protocol h2)
+        -h2-connections    uint       Number of HTTP/2 connections to each host (default %d with -protocol h2)
+        -ws-match          string     Regex that picks the answer to each WebSocket message (a capture group correlates them by ID)
+        -grpc-method       string     gRPC method to call with the JSON bodies (e.g. "package.Service/Method")
+        -grpc-proto        []string   Proto file that defines the gRPC method (server reflection is used without one)
+        -grpc-import-path  []string   Directory to look up proto files and their imports in
+        -stream            bool       Read each response body as a stream of events and measure them (default %v)
+        -stream-hold       time       How long to hold each stream open before ending it (default the timeout with -stream)
+        -lua               []string   Lua script for request transformation (inline or @file/@url)
+        -js                []string   JavaScript script for request transformation (inline or @file/@url)`
 
 var _ IParser = ConfigCLIParser{}
 
@@ -84,31 +123,70 @@ func (parser ConfigCLIParser) Parse() (*Config, error) {
 		config = &Config{}
 
 		// General config
-		version      bool
-		showConfig   bool
-		configFiles  = stringSliceArg{}
-		concurrency  uint
-		requestCount uint64
-		duration     time.Duration
-		logLevel     string
-		logFile      string
-		progress     string
-		output       string
-		dryRun       bool
+		version          bool
+		showConfig       bool
+		configFiles      = stringSliceArg{}
+		concurrency      uint
+		requestCount     uint64
+		duration         time.Duration
+		rate             uint
+		rateOverflow     string
+		maxConcurrency   uint
+		sendInterval     time.Duration
+		logLevel         string
+		logFile          string
+		progress         string
+		output           string
+		percentiles      string
+		remoteIPStats    bool
+		timelineInterval time.Duration
+		timelineFile     string
+		thresholds       = stringSliceArg{}
+		abortOn          = stringSliceArg{}
+		abortWindow      time.Duration
+		abortErrors      uint
+		dryRun           bool
 
 		// Request config
-		urlInput   string
-		methods    = stringSliceArg{}
-		bodies     = stringSliceArg{}
-		params     = stringSliceArg{}
-		headers    = stringSliceArg{}
-		cookies    = stringSliceArg{}
-		proxies    = stringSliceArg{}
-		values     = stringSliceArg{}
-		timeout    time.Duration
-		insecure   bool
-		luaScripts = stringSliceArg{}
-		jsScripts  = stringSliceArg{}
+		urlInput       string
+		methods        = stringSliceArg{}
+		bodies         = stringSliceArg{}
+		params         = stringSliceArg{}
+		headers        = stringSliceArg{}
+		cookies        = stringSliceArg{}
+		cookieJar      bool
+		cookieJarReset uint
+		proxies        = stringSliceArg{}
+		resolve        = stringSliceArg{}
+		dnsServer      string
+		dnsCache       bool
+		ipStrategy     string
+		localAddress   = stringSliceArg{}
+		values         = stringSliceArg{}
+		timeout        time.Duration
+		insecure       bool
+		tlsCerts       = stringSliceArg{}
+		tlsKeys        = stringSliceArg{}
+		tlsCA          = stringSliceArg{}
+		tlsRotate      string
+		tlsMinVersion  string
+		tlsMaxVersion  string
+		tlsCiphers     = stringSliceArg{}
+		tlsCurves      = stringSliceArg{}
+		tlsServerName  string
+		tlsALPN        = stringSliceArg{}
+		sessionReuse   bool
+		protocol       string
+		h2MaxStreams   uint
+		h2Connections  uint
+		wsMatch        string
+		grpcMethod     string
+		grpcProto      = stringSliceArg{}
+		grpcImportPath = stringSliceArg{}
+		stream         bool
+		streamHold     time.Duration
+		luaScripts     = stringSliceArg{}
+		jsScripts      = stringSliceArg{}
 	)
 
 	{
@@ -131,6 +209,15 @@ func (parser ConfigCLIParser) Parse() (*Config, error) {
 		flagSet.DurationVar(&duration, "duration", 0, "Maximum duration for the test")
 		flagSet.DurationVar(&duration, "d", 0, "Maximum duration for the test")
 
+		flagSet.UintVar(&rate, "rate", 0, "Target request rate per second")
+		flagSet.UintVar(&rate, "R", 0, "Target request rate per second")
+
+		flagSet.StringVar(&rateOverflow, "rate-overflow", "", "What to do when no worker is free at a scheduled send (possible values: queue, drop, spawn)")
+
+		flagSet.UintVar(&maxConcurrency, "max-concurrency", 0, "Most workers -rate-overflow spawn can grow the pool to")
+
+		flagSet.DurationVar(&sendInterval, "send-interval", 0, "Minimum time between the requests of each worker")
+
 		flagSet.StringVar(&logLevel, "log-level", "", "Runtime log levels to emit, comma-separated (possible values: info, error)")
 		flagSet.StringVar(&logLevel, "l", "", "Runtime log levels to emit, comma-separated (possible values: info, error)")
 
@@ -143,6 +230,22 @@ func (parser ConfigCLIParser) Parse() (*Config, error) {
 		flagSet.StringVar(&output, "output", "", "Output format (possible values: table, json, yaml, none)")
 		flagSet.StringVar(&output, "o", "", "Output format (possible values: table, json, yaml, none)")
 
+		flagSet.StringVar(&percentiles, "percentiles", "", "Latency percentiles to report, comma-separated (e.g. 50,99.9)")
+
+		flagSet.BoolVar(&remoteIPStats, "remote-ip-stats", false, "Break the responses down by the IP address they came from")
+
+		flagSet.DurationVar(&timelineInterval, "timeline-interval", 0, "Width of each timeline window")
+
+		flagSet.StringVar(&timelineFile, "timeline-file", "", "Write the timeline to this file (.csv, .ndjson or .jsonl)")
+
+		flagSet.Var(&thresholds, "threshold", "Pass/fail condition on the results")
+
+		flagSet.Var(&abortOn, "abort-on", "Stop the run when this holds over the abort window")
+
+		flagSet.DurationVar(&abortWindow, "abort-window", 0, "Sliding window the abort conditions are checked over")
+
+		flagSet.UintVar(&abortErrors, "abort-errors", 0, "Stop the run after this many requests in a row get no response")
+
 		flagSet.BoolVar(&dryRun, "dry-run", false, "Run without sending requests")
 		flagSet.BoolVar(&dryRun, "z", false, "Run without sending requests")
 
@@ -165,9 +268,23 @@ func (parser ConfigCLIParser) Parse() (*Config, error) {
 		flagSet.Var(&cookies, "cookie", "Cookie for the request")
 		flagSet.Var(&cookies, "C", "Cookie for the request")
 
+		flagSet.BoolVar(&cookieJar, "cookie-jar", false, "Keep the cookies each worker receives and send them back")
+
+		flagSet.UintVar(&cookieJarReset, "cookie-jar-reset", 0, "Empty each worker's cookie jar every this many iterations")
+
 		flagSet.Var(&proxies, "proxy", "Proxy for the request")
 		flagSet.Var(&proxies, "X", "Proxy for the request")
 
+		flagSet.Var(&resolve, "resolve", "Addresses to connect to for a host and port instead of looking them up")
+
+		flagSet.StringVar(&dnsServer, "dns-server", "", "DNS server to look host names up with instead of the system resolver")
+
+		flagSet.BoolVar(&dnsCache, "dns-cache", false, "Look each host name up once per run instead of on every new connection")
+
+		flagSet.StringVar(&ipStrategy, "ip-strategy", "", "Which address of a host new connections go to (possible values: first, round-robin, random, worker)")
+
+		flagSet.Var(&localAddress, "local-address", "Local address, CIDR range or network interface to open connections from, in turn")
+
 		flagSet.Var(&values, "values", "List of values for templating")
 		flagSet.Var(&values, "V", "List of values for templating")
 
@@ -177,6 +294,46 @@ func (parser ConfigCLIParser) Parse() (*Config, error) {
 		flagSet.BoolVar(&insecure, "insecure", false, "Skip SSL/TLS certificate verification")
 		flagSet.BoolVar(&insecure, "I", false, "Skip SSL/TLS certificate verification")
 
+		flagSet.Var(&tlsCerts, "tls-cert", "Client certificate for mutual TLS")
+
+		flagSet.Var(&tlsKeys, "tls-key", "Private key of the client certificate at the same position")
+
+		flagSet.Var(&tlsCA, "tls-ca", "CA bundle to verify the server certificate with")
+
+		flagSet.StringVar(&tlsRotate, "tls-rotate", "", "How workers take turns with the client certificates (possible values: worker, request)")
+
+		flagSet.StringVar(&tlsMinVersion, "tls-min-version", "", "Minimum TLS version to negotiate (possible values: 1.0, 1.1, 1.2, 1.3)")
+
+		flagSet.StringVar(&tlsMaxVersion, "tls-max-version", "", "Maximum TLS version to negotiate (possible values: 1.0, 1.1, 1.2, 1.3)")
+
+		flagSet.Var(&tlsCiphers, "tls-cipher", "Cipher suite to offer for TLS 1.0-1.2, by IANA name")
+
+		flagSet.Var(&tlsCurves, "tls-curve", "Key exchange to offer, in order of preference")
+
+		flagSet.StringVar(&tlsServerName, "tls-server-name", "", "Server name to send in SNI and verify the certificate against")
+
+		flagSet.Var(&tlsALPN, "tls-alpn", "Application protocol to offer in ALPN, in order of preference")
+
+		flagSet.BoolVar(&sessionReuse, "tls-session-reuse", false, "Resume TLS sessions with session tickets on new connections")
+
+		flagSet.StringVar(&protocol, "protocol", "", "HTTP version to send requests with (possible values: h1, h2, h3)")
+
+		flagSet.UintVar(&h2MaxStreams, "h2-max-streams", 0, "Maximum requests in flight on each HTTP/2 connection")
+
+		flagSet.UintVar(&h2Connections, "h2-connections", 0, "Number of HTTP/2 connections to each host")
+
+		flagSet.StringVar(&wsMatch, "ws-match", "", "Regex that picks the answer to each WebSocket message")
+
+		flagSet.StringVar(&grpcMethod, "grpc-method", "", "gRPC method to call with the JSON bodies")
+
+		flagSet.Var(&grpcProto, "grpc-proto", "Proto file that defines the gRPC method")
+
+		flagSet.Var(&grpcImportPath, "grpc-import-path", "Directory to look up proto files and their imports in")
+
+		flagSet.BoolVar(&stream, "stream", false, "Read each response body as a stream of events and measure them")
+
+		flagSet.DurationVar(&streamHold, "stream-hold", 0, "How long to hold each stream open before ending it")
+
 		flagSet.Var(&luaScripts, "lua", "Lua script for request transformation (inline or @file/@url)")
 
 		flagSet.Var(&jsScripts, "js", "JavaScript script for request transformation (inline or @file/@url)")
@@ -215,6 +372,14 @@ func (parser ConfigCLIParser) Parse() (*Config, error) {
 			config.Requests = new(requestCount)
 		case "duration", "d":
 			config.Duration = new(duration)
+		case "rate", "R":
+			config.Rate = new(rate)
+		case "rate-overflow":
+			config.RateOverflow = new(ConfigRateOverflowType(rateOverflow))
+		case "max-concurrency":
+			config.MaxConcurrency = new(maxConcurrency)
+		case "send-interval":
+			config.SendInterval = new(sendInterval)
 		case "log-level", "l":
 			config.LogLevel = new(logLevel)
 		case "log-file", "w":
@@ -223,12 +388,44 @@ func (parser ConfigCLIParser) Parse() (*Config, error) {
 			config.Progress = new(ConfigProgressType(progress))
 		case "output", "o":
 			config.Output = new(ConfigOutputType(output))
+		case "percentiles":
+			if err := config.Percentiles.Parse(percentiles); err != nil {
+				fieldParseErrors = append(fieldParseErrors, types.NewFieldParseError("percentiles", percentiles, err))
+			}
+		case "remote-ip-stats":
+			config.RemoteIPStats = new(remoteIPStats)
+		case "timeline-interval":
+			config.TimelineInterval = new(timelineInterval)
+		case "timeline-file":
+			config.TimelineFile = new(timelineFile)
+		case "threshold":
+			for i, threshold := range thresholds {
+				if err := config.Thresholds.Parse(threshold); err != nil {
+					fieldParseErrors = append(
+						fieldParseErrors,
+						types.NewFieldParseError(fmt.Sprintf("threshold[%d]", i), threshold, err),
+					)
+				}
+			}
+		case "abort-on":
+			for i, condition := range abortOn {
+				if err := config.AbortOn.Parse(condition); err != nil {
+					fieldParseErrors = append(
+						fieldParseErrors,
+						types.NewFieldParseError(fmt.Sprintf("abort-on[%d]", i), condition, err),
+					)
+				}
+			}
+		case "abort-window":
+			config.AbortWindow = new(abortWindow)
+		case "abort-errors":
+			config.AbortErrors = new(abortErrors)
 		case "dry-run", "z":
 			config.DryRun = new(dryRun)
 
 		// Request config
 		case "url", "U":
-			urlParsed, err := url.Parse(urlInput)
+			urlParsed, err := types.ParseRequestURL(urlInput)
 			if err != nil {
 				fieldParseErrors = append(fieldParseErrors, types.NewFieldParseError("url", urlInput, err))
 			} else {
@@ -244,6 +441,10 @@ func (parser ConfigCLIParser) Parse() (*Config, error) {
 			config.Headers.Parse(headers...)
 		case "cookie", "C":
 			config.Cookies.Parse(cookies...)
+		case "cookie-jar":
+			config.CookieJar = new(cookieJar)
+		case "cookie-jar-reset":
+			config.CookieJarReset = new(cookieJarReset)
 		case "proxy", "X":
 			for i, proxy := range proxies {
 				err := config.Proxies.Parse(proxy)
@@ -254,12 +455,71 @@ func (parser ConfigCLIParser) Parse() (*Config, error) {
 					)
 				}
 			}
+		case "resolve":
+			for i, override := range resolve {
+				err := config.Resolve.Parse(override)
+				if err != nil {
+					fieldParseErrors = append(
+						fieldParseErrors,
+						types.NewFieldParseError(fmt.Sprintf("resolve[%d]", i), override, err),
+					)
+				}
+			}
+		case "dns-server":
+			config.DNSServer = new(dnsServer)
+		case "dns-cache":
+			config.DNSCache = new(dnsCache)
+		case "ip-strategy":
+			config.IPStrategy = new(ConfigIPStrategyType(ipStrategy))
+		case "local-address":
+			config.LocalAddresses = append(config.LocalAddresses, localAddress...)
 		case "values", "V":
 			config.Values = append(config.Values, values...)
 		case "timeout", "T":
 			config.Timeout = new(timeout)
 		case "insecure", "I":
 			config.Insecure = new(insecure)
+		case "tls-ca":
+			config.TLSCA = append(config.TLSCA, tlsCA...)
+		case "tls-rotate":
+			config.TLSRotate = new(ConfigTLSRotateType(tlsRotate))
+		case "tls-min-version":
+			config.TLSMinVersion = new(tlsMinVersion)
+		case "tls-max-version":
+			config.TLSMaxVersion = new(tlsMaxVersion)
+		case "tls-cipher":
+			config.TLSCiphers = append(config.TLSCiphers, tlsCiphers...)
+		case "tls-curve":
+			config.TLSCurves = append(config.TLSCurves, tlsCurves...)
+		case "tls-server-name":
+			config.TLSServerName = new(tlsServerName)
+		case "tls-alpn":
+			config.TLSALPN = append(config.TLSALPN, tlsALPN...)
+		case "tls-session-reuse":
+			config.TLSSessionReuse = new(sessionReuse)
+		case "protocol":
+			config.Protocol = new(ConfigProtocolType(protocol))
+		case "h2-max-streams":
+			config.H2MaxStreams = new(h2MaxStreams)
+		case "h2-connections":
+			config.H2Connections = new(h2Connections)
+		case "ws-match":
+			pattern, err := regexp.Compile(wsMatch)
+			if err != nil {
+				fieldParseErrors = append(fieldParseErrors, types.NewFieldParseError("ws-match", wsMatch, err))
+			} else {
+				config.WSMatch = pattern
+			}
+		case "grpc-method":
+			config.GRPCMethod = new(grpcMethod)
+		case "grpc-proto":
+			config.GRPCProto = append(config.GRPCProto, grpcProto...)
+		case "grpc-import-path":
+			config.GRPCImportPath = append(config.GRPCImportPath, grpcImportPath...)
+		case "stream":
+			config.Stream = new(stream)
+		case "stream-hold":
+			config.StreamHold = new(streamHold)
 		case "lua":
 			config.Lua = append(config.Lua, luaScripts...)
 		case "js":
@@ -267,6 +527,19 @@ func (parser ConfigCLIParser) Parse() (*Config, error) {
 		}
 	})
 
+	// Client certificates and keys are paired by position. One without a
+	// partner is kept with an empty one, which validation reports.
+	for i := range max(len(tlsCerts), len(tlsKeys)) {
+		var cert types.TLSClientCert
+		if i < len(tlsCerts) {
+			cert.Cert = tlsCerts[i]
+		}
+		if i < len(tlsKeys) {
+			cert.Key = tlsKeys[i]
+		}
+		config.TLSCerts = append(config.TLSCerts, cert)
+	}
+
 	if len(fieldParseErrors) > 0 {
 		return nil, types.NewFieldParseErrors(fieldParseErrors)
 	}
@@ -279,13 +552,28 @@ func (parser ConfigCLIParser) PrintHelp() {
 		cliUsageText+"\n",
 		Defaults.ShowConfig,
 		Defaults.Concurrency,
+		Defaults.RateOverflow,
+		Defaults.MaxConcurrency,
 		Defaults.LogLevel,
 		Defaults.Progress,
 		Defaults.Output,
+		Defaults.Percentiles,
+		Defaults.RemoteIPStats,
+		Defaults.TimelineInterval,
+		Defaults.AbortWindow,
 		Defaults.DryRun,
 
 		Defaults.Method,
+		Defaults.CookieJar,
+		Defaults.DNSCache,
+		Defaults.IPStrategy,
 		Defaults.RequestTimeout,
 		Defaults.Insecure,
+		Defaults.TLSRotate,
+		Defaults.TLSSessionReuse,
+		Defaults.Protocol,
+		Defaults.H2MaxStreams,
+		Defaults.H2Connections,
+		Defaults.Stream,
 	)
 }
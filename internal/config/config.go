@@ -2,11 +2,15 @@ package config
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"slices"
 	"strconv"
 	"strings"
@@ -27,33 +31,66 @@ import (
 )
 
 var Defaults = struct {
-	UserAgent      string
-	Method         string
-	RequestTimeout time.Duration
-	Concurrency    uint
-	ShowConfig     bool
-	Progress       ConfigProgressType
-	Insecure       bool
-	Output         ConfigOutputType
-	DryRun         bool
-	LogLevel       string
+	UserAgent        string
+	Method           string
+	RequestTimeout   time.Duration
+	Concurrency      uint
+	ShowConfig       bool
+	Progress         ConfigProgressType
+	Insecure         bool
+	Output           ConfigOutputType
+	DryRun           bool
+	LogLevel         string
+	RateOverflow     ConfigRateOverflowType
+	MaxConcurrency   uint
+	Percentiles      types.Percentiles
+	TimelineInterval time.Duration
+	AbortWindow      time.Duration
+	ScenarioWeight   uint
+	CookieJar        bool
+	Protocol         ConfigProtocolType
+	H2MaxStreams     uint
+	H2Connections    uint
+	Stream           bool
+	TLSRotate        ConfigTLSRotateType
+	TLSSessionReuse  bool
+	DNSCache         bool
+	IPStrategy       ConfigIPStrategyType
+	RemoteIPStats    bool
 }{
-	UserAgent:      "Sarin/" + version.Version,
-	Method:         "GET",
-	RequestTimeout: time.Second * 10,
-	Concurrency:    1,
-	ShowConfig:     false,
-	Progress:       ConfigProgressTypeBar,
-	Insecure:       false,
-	Output:         ConfigOutputTypeTable,
-	DryRun:         false,
-	LogLevel:       "error",
+	UserAgent:        "Sarin/" + version.Version,
+	Method:           "GET",
+	RequestTimeout:   time.Second * 10,
+	Concurrency:      1,
+	ShowConfig:       false,
+	Progress:         ConfigProgressTypeBar,
+	Insecure:         false,
+	Output:           ConfigOutputTypeTable,
+	DryRun:           false,
+	LogLevel:         "error",
+	RateOverflow:     ConfigRateOverflowTypeDrop,
+	MaxConcurrency:   10_000,
+	Percentiles:      types.Percentiles{90, 95, 99},
+	TimelineInterval: time.Second,
+	AbortWindow:      time.Second * 10,
+	ScenarioWeight:   1,
+	CookieJar:        false,
+	Protocol:         ConfigProtocolTypeH1,
+	H2MaxStreams:     100,
+	H2Connections:    1,
+	Stream:           false,
+	TLSRotate:        ConfigTLSRotateTypeWorker,
+	TLSSessionReuse:  false,
+	DNSCache:         false,
+	IPStrategy:       ConfigIPStrategyTypeFirst,
+	RemoteIPStats:    false,
 }
 
 var (
 	ValidProxySchemes      = []string{"http", "https", "socks5", "socks5h"}
-	ValidRequestURLSchemes = []string{"http", "https"}
+	ValidRequestURLSchemes = []string{"http", "https", "ws", "wss"}
 	ValidLogLevels         = []string{"info", "error"}
+	ValidTimelineFileExts  = []string{".csv", ".ndjson", ".jsonl"}
 )
 
 var (
@@ -81,29 +118,103 @@ var (
 	ConfigProgressTypeNone ConfigProgressType = "none"
 )
 
+type ConfigRateOverflowType string
+
+var (
+	ConfigRateOverflowTypeQueue ConfigRateOverflowType = "queue"
+	ConfigRateOverflowTypeDrop  ConfigRateOverflowType = "drop"
+	ConfigRateOverflowTypeSpawn ConfigRateOverflowType = "spawn"
+)
+
+type ConfigProtocolType string
+
+var (
+	ConfigProtocolTypeH1 ConfigProtocolType = "h1"
+	ConfigProtocolTypeH2 ConfigProtocolType = "h2"
+	ConfigProtocolTypeH3 ConfigProtocolType = "h3"
+)
+
+type ConfigTLSRotateType string
+
+var (
+	ConfigTLSRotateTypeWorker  ConfigTLSRotateType = "worker"
+	ConfigTLSRotateTypeRequest ConfigTLSRotateType = "request"
+)
+
+type ConfigIPStrategyType string
+
+var (
+	ConfigIPStrategyTypeFirst      ConfigIPStrategyType = "first"
+	ConfigIPStrategyTypeRoundRobin ConfigIPStrategyType = "round-robin"
+	ConfigIPStrategyTypeRandom     ConfigIPStrategyType = "random"
+	ConfigIPStrategyTypeWorker     ConfigIPStrategyType = "worker"
+)
+
 type Config struct {
-	ShowConfig  *bool               `yaml:"showConfig,omitempty"`
-	Files       []types.ConfigFile  `yaml:"files,omitempty"`
-	Methods     []string            `yaml:"methods,omitempty"`
-	URL         *url.URL            `yaml:"url,omitempty"`
-	Timeout     *time.Duration      `yaml:"timeout,omitempty"`
-	Concurrency *uint               `yaml:"concurrency,omitempty"`
-	Requests    *uint64             `yaml:"requests,omitempty"`
-	Duration    *time.Duration      `yaml:"duration,omitempty"`
-	Progress    *ConfigProgressType `yaml:"progress,omitempty"`
-	Output      *ConfigOutputType   `yaml:"output,omitempty"`
-	Insecure    *bool               `yaml:"insecure,omitempty"`
-	DryRun      *bool               `yaml:"dryRun,omitempty"`
-	Params      types.Params        `yaml:"params,omitempty"`
-	Headers     types.Headers       `yaml:"headers,omitempty"`
-	Cookies     types.Cookies       `yaml:"cookies,omitempty"`
-	Bodies      []string            `yaml:"bodies,omitempty"`
-	Proxies     types.Proxies       `yaml:"proxies,omitempty"`
-	Values      []string            `yaml:"values,omitempty"`
-	Lua         []string            `yaml:"lua,omitempty"`
-	Js          []string            `yaml:"js,omitempty"`
-	LogLevel    *string             `yaml:"logLevel,omitempty"`
-	LogFile     *string             `yaml:"logFile,omitempty"`
+	ShowConfig       *bool                   `yaml:"showConfig,omitempty"`
+	Files            []types.ConfigFile      `yaml:"files,omitempty"`
+	Methods          []string                `yaml:"methods,omitempty"`
+	URL              *url.URL                `yaml:"url,omitempty"`
+	Timeout          *time.Duration          `yaml:"timeout,omitempty"`
+	Concurrency      *uint                   `yaml:"concurrency,omitempty"`
+	Requests         *uint64                 `yaml:"requests,omitempty"`
+	Duration         *time.Duration          `yaml:"duration,omitempty"`
+	Rate             *uint                   `yaml:"rate,omitempty"`
+	RateOverflow     *ConfigRateOverflowType `yaml:"rateOverflow,omitempty"`
+	MaxConcurrency   *uint                   `yaml:"maxConcurrency,omitempty"`
+	Stages           types.Stages            `yaml:"stages,omitempty"`
+	SendInterval     *time.Duration          `yaml:"sendInterval,omitempty"`
+	Progress         *ConfigProgressType     `yaml:"progress,omitempty"`
+	Output           *ConfigOutputType       `yaml:"output,omitempty"`
+	Percentiles      types.Percentiles       `yaml:"percentiles,omitempty"`
+	RemoteIPStats    *bool                   `yaml:"remoteIPStats,omitempty"`
+	TimelineInterval *time.Duration          `yaml:"timelineInterval,omitempty"`
+	TimelineFile     *string                 `yaml:"timelineFile,omitempty"`
+	Thresholds       types.Thresholds        `yaml:"thresholds,omitempty"`
+	Assertions       *types.Assertions       `yaml:"assertions,omitempty"`
+	AbortOn          types.Thresholds        `yaml:"abortOn,omitempty"`
+	AbortWindow      *time.Duration          `yaml:"abortWindow,omitempty"`
+	AbortErrors      *uint                   `yaml:"abortErrors,omitempty"`
+	Insecure         *bool                   `yaml:"insecure,omitempty"`
+	TLSCerts         []types.TLSClientCert   `yaml:"tlsCerts,omitempty"`
+	TLSCA            []string                `yaml:"tlsCA,omitempty"`
+	TLSRotate        *ConfigTLSRotateType    `yaml:"tlsRotate,omitempty"`
+	TLSMinVersion    *string                 `yaml:"tlsMinVersion,omitempty"`
+	TLSMaxVersion    *string                 `yaml:"tlsMaxVersion,omitempty"`
+	TLSCiphers       []string                `yaml:"tlsCiphers,omitempty"`
+	TLSCurves        []string                `yaml:"tlsCurves,omitempty"`
+	TLSServerName    *string                 `yaml:"tlsServerName,omitempty"`
+	TLSALPN          []string                `yaml:"tlsALPN,omitempty"`
+	TLSSessionReuse  *bool                   `yaml:"tlsSessionReuse,omitempty"`
+	Protocol         *ConfigProtocolType     `yaml:"protocol,omitempty"`
+	H2MaxStreams     *uint                   `yaml:"h2MaxStreams,omitempty"`
+	H2Connections    *uint                   `yaml:"h2Connections,omitempty"`
+	WSMatch          *regexp.Regexp          `yaml:"wsMatch,omitempty"`
+	GRPCMethod       *string                 `yaml:"grpcMethod,omitempty"`
+	GRPCProto        []string                `yaml:"grpcProto,omitempty"`
+	GRPCImportPath   []string                `yaml:"grpcImportPath,omitempty"`
+	Stream           *bool                   `yaml:"stream,omitempty"`
+	StreamHold       *time.Duration          `yaml:"streamHold,omitempty"`
+	DryRun           *bool                   `yaml:"dryRun,omitempty"`
+	Params           types.Params            `yaml:"params,omitempty"`
+	Headers          types.Headers           `yaml:"headers,omitempty"`
+	Cookies          types.Cookies           `yaml:"cookies,omitempty"`
+	CookieJar        *bool                   `yaml:"cookieJar,omitempty"`
+	CookieJarReset   *uint                   `yaml:"cookieJarReset,omitempty"`
+	Bodies           []string                `yaml:"bodies,omitempty"`
+	Scenarios        types.Scenarios         `yaml:"scenarios,omitempty"`
+	Flow             types.Flow              `yaml:"flow,omitempty"`
+	Proxies          types.Proxies           `yaml:"proxies,omitempty"`
+	Resolve          types.ResolveOverrides  `yaml:"resolve,omitempty"`
+	DNSServer        *string                 `yaml:"dnsServer,omitempty"`
+	DNSCache         *bool                   `yaml:"dnsCache,omitempty"`
+	IPStrategy       *ConfigIPStrategyType   `yaml:"ipStrategy,omitempty"`
+	LocalAddresses   []string                `yaml:"localAddresses,omitempty"`
+	Values           []string                `yaml:"values,omitempty"`
+	Lua              []string                `yaml:"lua,omitempty"`
+	Js               []string                `yaml:"js,omitempty"`
+	LogLevel         *string                 `yaml:"logLevel,omitempty"`
+	LogFile          *string                 `yaml:"logFile,omitempty"`
 }
 
 func (config Config) MarshalYAML() (any, error) {
@@ -162,6 +273,145 @@ func (config Config) MarshalYAML() (any, error) {
 		return seqNode
 	}
 
+	marshalStages := func(stages types.Stages) *yaml.Node {
+		seqNode := &yaml.Node{Kind: yaml.SequenceNode}
+		for _, stage := range stages {
+			mapNode := &yaml.Node{Kind: yaml.MappingNode}
+			addField(&mapNode.Content, "duration", toNode(stage.Duration), "")
+			if stage.Rate != nil {
+				addField(&mapNode.Content, "rate", toNode(*stage.Rate), "")
+			}
+			if stage.Concurrency != nil {
+				addField(&mapNode.Content, "concurrency", toNode(*stage.Concurrency), "")
+			}
+			seqNode.Content = append(seqNode.Content, mapNode)
+		}
+		return seqNode
+	}
+
+	// Expected values are added even when empty, since an empty value is a
+	// valid expectation.
+	addExpected := func(content *[]*yaml.Node, key string, value any) {
+		*content = append(*content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, toNode(value))
+	}
+
+	addRequest := func(content *[]*yaml.Node, request types.Request) {
+		if request.URL != nil {
+			addField(content, "url", toNode(types.RequestURLString(request.URL)), "")
+		}
+		addStringSlice(content, "method", request.Methods, true)
+
+		params := make([]types.KeyValue[string, []string], len(request.Params))
+		for i, p := range request.Params {
+			params[i] = types.KeyValue[string, []string](p)
+		}
+		addField(content, "params", marshalKeyValues(params), "")
+		headers := make([]types.KeyValue[string, []string], len(request.Headers))
+		for i, h := range request.Headers {
+			headers[i] = types.KeyValue[string, []string](h)
+		}
+		addField(content, "headers", marshalKeyValues(headers), "")
+		cookies := make([]types.KeyValue[string, []string], len(request.Cookies))
+		for i, c := range request.Cookies {
+			cookies[i] = types.KeyValue[string, []string](c)
+		}
+		addField(content, "cookies", marshalKeyValues(cookies), "")
+
+		addStringSlice(content, "body", request.Bodies, true)
+	}
+
+	marshalScenarios := func(scenarios types.Scenarios) *yaml.Node {
+		seqNode := &yaml.Node{Kind: yaml.SequenceNode}
+		for _, scenario := range scenarios {
+			mapNode := &yaml.Node{Kind: yaml.MappingNode}
+			addField(&mapNode.Content, "name", toNode(scenario.Name), "")
+			if scenario.Weight != nil {
+				addField(&mapNode.Content, "weight", toNode(*scenario.Weight), "")
+			}
+			addRequest(&mapNode.Content, scenario.Request)
+			seqNode.Content = append(seqNode.Content, mapNode)
+		}
+		return seqNode
+	}
+
+	marshalFlow := func(flow types.Flow) *yaml.Node {
+		seqNode := &yaml.Node{Kind: yaml.SequenceNode}
+		for _, step := range flow {
+			mapNode := &yaml.Node{Kind: yaml.MappingNode}
+			addField(&mapNode.Content, "name", toNode(step.Name), "")
+			addRequest(&mapNode.Content, step.Request)
+
+			extractNode := &yaml.Node{Kind: yaml.SequenceNode}
+			for _, extractor := range step.Extract {
+				extractorNode := &yaml.Node{Kind: yaml.MappingNode}
+				addField(&extractorNode.Content, "name", toNode(extractor.Name), "")
+				addExpected(&extractorNode.Content, string(extractor.Source), extractor.Expression)
+				extractNode.Content = append(extractNode.Content, extractorNode)
+			}
+			addField(&mapNode.Content, "extract", extractNode, "")
+
+			seqNode.Content = append(seqNode.Content, mapNode)
+		}
+		return seqNode
+	}
+
+	marshalAssertions := func(assertions *types.Assertions) *yaml.Node {
+		mapNode := &yaml.Node{Kind: yaml.MappingNode}
+
+		statuses := make([]string, len(assertions.Status))
+		for i, status := range assertions.Status {
+			statuses[i] = string(status)
+		}
+		addStringSlice(&mapNode.Content, "status", statuses, false)
+
+		headersNode := &yaml.Node{Kind: yaml.SequenceNode}
+		for _, header := range assertions.Headers {
+			headerNode := &yaml.Node{Kind: yaml.MappingNode}
+			addField(&headerNode.Content, "name", toNode(header.Name), "")
+			if header.Equals != nil {
+				addExpected(&headerNode.Content, "equals", *header.Equals)
+			}
+			if header.Contains != nil {
+				addExpected(&headerNode.Content, "contains", *header.Contains)
+			}
+			headersNode.Content = append(headersNode.Content, headerNode)
+		}
+		addField(&mapNode.Content, "headers", headersNode, "")
+
+		bodies := make([]string, len(assertions.Body))
+		for i, body := range assertions.Body {
+			bodies[i] = body.String()
+		}
+		addStringSlice(&mapNode.Content, "body", bodies, false)
+
+		jsonNode := &yaml.Node{Kind: yaml.SequenceNode}
+		for _, field := range assertions.JSON {
+			fieldNode := &yaml.Node{Kind: yaml.MappingNode}
+			addField(&fieldNode.Content, "path", toNode(field.Path), "")
+			if field.Equals != nil {
+				addExpected(&fieldNode.Content, "equals", *field.Equals)
+			}
+			jsonNode.Content = append(jsonNode.Content, fieldNode)
+		}
+		addField(&mapNode.Content, "json", jsonNode, "")
+
+		if assertions.MaxBodySize != nil {
+			addExpected(&mapNode.Content, "maxBodySize", *assertions.MaxBodySize)
+		}
+		return mapNode
+	}
+
+	marshalTLSCerts := func(certs []types.TLSClientCert) *yaml.Node {
+		seqNode := &yaml.Node{Kind: yaml.SequenceNode}
+		for _, cert := range certs {
+			mapNode := &yaml.Node{Kind: yaml.MappingNode}
+			addField(&mapNode.Content, "cert", toNode(cert.Cert), "")
+			addField(&mapNode.Content, "key", toNode(cert.Key), "")
+			seqNode.Content = append(seqNode.Content, mapNode)
+		}
+		return seqNode
+	}
+
 	root := &yaml.Node{Kind: yaml.MappingNode}
 	content := &root.Content
 
@@ -172,7 +422,7 @@ func (config Config) MarshalYAML() (any, error) {
 	addStringSlice(content, "method", config.Methods, true)
 
 	if config.URL != nil {
-		addField(content, "url", toNode(config.URL.String()), "")
+		addField(content, "url", toNode(types.RequestURLString(config.URL)), "")
 	}
 	if config.Timeout != nil {
 		addField(content, "timeout", toNode(*config.Timeout), "")
@@ -186,15 +436,122 @@ func (config Config) MarshalYAML() (any, error) {
 	if config.Duration != nil {
 		addField(content, "duration", toNode(*config.Duration), "")
 	}
+	if config.Rate != nil {
+		addField(content, "rate", toNode(*config.Rate), "")
+		if config.RateOverflow != nil {
+			addField(content, "rateOverflow", toNode(string(*config.RateOverflow)), "")
+		}
+		if config.MaxConcurrency != nil {
+			addField(content, "maxConcurrency", toNode(*config.MaxConcurrency), "")
+		}
+	}
+	if len(config.Stages) > 0 {
+		addField(content, "stages", marshalStages(config.Stages), "")
+		if config.Rate == nil && config.Stages.ByRate() {
+			if config.RateOverflow != nil {
+				addField(content, "rateOverflow", toNode(string(*config.RateOverflow)), "")
+			}
+			if config.MaxConcurrency != nil {
+				addField(content, "maxConcurrency", toNode(*config.MaxConcurrency), "")
+			}
+		}
+	}
+	if config.SendInterval != nil {
+		addField(content, "sendInterval", toNode(*config.SendInterval), "")
+	}
 	if config.Progress != nil {
 		addField(content, "progress", toNode(string(*config.Progress)), "")
 	}
 	if config.Output != nil {
 		addField(content, "output", toNode(string(*config.Output)), "")
 	}
+	if len(config.Percentiles) > 0 {
+		addField(content, "percentiles", toNode([]float64(config.Percentiles)), "")
+	}
+	if config.RemoteIPStats != nil {
+		addField(content, "remoteIPStats", toNode(*config.RemoteIPStats), "")
+	}
+	if config.TimelineInterval != nil {
+		addField(content, "timelineInterval", toNode(*config.TimelineInterval), "")
+	}
+	if config.TimelineFile != nil {
+		addField(content, "timelineFile", toNode(*config.TimelineFile), "")
+	}
+	if len(config.Thresholds) > 0 {
+		addStringSlice(content, "thresholds", config.Thresholds.Strings(), false)
+	}
+	if config.Assertions != nil && !config.Assertions.IsEmpty() {
+		addField(content, "assertions", marshalAssertions(config.Assertions), "")
+	}
+	if len(config.AbortOn) > 0 {
+		addStringSlice(content, "abortOn", config.AbortOn.Strings(), false)
+	}
+	if config.AbortWindow != nil {
+		addField(content, "abortWindow", toNode(*config.AbortWindow), "")
+	}
+	if config.AbortErrors != nil {
+		addField(content, "abortErrors", toNode(*config.AbortErrors), "")
+	}
 	if config.Insecure != nil {
 		addField(content, "insecure", toNode(*config.Insecure), "")
 	}
+	if len(config.TLSCerts) > 0 {
+		addField(content, "tlsCerts", marshalTLSCerts(config.TLSCerts), "")
+	}
+	if len(config.TLSCA) > 0 {
+		addStringSlice(content, "tlsCA", config.TLSCA, false)
+	}
+	if config.TLSRotate != nil {
+		addField(content, "tlsRotate", toNode(string(*config.TLSRotate)), "")
+	}
+	if config.TLSMinVersion != nil {
+		addField(content, "tlsMinVersion", toNode(*config.TLSMinVersion), "")
+	}
+	if config.TLSMaxVersion != nil {
+		addField(content, "tlsMaxVersion", toNode(*config.TLSMaxVersion), "")
+	}
+	if len(config.TLSCiphers) > 0 {
+		addStringSlice(content, "tlsCiphers", config.TLSCiphers, false)
+	}
+	if len(config.TLSCurves) > 0 {
+		addStringSlice(content, "tlsCurves", config.TLSCurves, false)
+	}
+	if config.TLSServerName != nil {
+		addField(content, "tlsServerName", toNode(*config.TLSServerName), "")
+	}
+	if len(config.TLSALPN) > 0 {
+		addStringSlice(content, "tlsALPN", config.TLSALPN, false)
+	}
+	if config.TLSSessionReuse != nil {
+		addField(content, "tlsSessionReuse", toNode(*config.TLSSessionReuse), "")
+	}
+	if config.Protocol != nil {
+		addField(content, "protocol", toNode(string(*config.Protocol)), "")
+	}
+	if config.H2MaxStreams != nil {
+		addField(content, "h2MaxStreams", toNode(*config.H2MaxStreams), "")
+	}
+	if config.H2Connections != nil {
+		addField(content, "h2Connections", toNode(*config.H2Connections), "")
+	}
+	if config.WSMatch != nil {
+		addField(content, "wsMatch", toNode(config.WSMatch.String()), "")
+	}
+	if config.GRPCMethod != nil {
+		addField(content, "grpcMethod", toNode(*config.GRPCMethod), "")
+	}
+	if len(config.GRPCProto) > 0 {
+		addStringSlice(content, "grpcProto", config.GRPCProto, false)
+	}
+	if len(config.GRPCImportPath) > 0 {
+		addStringSlice(content, "grpcImportPath", config.GRPCImportPath, false)
+	}
+	if config.Stream != nil {
+		addField(content, "stream", toNode(*config.Stream), "")
+	}
+	if config.StreamHold != nil {
+		addField(content, "streamHold", toNode(*config.StreamHold), "")
+	}
 	if config.DryRun != nil {
 		addField(content, "dryRun", toNode(*config.DryRun), "")
 	}
@@ -220,9 +577,22 @@ func (config Config) MarshalYAML() (any, error) {
 		}
 		addField(content, "cookies", marshalKeyValues(items), "")
 	}
+	if config.CookieJar != nil {
+		addField(content, "cookieJar", toNode(*config.CookieJar), "")
+	}
+	if config.CookieJarReset != nil {
+		addField(content, "cookieJarReset", toNode(*config.CookieJarReset), "")
+	}
 
 	addStringSlice(content, "body", config.Bodies, true)
 
+	if len(config.Scenarios) > 0 {
+		addField(content, "scenarios", marshalScenarios(config.Scenarios), "")
+	}
+	if len(config.Flow) > 0 {
+		addField(content, "flow", marshalFlow(config.Flow), "")
+	}
+
 	if len(config.Proxies) > 0 {
 		proxyStrings := make([]string, len(config.Proxies))
 		for i, p := range config.Proxies {
@@ -230,6 +600,23 @@ func (config Config) MarshalYAML() (any, error) {
 		}
 		addStringSlice(content, "proxy", proxyStrings, true)
 	}
+	if len(config.Resolve) > 0 {
+		resolveStrings := make([]string, len(config.Resolve))
+		for i, override := range config.Resolve {
+			resolveStrings[i] = override.String()
+		}
+		addStringSlice(content, "resolve", resolveStrings, false)
+	}
+	if config.DNSServer != nil {
+		addField(content, "dnsServer", toNode(*config.DNSServer), "")
+	}
+	if config.DNSCache != nil {
+		addField(content, "dnsCache", toNode(*config.DNSCache), "")
+	}
+	if config.IPStrategy != nil {
+		addField(content, "ipStrategy", toNode(string(*config.IPStrategy)), "")
+	}
+	addStringSlice(content, "localAddresses", config.LocalAddresses, false)
 
 	addStringSlice(content, "values", config.Values, false)
 	addStringSlice(content, "lua", config.Lua, false)
@@ -310,6 +697,21 @@ func (config *Config) Merge(newConfig *Config) {
 	if newConfig.Duration != nil {
 		config.Duration = newConfig.Duration
 	}
+	if newConfig.Rate != nil {
+		config.Rate = newConfig.Rate
+	}
+	if newConfig.RateOverflow != nil {
+		config.RateOverflow = newConfig.RateOverflow
+	}
+	if newConfig.MaxConcurrency != nil {
+		config.MaxConcurrency = newConfig.MaxConcurrency
+	}
+	if len(newConfig.Stages) != 0 {
+		config.Stages = newConfig.Stages
+	}
+	if newConfig.SendInterval != nil {
+		config.SendInterval = newConfig.SendInterval
+	}
 	if newConfig.ShowConfig != nil {
 		config.ShowConfig = newConfig.ShowConfig
 	}
@@ -319,9 +721,99 @@ func (config *Config) Merge(newConfig *Config) {
 	if newConfig.Output != nil {
 		config.Output = newConfig.Output
 	}
+	if len(newConfig.Percentiles) != 0 {
+		config.Percentiles = newConfig.Percentiles
+	}
+	if newConfig.RemoteIPStats != nil {
+		config.RemoteIPStats = newConfig.RemoteIPStats
+	}
+	if newConfig.TimelineInterval != nil {
+		config.TimelineInterval = newConfig.TimelineInterval
+	}
+	if newConfig.TimelineFile != nil {
+		config.TimelineFile = newConfig.TimelineFile
+	}
+	if len(newConfig.Thresholds) != 0 {
+		config.Thresholds = append(config.Thresholds, newConfig.Thresholds...)
+	}
+	if newConfig.Assertions != nil {
+		config.Assertions = newConfig.Assertions
+	}
+	if len(newConfig.Scenarios) != 0 {
+		config.Scenarios = newConfig.Scenarios
+	}
+	if len(newConfig.Flow) != 0 {
+		config.Flow = newConfig.Flow
+	}
+	if len(newConfig.AbortOn) != 0 {
+		config.AbortOn = append(config.AbortOn, newConfig.AbortOn...)
+	}
+	if newConfig.AbortWindow != nil {
+		config.AbortWindow = newConfig.AbortWindow
+	}
+	if newConfig.AbortErrors != nil {
+		config.AbortErrors = newConfig.AbortErrors
+	}
 	if newConfig.Insecure != nil {
 		config.Insecure = newConfig.Insecure
 	}
+	if len(newConfig.TLSCerts) != 0 {
+		config.TLSCerts = append(config.TLSCerts, newConfig.TLSCerts...)
+	}
+	if len(newConfig.TLSCA) != 0 {
+		config.TLSCA = append(config.TLSCA, newConfig.TLSCA...)
+	}
+	if newConfig.TLSRotate != nil {
+		config.TLSRotate = newConfig.TLSRotate
+	}
+	if newConfig.TLSMinVersion != nil {
+		config.TLSMinVersion = newConfig.TLSMinVersion
+	}
+	if newConfig.TLSMaxVersion != nil {
+		config.TLSMaxVersion = newConfig.TLSMaxVersion
+	}
+	if len(newConfig.TLSCiphers) != 0 {
+		config.TLSCiphers = append(config.TLSCiphers, newConfig.TLSCiphers...)
+	}
+	if len(newConfig.TLSCurves) != 0 {
+		config.TLSCurves = append(config.TLSCurves, newConfig.TLSCurves...)
+	}
+	if newConfig.TLSServerName != nil {
+		config.TLSServerName = newConfig.TLSServerName
+	}
+	if len(newConfig.TLSALPN) != 0 {
+		config.TLSALPN = append(config.TLSALPN, newConfig.TLSALPN...)
+	}
+	if newConfig.TLSSessionReuse != nil {
+		config.TLSSessionReuse = newConfig.TLSSessionReuse
+	}
+	if newConfig.Protocol != nil {
+		config.Protocol = newConfig.Protocol
+	}
+	if newConfig.H2MaxStreams != nil {
+		config.H2MaxStreams = newConfig.H2MaxStreams
+	}
+	if newConfig.H2Connections != nil {
+		config.H2Connections = newConfig.H2Connections
+	}
+	if newConfig.WSMatch != nil {
+		config.WSMatch = newConfig.WSMatch
+	}
+	if newConfig.GRPCMethod != nil {
+		config.GRPCMethod = newConfig.GRPCMethod
+	}
+	if len(newConfig.GRPCProto) != 0 {
+		config.GRPCProto = append(config.GRPCProto, newConfig.GRPCProto...)
+	}
+	if len(newConfig.GRPCImportPath) != 0 {
+		config.GRPCImportPath = append(config.GRPCImportPath, newConfig.GRPCImportPath...)
+	}
+	if newConfig.Stream != nil {
+		config.Stream = newConfig.Stream
+	}
+	if newConfig.StreamHold != nil {
+		config.StreamHold = newConfig.StreamHold
+	}
 	if newConfig.DryRun != nil {
 		config.DryRun = newConfig.DryRun
 	}
@@ -334,12 +826,33 @@ func (config *Config) Merge(newConfig *Config) {
 	if len(newConfig.Cookies) != 0 {
 		config.Cookies = append(config.Cookies, newConfig.Cookies...)
 	}
+	if newConfig.CookieJar != nil {
+		config.CookieJar = newConfig.CookieJar
+	}
+	if newConfig.CookieJarReset != nil {
+		config.CookieJarReset = newConfig.CookieJarReset
+	}
 	if len(newConfig.Bodies) != 0 {
 		config.Bodies = newConfig.Bodies
 	}
 	if len(newConfig.Proxies) != 0 {
 		config.Proxies.Append(newConfig.Proxies...)
 	}
+	if len(newConfig.Resolve) != 0 {
+		config.Resolve.Append(newConfig.Resolve...)
+	}
+	if newConfig.DNSServer != nil {
+		config.DNSServer = newConfig.DNSServer
+	}
+	if newConfig.DNSCache != nil {
+		config.DNSCache = newConfig.DNSCache
+	}
+	if newConfig.IPStrategy != nil {
+		config.IPStrategy = newConfig.IPStrategy
+	}
+	if len(newConfig.LocalAddresses) != 0 {
+		config.LocalAddresses = append(config.LocalAddresses, newConfig.LocalAddresses...)
+	}
 	if len(newConfig.Values) != 0 {
 		config.Values = append(config.Values, newConfig.Values...)
 	}
@@ -358,19 +871,23 @@ func (config *Config) Merge(newConfig *Config) {
 }
 
 func (config *Config) SetDefaults() {
-	if config.URL != nil && len(config.URL.Query()) > 0 {
-		urlParams := types.Params{}
-		for key, values := range config.URL.Query() {
-			for _, value := range values {
-				urlParams = append(urlParams, types.Param{
-					Key:   key,
-					Value: []string{value},
-				})
-			}
+	config.Params = append(moveURLQueryToParams(config.URL), config.Params...)
+	for i := range config.Scenarios {
+		scenario := &config.Scenarios[i]
+		scenario.Params = append(moveURLQueryToParams(scenario.URL), scenario.Params...)
+		if scenario.Name == "" {
+			scenario.Name = "scenario " + strconv.Itoa(i+1)
+		}
+		if scenario.Weight == nil {
+			scenario.Weight = new(Defaults.ScenarioWeight)
+		}
+	}
+	for i := range config.Flow {
+		step := &config.Flow[i]
+		step.Params = append(moveURLQueryToParams(step.URL), step.Params...)
+		if step.Name == "" {
+			step.Name = "step " + strconv.Itoa(i+1)
 		}
-
-		config.Params = append(urlParams, config.Params...)
-		config.URL.RawQuery = ""
 	}
 
 	if len(config.Methods) == 0 {
@@ -382,6 +899,14 @@ func (config *Config) SetDefaults() {
 	if config.Concurrency == nil {
 		config.Concurrency = new(Defaults.Concurrency)
 	}
+	if config.RateOverflow == nil {
+		config.RateOverflow = new(Defaults.RateOverflow)
+	}
+	// Spawning starts from the configured workers, so the default cap is
+	// never below them.
+	if *config.RateOverflow == ConfigRateOverflowTypeSpawn && config.MaxConcurrency == nil {
+		config.MaxConcurrency = new(max(Defaults.MaxConcurrency, *config.Concurrency))
+	}
 	if config.ShowConfig == nil {
 		config.ShowConfig = new(Defaults.ShowConfig)
 	}
@@ -391,9 +916,47 @@ func (config *Config) SetDefaults() {
 	if config.Insecure == nil {
 		config.Insecure = new(Defaults.Insecure)
 	}
+	if config.TLSRotate == nil && len(config.TLSCerts) > 0 {
+		config.TLSRotate = new(Defaults.TLSRotate)
+	}
+	if config.TLSSessionReuse == nil {
+		config.TLSSessionReuse = new(Defaults.TLSSessionReuse)
+	}
+	if config.DNSCache == nil {
+		config.DNSCache = new(Defaults.DNSCache)
+	}
+	if config.IPStrategy == nil {
+		config.IPStrategy = new(Defaults.IPStrategy)
+	}
 	if config.DryRun == nil {
 		config.DryRun = new(Defaults.DryRun)
 	}
+	if config.CookieJar == nil {
+		config.CookieJar = new(Defaults.CookieJar)
+	}
+	if config.Stream == nil {
+		config.Stream = new(Defaults.Stream)
+	}
+	// A stream is held open for the request timeout unless told otherwise.
+	if *config.Stream && config.StreamHold == nil {
+		config.StreamHold = new(*config.Timeout)
+	}
+	if config.Protocol == nil {
+		// gRPC runs over HTTP/2.
+		if config.GRPCMethod != nil {
+			config.Protocol = new(ConfigProtocolTypeH2)
+		} else {
+			config.Protocol = new(Defaults.Protocol)
+		}
+	}
+	if *config.Protocol == ConfigProtocolTypeH2 {
+		if config.H2MaxStreams == nil {
+			config.H2MaxStreams = new(Defaults.H2MaxStreams)
+		}
+		if config.H2Connections == nil {
+			config.H2Connections = new(Defaults.H2Connections)
+		}
+	}
 	if !config.Headers.Has("User-Agent") {
 		config.Headers = append(config.Headers, types.Header{Key: "User-Agent", Value: []string{Defaults.UserAgent}})
 	}
@@ -402,6 +965,24 @@ func (config *Config) SetDefaults() {
 		config.Output = new(Defaults.Output)
 	}
 
+	if len(config.Percentiles) == 0 {
+		config.Percentiles = slices.Clone(Defaults.Percentiles)
+	}
+	if config.RemoteIPStats == nil {
+		config.RemoteIPStats = new(Defaults.RemoteIPStats)
+	}
+
+	if config.TimelineFile == nil {
+		config.TimelineFile = new("")
+	}
+	if config.TimelineInterval == nil && *config.TimelineFile != "" {
+		config.TimelineInterval = new(Defaults.TimelineInterval)
+	}
+
+	if config.AbortWindow == nil && len(config.AbortOn) > 0 {
+		config.AbortWindow = new(Defaults.AbortWindow)
+	}
+
 	if config.LogLevel == nil {
 		config.LogLevel = new(Defaults.LogLevel)
 	}
@@ -421,14 +1002,13 @@ func (config Config) Validate() error {
 		validationErrors = append(validationErrors, types.NewFieldValidationError("Method", "", errors.New("method is required")))
 	}
 
-	switch {
-	case config.URL == nil:
-		validationErrors = append(validationErrors, types.NewFieldValidationError("URL", "", errors.New("URL is required")))
-	case !slices.Contains(ValidRequestURLSchemes, config.URL.Scheme):
-		validationErrors = append(validationErrors, types.NewFieldValidationError("URL", config.URL.String(), fmt.Errorf("URL scheme must be one of: %s", strings.Join(ValidRequestURLSchemes, ", "))))
-	case config.URL.Host == "":
-		validationErrors = append(validationErrors, types.NewFieldValidationError("URL", config.URL.String(), errors.New("URL must have a host")))
+	// Scenarios or flow steps that all set their own URL make the top-level
+	// one optional.
+	if config.URL != nil || (!config.Scenarios.HaveURLs() && !config.Flow.HaveURLs()) {
+		validationErrors = append(validationErrors, validateRequestURL("URL", config.URL)...)
 	}
+	validationErrors = append(validationErrors, validateScenarios(config.Scenarios)...)
+	validationErrors = append(validationErrors, validateFlow(config.Flow, len(config.Scenarios) > 0)...)
 
 	switch {
 	case config.Concurrency == nil:
@@ -440,6 +1020,8 @@ func (config Config) Validate() error {
 	}
 
 	switch {
+	case len(config.Stages) > 0:
+		// Stages define the test length themselves; requests and duration only cap it further.
 	case config.Requests == nil && config.Duration == nil:
 		validationErrors = append(validationErrors, types.NewFieldValidationError("Requests / Duration", "", errors.New("either request count or duration must be specified")))
 	case (config.Requests != nil && config.Duration != nil) && (*config.Requests == 0 && *config.Duration == 0):
@@ -450,6 +1032,83 @@ func (config Config) Validate() error {
 		validationErrors = append(validationErrors, types.NewFieldValidationError("Duration", "0", errors.New("duration must be greater than 0")))
 	}
 
+	if config.Rate != nil {
+		switch {
+		case *config.Rate == 0:
+			validationErrors = append(validationErrors, types.NewFieldValidationError("Rate", "0", errors.New("rate must be greater than 0")))
+		case *config.Rate > 100_000_000:
+			validationErrors = append(validationErrors, types.NewFieldValidationError("Rate", strconv.FormatUint(uint64(*config.Rate), 10), errors.New("rate must not exceed 100,000,000")))
+		}
+	}
+
+	validationErrors = append(validationErrors, validateStages(config.Stages, config.Rate != nil)...)
+
+	if config.SendInterval != nil && *config.SendInterval <= 0 {
+		validationErrors = append(
+			validationErrors,
+			types.NewFieldValidationError("SendInterval", config.SendInterval.String(), errors.New("send interval must be greater than 0")),
+		)
+	}
+	validationErrors = append(validationErrors, validateAssertions(config.Assertions)...)
+
+	if config.AbortWindow != nil && *config.AbortWindow <= 0 {
+		validationErrors = append(
+			validationErrors,
+			types.NewFieldValidationError("AbortWindow", config.AbortWindow.String(), errors.New("abort window must be greater than 0")),
+		)
+	}
+	if config.AbortErrors != nil && *config.AbortErrors == 0 {
+		validationErrors = append(
+			validationErrors,
+			types.NewFieldValidationError("AbortErrors", "0", errors.New("abort errors must be greater than 0")),
+		)
+	}
+	if config.CookieJarReset != nil && *config.CookieJarReset == 0 {
+		validationErrors = append(
+			validationErrors,
+			types.NewFieldValidationError("CookieJarReset", "0", errors.New("cookie jar reset must be greater than 0")),
+		)
+	}
+
+	if config.RateOverflow == nil {
+		validationErrors = append(validationErrors, types.NewFieldValidationError("RateOverflow", "", errors.New("rateOverflow field is required")))
+	} else {
+		switch *config.RateOverflow {
+		case ConfigRateOverflowTypeQueue, ConfigRateOverflowTypeDrop, ConfigRateOverflowTypeSpawn:
+		default:
+			validationErrors = append(
+				validationErrors,
+				types.NewFieldValidationError(
+					"RateOverflow",
+					string(*config.RateOverflow),
+					fmt.Errorf(
+						"rate overflow must be one of: %s, %s, %s",
+						ConfigRateOverflowTypeQueue, ConfigRateOverflowTypeDrop, ConfigRateOverflowTypeSpawn,
+					),
+				),
+			)
+		}
+	}
+
+	if config.MaxConcurrency != nil {
+		switch {
+		case *config.MaxConcurrency == 0:
+			validationErrors = append(
+				validationErrors,
+				types.NewFieldValidationError("MaxConcurrency", "0", errors.New("max concurrency must be greater than 0")),
+			)
+		case config.Concurrency != nil && *config.MaxConcurrency < *config.Concurrency:
+			validationErrors = append(
+				validationErrors,
+				types.NewFieldValidationError(
+					"MaxConcurrency",
+					strconv.FormatUint(uint64(*config.MaxConcurrency), 10),
+					errors.New("max concurrency cannot be less than concurrency"),
+				),
+			)
+		}
+	}
+
 	if config.Timeout == nil || *config.Timeout < 1 {
 		validationErrors = append(validationErrors, types.NewFieldValidationError("Timeout", "0", errors.New("timeout must be greater than 0")))
 	}
@@ -497,9 +1156,92 @@ func (config Config) Validate() error {
 		}
 	}
 
+	if len(config.Percentiles) == 0 {
+		validationErrors = append(validationErrors, types.NewFieldValidationError("Percentiles", "", errors.New("at least one percentile is required")))
+	}
+	for i, percentile := range config.Percentiles {
+		if math.IsNaN(percentile) || percentile <= 0 || percentile > 100 {
+			validationErrors = append(
+				validationErrors,
+				types.NewFieldValidationError(
+					fmt.Sprintf("Percentiles[%d]", i),
+					strconv.FormatFloat(percentile, 'f', -1, 64),
+					errors.New("percentile must be greater than 0 and at most 100"),
+				),
+			)
+		}
+	}
+
+	if config.TimelineInterval != nil && *config.TimelineInterval <= 0 {
+		validationErrors = append(
+			validationErrors,
+			types.NewFieldValidationError("TimelineInterval", config.TimelineInterval.String(), errors.New("timeline interval must be greater than 0")),
+		)
+	}
+
+	if config.TimelineFile != nil && *config.TimelineFile != "" {
+		if ext := strings.ToLower(filepath.Ext(*config.TimelineFile)); !slices.Contains(ValidTimelineFileExts, ext) {
+			validationErrors = append(
+				validationErrors,
+				types.NewFieldValidationError(
+					"TimelineFile",
+					*config.TimelineFile,
+					fmt.Errorf("timeline file extension must be one of: %s", strings.Join(ValidTimelineFileExts, ", ")),
+				),
+			)
+		}
+		dir := filepath.Dir(*config.TimelineFile)
+		if info, err := os.Stat(dir); err != nil {
+			validationErrors = append(
+				validationErrors,
+				types.NewFieldValidationError("TimelineFile", *config.TimelineFile, fmt.Errorf("parent directory %q is not accessible", dir)),
+			)
+		} else if !info.IsDir() {
+			validationErrors = append(
+				validationErrors,
+				types.NewFieldValidationError("TimelineFile", *config.TimelineFile, fmt.Errorf("parent path %q is not a directory", dir)),
+			)
+		}
+	}
+
 	if config.Insecure == nil {
 		validationErrors = append(validationErrors, types.NewFieldValidationError("Insecure", "", errors.New("insecure field is required")))
 	}
+	validationErrors = append(validationErrors, validateTLS(config)...)
+
+	if config.Protocol == nil {
+		validationErrors = append(validationErrors, types.NewFieldValidationError("Protocol", "", errors.New("protocol field is required")))
+	} else {
+		switch *config.Protocol {
+		case ConfigProtocolTypeH1, ConfigProtocolTypeH2:
+		case ConfigProtocolTypeH3:
+			validationErrors = append(validationErrors, validateH3(config)...)
+		default:
+			validationErrors = append(
+				validationErrors,
+				types.NewFieldValidationError(
+					"Protocol",
+					string(*config.Protocol),
+					fmt.Errorf("protocol must be one of: %s, %s, %s", ConfigProtocolTypeH1, ConfigProtocolTypeH2, ConfigProtocolTypeH3),
+				),
+			)
+		}
+	}
+	validationErrors = append(validationErrors, validateWebSocket(config)...)
+	validationErrors = append(validationErrors, validateGRPC(config)...)
+	validationErrors = append(validationErrors, validateStream(config)...)
+	if config.H2MaxStreams != nil && *config.H2MaxStreams == 0 {
+		validationErrors = append(
+			validationErrors,
+			types.NewFieldValidationError("H2MaxStreams", "0", errors.New("h2 max streams must be greater than 0")),
+		)
+	}
+	if config.H2Connections != nil && *config.H2Connections == 0 {
+		validationErrors = append(
+			validationErrors,
+			types.NewFieldValidationError("H2Connections", "0", errors.New("h2 connections must be greater than 0")),
+		)
+	}
 
 	if config.DryRun == nil {
 		validationErrors = append(validationErrors, types.NewFieldValidationError("DryRun", "", errors.New("dryRun field is required")))
@@ -548,6 +1290,44 @@ func (config Config) Validate() error {
 		}
 	}
 
+	if config.DNSServer != nil {
+		if _, ok := sarin.ParseDNSServer(*config.DNSServer); !ok {
+			validationErrors = append(
+				validationErrors,
+				types.NewFieldValidationError("DNSServer", *config.DNSServer, errors.New("DNS server must be a host or host:port")),
+			)
+		}
+	}
+
+	for i, localAddress := range config.LocalAddresses {
+		if _, err := sarin.ParseLocalAddress(localAddress); err != nil {
+			validationErrors = append(
+				validationErrors,
+				types.NewFieldValidationError(fmt.Sprintf("LocalAddresses[%d]", i), localAddress, err),
+			)
+		}
+	}
+
+	if config.IPStrategy == nil {
+		validationErrors = append(validationErrors, types.NewFieldValidationError("IPStrategy", "", errors.New("ipStrategy field is required")))
+	} else {
+		switch *config.IPStrategy {
+		case ConfigIPStrategyTypeFirst, ConfigIPStrategyTypeRoundRobin, ConfigIPStrategyTypeRandom, ConfigIPStrategyTypeWorker:
+		default:
+			validationErrors = append(
+				validationErrors,
+				types.NewFieldValidationError(
+					"IPStrategy",
+					string(*config.IPStrategy),
+					fmt.Errorf(
+						"ip strategy must be one of: %s, %s, %s, %s",
+						ConfigIPStrategyTypeFirst, ConfigIPStrategyTypeRoundRobin, ConfigIPStrategyTypeRandom, ConfigIPStrategyTypeWorker,
+					),
+				),
+			)
+		}
+	}
+
 	// Create a context with timeout for script validation (loading from URLs)
 	scriptCtx, scriptCancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer scriptCancel()
@@ -596,6 +1376,491 @@ func (config Config) Validate() error {
 	return nil
 }
 
+// moveURLQueryToParams clears the query of requestURL and returns its values as
+// params, so that they go through the same generators as the other params.
+func moveURLQueryToParams(requestURL *url.URL) types.Params {
+	if requestURL == nil || len(requestURL.Query()) == 0 {
+		return nil
+	}
+
+	urlParams := types.Params{}
+	for key, values := range requestURL.Query() {
+		for _, value := range values {
+			urlParams = append(urlParams, types.Param{
+				Key:   key,
+				Value: []string{value},
+			})
+		}
+	}
+	requestURL.RawQuery = ""
+	return urlParams
+}
+
+func validateRequestURL(field string, requestURL *url.URL) []types.FieldValidationError {
+	switch {
+	case requestURL == nil:
+		return []types.FieldValidationError{types.NewFieldValidationError(field, "", errors.New("URL is required"))}
+	case !slices.Contains(ValidRequestURLSchemes, requestURL.Scheme):
+		return []types.FieldValidationError{types.NewFieldValidationError(field, types.RequestURLString(requestURL), fmt.Errorf("URL scheme must be one of: %s", strings.Join(ValidRequestURLSchemes, ", ")))}
+	case requestURL.Host == "":
+		return []types.FieldValidationError{types.NewFieldValidationError(field, types.RequestURLString(requestURL), errors.New("URL must have a host"))}
+	default:
+		return nil
+	}
+}
+
+// validateH3 checks that the requests can be sent over HTTP/3, which runs over
+// QUIC: every URL must be https, and there must be no proxies, since they only
+// tunnel TCP.
+func validateH3(config Config) []types.FieldValidationError {
+	var validationErrors []types.FieldValidationError
+	if len(config.Proxies) > 0 {
+		validationErrors = append(validationErrors, types.NewFieldValidationError("Protocol", string(ConfigProtocolTypeH3), errors.New("h3 cannot be combined with proxies")))
+	}
+
+	validateScheme := func(field string, requestURL *url.URL) {
+		if requestURL != nil && requestURL.Scheme != "https" {
+			validationErrors = append(validationErrors, types.NewFieldValidationError(field, types.RequestURLString(requestURL), errors.New("URL scheme must be https with protocol h3")))
+		}
+	}
+	validateScheme("URL", config.URL)
+	for i, scenario := range config.Scenarios {
+		validateScheme(fmt.Sprintf("Scenarios[%d].URL", i), scenario.URL)
+	}
+	for i, step := range config.Flow {
+		validateScheme(fmt.Sprintf("Flow[%d].URL", i), step.URL)
+	}
+	return validationErrors
+}
+
+// validateWebSocket checks that WebSocket URLs aren't mixed with HTTP ones,
+// since a run either sends messages or requests, and that they are only used
+// with HTTP/1.1, which the upgrade runs over. The ws match pattern needs them.
+func validateWebSocket(config Config) []types.FieldValidationError {
+	type fieldURL struct {
+		field string
+		url   *url.URL
+	}
+
+	var requestURLs []fieldURL
+	if config.URL != nil {
+		requestURLs = append(requestURLs, fieldURL{"URL", config.URL})
+	}
+	for i, scenario := range config.Scenarios {
+		if scenario.URL != nil {
+			requestURLs = append(requestURLs, fieldURL{fmt.Sprintf("Scenarios[%d].URL", i), scenario.URL})
+		}
+	}
+	for i, step := range config.Flow {
+		if step.URL != nil {
+			requestURLs = append(requestURLs, fieldURL{fmt.Sprintf("Flow[%d].URL", i), step.URL})
+		}
+	}
+
+	isWebSocket := func(requestURL fieldURL) bool {
+		return requestURL.url.Scheme == "ws" || requestURL.url.Scheme == "wss"
+	}
+	if !slices.ContainsFunc(requestURLs, isWebSocket) {
+		if config.WSMatch != nil {
+			return []types.FieldValidationError{
+				types.NewFieldValidationError("WSMatch", config.WSMatch.String(), errors.New("ws match requires ws or wss URLs")),
+			}
+		}
+		return nil
+	}
+
+	var validationErrors []types.FieldValidationError
+	for _, requestURL := range requestURLs {
+		if !isWebSocket(requestURL) {
+			validationErrors = append(
+				validationErrors,
+				types.NewFieldValidationError(requestURL.field, types.RequestURLString(requestURL.url), errors.New("http and https URLs can't be mixed with ws and wss URLs")),
+			)
+		}
+	}
+	if config.Protocol != nil && *config.Protocol != ConfigProtocolTypeH1 {
+		validationErrors = append(
+			validationErrors,
+			types.NewFieldValidationError("Protocol", string(*config.Protocol), errors.New("ws and wss URLs can only be used with protocol h1")),
+		)
+	}
+	if config.Stream != nil && *config.Stream {
+		validationErrors = append(
+			validationErrors,
+			types.NewFieldValidationError("Stream", "true", errors.New("ws and wss URLs can't be used with stream")),
+		)
+	}
+	return validationErrors
+}
+
+// validateGRPC checks that the gRPC method is named as package.Service/Method
+// and is called over HTTP/2, and that proto files and import paths only come
+// with a method.
+func validateGRPC(config Config) []types.FieldValidationError {
+	if config.GRPCMethod == nil {
+		var validationErrors []types.FieldValidationError
+		if len(config.GRPCProto) > 0 {
+			validationErrors = append(
+				validationErrors,
+				types.NewFieldValidationError("GRPCProto", strings.Join(config.GRPCProto, ", "), errors.New("grpc proto requires a grpc method")),
+			)
+		}
+		if len(config.GRPCImportPath) > 0 {
+			validationErrors = append(
+				validationErrors,
+				types.NewFieldValidationError("GRPCImportPath", strings.Join(config.GRPCImportPath, ", "), errors.New("grpc import path requires a grpc method")),
+			)
+		}
+		return validationErrors
+	}
+
+	var validationErrors []types.FieldValidationError
+	service, method, ok := strings.Cut(strings.TrimPrefix(*config.GRPCMethod, "/"), "/")
+	if !ok || service == "" || method == "" || strings.Contains(method, "/") ||
+		strings.HasPrefix(service, ".") || strings.HasSuffix(service, ".") {
+		validationErrors = append(
+			validationErrors,
+			types.NewFieldValidationError("GRPCMethod", *config.GRPCMethod, errors.New("grpc method must be in the form package.Service/Method")),
+		)
+	}
+	if config.Protocol != nil && *config.Protocol != ConfigProtocolTypeH2 {
+		validationErrors = append(
+			validationErrors,
+			types.NewFieldValidationError("Protocol", string(*config.Protocol), errors.New("gRPC methods can only be called with protocol h2")),
+		)
+	}
+	if config.Stream != nil && *config.Stream {
+		validationErrors = append(
+			validationErrors,
+			types.NewFieldValidationError("Stream", "true", errors.New("gRPC methods can't be called with stream")),
+		)
+	}
+	return validationErrors
+}
+
+// validateStream checks that the stream hold is positive and only comes with
+// stream.
+func validateStream(config Config) []types.FieldValidationError {
+	if config.StreamHold == nil {
+		return nil
+	}
+
+	var validationErrors []types.FieldValidationError
+	if config.Stream == nil || !*config.Stream {
+		validationErrors = append(
+			validationErrors,
+			types.NewFieldValidationError("StreamHold", config.StreamHold.String(), errors.New("stream hold requires stream")),
+		)
+	}
+	if *config.StreamHold <= 0 {
+		validationErrors = append(
+			validationErrors,
+			types.NewFieldValidationError("StreamHold", config.StreamHold.String(), errors.New("stream hold must be greater than 0")),
+		)
+	}
+	return validationErrors
+}
+
+// validateTLS checks that every client certificate comes with its key, that
+// the rotation is valid and only comes with client certificates, and that the
+// handshake options are known and fit the protocol.
+func validateTLS(config Config) []types.FieldValidationError {
+	var validationErrors []types.FieldValidationError
+	validationErrors = append(validationErrors, validateTLSHandshake(config)...)
+	for i, cert := range config.TLSCerts {
+		field := fmt.Sprintf("TLSCerts[%d]", i)
+		if cert.Cert == "" {
+			validationErrors = append(
+				validationErrors,
+				types.NewFieldValidationError(field+".Cert", cert.Key, errors.New("client key requires a client certificate")),
+			)
+		}
+		if cert.Key == "" {
+			validationErrors = append(
+				validationErrors,
+				types.NewFieldValidationError(field+".Key", cert.Cert, errors.New("client certificate requires a client key")),
+			)
+		}
+	}
+	for i, ca := range config.TLSCA {
+		if ca == "" {
+			validationErrors = append(
+				validationErrors,
+				types.NewFieldValidationError(fmt.Sprintf("TLSCA[%d]", i), "", errors.New("CA file cannot be empty")),
+			)
+		}
+	}
+
+	if config.TLSRotate == nil {
+		return validationErrors
+	}
+	switch *config.TLSRotate {
+	case ConfigTLSRotateTypeWorker, ConfigTLSRotateTypeRequest:
+	default:
+		validationErrors = append(
+			validationErrors,
+			types.NewFieldValidationError(
+				"TLSRotate",
+				string(*config.TLSRotate),
+				fmt.Errorf("tls rotate must be one of: %s, %s", ConfigTLSRotateTypeWorker, ConfigTLSRotateTypeRequest),
+			),
+		)
+	}
+	if len(config.TLSCerts) == 0 {
+		validationErrors = append(
+			validationErrors,
+			types.NewFieldValidationError("TLSRotate", string(*config.TLSRotate), errors.New("tls rotate requires client certificates")),
+		)
+	}
+	return validationErrors
+}
+
+// validateTLSHandshake checks the TLS versions, cipher suites, curves, server
+// name and ALPN protocols.
+func validateTLSHandshake(config Config) []types.FieldValidationError {
+	var validationErrors []types.FieldValidationError
+	var minVersion, maxVersion uint16
+	for _, version := range []struct {
+		field string
+		value *string
+		id    *uint16
+	}{
+		{"TLSMinVersion", config.TLSMinVersion, &minVersion},
+		{"TLSMaxVersion", config.TLSMaxVersion, &maxVersion},
+	} {
+		if version.value == nil {
+			continue
+		}
+		id, ok := sarin.ParseTLSVersion(*version.value)
+		if !ok {
+			validationErrors = append(
+				validationErrors,
+				types.NewFieldValidationError(version.field, *version.value, errors.New("tls version must be one of: 1.0, 1.1, 1.2, 1.3")),
+			)
+		}
+		*version.id = id
+	}
+	if minVersion != 0 && maxVersion != 0 && minVersion > maxVersion {
+		validationErrors = append(
+			validationErrors,
+			types.NewFieldValidationError("TLSMinVersion", *config.TLSMinVersion, errors.New("tls min version cannot be greater than tls max version")),
+		)
+	}
+	if maxVersion != 0 && maxVersion < tls.VersionTLS13 && config.Protocol != nil && *config.Protocol == ConfigProtocolTypeH3 {
+		validationErrors = append(
+			validationErrors,
+			types.NewFieldValidationError("TLSMaxVersion", *config.TLSMaxVersion, errors.New("protocol h3 requires tls version 1.3")),
+		)
+	}
+
+	for i, cipher := range config.TLSCiphers {
+		if _, ok := sarin.ParseTLSCipherSuite(cipher); !ok {
+			validationErrors = append(
+				validationErrors,
+				types.NewFieldValidationError(
+					fmt.Sprintf("TLSCiphers[%d]", i),
+					cipher,
+					errors.New("tls cipher must be the IANA name of a TLS 1.0-1.2 cipher suite (e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256)"),
+				),
+			)
+		}
+	}
+	for i, curve := range config.TLSCurves {
+		if _, ok := sarin.ParseTLSCurve(curve); !ok {
+			validationErrors = append(
+				validationErrors,
+				types.NewFieldValidationError(
+					fmt.Sprintf("TLSCurves[%d]", i),
+					curve,
+					fmt.Errorf("tls curve must be one of: %s", strings.Join(sarin.TLSCurveNames(), ", ")),
+				),
+			)
+		}
+	}
+
+	if config.TLSServerName != nil && *config.TLSServerName == "" {
+		validationErrors = append(
+			validationErrors,
+			types.NewFieldValidationError("TLSServerName", "", errors.New("tls server name cannot be empty")),
+		)
+	}
+
+	// The requests are sent over HTTP/1.x whatever the server picks, so only
+	// those protocols may be offered.
+	for i, protocol := range config.TLSALPN {
+		if protocol != "http/1.1" && protocol != "http/1.0" {
+			validationErrors = append(
+				validationErrors,
+				types.NewFieldValidationError(fmt.Sprintf("TLSALPN[%d]", i), protocol, errors.New("tls alpn protocol must be one of: http/1.1, http/1.0")),
+			)
+		}
+	}
+	if len(config.TLSALPN) > 0 && config.Protocol != nil && *config.Protocol != ConfigProtocolTypeH1 {
+		validationErrors = append(
+			validationErrors,
+			types.NewFieldValidationError("TLSALPN", strings.Join(config.TLSALPN, ", "), errors.New("tls alpn can only be used with protocol h1")),
+		)
+	}
+	return validationErrors
+}
+
+// validateScenarios checks the scenarios on their own. A scenario without a URL
+// uses the top-level one, which is validated separately.
+func validateScenarios(scenarios types.Scenarios) []types.FieldValidationError {
+	var validationErrors []types.FieldValidationError
+	names := make(map[string]bool, len(scenarios))
+	for i, scenario := range scenarios {
+		field := fmt.Sprintf("Scenarios[%d]", i)
+
+		if names[scenario.Name] {
+			validationErrors = append(validationErrors, types.NewFieldValidationError(field+".Name", scenario.Name, errors.New("scenario names must be unique")))
+		}
+		names[scenario.Name] = true
+
+		if scenario.Weight != nil && *scenario.Weight == 0 {
+			validationErrors = append(validationErrors, types.NewFieldValidationError(field+".Weight", "0", errors.New("scenario weight must be greater than 0")))
+		}
+
+		if scenario.URL != nil {
+			validationErrors = append(validationErrors, validateRequestURL(field+".URL", scenario.URL)...)
+		}
+	}
+	return validationErrors
+}
+
+// validateFlow checks the flow steps on their own. A step without a URL uses
+// the top-level one, which is validated separately.
+func validateFlow(flow types.Flow, hasScenarios bool) []types.FieldValidationError {
+	if len(flow) == 0 {
+		return nil
+	}
+
+	var validationErrors []types.FieldValidationError
+	if hasScenarios {
+		validationErrors = append(validationErrors, types.NewFieldValidationError("Flow", "", errors.New("flow cannot be combined with scenarios")))
+	}
+
+	names := make(map[string]bool, len(flow))
+	for i, step := range flow {
+		field := fmt.Sprintf("Flow[%d]", i)
+
+		if names[step.Name] {
+			validationErrors = append(validationErrors, types.NewFieldValidationError(field+".Name", step.Name, errors.New("step names must be unique")))
+		}
+		names[step.Name] = true
+
+		if step.URL != nil {
+			validationErrors = append(validationErrors, validateRequestURL(field+".URL", step.URL)...)
+		}
+
+		for j, extractor := range step.Extract {
+			extractorField := fmt.Sprintf("%s.Extract[%d]", field, j)
+			if extractor.Name == "" {
+				validationErrors = append(validationErrors, types.NewFieldValidationError(extractorField+".Name", "", errors.New("extractor name is required")))
+			}
+			if extractor.Expression == "" {
+				validationErrors = append(validationErrors, types.NewFieldValidationError(extractorField, "", fmt.Errorf("extractor %s cannot be empty", extractor.Source)))
+			}
+		}
+	}
+	return validationErrors
+}
+
+func validateStages(stages types.Stages, hasRate bool) []types.FieldValidationError {
+	if len(stages) == 0 {
+		return nil
+	}
+
+	validationErrors := make([]types.FieldValidationError, 0)
+	if hasRate {
+		validationErrors = append(validationErrors, types.NewFieldValidationError("Stages", "", errors.New("stages cannot be combined with rate")))
+	}
+
+	byRate := stages.ByRate()
+	hasTarget := false
+	for i, stage := range stages {
+		field := fmt.Sprintf("Stages[%d]", i)
+
+		if stage.Duration <= 0 {
+			validationErrors = append(validationErrors, types.NewFieldValidationError(field+".Duration", stage.Duration.String(), errors.New("stage duration must be greater than 0")))
+		}
+
+		var target *uint
+		switch {
+		case stage.Rate != nil && stage.Concurrency != nil:
+			validationErrors = append(validationErrors, types.NewFieldValidationError(field, "", errors.New("stage must set either rate or concurrency, not both")))
+			continue
+		case stage.Rate == nil && stage.Concurrency == nil:
+			validationErrors = append(validationErrors, types.NewFieldValidationError(field, "", errors.New("stage must set either rate or concurrency")))
+			continue
+		case (stage.Rate != nil) != byRate:
+			validationErrors = append(validationErrors, types.NewFieldValidationError(field, "", errors.New("all stages must target the same kind (rate or concurrency)")))
+			continue
+		case stage.Rate != nil:
+			target = stage.Rate
+		default:
+			target = stage.Concurrency
+		}
+
+		if *target > 100_000_000 {
+			validationErrors = append(validationErrors, types.NewFieldValidationError(field, strconv.FormatUint(uint64(*target), 10), errors.New("stage target must not exceed 100,000,000")))
+		}
+		if *target > 0 {
+			hasTarget = true
+		}
+	}
+
+	if !hasTarget {
+		validationErrors = append(validationErrors, types.NewFieldValidationError("Stages", "", errors.New("at least one stage must have a target greater than 0")))
+	}
+
+	return validationErrors
+}
+
+func validateAssertions(assertions *types.Assertions) []types.FieldValidationError {
+	if assertions == nil {
+		return nil
+	}
+
+	validationErrors := make([]types.FieldValidationError, 0)
+	for i, status := range assertions.Status {
+		if !status.IsValid() {
+			validationErrors = append(
+				validationErrors,
+				types.NewFieldValidationError(
+					fmt.Sprintf("Assertions.Status[%d]", i),
+					string(status),
+					errors.New("status must be a status code (e.g. 200) or a status class (e.g. 2xx)"),
+				),
+			)
+		}
+	}
+
+	for i, header := range assertions.Headers {
+		field := fmt.Sprintf("Assertions.Headers[%d]", i)
+		if header.Name == "" {
+			validationErrors = append(validationErrors, types.NewFieldValidationError(field+".Name", "", errors.New("header name is required")))
+		}
+		if header.Equals != nil && header.Contains != nil {
+			validationErrors = append(validationErrors, types.NewFieldValidationError(field, header.Name, errors.New("header assertion must set either equals or contains, not both")))
+		}
+	}
+
+	for i, jsonField := range assertions.JSON {
+		field := fmt.Sprintf("Assertions.JSON[%d]", i)
+		if jsonField.Path == "" {
+			validationErrors = append(validationErrors, types.NewFieldValidationError(field+".Path", "", errors.New("JSON path is required")))
+		}
+		if jsonField.Equals != nil {
+			if _, err := json.Marshal(*jsonField.Equals); err != nil {
+				validationErrors = append(validationErrors, types.NewFieldValidationError(field+".Equals", jsonField.Path, fmt.Errorf("expected value must be representable as JSON: %w", err)))
+			}
+		}
+	}
+
+	return validationErrors
+}
+
 func ReadAllConfigs() *Config {
 	envParser := NewConfigENVParser("SARIN")
 	envConfig, err := envParser.Parse()
@@ -0,0 +1,198 @@
+package config
+
+import (
+	"errors"
+	"maps"
+	"math"
+	"net/url"
+	"slices"
+	"strconv"
+	"testing"
+
+	"go.aykhans.me/sarin/internal/types"
+)
+
+// newTestConfig returns a valid config that sends one request to
+// http://example.com, after edit has changed it and the defaults are set.
+func newTestConfig(t *testing.T, edit func(config *Config)) Config {
+	t.Helper()
+
+	requestURL, err := url.Parse("http://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := Config{URL: requestURL, Requests: new(uint64(1))}
+	edit(&config)
+	config.SetDefaults()
+	return config
+}
+
+// validationErrors validates config and returns the fields that failed, with
+// their errors.
+func validationErrors(t *testing.T, config Config) map[string]error {
+	t.Helper()
+
+	err := config.Validate()
+	if err == nil {
+		return nil
+	}
+	fieldErrs, ok := errors.AsType[types.FieldValidationErrors](err)
+	if !ok {
+		t.Fatalf("got error %v, want a types.FieldValidationErrors", err)
+	}
+	fields := make(map[string]error, len(fieldErrs.Errors))
+	for _, fieldErr := range fieldErrs.Errors {
+		fields[fieldErr.Field] = fieldErr.Err
+	}
+	return fields
+}
+
+func TestValidatePercentiles(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		percentile float64
+		valid      bool
+	}{
+		{percentile: 50, valid: true},
+		{percentile: 99.99, valid: true},
+		{percentile: 100, valid: true},
+		{percentile: 0.001, valid: true},
+		{percentile: 0, valid: false},
+		{percentile: -1, valid: false},
+		{percentile: 100.5, valid: false},
+		{percentile: math.Inf(1), valid: false},
+		{percentile: math.NaN(), valid: false},
+	}
+
+	for _, test := range tests {
+		t.Run(strconv.FormatFloat(test.percentile, 'f', -1, 64), func(t *testing.T) {
+			t.Parallel()
+
+			config := newTestConfig(t, func(config *Config) {
+				config.Percentiles = types.Percentiles{50, test.percentile}
+			})
+			fields := validationErrors(t, config)
+			if test.valid && fields != nil {
+				t.Errorf("got validation errors %v for a valid percentile", fields)
+			}
+			if _, failed := fields["Percentiles[1]"]; !test.valid && (!failed || len(fields) != 1) {
+				t.Errorf("got validation errors %v, want one for Percentiles[1]", fields)
+			}
+		})
+	}
+}
+
+func TestValidateNaNPercentileFromCLI(t *testing.T) {
+	t.Parallel()
+
+	// ParseFloat accepts NaN, so it gets as far as validation.
+	var percentiles types.Percentiles
+	if err := percentiles.Parse("p50,NaN"); err != nil {
+		t.Fatal(err)
+	}
+	config := newTestConfig(t, func(config *Config) { config.Percentiles = percentiles })
+	if _, failed := validationErrors(t, config)["Percentiles[1]"]; !failed {
+		t.Error("a NaN percentile passed validation")
+	}
+}
+
+func TestValidateTLSHandshake(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		edit func(config *Config)
+		// wantFields are the fields that fail validation.
+		wantFields []string
+	}{
+		{
+			name: "Valid",
+			edit: func(config *Config) {
+				config.TLSMinVersion = new("1.2")
+				config.TLSMaxVersion = new("1.3")
+				config.TLSCiphers = []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}
+				config.TLSCurves = []string{"X25519", "P-256"}
+				config.TLSServerName = new("api.internal")
+				config.TLSALPN = []string{"http/1.1", "http/1.0"}
+				config.Protocol = new(ConfigProtocolTypeH1)
+			},
+		},
+		{
+			name:       "Unknown versions",
+			edit:       func(config *Config) { config.TLSMinVersion, config.TLSMaxVersion = new("1.4"), new("TLS1.2") },
+			wantFields: []string{"TLSMaxVersion", "TLSMinVersion"},
+		},
+		{
+			name:       "Min version above max version",
+			edit:       func(config *Config) { config.TLSMinVersion, config.TLSMaxVersion = new("1.3"), new("1.2") },
+			wantFields: []string{"TLSMinVersion"},
+		},
+		{
+			name: "Max version below 1.3 with h3",
+			edit: func(config *Config) {
+				config.URL.Scheme = "https"
+				config.TLSMaxVersion = new("1.2")
+				config.Protocol = new(ConfigProtocolTypeH3)
+			},
+			wantFields: []string{"TLSMaxVersion"},
+		},
+		{
+			name: "Unknown and TLS 1.3 cipher suites",
+			edit: func(config *Config) {
+				config.TLSCiphers = []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_AES_128_GCM_SHA256", "AES128-GCM-SHA256"}
+			},
+			wantFields: []string{"TLSCiphers[1]", "TLSCiphers[2]"},
+		},
+		{
+			name:       "Unknown curve",
+			edit:       func(config *Config) { config.TLSCurves = []string{"P-192", "X25519"} },
+			wantFields: []string{"TLSCurves[0]"},
+		},
+		{
+			name:       "Empty server name",
+			edit:       func(config *Config) { config.TLSServerName = new("") },
+			wantFields: []string{"TLSServerName"},
+		},
+		{
+			// The requests are sent over HTTP/1.x, so a server that picked
+			// h2 couldn't be spoken to.
+			name:       "h2 in ALPN",
+			edit:       func(config *Config) { config.TLSALPN = []string{"http/1.1", "h2"} },
+			wantFields: []string{"TLSALPN[1]"},
+		},
+		{
+			name:       "Unknown ALPN protocol",
+			edit:       func(config *Config) { config.TLSALPN = []string{"spdy/3"} },
+			wantFields: []string{"TLSALPN[0]"},
+		},
+		{
+			name: "ALPN with h2",
+			edit: func(config *Config) {
+				config.TLSALPN = []string{"http/1.1"}
+				config.Protocol = new(ConfigProtocolTypeH2)
+			},
+			wantFields: []string{"TLSALPN"},
+		},
+		{
+			name: "h2 in ALPN with h2",
+			edit: func(config *Config) {
+				config.TLSALPN = []string{"h2"}
+				config.Protocol = new(ConfigProtocolTypeH2)
+			},
+			wantFields: []string{"TLSALPN", "TLSALPN[0]"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			fields := validationErrors(t, newTestConfig(t, test.edit))
+			got := slices.Sorted(maps.Keys(fields))
+			if !slices.Equal(got, test.wantFields) {
+				t.Errorf("got validation errors %v, want them for %v", fields, test.wantFields)
+			}
+		})
+	}
+}
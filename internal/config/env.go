@@ -2,8 +2,8 @@ package config
 
 import (
 	"errors"
-	"net/url"
 	"os"
+	"regexp"
 	"time"
 
 	"go.aykhans.me/sarin/internal/types"
@@ -97,6 +97,58 @@ func (parser ConfigENVParser) Parse() (*Config, error) {
 		}
 	}
 
+	if rate := parser.getEnv("RATE"); rate != "" {
+		rateParsed, err := utilsParse.ParseString[uint](rate)
+		if err != nil {
+			fieldParseErrors = append(
+				fieldParseErrors,
+				types.NewFieldParseError(
+					parser.getFullEnvName("RATE"),
+					rate,
+					errors.New("invalid value for unsigned integer"),
+				),
+			)
+		} else {
+			config.Rate = &rateParsed
+		}
+	}
+
+	if rateOverflow := parser.getEnv("RATE_OVERFLOW"); rateOverflow != "" {
+		config.RateOverflow = new(ConfigRateOverflowType(rateOverflow))
+	}
+
+	if maxConcurrency := parser.getEnv("MAX_CONCURRENCY"); maxConcurrency != "" {
+		maxConcurrencyParsed, err := utilsParse.ParseString[uint](maxConcurrency)
+		if err != nil {
+			fieldParseErrors = append(
+				fieldParseErrors,
+				types.NewFieldParseError(
+					parser.getFullEnvName("MAX_CONCURRENCY"),
+					maxConcurrency,
+					errors.New("invalid value for unsigned integer"),
+				),
+			)
+		} else {
+			config.MaxConcurrency = &maxConcurrencyParsed
+		}
+	}
+
+	if sendInterval := parser.getEnv("SEND_INTERVAL"); sendInterval != "" {
+		sendIntervalParsed, err := utilsParse.ParseString[time.Duration](sendInterval)
+		if err != nil {
+			fieldParseErrors = append(
+				fieldParseErrors,
+				types.NewFieldParseError(
+					parser.getFullEnvName("SEND_INTERVAL"),
+					sendInterval,
+					errors.New("invalid value for duration, expected a duration string (e.g., '10s', '1h30m')"),
+				),
+			)
+		} else {
+			config.SendInterval = &sendIntervalParsed
+		}
+	}
+
 	if logLevel := parser.getEnv("LOG_LEVEL"); logLevel != "" {
 		config.LogLevel = new(logLevel)
 	}
@@ -113,6 +165,101 @@ func (parser ConfigENVParser) Parse() (*Config, error) {
 		config.Output = new(ConfigOutputType(output))
 	}
 
+	if percentiles := parser.getEnv("PERCENTILES"); percentiles != "" {
+		if err := config.Percentiles.Parse(percentiles); err != nil {
+			fieldParseErrors = append(
+				fieldParseErrors,
+				types.NewFieldParseError(parser.getFullEnvName("PERCENTILES"), percentiles, err),
+			)
+		}
+	}
+
+	if remoteIPStats := parser.getEnv("REMOTE_IP_STATS"); remoteIPStats != "" {
+		remoteIPStatsParsed, err := utilsParse.ParseString[bool](remoteIPStats)
+		if err != nil {
+			fieldParseErrors = append(
+				fieldParseErrors,
+				types.NewFieldParseError(
+					parser.getFullEnvName("REMOTE_IP_STATS"),
+					remoteIPStats,
+					errors.New("invalid value for boolean, expected 'true' or 'false'"),
+				),
+			)
+		} else {
+			config.RemoteIPStats = &remoteIPStatsParsed
+		}
+	}
+
+	if timelineInterval := parser.getEnv("TIMELINE_INTERVAL"); timelineInterval != "" {
+		timelineIntervalParsed, err := utilsParse.ParseString[time.Duration](timelineInterval)
+		if err != nil {
+			fieldParseErrors = append(
+				fieldParseErrors,
+				types.NewFieldParseError(
+					parser.getFullEnvName("TIMELINE_INTERVAL"),
+					timelineInterval,
+					errors.New("invalid value for timeline interval, expected a duration string (e.g., '1s', '10s')"),
+				),
+			)
+		} else {
+			config.TimelineInterval = &timelineIntervalParsed
+		}
+	}
+
+	if timelineFile := parser.getEnv("TIMELINE_FILE"); timelineFile != "" {
+		config.TimelineFile = new(timelineFile)
+	}
+
+	if abortOn := parser.getEnv("ABORT_ON"); abortOn != "" {
+		if err := config.AbortOn.Parse(abortOn); err != nil {
+			fieldParseErrors = append(
+				fieldParseErrors,
+				types.NewFieldParseError(parser.getFullEnvName("ABORT_ON"), abortOn, err),
+			)
+		}
+	}
+
+	if abortWindow := parser.getEnv("ABORT_WINDOW"); abortWindow != "" {
+		abortWindowParsed, err := utilsParse.ParseString[time.Duration](abortWindow)
+		if err != nil {
+			fieldParseErrors = append(
+				fieldParseErrors,
+				types.NewFieldParseError(
+					parser.getFullEnvName("ABORT_WINDOW"),
+					abortWindow,
+					errors.New("invalid value for abort window, expected a duration string (e.g., '10s', '1m')"),
+				),
+			)
+		} else {
+			config.AbortWindow = &abortWindowParsed
+		}
+	}
+
+	if abortErrors := parser.getEnv("ABORT_ERRORS"); abortErrors != "" {
+		abortErrorsParsed, err := utilsParse.ParseString[uint](abortErrors)
+		if err != nil {
+			fieldParseErrors = append(
+				fieldParseErrors,
+				types.NewFieldParseError(
+					parser.getFullEnvName("ABORT_ERRORS"),
+					abortErrors,
+					errors.New("invalid value for unsigned integer"),
+				),
+			)
+		} else {
+			config.AbortErrors = &abortErrorsParsed
+		}
+	}
+
+	if threshold := parser.getEnv("THRESHOLD"); threshold != "" {
+		if err := config.Thresholds.Parse(threshold); err != nil {
+			fieldParseErrors = append(
+				fieldParseErrors,
+				types.NewFieldParseError(parser.getFullEnvName("THRESHOLD"), threshold, err),
+			)
+		}
+	}
+
 	if dryRun := parser.getEnv("DRY_RUN"); dryRun != "" {
 		dryRunParsed, err := utilsParse.ParseString[bool](dryRun)
 		if err != nil {
@@ -130,7 +277,7 @@ func (parser ConfigENVParser) Parse() (*Config, error) {
 	}
 
 	if urlEnv := parser.getEnv("URL"); urlEnv != "" {
-		urlEnvParsed, err := url.Parse(urlEnv)
+		urlEnvParsed, err := types.ParseRequestURL(urlEnv)
 		if err != nil {
 			fieldParseErrors = append(
 				fieldParseErrors,
@@ -161,6 +308,38 @@ func (parser ConfigENVParser) Parse() (*Config, error) {
 		config.Cookies.Parse(cookie)
 	}
 
+	if cookieJar := parser.getEnv("COOKIE_JAR"); cookieJar != "" {
+		cookieJarParsed, err := utilsParse.ParseString[bool](cookieJar)
+		if err != nil {
+			fieldParseErrors = append(
+				fieldParseErrors,
+				types.NewFieldParseError(
+					parser.getFullEnvName("COOKIE_JAR"),
+					cookieJar,
+					errors.New("invalid value for boolean, expected 'true' or 'false'"),
+				),
+			)
+		} else {
+			config.CookieJar = &cookieJarParsed
+		}
+	}
+
+	if cookieJarReset := parser.getEnv("COOKIE_JAR_RESET"); cookieJarReset != "" {
+		cookieJarResetParsed, err := utilsParse.ParseString[uint](cookieJarReset)
+		if err != nil {
+			fieldParseErrors = append(
+				fieldParseErrors,
+				types.NewFieldParseError(
+					parser.getFullEnvName("COOKIE_JAR_RESET"),
+					cookieJarReset,
+					errors.New("invalid value for unsigned integer"),
+				),
+			)
+		} else {
+			config.CookieJarReset = &cookieJarResetParsed
+		}
+	}
+
 	if proxy := parser.getEnv("PROXY"); proxy != "" {
 		err := config.Proxies.Parse(proxy)
 		if err != nil {
@@ -175,6 +354,48 @@ func (parser ConfigENVParser) Parse() (*Config, error) {
 		}
 	}
 
+	if resolve := parser.getEnv("RESOLVE"); resolve != "" {
+		err := config.Resolve.Parse(resolve)
+		if err != nil {
+			fieldParseErrors = append(
+				fieldParseErrors,
+				types.NewFieldParseError(
+					parser.getFullEnvName("RESOLVE"),
+					resolve,
+					err,
+				),
+			)
+		}
+	}
+
+	if dnsServer := parser.getEnv("DNS_SERVER"); dnsServer != "" {
+		config.DNSServer = new(dnsServer)
+	}
+
+	if dnsCache := parser.getEnv("DNS_CACHE"); dnsCache != "" {
+		dnsCacheParsed, err := utilsParse.ParseString[bool](dnsCache)
+		if err != nil {
+			fieldParseErrors = append(
+				fieldParseErrors,
+				types.NewFieldParseError(
+					parser.getFullEnvName("DNS_CACHE"),
+					dnsCache,
+					errors.New("invalid value for boolean, expected 'true' or 'false'"),
+				),
+			)
+		} else {
+			config.DNSCache = &dnsCacheParsed
+		}
+	}
+
+	if ipStrategy := parser.getEnv("IP_STRATEGY"); ipStrategy != "" {
+		config.IPStrategy = new(ConfigIPStrategyType(ipStrategy))
+	}
+
+	if localAddress := parser.getEnv("LOCAL_ADDRESS"); localAddress != "" {
+		config.LocalAddresses = []string{localAddress}
+	}
+
 	if values := parser.getEnv("VALUES"); values != "" {
 		config.Values = []string{values}
 	}
@@ -211,6 +432,151 @@ func (parser ConfigENVParser) Parse() (*Config, error) {
 		}
 	}
 
+	tlsCert, tlsKey := parser.getEnv("TLS_CERT"), parser.getEnv("TLS_KEY")
+	if tlsCert != "" || tlsKey != "" {
+		config.TLSCerts = []types.TLSClientCert{{Cert: tlsCert, Key: tlsKey}}
+	}
+
+	if tlsCA := parser.getEnv("TLS_CA"); tlsCA != "" {
+		config.TLSCA = []string{tlsCA}
+	}
+
+	if tlsRotate := parser.getEnv("TLS_ROTATE"); tlsRotate != "" {
+		config.TLSRotate = new(ConfigTLSRotateType(tlsRotate))
+	}
+
+	if tlsMinVersion := parser.getEnv("TLS_MIN_VERSION"); tlsMinVersion != "" {
+		config.TLSMinVersion = new(tlsMinVersion)
+	}
+
+	if tlsMaxVersion := parser.getEnv("TLS_MAX_VERSION"); tlsMaxVersion != "" {
+		config.TLSMaxVersion = new(tlsMaxVersion)
+	}
+
+	if tlsCipher := parser.getEnv("TLS_CIPHER"); tlsCipher != "" {
+		config.TLSCiphers = []string{tlsCipher}
+	}
+
+	if tlsCurve := parser.getEnv("TLS_CURVE"); tlsCurve != "" {
+		config.TLSCurves = []string{tlsCurve}
+	}
+
+	if tlsServerName := parser.getEnv("TLS_SERVER_NAME"); tlsServerName != "" {
+		config.TLSServerName = new(tlsServerName)
+	}
+
+	if tlsALPN := parser.getEnv("TLS_ALPN"); tlsALPN != "" {
+		config.TLSALPN = []string{tlsALPN}
+	}
+
+	if tlsSessionReuse := parser.getEnv("TLS_SESSION_REUSE"); tlsSessionReuse != "" {
+		tlsSessionReuseParsed, err := utilsParse.ParseString[bool](tlsSessionReuse)
+		if err != nil {
+			fieldParseErrors = append(
+				fieldParseErrors,
+				types.NewFieldParseError(
+					parser.getFullEnvName("TLS_SESSION_REUSE"),
+					tlsSessionReuse,
+					errors.New("invalid value for boolean, expected 'true' or 'false'"),
+				),
+			)
+		} else {
+			config.TLSSessionReuse = &tlsSessionReuseParsed
+		}
+	}
+
+	if protocol := parser.getEnv("PROTOCOL"); protocol != "" {
+		config.Protocol = new(ConfigProtocolType(protocol))
+	}
+
+	if h2MaxStreams := parser.getEnv("H2_MAX_STREAMS"); h2MaxStreams != "" {
+		h2MaxStreamsParsed, err := utilsParse.ParseString[uint](h2MaxStreams)
+		if err != nil {
+			fieldParseErrors = append(
+				fieldParseErrors,
+				types.NewFieldParseError(
+					parser.getFullEnvName("H2_MAX_STREAMS"),
+					h2MaxStreams,
+					errors.New("invalid value for unsigned integer"),
+				),
+			)
+		} else {
+			config.H2MaxStreams = &h2MaxStreamsParsed
+		}
+	}
+
+	if h2Connections := parser.getEnv("H2_CONNECTIONS"); h2Connections != "" {
+		h2ConnectionsParsed, err := utilsParse.ParseString[uint](h2Connections)
+		if err != nil {
+			fieldParseErrors = append(
+				fieldParseErrors,
+				types.NewFieldParseError(
+					parser.getFullEnvName("H2_CONNECTIONS"),
+					h2Connections,
+					errors.New("invalid value for unsigned integer"),
+				),
+			)
+		} else {
+			config.H2Connections = &h2ConnectionsParsed
+		}
+	}
+
+	if wsMatch := parser.getEnv("WS_MATCH"); wsMatch != "" {
+		pattern, err := regexp.Compile(wsMatch)
+		if err != nil {
+			fieldParseErrors = append(
+				fieldParseErrors,
+				types.NewFieldParseError(parser.getFullEnvName("WS_MATCH"), wsMatch, err),
+			)
+		} else {
+			config.WSMatch = pattern
+		}
+	}
+
+	if grpcMethod := parser.getEnv("GRPC_METHOD"); grpcMethod != "" {
+		config.GRPCMethod = new(grpcMethod)
+	}
+
+	if grpcProto := parser.getEnv("GRPC_PROTO"); grpcProto != "" {
+		config.GRPCProto = []string{grpcProto}
+	}
+
+	if grpcImportPath := parser.getEnv("GRPC_IMPORT_PATH"); grpcImportPath != "" {
+		config.GRPCImportPath = []string{grpcImportPath}
+	}
+
+	if stream := parser.getEnv("STREAM"); stream != "" {
+		streamParsed, err := utilsParse.ParseString[bool](stream)
+		if err != nil {
+			fieldParseErrors = append(
+				fieldParseErrors,
+				types.NewFieldParseError(
+					parser.getFullEnvName("STREAM"),
+					stream,
+					errors.New("invalid value for boolean, expected 'true' or 'false'"),
+				),
+			)
+		} else {
+			config.Stream = &streamParsed
+		}
+	}
+
+	if streamHold := parser.getEnv("STREAM_HOLD"); streamHold != "" {
+		streamHoldParsed, err := utilsParse.ParseString[time.Duration](streamHold)
+		if err != nil {
+			fieldParseErrors = append(
+				fieldParseErrors,
+				types.NewFieldParseError(
+					parser.getFullEnvName("STREAM_HOLD"),
+					streamHold,
+					errors.New("invalid value for duration, expected a duration string (e.g., '10s', '1h30m')"),
+				),
+			)
+		} else {
+			config.StreamHold = &streamHoldParsed
+		}
+	}
+
 	if lua := parser.getEnv("LUA"); lua != "" {
 		config.Lua = []string{lua}
 	}
@@ -2,12 +2,13 @@ package config
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 
@@ -191,29 +192,133 @@ func (kv *keyValuesField) unmarshalMapping(node *yaml.Node) error {
 	return nil
 }
 
+type headerAssertionYAML struct {
+	Name     string  `yaml:"name"`
+	Equals   *string `yaml:"equals"`
+	Contains *string `yaml:"contains"`
+}
+
+type jsonAssertionYAML struct {
+	Path string `yaml:"path"`
+	// Equals is kept as a node so that a missing value can be told apart
+	// from an explicit null.
+	Equals yaml.Node `yaml:"equals"`
+}
+
+type assertionsYAML struct {
+	Status      stringOrSliceField    `yaml:"status"`
+	Headers     []headerAssertionYAML `yaml:"headers"`
+	Body        stringOrSliceField    `yaml:"body"`
+	JSON        []jsonAssertionYAML   `yaml:"json"`
+	MaxBodySize *uint64               `yaml:"maxBodySize"`
+}
+
+// requestYAML holds the request fields that scenarios and flow steps can set.
+type requestYAML struct {
+	URL     *string            `yaml:"url"`
+	Method  stringOrSliceField `yaml:"method"`
+	Bodies  stringOrSliceField `yaml:"body"`
+	Params  keyValuesField     `yaml:"params"`
+	Headers keyValuesField     `yaml:"headers"`
+	Cookies keyValuesField     `yaml:"cookies"`
+}
+
+type scenarioYAML struct {
+	Name        string `yaml:"name"`
+	Weight      *uint  `yaml:"weight"`
+	requestYAML `yaml:",inline"`
+}
+
+type extractorYAML struct {
+	Name   string  `yaml:"name"`
+	JSON   *string `yaml:"json"`
+	Regex  *string `yaml:"regex"`
+	Header *string `yaml:"header"`
+	Cookie *string `yaml:"cookie"`
+}
+
+type flowStepYAML struct {
+	Name        string          `yaml:"name"`
+	Extract     []extractorYAML `yaml:"extract"`
+	requestYAML `yaml:",inline"`
+}
+
+type stageYAML struct {
+	Duration    *time.Duration `yaml:"duration"`
+	Rate        *uint          `yaml:"rate"`
+	Concurrency *uint          `yaml:"concurrency"`
+}
+
+type tlsCertYAML struct {
+	Cert string `yaml:"cert"`
+	Key  string `yaml:"key"`
+}
+
 type configYAML struct {
-	ShowConfig   *bool              `yaml:"showConfig"`
-	ConfigFiles  stringOrSliceField `yaml:"configFile"`
-	Concurrency  *uint              `yaml:"concurrency"`
-	RequestCount *uint64            `yaml:"requests"`
-	Duration     *time.Duration     `yaml:"duration"`
-	LogLevel     *string            `yaml:"logLevel"`
-	LogFile      *string            `yaml:"logFile"`
-	Progress     *string            `yaml:"progress"`
-	Output       *string            `yaml:"output"`
-	DryRun       *bool              `yaml:"dryRun"`
-	URL          *string            `yaml:"url"`
-	Method       stringOrSliceField `yaml:"method"`
-	Bodies       stringOrSliceField `yaml:"body"`
-	Params       keyValuesField     `yaml:"params"`
-	Headers      keyValuesField     `yaml:"headers"`
-	Cookies      keyValuesField     `yaml:"cookies"`
-	Proxies      stringOrSliceField `yaml:"proxy"`
-	Values       stringOrSliceField `yaml:"values"`
-	Timeout      *time.Duration     `yaml:"timeout"`
-	Insecure     *bool              `yaml:"insecure"`
-	Lua          stringOrSliceField `yaml:"lua"`
-	Js           stringOrSliceField `yaml:"js"`
+	ShowConfig       *bool              `yaml:"showConfig"`
+	ConfigFiles      stringOrSliceField `yaml:"configFile"`
+	Concurrency      *uint              `yaml:"concurrency"`
+	RequestCount     *uint64            `yaml:"requests"`
+	Duration         *time.Duration     `yaml:"duration"`
+	Rate             *uint              `yaml:"rate"`
+	RateOverflow     *string            `yaml:"rateOverflow"`
+	MaxConcurrency   *uint              `yaml:"maxConcurrency"`
+	Stages           []stageYAML        `yaml:"stages"`
+	SendInterval     *time.Duration     `yaml:"sendInterval"`
+	LogLevel         *string            `yaml:"logLevel"`
+	LogFile          *string            `yaml:"logFile"`
+	Progress         *string            `yaml:"progress"`
+	Output           *string            `yaml:"output"`
+	Percentiles      stringOrSliceField `yaml:"percentiles"`
+	RemoteIPStats    *bool              `yaml:"remoteIPStats"`
+	TimelineInterval *time.Duration     `yaml:"timelineInterval"`
+	TimelineFile     *string            `yaml:"timelineFile"`
+	Thresholds       stringOrSliceField `yaml:"thresholds"`
+	Assertions       *assertionsYAML    `yaml:"assertions"`
+	AbortOn          stringOrSliceField `yaml:"abortOn"`
+	AbortWindow      *time.Duration     `yaml:"abortWindow"`
+	AbortErrors      *uint              `yaml:"abortErrors"`
+	DryRun           *bool              `yaml:"dryRun"`
+	URL              *string            `yaml:"url"`
+	Method           stringOrSliceField `yaml:"method"`
+	Bodies           stringOrSliceField `yaml:"body"`
+	Params           keyValuesField     `yaml:"params"`
+	Headers          keyValuesField     `yaml:"headers"`
+	Cookies          keyValuesField     `yaml:"cookies"`
+	CookieJar        *bool              `yaml:"cookieJar"`
+	CookieJarReset   *uint              `yaml:"cookieJarReset"`
+	Scenarios        []scenarioYAML     `yaml:"scenarios"`
+	Flow             []flowStepYAML     `yaml:"flow"`
+	Proxies          stringOrSliceField `yaml:"proxy"`
+	Resolve          stringOrSliceField `yaml:"resolve"`
+	DNSServer        *string            `yaml:"dnsServer"`
+	DNSCache         *bool              `yaml:"dnsCache"`
+	IPStrategy       *string            `yaml:"ipStrategy"`
+	LocalAddresses   stringOrSliceField `yaml:"localAddresses"`
+	Values           stringOrSliceField `yaml:"values"`
+	Timeout          *time.Duration     `yaml:"timeout"`
+	Insecure         *bool              `yaml:"insecure"`
+	TLSCerts         []tlsCertYAML      `yaml:"tlsCerts"`
+	TLSCA            stringOrSliceField `yaml:"tlsCA"`
+	TLSRotate        *string            `yaml:"tlsRotate"`
+	TLSMinVersion    *string            `yaml:"tlsMinVersion"`
+	TLSMaxVersion    *string            `yaml:"tlsMaxVersion"`
+	TLSCiphers       stringOrSliceField `yaml:"tlsCiphers"`
+	TLSCurves        stringOrSliceField `yaml:"tlsCurves"`
+	TLSServerName    *string            `yaml:"tlsServerName"`
+	TLSALPN          stringOrSliceField `yaml:"tlsALPN"`
+	TLSSessionReuse  *bool              `yaml:"tlsSessionReuse"`
+	Protocol         *string            `yaml:"protocol"`
+	H2MaxStreams     *uint              `yaml:"h2MaxStreams"`
+	H2Connections    *uint              `yaml:"h2Connections"`
+	WSMatch          *string            `yaml:"wsMatch"`
+	GRPCMethod       *string            `yaml:"grpcMethod"`
+	GRPCProto        stringOrSliceField `yaml:"grpcProto"`
+	GRPCImportPath   stringOrSliceField `yaml:"grpcImportPath"`
+	Stream           *bool              `yaml:"stream"`
+	StreamHold       *time.Duration     `yaml:"streamHold"`
+	Lua              stringOrSliceField `yaml:"lua"`
+	Js               stringOrSliceField `yaml:"js"`
 }
 
 // ParseYAML parses YAML config file arguments into a Config object.
@@ -244,6 +349,26 @@ func (parser ConfigFileParser) ParseYAML(data []byte) (*Config, error) {
 	config.Concurrency = parsedData.Concurrency
 	config.Requests = parsedData.RequestCount
 	config.Duration = parsedData.Duration
+	config.Rate = parsedData.Rate
+	if parsedData.RateOverflow != nil {
+		config.RateOverflow = new(ConfigRateOverflowType(*parsedData.RateOverflow))
+	}
+	config.MaxConcurrency = parsedData.MaxConcurrency
+	for i, stage := range parsedData.Stages {
+		if stage.Duration == nil {
+			fieldParseErrors = append(
+				fieldParseErrors,
+				types.NewFieldParseError(fmt.Sprintf("stages[%d].duration", i), "", errors.New("stage duration is required")),
+			)
+			continue
+		}
+		config.Stages = append(config.Stages, types.Stage{
+			Duration:    *stage.Duration,
+			Rate:        stage.Rate,
+			Concurrency: stage.Concurrency,
+		})
+	}
+	config.SendInterval = parsedData.SendInterval
 	config.LogLevel = parsedData.LogLevel
 	config.LogFile = parsedData.LogFile
 
@@ -255,10 +380,32 @@ func (parser ConfigFileParser) ParseYAML(data []byte) (*Config, error) {
 		config.Output = new(ConfigOutputType(*parsedData.Output))
 	}
 
+	for i, percentiles := range parsedData.Percentiles {
+		if err := config.Percentiles.Parse(percentiles); err != nil {
+			fieldParseErrors = append(
+				fieldParseErrors,
+				types.NewFieldParseError(fmt.Sprintf("percentiles[%d]", i), percentiles, err),
+			)
+		}
+	}
+	config.RemoteIPStats = parsedData.RemoteIPStats
+
+	config.TimelineInterval = parsedData.TimelineInterval
+	config.TimelineFile = parsedData.TimelineFile
+
+	for i, threshold := range parsedData.Thresholds {
+		if err := config.Thresholds.Parse(threshold); err != nil {
+			fieldParseErrors = append(
+				fieldParseErrors,
+				types.NewFieldParseError(fmt.Sprintf("thresholds[%d]", i), threshold, err),
+			)
+		}
+	}
+
 	config.DryRun = parsedData.DryRun
 
 	if parsedData.URL != nil {
-		urlParsed, err := url.Parse(*parsedData.URL)
+		urlParsed, err := types.ParseRequestURL(*parsedData.URL)
 		if err != nil {
 			fieldParseErrors = append(fieldParseErrors, types.NewFieldParseError("url", *parsedData.URL, err))
 		} else {
@@ -277,6 +424,19 @@ func (parser ConfigFileParser) ParseYAML(data []byte) (*Config, error) {
 	for _, kv := range parsedData.Cookies {
 		config.Cookies = append(config.Cookies, types.Cookie(kv))
 	}
+	config.CookieJar = parsedData.CookieJar
+	config.CookieJarReset = parsedData.CookieJarReset
+
+	for i, scenario := range parsedData.Scenarios {
+		config.Scenarios = append(config.Scenarios, types.Scenario{
+			Name:    scenario.Name,
+			Weight:  scenario.Weight,
+			Request: parseRequestYAML(fmt.Sprintf("scenarios[%d]", i), scenario.requestYAML, &fieldParseErrors),
+		})
+	}
+	for i, step := range parsedData.Flow {
+		config.Flow = append(config.Flow, parseFlowStepYAML(fmt.Sprintf("flow[%d]", i), step, &fieldParseErrors))
+	}
 
 	for i, proxy := range parsedData.Proxies {
 		err := config.Proxies.Parse(proxy)
@@ -288,9 +448,72 @@ func (parser ConfigFileParser) ParseYAML(data []byte) (*Config, error) {
 		}
 	}
 
+	for i, override := range parsedData.Resolve {
+		err := config.Resolve.Parse(override)
+		if err != nil {
+			fieldParseErrors = append(
+				fieldParseErrors,
+				types.NewFieldParseError(fmt.Sprintf("resolve[%d]", i), override, err),
+			)
+		}
+	}
+	config.DNSServer = parsedData.DNSServer
+	config.DNSCache = parsedData.DNSCache
+	if parsedData.IPStrategy != nil {
+		config.IPStrategy = new(ConfigIPStrategyType(*parsedData.IPStrategy))
+	}
+	config.LocalAddresses = append(config.LocalAddresses, parsedData.LocalAddresses...)
+
+	for i, condition := range parsedData.AbortOn {
+		if err := config.AbortOn.Parse(condition); err != nil {
+			fieldParseErrors = append(
+				fieldParseErrors,
+				types.NewFieldParseError(fmt.Sprintf("abortOn[%d]", i), condition, err),
+			)
+		}
+	}
+	config.AbortWindow = parsedData.AbortWindow
+	config.AbortErrors = parsedData.AbortErrors
+
+	if parsedData.Assertions != nil {
+		config.Assertions = parseAssertionsYAML(parsedData.Assertions, &fieldParseErrors)
+	}
+
 	config.Values = append(config.Values, parsedData.Values...)
 	config.Timeout = parsedData.Timeout
 	config.Insecure = parsedData.Insecure
+	for _, cert := range parsedData.TLSCerts {
+		config.TLSCerts = append(config.TLSCerts, types.TLSClientCert(cert))
+	}
+	config.TLSCA = append(config.TLSCA, parsedData.TLSCA...)
+	if parsedData.TLSRotate != nil {
+		config.TLSRotate = new(ConfigTLSRotateType(*parsedData.TLSRotate))
+	}
+	config.TLSMinVersion = parsedData.TLSMinVersion
+	config.TLSMaxVersion = parsedData.TLSMaxVersion
+	config.TLSCiphers = append(config.TLSCiphers, parsedData.TLSCiphers...)
+	config.TLSCurves = append(config.TLSCurves, parsedData.TLSCurves...)
+	config.TLSServerName = parsedData.TLSServerName
+	config.TLSALPN = append(config.TLSALPN, parsedData.TLSALPN...)
+	config.TLSSessionReuse = parsedData.TLSSessionReuse
+	if parsedData.Protocol != nil {
+		config.Protocol = new(ConfigProtocolType(*parsedData.Protocol))
+	}
+	config.H2MaxStreams = parsedData.H2MaxStreams
+	config.H2Connections = parsedData.H2Connections
+	if parsedData.WSMatch != nil {
+		pattern, err := regexp.Compile(*parsedData.WSMatch)
+		if err != nil {
+			fieldParseErrors = append(fieldParseErrors, types.NewFieldParseError("wsMatch", *parsedData.WSMatch, err))
+		} else {
+			config.WSMatch = pattern
+		}
+	}
+	config.GRPCMethod = parsedData.GRPCMethod
+	config.GRPCProto = append(config.GRPCProto, parsedData.GRPCProto...)
+	config.GRPCImportPath = append(config.GRPCImportPath, parsedData.GRPCImportPath...)
+	config.Stream = parsedData.Stream
+	config.StreamHold = parsedData.StreamHold
 	config.Lua = append(config.Lua, parsedData.Lua...)
 	config.Js = append(config.Js, parsedData.Js...)
 
@@ -300,3 +523,123 @@ func (parser ConfigFileParser) ParseYAML(data []byte) (*Config, error) {
 
 	return config, nil
 }
+
+// parseRequestYAML converts the request fields of a scenario or flow step,
+// adding an error to fieldParseErrors if its URL can't be parsed. field names
+// the scenario or step in errors.
+func parseRequestYAML(field string, parsed requestYAML, fieldParseErrors *[]types.FieldParseError) types.Request {
+	request := types.Request{
+		Methods: parsed.Method,
+		Bodies:  parsed.Bodies,
+	}
+
+	if parsed.URL != nil {
+		urlParsed, err := types.ParseRequestURL(*parsed.URL)
+		if err != nil {
+			*fieldParseErrors = append(*fieldParseErrors, types.NewFieldParseError(field+".url", *parsed.URL, err))
+		} else {
+			request.URL = urlParsed
+		}
+	}
+
+	for _, kv := range parsed.Params {
+		request.Params = append(request.Params, types.Param(kv))
+	}
+	for _, kv := range parsed.Headers {
+		request.Headers = append(request.Headers, types.Header(kv))
+	}
+	for _, kv := range parsed.Cookies {
+		request.Cookies = append(request.Cookies, types.Cookie(kv))
+	}
+
+	return request
+}
+
+// parseFlowStepYAML converts a step of the flow block of a config file, adding
+// an error to fieldParseErrors for its URL or any extractor that can't be
+// parsed. field names the step in errors.
+func parseFlowStepYAML(field string, parsed flowStepYAML, fieldParseErrors *[]types.FieldParseError) types.FlowStep {
+	step := types.FlowStep{
+		Name:    parsed.Name,
+		Request: parseRequestYAML(field, parsed.requestYAML, fieldParseErrors),
+	}
+
+	for i, extractor := range parsed.Extract {
+		extractorField := fmt.Sprintf("%s.extract[%d]", field, i)
+
+		var sources []types.Extractor
+		if extractor.JSON != nil {
+			sources = append(sources, types.Extractor{Source: types.ExtractorSourceJSON, Expression: *extractor.JSON})
+		}
+		if extractor.Regex != nil {
+			pattern, err := regexp.Compile(*extractor.Regex)
+			if err != nil {
+				*fieldParseErrors = append(*fieldParseErrors, types.NewFieldParseError(extractorField+".regex", *extractor.Regex, err))
+				continue
+			}
+			sources = append(sources, types.Extractor{Source: types.ExtractorSourceRegex, Expression: *extractor.Regex, Pattern: pattern})
+		}
+		if extractor.Header != nil {
+			sources = append(sources, types.Extractor{Source: types.ExtractorSourceHeader, Expression: *extractor.Header})
+		}
+		if extractor.Cookie != nil {
+			sources = append(sources, types.Extractor{Source: types.ExtractorSourceCookie, Expression: *extractor.Cookie})
+		}
+
+		if len(sources) != 1 {
+			*fieldParseErrors = append(
+				*fieldParseErrors,
+				types.NewFieldParseError(extractorField, extractor.Name, errors.New("extractor must set exactly one of json, regex, header or cookie")),
+			)
+			continue
+		}
+
+		sources[0].Name = extractor.Name
+		step.Extract = append(step.Extract, sources[0])
+	}
+
+	return step
+}
+
+// parseAssertionsYAML converts the assertions block of a config file, adding an
+// error to fieldParseErrors for every body pattern or expected value that
+// can't be parsed.
+func parseAssertionsYAML(parsed *assertionsYAML, fieldParseErrors *[]types.FieldParseError) *types.Assertions {
+	assertions := &types.Assertions{MaxBodySize: parsed.MaxBodySize}
+
+	for _, status := range parsed.Status {
+		assertions.Status = append(assertions.Status, types.StatusPattern(strings.ToLower(strings.TrimSpace(status))))
+	}
+
+	for _, header := range parsed.Headers {
+		assertions.Headers = append(assertions.Headers, types.HeaderAssertion{
+			Name:     header.Name,
+			Equals:   header.Equals,
+			Contains: header.Contains,
+		})
+	}
+
+	for i, body := range parsed.Body {
+		pattern, err := regexp.Compile(body)
+		if err != nil {
+			*fieldParseErrors = append(*fieldParseErrors, types.NewFieldParseError(fmt.Sprintf("assertions.body[%d]", i), body, err))
+			continue
+		}
+		assertions.Body = append(assertions.Body, pattern)
+	}
+
+	for i, field := range parsed.JSON {
+		jsonAssertion := types.JSONAssertion{Path: field.Path}
+		if field.Equals.Kind != 0 {
+			var equals any
+			if err := field.Equals.Decode(&equals); err != nil {
+				*fieldParseErrors = append(*fieldParseErrors, types.NewFieldParseError(fmt.Sprintf("assertions.json[%d].equals", i), field.Equals.Value, err))
+				continue
+			}
+			jsonAssertion.Equals = &equals
+		}
+		assertions.JSON = append(assertions.JSON, jsonAssertion)
+	}
+
+	return assertions
+}
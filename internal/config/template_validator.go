@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 	"text/template"
 
 	"go.aykhans.me/sarin/internal/sarin"
@@ -182,13 +183,34 @@ func validateTemplateValues(values []string, funcMap template.FuncMap) []types.F
 	return validationErrors
 }
 
-func validateTemplateURLPath(urlPath string, funcMap template.FuncMap) []types.FieldValidationError {
-	if err := validateTemplateString(urlPath, funcMap); err != nil {
-		return []types.FieldValidationError{
-			types.NewFieldValidationError("URL.Path", urlPath, err),
-		}
+func validateTemplateURL(requestURL *url.URL, funcMap template.FuncMap) []types.FieldValidationError {
+	var validationErrors []types.FieldValidationError
+	if err := validateTemplateString(requestURL.Host, funcMap); err != nil {
+		validationErrors = append(validationErrors, types.NewFieldValidationError("URL.Host", requestURL.Host, err))
 	}
-	return nil
+	if err := validateTemplateString(requestURL.Path, funcMap); err != nil {
+		validationErrors = append(validationErrors, types.NewFieldValidationError("URL.Path", requestURL.Path, err))
+	}
+	return validationErrors
+}
+
+// validateTemplateRequest validates the request fields of a scenario or flow
+// step, prefixing the field of every error with field.
+func validateTemplateRequest(field string, request types.Request, funcMap, bodyFuncMap template.FuncMap) []types.FieldValidationError {
+	var validationErrors []types.FieldValidationError
+	if request.URL != nil {
+		validationErrors = append(validationErrors, validateTemplateURL(request.URL, funcMap)...)
+	}
+	validationErrors = append(validationErrors, validateTemplateMethods(request.Methods, funcMap)...)
+	validationErrors = append(validationErrors, validateTemplateParams(request.Params, funcMap)...)
+	validationErrors = append(validationErrors, validateTemplateHeaders(request.Headers, funcMap)...)
+	validationErrors = append(validationErrors, validateTemplateCookies(request.Cookies, funcMap)...)
+	validationErrors = append(validationErrors, validateTemplateBodies(request.Bodies, bodyFuncMap)...)
+
+	for i := range validationErrors {
+		validationErrors[i].Field = field + "." + validationErrors[i].Field
+	}
+	return validationErrors
 }
 
 func ValidateTemplates(config *Config) []types.FieldValidationError {
@@ -202,9 +224,9 @@ func ValidateTemplates(config *Config) []types.FieldValidationError {
 
 	var allErrors []types.FieldValidationError
 
-	// Validate URL path
+	// Validate URL host and path
 	if config.URL != nil {
-		allErrors = append(allErrors, validateTemplateURLPath(config.URL.Path, funcMap)...)
+		allErrors = append(allErrors, validateTemplateURL(config.URL, funcMap)...)
 	}
 
 	// Validate methods
@@ -225,5 +247,14 @@ func ValidateTemplates(config *Config) []types.FieldValidationError {
 	// Validate values
 	allErrors = append(allErrors, validateTemplateValues(config.Values, funcMap)...)
 
+	// Validate scenarios and flow steps, naming each field after its scenario
+	// or step
+	for i, scenario := range config.Scenarios {
+		allErrors = append(allErrors, validateTemplateRequest(fmt.Sprintf("Scenarios[%d]", i), scenario.Request, funcMap, bodyFuncMap)...)
+	}
+	for i, step := range config.Flow {
+		allErrors = append(allErrors, validateTemplateRequest(fmt.Sprintf("Flow[%d]", i), step.Request, funcMap, bodyFuncMap)...)
+	}
+
 	return allErrors
 }
@@ -0,0 +1,193 @@
+package sarin
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.aykhans.me/sarin/internal/types"
+)
+
+// abortWindowSteps is how many steps the abort window slides in. The
+// conditions are checked, and the oldest step dropped, every
+// window/abortWindowSteps.
+const abortWindowSteps = 10
+
+// abortPolicy stops the run early once the target is clearly failing, through
+// the same path as an interrupt. It is shared by all workers.
+// A nil policy never aborts.
+type abortPolicy struct {
+	// conditions abort the run as soon as one of them holds over the last
+	// window.
+	conditions types.Thresholds
+	window     time.Duration
+	// consecutiveErrorLimit aborts the run once this many requests in a row
+	// got no response. Zero disables it.
+	consecutiveErrorLimit uint64
+	consecutiveErrors     atomic.Uint64
+
+	once sync.Once
+	// cancel starts the shutdown, and reports whether it did, which it
+	// doesn't if the run was already stopping.
+	cancel func() bool
+	reason string
+}
+
+// newAbortPolicy returns nil when there is nothing to abort on.
+func newAbortPolicy(conditions types.Thresholds, window time.Duration, consecutiveErrorLimit uint) *abortPolicy {
+	if len(conditions) == 0 && consecutiveErrorLimit == 0 {
+		return nil
+	}
+	return &abortPolicy{
+		conditions:            conditions,
+		window:                window,
+		consecutiveErrorLimit: uint64(consecutiveErrorLimit),
+	}
+}
+
+// requestFailed records a request that got no response.
+func (p *abortPolicy) requestFailed() {
+	if p == nil || p.consecutiveErrorLimit == 0 {
+		return
+	}
+	if p.consecutiveErrors.Add(1) == p.consecutiveErrorLimit {
+		p.abort(fmt.Sprintf("%d requests in a row got no response", p.consecutiveErrorLimit))
+	}
+}
+
+// responseReceived records a request that got a response, ending any run of
+// failed ones.
+func (p *abortPolicy) responseReceived() {
+	if p == nil || p.consecutiveErrorLimit == 0 {
+		return
+	}
+	// Most responses follow another response, so skip the store to keep the
+	// counter's cache line shared between workers.
+	if p.consecutiveErrors.Load() != 0 {
+		p.consecutiveErrors.Store(0)
+	}
+}
+
+// abort stops the run. Only the first reason is kept, and only if the run
+// wasn't already stopping for another reason, such as an interrupt.
+func (p *abortPolicy) abort(reason string) {
+	p.once.Do(func() {
+		if p.cancel() {
+			p.reason = reason
+		}
+	})
+}
+
+// StartAbortWindow starts collecting responses into the abort window.
+func (data *SarinResponseData) StartAbortWindow() {
+	data.Lock()
+	defer data.Unlock()
+
+	data.collect()
+	data.abortSteps = []map[string]*Response{make(map[string]*Response)}
+}
+
+// SlideAbortWindow checks the conditions against the responses of the last
+// window, if check is set, and then drops the oldest step of the window.
+// It returns a description of the first condition that holds, or an empty
+// string if none does. A latency condition holds only if there were
+// responses to measure.
+func (data *SarinResponseData) SlideAbortWindow(conditions types.Thresholds, window time.Duration, check bool) string {
+	data.Lock()
+	defer data.Unlock()
+
+	data.collect()
+
+	var reason string
+	if check {
+		responses := make(map[string]*Response)
+		for _, step := range data.abortSteps {
+			for key, response := range step {
+				existing, ok := responses[key]
+				if !ok {
+					existing = &Response{}
+					responses[key] = existing
+				}
+				existing.merge(response)
+			}
+		}
+
+		for _, condition := range conditions {
+			actual, samples := measureThreshold(condition, responses, window)
+			if condition.IsLatency() && samples == 0 {
+				continue
+			}
+			if condition.Check(actual) {
+				reason = fmt.Sprintf(
+					"%s (was %s over the last %s)",
+					condition.String(), formatThresholdActual(condition, actual), window,
+				)
+				break
+			}
+		}
+	}
+
+	if len(data.abortSteps) == abortWindowSteps {
+		data.abortSteps = data.abortSteps[1:]
+	}
+	data.abortSteps = append(data.abortSteps, make(map[string]*Response))
+
+	return reason
+}
+
+// SetAbortReason records why the run was aborted.
+func (data *SarinResponseData) SetAbortReason(reason string) {
+	data.Lock()
+	defer data.Unlock()
+
+	data.abortReason = reason
+}
+
+// AbortReason returns why the run was aborted, or an empty string if it
+// wasn't.
+func (s sarin) AbortReason() string {
+	if s.abort == nil {
+		return ""
+	}
+	return s.abort.reason
+}
+
+// watchAbort arms the abort policy to stop the run through cancel, and starts
+// checking its conditions every step of the window once a full window has
+// passed. The returned function stops the checks.
+func (s sarin) watchAbort(cancel func() bool) func() {
+	s.abort.cancel = cancel
+	if len(s.abort.conditions) == 0 || !s.collectStats {
+		return func() {}
+	}
+
+	s.responses.StartAbortWindow()
+	started := time.Now()
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(max(s.abort.window/abortWindowSteps, time.Millisecond))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				check := time.Since(started) >= s.abort.window
+				if reason := s.responses.SlideAbortWindow(s.abort.conditions, s.abort.window, check); reason != "" {
+					s.abort.abort(reason)
+					return
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
@@ -0,0 +1,244 @@
+package sarin
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.aykhans.me/sarin/internal/types"
+)
+
+// parseThresholds parses each of the raw thresholds.
+func parseThresholds(t *testing.T, rawValues ...string) types.Thresholds {
+	t.Helper()
+
+	var thresholds types.Thresholds
+	for _, rawValue := range rawValues {
+		threshold, err := types.ParseThreshold(rawValue)
+		if err != nil {
+			t.Fatal(err)
+		}
+		thresholds = append(thresholds, *threshold)
+	}
+	return thresholds
+}
+
+func TestAbortPolicyConsecutiveErrors(t *testing.T) {
+	t.Parallel()
+
+	policy := newAbortPolicy(nil, 0, 3)
+	var cancels atomic.Int64
+	policy.cancel = func() bool {
+		cancels.Add(1)
+		return true
+	}
+
+	// A response ends the run of failed requests.
+	for _, failed := range []bool{true, true, false, true, true} {
+		if failed {
+			policy.requestFailed()
+		} else {
+			policy.responseReceived()
+		}
+	}
+	if cancels.Load() != 0 || policy.reason != "" {
+		t.Fatalf("got aborted with %q before 3 requests in a row failed", policy.reason)
+	}
+
+	policy.requestFailed()
+	if want := "3 requests in a row got no response"; policy.reason != want {
+		t.Errorf("got reason %q, want %q", policy.reason, want)
+	}
+
+	// The requests that keep failing while the run stops don't abort it
+	// again.
+	for range 10 {
+		policy.requestFailed()
+	}
+	if got := cancels.Load(); got != 1 {
+		t.Errorf("got %d cancels, want 1", got)
+	}
+}
+
+func TestAbortPolicyConsecutiveErrorsOnce(t *testing.T) {
+	t.Parallel()
+
+	// However the failures of the workers interleave, the limit is reached
+	// exactly once.
+	policy := newAbortPolicy(nil, 0, 50)
+	var cancels atomic.Int64
+	policy.cancel = func() bool {
+		cancels.Add(1)
+		return true
+	}
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Go(func() {
+			for range 10 {
+				policy.requestFailed()
+			}
+		})
+	}
+	wg.Wait()
+	if got := cancels.Load(); got != 1 {
+		t.Errorf("got %d cancels, want 1", got)
+	}
+}
+
+func TestNewAbortPolicy(t *testing.T) {
+	t.Parallel()
+
+	policy := newAbortPolicy(nil, time.Second, 0)
+	if policy != nil {
+		t.Fatal("got a policy without anything to abort on")
+	}
+	// A nil policy never aborts.
+	policy.requestFailed()
+	policy.responseReceived()
+
+	// Without a limit, failed requests aren't counted.
+	policy = newAbortPolicy(parseThresholds(t, "error_rate > 50%"), time.Second, 0)
+	policy.cancel = func() bool {
+		t.Error("got aborted by failed requests without a limit")
+		return true
+	}
+	for range 100 {
+		policy.requestFailed()
+	}
+}
+
+func TestAbortPolicyAfterInterrupt(t *testing.T) {
+	t.Parallel()
+
+	var cancels atomic.Int64
+	stop := NewStopController(func() { cancels.Add(1) })
+	policy := newAbortPolicy(nil, 0, 1)
+	policy.cancel = stop.Cancel
+
+	// The run is already stopping because of an interrupt when the abort
+	// condition is reached, so it isn't what stopped the run.
+	stop.Stop()
+	policy.requestFailed()
+	if policy.reason != "" {
+		t.Errorf("got reason %q for a run stopped by an interrupt", policy.reason)
+	}
+	if got := cancels.Load(); got != 1 {
+		t.Errorf("got %d cancels, want 1", got)
+	}
+}
+
+func TestStopControllerCancel(t *testing.T) {
+	t.Parallel()
+
+	var cancels atomic.Int64
+	stop := NewStopController(func() { cancels.Add(1) })
+	if !stop.Cancel() {
+		t.Error("the first Cancel didn't start the shutdown")
+	}
+	if stop.Cancel() {
+		t.Error("the second Cancel started the shutdown again")
+	}
+	if got := cancels.Load(); got != 1 {
+		t.Errorf("got %d cancels, want 1", got)
+	}
+}
+
+func TestSlideAbortWindow(t *testing.T) {
+	t.Parallel()
+
+	const window = 10 * time.Second
+
+	// addResponses records count responses under key in shard, taking 10ms
+	// each.
+	addResponses := func(shard *statsShard, key string, count int) {
+		for range count {
+			shard.Add(key, 10*time.Millisecond, time.Time{}, time.Time{}, nil)
+		}
+	}
+
+	t.Run("Conditions hold over the window", func(t *testing.T) {
+		t.Parallel()
+
+		data := NewSarinResponseData(nil, nil, false)
+		shard := data.NewShard()
+		data.StartAbortWindow()
+		conditions := parseThresholds(t, "error_rate > 50%")
+
+		// The failures of the first step hold the error rate above the
+		// limit until they fall out of the window, whatever succeeds
+		// after them in smaller numbers.
+		addResponses(shard, "503", 3)
+		addResponses(shard, "200", 1)
+		want := "error_rate > 50% (was 75.00% over the last 10s)"
+		if got := data.SlideAbortWindow(conditions, window, true); got != want {
+			t.Fatalf("got reason %q, want %q", got, want)
+		}
+		for step := 1; step < abortWindowSteps; step++ {
+			if got := data.SlideAbortWindow(conditions, window, true); got != want {
+				t.Fatalf("got reason %q at step %d, want %q", got, step, want)
+			}
+		}
+		if got := data.SlideAbortWindow(conditions, window, true); got != "" {
+			t.Errorf("got reason %q after the failures left the window", got)
+		}
+	})
+
+	t.Run("Unchecked steps", func(t *testing.T) {
+		t.Parallel()
+
+		data := NewSarinResponseData(nil, nil, false)
+		shard := data.NewShard()
+		data.StartAbortWindow()
+		conditions := parseThresholds(t, "error_rate > 50%")
+
+		// The first steps of the run aren't checked, but their responses
+		// count once the checks start.
+		addResponses(shard, "503", 4)
+		if got := data.SlideAbortWindow(conditions, window, false); got != "" {
+			t.Fatalf("got reason %q from an unchecked step", got)
+		}
+		addResponses(shard, "200", 2)
+		want := "error_rate > 50% (was 66.67% over the last 10s)"
+		if got := data.SlideAbortWindow(conditions, window, true); got != want {
+			t.Errorf("got reason %q, want %q", got, want)
+		}
+	})
+
+	t.Run("First condition that holds", func(t *testing.T) {
+		t.Parallel()
+
+		data := NewSarinResponseData(nil, nil, false)
+		shard := data.NewShard()
+		data.StartAbortWindow()
+		conditions := parseThresholds(t, "count > 100", "5xx:count >= 2", "error_rate > 10%")
+
+		addResponses(shard, "500", 2)
+		addResponses(shard, "200", 2)
+		want := "5xx:count >= 2 (was 2 over the last 10s)"
+		if got := data.SlideAbortWindow(conditions, window, true); got != want {
+			t.Errorf("got reason %q, want %q", got, want)
+		}
+	})
+
+	t.Run("Latency without responses", func(t *testing.T) {
+		t.Parallel()
+
+		data := NewSarinResponseData(nil, nil, false)
+		shard := data.NewShard()
+		data.StartAbortWindow()
+		conditions := parseThresholds(t, "p99 < 1s", "404:max < 1s")
+
+		// A latency condition doesn't hold while there is nothing to
+		// measure.
+		if got := data.SlideAbortWindow(conditions, window, true); got != "" {
+			t.Fatalf("got reason %q without responses", got)
+		}
+		addResponses(shard, "200", 1)
+		want := "p99 < 1s (was 10ms over the last 10s)"
+		if got := data.SlideAbortWindow(conditions, window, true); got != want {
+			t.Errorf("got reason %q, want %q", got, want)
+		}
+	})
+}
@@ -0,0 +1,193 @@
+package sarin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+	"go.aykhans.me/sarin/internal/types"
+)
+
+// assertionValueLen bounds how much of an unexpected value the runtime log
+// shows.
+const assertionValueLen = 100
+
+// responseChecker checks responses against the configured assertions.
+// A nil checker passes every response.
+type responseChecker struct {
+	assertions types.Assertions
+	// expectedJSON holds the JSON encoding of each JSON assertion's expected
+	// value, compared against the encoding of the value found in the body.
+	expectedJSON []string
+}
+
+// newResponseChecker returns nil when there is nothing to check.
+func newResponseChecker(assertions *types.Assertions) *responseChecker {
+	if assertions == nil || assertions.IsEmpty() {
+		return nil
+	}
+
+	checker := &responseChecker{
+		assertions:   *assertions,
+		expectedJSON: make([]string, len(assertions.JSON)),
+	}
+	for i, field := range assertions.JSON {
+		if field.Equals != nil {
+			// Expected values are validated to be representable as JSON.
+			expected, _ := json.Marshal(*field.Equals)
+			checker.expectedJSON[i] = string(expected)
+		}
+	}
+	return checker
+}
+
+// check returns the response key of the first assertion the response fails,
+// along with a log line describing what was received instead. An empty key
+// means the response passed. The status is checked first, then the headers,
+// the body size, the body patterns and the JSON fields.
+func (c *responseChecker) check(resp *fasthttp.Response) (string, string) {
+	if c == nil {
+		return "", ""
+	}
+
+	statusCode := resp.StatusCode()
+	if len(c.assertions.Status) > 0 &&
+		!slices.ContainsFunc(c.assertions.Status, func(status types.StatusPattern) bool { return status.Matches(statusCode) }) {
+		key := "assertion failed: status " + statusCodeToString(statusCode)
+		return key, key
+	}
+
+	for _, header := range c.assertions.Headers {
+		value := resp.Header.Peek(header.Name)
+		switch {
+		case value == nil:
+			key := headerAssertionKey(header)
+			return key, key + ", header missing"
+		case header.Equals != nil && string(value) != *header.Equals,
+			header.Contains != nil && !bytes.Contains(value, []byte(*header.Contains)):
+			key := headerAssertionKey(header)
+			return key, fmt.Sprintf("%s, got %q", key, bodySnippet(value, assertionValueLen))
+		}
+	}
+
+	if c.assertions.MaxBodySize == nil && len(c.assertions.Body) == 0 && len(c.assertions.JSON) == 0 {
+		return "", ""
+	}
+
+	// The body checks, the size included, all see the body with its
+	// Content-Encoding undone.
+	body, err := resp.BodyUncompressed()
+	if err != nil {
+		key := "assertion failed: body could not be decoded"
+		return key, key + ": " + err.Error()
+	}
+
+	if maxSize := c.assertions.MaxBodySize; maxSize != nil && uint64(len(body)) > *maxSize {
+		key := "assertion failed: body size <= " + strconv.FormatUint(*maxSize, 10)
+		return key, key + ", got " + strconv.Itoa(len(body)) + " bytes"
+	}
+
+	for _, pattern := range c.assertions.Body {
+		if !pattern.Match(body) {
+			key := fmt.Sprintf("assertion failed: body matches %q", pattern.String())
+			return key, key + ", got " + bodySnippet(body, assertionValueLen)
+		}
+	}
+
+	if len(c.assertions.JSON) == 0 {
+		return "", ""
+	}
+
+	var document any
+	if err := json.Unmarshal(body, &document); err != nil {
+		key := "assertion failed: body is not valid JSON"
+		return key, key + ", got " + bodySnippet(body, assertionValueLen)
+	}
+
+	for i, field := range c.assertions.JSON {
+		value, found := lookupJSONPath(document, field.Path)
+		if !found {
+			key := fmt.Sprintf("assertion failed: json %q exists", field.Path)
+			if field.Equals != nil {
+				key = fmt.Sprintf("assertion failed: json %q == %s", field.Path, c.expectedJSON[i])
+			}
+			return key, key + ", field missing"
+		}
+		if field.Equals == nil {
+			continue
+		}
+
+		actual, _ := json.Marshal(value)
+		if string(actual) != c.expectedJSON[i] {
+			key := fmt.Sprintf("assertion failed: json %q == %s", field.Path, c.expectedJSON[i])
+			return key, key + ", got " + bodySnippet(actual, assertionValueLen)
+		}
+	}
+
+	return "", ""
+}
+
+// headerAssertionKey returns the response key of a failed header assertion.
+func headerAssertionKey(header types.HeaderAssertion) string {
+	switch {
+	case header.Equals != nil:
+		return fmt.Sprintf("assertion failed: header %q == %q", header.Name, *header.Equals)
+	case header.Contains != nil:
+		return fmt.Sprintf("assertion failed: header %q contains %q", header.Name, *header.Contains)
+	default:
+		return fmt.Sprintf("assertion failed: header %q exists", header.Name)
+	}
+}
+
+// lookupJSONPath walks a decoded JSON document along a dot-separated path of
+// object keys and array indexes. A "#" segment gives the length of an array.
+func lookupJSONPath(document any, path string) (any, bool) {
+	value := document
+	for segment := range strings.SplitSeq(path, ".") {
+		switch node := value.(type) {
+		case map[string]any:
+			child, ok := node[segment]
+			if !ok {
+				return nil, false
+			}
+			value = child
+		case []any:
+			if segment == "#" {
+				value = float64(len(node))
+				continue
+			}
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(node) {
+				return nil, false
+			}
+			value = node[index]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// responseKey returns the key a response is counted under: its status code, or
+// the name of its status for gRPC calls, or the assertion it fails or the value
+// a flow step couldn't extract from it, which is also logged as an error and
+// sends the flow back to its first step.
+func (s sarin) responseKey(resp *fasthttp.Response, flow *workerFlow, sendLog runtimeLogger) string {
+	key, detail := s.responseChecker.check(resp)
+	if key == "" {
+		key, detail = flow.extract(resp)
+	}
+	switch {
+	case key == "" && s.grpc != nil:
+		return grpcStatusKey(resp)
+	case key == "":
+		return statusCodeToString(resp.StatusCode())
+	}
+	flow.fail()
+	sendLog(runtimeLogLevelError, detail)
+	return key
+}
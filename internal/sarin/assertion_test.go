@@ -0,0 +1,218 @@
+package sarin
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"go.aykhans.me/sarin/internal/types"
+)
+
+func TestLookupJSONPath(t *testing.T) {
+	t.Parallel()
+
+	var document any
+	if err := json.Unmarshal([]byte(`{
+		"data": {
+			"user": {"active": true, "name": "ada"},
+			"items": [{"id": 1}, {"id": 2}],
+			"empty": [],
+			"missing": null
+		},
+		"a.b": 1,
+		"#": "hash"
+	}`), &document); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path      string
+		want      any
+		wantFound bool
+	}{
+		{path: "data.user.active", want: true, wantFound: true},
+		{path: "data.user.name", want: "ada", wantFound: true},
+		{path: "data.user", want: map[string]any{"active": true, "name": "ada"}, wantFound: true},
+		{path: "data.items.1.id", want: float64(2), wantFound: true},
+		{path: "data.items.#", want: float64(2), wantFound: true},
+		{path: "data.empty.#", want: float64(0), wantFound: true},
+		{path: "data.missing", want: nil, wantFound: true},
+		{path: "#", want: "hash", wantFound: true},
+		{path: "data.user.email", wantFound: false},
+		{path: "data.items.2", wantFound: false},
+		{path: "data.items.-1", wantFound: false},
+		{path: "data.items.first", wantFound: false},
+		{path: "data.empty.0", wantFound: false},
+		{path: "data.missing.id", wantFound: false},
+		{path: "data.user.name.first", wantFound: false},
+		{path: "data.items.#.id", wantFound: false},
+		{path: "data.user.#", wantFound: false},
+		// Keys can't contain dots, since the path is split on them.
+		{path: "a.b", wantFound: false},
+		{path: "", wantFound: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			t.Parallel()
+
+			got, found := lookupJSONPath(document, test.path)
+			if found != test.wantFound {
+				t.Fatalf("got found %v, want %v", found, test.wantFound)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestResponseCheckerOrder(t *testing.T) {
+	t.Parallel()
+
+	var expected any = "ok"
+	checker := newResponseChecker(&types.Assertions{
+		Status:      []types.StatusPattern{"2xx"},
+		Headers:     []types.HeaderAssertion{{Name: "X-Cache", Equals: new("HIT")}},
+		MaxBodySize: new(uint64(64)),
+		Body:        []*regexp.Regexp{regexp.MustCompile(`"ok"`)},
+		JSON:        []types.JSONAssertion{{Path: "status", Equals: &expected}},
+	})
+
+	// Each response fails every assertion that comes after the one it is
+	// counted under.
+	tests := []struct {
+		name       string
+		status     int
+		cache      string
+		encoding   string
+		body       string
+		wantKey    string
+		wantDetail string
+	}{
+		{
+			name:    "Status first",
+			status:  fasthttp.StatusInternalServerError,
+			body:    strings.Repeat("x", 80),
+			wantKey: "assertion failed: status 500",
+		},
+		{
+			name:       "Headers second",
+			status:     fasthttp.StatusOK,
+			body:       strings.Repeat("x", 80),
+			wantKey:    `assertion failed: header "X-Cache" == "HIT"`,
+			wantDetail: `assertion failed: header "X-Cache" == "HIT", header missing`,
+		},
+		{
+			name:       "Body size third",
+			status:     fasthttp.StatusOK,
+			cache:      "HIT",
+			body:       strings.Repeat("x", 80),
+			wantKey:    "assertion failed: body size <= 64",
+			wantDetail: "assertion failed: body size <= 64, got 80 bytes",
+		},
+		{
+			name:    "Body patterns fourth",
+			status:  fasthttp.StatusOK,
+			cache:   "HIT",
+			body:    "not json",
+			wantKey: `assertion failed: body matches "\"ok\""`,
+		},
+		{
+			name:       "JSON fields last",
+			status:     fasthttp.StatusOK,
+			cache:      "HIT",
+			body:       `{"status": "down", "x": "ok"}`,
+			wantKey:    `assertion failed: json "status" == "ok"`,
+			wantDetail: `assertion failed: json "status" == "ok", got "down"`,
+		},
+		{
+			name:   "Passed",
+			status: fasthttp.StatusCreated,
+			cache:  "HIT",
+			body:   `{"status": "ok"}`,
+		},
+		{
+			name:       "Size of the decoded body",
+			status:     fasthttp.StatusOK,
+			cache:      "HIT",
+			encoding:   "gzip",
+			body:       `{"status": "ok", "padding": "` + strings.Repeat("a", 100) + `"}`,
+			wantKey:    "assertion failed: body size <= 64",
+			wantDetail: "assertion failed: body size <= 64, got 131 bytes",
+		},
+		{
+			name:     "Compressed body that passes",
+			status:   fasthttp.StatusOK,
+			cache:    "HIT",
+			encoding: "gzip",
+			body:     `{"status": "ok"}`,
+		},
+		{
+			name:     "Body that can't be decoded",
+			status:   fasthttp.StatusOK,
+			cache:    "HIT",
+			encoding: "br",
+			body:     `{"status": "ok"}`,
+			wantKey:  "assertion failed: body could not be decoded",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			resp := fasthttp.AcquireResponse()
+			defer fasthttp.ReleaseResponse(resp)
+			resp.SetStatusCode(test.status)
+			if test.cache != "" {
+				resp.Header.Set("X-Cache", test.cache)
+			}
+			if test.encoding == "gzip" {
+				resp.SetBody(fasthttp.AppendGzipBytes(nil, []byte(test.body)))
+				if len(resp.Body()) > 64 {
+					t.Fatalf("got %d bytes of compressed body, want at most 64", len(resp.Body()))
+				}
+			} else {
+				resp.SetBodyString(test.body)
+			}
+			if test.encoding != "" {
+				resp.Header.SetContentEncoding(test.encoding)
+			}
+
+			key, detail := checker.check(resp)
+			if key != test.wantKey {
+				t.Errorf("got key %q, want %q", key, test.wantKey)
+			}
+			switch {
+			case test.wantKey == "" && detail != "":
+				t.Errorf("got detail %q for a response that passed", detail)
+			case test.wantDetail != "" && detail != test.wantDetail:
+				t.Errorf("got detail %q, want %q", detail, test.wantDetail)
+			case !strings.HasPrefix(detail, test.wantKey):
+				t.Errorf("got detail %q, want it to start with the key", detail)
+			}
+		})
+	}
+}
+
+func TestNewResponseCheckerWithoutAssertions(t *testing.T) {
+	t.Parallel()
+
+	for _, assertions := range []*types.Assertions{nil, {}} {
+		checker := newResponseChecker(assertions)
+		if checker != nil {
+			t.Fatalf("got a checker for assertions %+v", assertions)
+		}
+
+		resp := fasthttp.AcquireResponse()
+		resp.SetStatusCode(fasthttp.StatusInternalServerError)
+		if key, _ := checker.check(resp); key != "" {
+			t.Errorf("got key %q from a nil checker", key)
+		}
+		fasthttp.ReleaseResponse(resp)
+	}
+}
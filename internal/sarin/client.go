@@ -5,119 +5,438 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/base64"
-	"math"
+	"errors"
 	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
+	"regexp"
 	"time"
 
 	"github.com/valyala/fasthttp"
-	"github.com/valyala/fasthttp/fasthttpproxy"
 	"go.aykhans.me/sarin/internal/types"
 	utilsSlice "go.aykhans.me/utils/slice"
 	"golang.org/x/net/proxy"
 )
 
-type HostClientGenerator func() *fasthttp.HostClient
+// HostClient sends requests to a single host. *fasthttp.HostClient is the
+// HTTP/1.1 implementation.
+type HostClient interface {
+	DoTimeout(req *fasthttp.Request, resp *fasthttp.Response, timeout time.Duration) error
+	CloseIdleConnections()
+}
+
+type HostClientGenerator func() HostClient
+
+// Protocol is the HTTP version requests are sent with.
+type Protocol uint8
+
+const (
+	// ProtocolHTTP1 sends every request over its own HTTP/1.1 connection.
+	ProtocolHTTP1 Protocol = iota
+	// ProtocolHTTP2 multiplexes the requests of all workers over shared
+	// HTTP/2 connections, negotiated with ALPN over TLS and with prior
+	// knowledge (h2c) otherwise.
+	ProtocolHTTP2
+	// ProtocolHTTP3 multiplexes the requests of all workers over shared QUIC
+	// connections. It only works for https URLs, without proxies.
+	ProtocolHTTP3
+)
 
-func safeUintToInt(u uint) int {
-	if u > math.MaxInt {
-		return math.MaxInt
+// ParseProtocol maps a config value to a Protocol.
+// Unknown values fall back to ProtocolHTTP1; config validation rejects them
+// before they get here.
+func ParseProtocol(protocol string) Protocol {
+	switch protocol {
+	case "h2":
+		return ProtocolHTTP2
+	case "h3":
+		return ProtocolHTTP3
+	default:
+		return ProtocolHTTP1
 	}
-	return int(u)
 }
 
-// NewHostClients creates a list of fasthttp.HostClient instances for the given proxies.
-// If no proxies are provided, a single client without a proxy is returned.
+// dialFunc opens a connection to addr, which always has a port, and reports the
+// DNS and connect phases to trace. A nil trace records nothing.
+type dialFunc func(addr string, trace *requestTrace) (net.Conn, error)
+
+// newDialFuncs creates a dial function for each of the given proxies.
+// If no proxies are provided, a single direct dial function is returned.
+// Host names that are resolved locally are resolved through res, and the
+// connections, to the target or the proxy, are opened from local.
 // It can return the following errors:
-// - types.ProxyDialError
-func NewHostClients(
-	ctx context.Context,
+//   - types.ProxyDialError
+func newDialFuncs(ctx context.Context, timeout time.Duration, proxies []url.URL, res *resolver, local *localAddrs) ([]dialFunc, error) {
+	if len(proxies) == 0 {
+		return []dialFunc{newDirectDialFunc(ctx, timeout, res, local)}, nil
+	}
+
+	dials := make([]dialFunc, 0, len(proxies))
+	for _, proxy := range proxies {
+		dial, err := newProxyDialFunc(ctx, &proxy, timeout, res, local)
+		if err != nil {
+			return nil, types.NewProxyDialError(proxy.String(), err)
+		}
+		dials = append(dials, dial)
+	}
+	return dials, nil
+}
+
+// maxPoolHosts caps how many hosts a worker keeps clients for, so that
+// templates sending every request to a different host don't grow its pool
+// without bound.
+const maxPoolHosts = 256
+
+// hostClientPool holds the host clients of a worker, one set per scheme and
+// host, creating each set the first time a request goes to its host. This lets
+// templates and scripts send every request to a different host. Past
+// maxPoolHosts hosts, the clients of the host that went the longest without a
+// request are closed to make room for a new one. With HTTP/2
+// and HTTP/3, the clients come from h2 or h3, which the workers share, and
+// ws and wss URLs get a WebSocket client. When the run calls a gRPC method,
+// every request is a call to it over h2. In stream mode, the clients read
+// every response body as a stream of events. With more than one client
+// certificate for the worker, every host gets clients for each of them, and
+// requests take turns with them. It is NOT safe for concurrent use.
+type hostClientPool struct {
+	dials   []dialFunc
+	timeout time.Duration
+	tls     *clientTLS
+	certs   []*tls.Certificate // a single nil without client certificates
+	trace   *requestTrace
+	stats   *statsShard
+	wsMatch *regexp.Regexp
+	grpc    *grpcMethod
+	stream  bool
+	h2      *h2ClientPool
+	h3      *h3ClientPool
+
+	// hosts holds the clients by host, for http and ws at index 0 and https
+	// and wss at index 1.
+	hosts [2]map[string]*pooledHost
+	// requests counts the requests the pool handed clients out for.
+	requests uint64
+}
+
+// pooledHost is the set of clients of a hostClientPool for one host.
+type pooledHost struct {
+	generator HostClientGenerator
+	// clients are the clients to close when the host is dropped. The shared
+	// HTTP/2 and HTTP/3 clients aren't among them.
+	clients []HostClient
+	// lastRequest is the pool's request count at the latest request to the
+	// host.
+	lastRequest uint64
+}
+
+func newHostClientPool(
+	dials []dialFunc,
 	timeout time.Duration,
-	proxies []url.URL,
-	maxConns uint,
-	requestURL *url.URL,
-	skipVerify bool,
-) ([]*fasthttp.HostClient, error) {
-	isTLS := requestURL.Scheme == "https"
-
-	if proxiesLen := len(proxies); proxiesLen > 0 {
-		clients := make([]*fasthttp.HostClient, 0, proxiesLen)
-		addr := requestURL.Host
-		if isTLS && requestURL.Port() == "" {
-			addr += ":443"
-		}
-
-		for _, proxy := range proxies {
-			dialFunc, err := NewProxyDialFunc(ctx, &proxy, timeout)
-			if err != nil {
-				return nil, types.NewProxyDialError(proxy.String(), err)
+	tlsSettings *clientTLS,
+	trace *requestTrace,
+	stats *statsShard,
+	wsMatch *regexp.Regexp,
+	grpc *grpcMethod,
+	stream bool,
+	h2 *h2ClientPool,
+	h3 *h3ClientPool,
+) *hostClientPool {
+	return &hostClientPool{
+		dials:   dials,
+		timeout: timeout,
+		tls:     tlsSettings,
+		certs:   tlsSettings.workerCerts(),
+		trace:   trace,
+		stats:   stats,
+		wsMatch: wsMatch,
+		grpc:    grpc,
+		stream:  stream,
+		h2:      h2,
+		h3:      h3,
+		hosts: [2]map[string]*pooledHost{
+			make(map[string]*pooledHost),
+			make(map[string]*pooledHost),
+		},
+	}
+}
+
+// forRequest returns the host client generator for the scheme and host of req,
+// creating its clients if req is the first request to that host.
+func (pool *hostClientPool) forRequest(req *fasthttp.Request) HostClientGenerator {
+	scheme := string(req.URI().Scheme())
+	isTLS := scheme == "https" || scheme == "wss"
+	hosts := pool.hosts[0]
+	if isTLS {
+		hosts = pool.hosts[1]
+	}
+
+	pool.requests++
+	host := req.Host()
+	if pooled, ok := hosts[string(host)]; ok {
+		pooled.lastRequest = pool.requests
+		return pooled.generator
+	}
+	if len(pool.hosts[0])+len(pool.hosts[1]) >= maxPoolHosts {
+		pool.dropLeastRecentHost()
+	}
+
+	pooled := &pooledHost{lastRequest: pool.requests}
+	var generator HostClientGenerator
+	switch {
+	case isWebSocketScheme(scheme):
+		// A WebSocket connection stays open across requests, so it presents
+		// the first certificate of the worker only.
+		tlsConfig := pool.tls.config(string(host), pool.certs[0])
+		client := HostClient(newWSClient(pool.dials, isTLS, tlsConfig, pool.trace, pool.stats, pool.wsMatch))
+		pooled.clients = append(pooled.clients, client)
+		generator = func() HostClient { return client }
+	case pool.grpc != nil:
+		clients := make([]HostClient, 0, len(pool.certs))
+		for _, cert := range pool.certs {
+			h2Client := pool.h2.get(isTLS, string(host), cert)
+			clients = append(clients, &grpcWorkerClient{client: h2Client, method: pool.grpc, trace: pool.trace})
+		}
+		generator = cycleHostClients(clients)
+	case pool.h2 != nil:
+		clients := make([]HostClient, 0, len(pool.certs))
+		for _, cert := range pool.certs {
+			h2Client := pool.h2.get(isTLS, string(host), cert)
+			clients = append(clients, h2WorkerClient{client: h2Client, trace: pool.trace, stream: pool.stream})
+		}
+		generator = cycleHostClients(clients)
+	case pool.h3 != nil:
+		clients := make([]HostClient, 0, len(pool.certs))
+		for _, cert := range pool.certs {
+			transport := pool.h3.get(string(host), cert)
+			clients = append(clients, h3WorkerClient{transport: transport, trace: pool.trace, stream: pool.stream})
+		}
+		generator = cycleHostClients(clients)
+	default:
+		var clients []*fasthttp.HostClient
+		for _, cert := range pool.certs {
+			tlsConfig := pool.tls.config(string(host), cert)
+			clients = append(clients, newHostClients(pool.dials, pool.timeout, isTLS, string(host), tlsConfig, pool.trace, pool.stream)...)
+		}
+		if !pool.stream {
+			for _, client := range clients {
+				pooled.clients = append(pooled.clients, client)
 			}
+			generator = NewHostClientGenerator(clients...)
+			break
+		}
 
-			clients = append(clients, &fasthttp.HostClient{
-				MaxConns: safeUintToInt(maxConns),
-				IsTLS:    isTLS,
-				TLSConfig: &tls.Config{
-					InsecureSkipVerify: skipVerify, //nolint:gosec
-				},
-				Addr:                          addr,
-				Dial:                          dialFunc,
-				MaxIdleConnDuration:           timeout,
-				MaxConnDuration:               timeout,
-				WriteTimeout:                  timeout,
-				ReadTimeout:                   timeout,
-				DisableHeaderNamesNormalizing: true,
-				DisablePathNormalizing:        true,
-				NoDefaultUserAgentHeader:      true,
-			},
-			)
-		}
-
-		return clients, nil
+		streamClients := make([]HostClient, 0, len(clients))
+		for _, client := range clients {
+			streamClients = append(streamClients, &streamHostClient{client: client, trace: pool.trace})
+		}
+		pooled.clients = append(pooled.clients, streamClients...)
+		generator = cycleHostClients(streamClients)
 	}
+	pooled.generator = generator
+	hosts[string(host)] = pooled
+	return generator
+}
 
-	client := &fasthttp.HostClient{
-		MaxConns: safeUintToInt(maxConns),
-		IsTLS:    isTLS,
-		TLSConfig: &tls.Config{
-			InsecureSkipVerify: skipVerify, //nolint:gosec
-		},
-		Addr:                          requestURL.Host,
-		MaxIdleConnDuration:           timeout,
-		MaxConnDuration:               timeout,
-		WriteTimeout:                  timeout,
-		ReadTimeout:                   timeout,
-		DisableHeaderNamesNormalizing: true,
-		DisablePathNormalizing:        true,
-		NoDefaultUserAgentHeader:      true,
+// dropLeastRecentHost closes the clients of the host that went the longest
+// without a request and drops them from the pool.
+func (pool *hostClientPool) dropLeastRecentHost() {
+	var (
+		oldest      *pooledHost
+		oldestHosts map[string]*pooledHost
+		oldestHost  string
+	)
+	for _, hosts := range pool.hosts {
+		for host, pooled := range hosts {
+			if oldest == nil || pooled.lastRequest < oldest.lastRequest {
+				oldest, oldestHosts, oldestHost = pooled, hosts, host
+			}
+		}
+	}
+	if oldest == nil {
+		return
+	}
+
+	for _, client := range oldest.clients {
+		client.CloseIdleConnections()
+	}
+	delete(oldestHosts, oldestHost)
+}
+
+// closeIdleConnections closes the idle connections of every client of the
+// worker, along with its WebSocket connections. The shared HTTP/2 and HTTP/3
+// connections are left open.
+func (pool *hostClientPool) closeIdleConnections() {
+	for _, hosts := range pool.hosts {
+		for _, pooled := range hosts {
+			for _, client := range pooled.clients {
+				client.CloseIdleConnections()
+			}
+		}
 	}
-	return []*fasthttp.HostClient{client}, nil
 }
 
-// NewProxyDialFunc creates a dial function for the given proxy URL.
+// newHostClients creates a fasthttp.HostClient to host for each dial function.
+// The clients do the TLS handshake themselves, with tlsConfig, and report every
+// phase of their requests to trace, so they must only be used by the worker
+// that owns it. With stream set, they leave response bodies for the caller to
+// stream.
+func newHostClients(
+	dials []dialFunc,
+	timeout time.Duration,
+	isTLS bool,
+	host string,
+	tlsConfig *tls.Config,
+	trace *requestTrace,
+	stream bool,
+) []*fasthttp.HostClient {
+	clients := make([]*fasthttp.HostClient, 0, len(dials))
+	for _, dial := range dials {
+		clients = append(clients, &fasthttp.HostClient{
+			IsTLS:                         isTLS,
+			TLSConfig:                     tlsConfig,
+			Addr:                          host,
+			Dial:                          tracedDialFunc(dial, trace, isTLS, tlsConfig, timeout),
+			MaxIdleConnDuration:           timeout,
+			MaxConnDuration:               timeout,
+			WriteTimeout:                  timeout,
+			ReadTimeout:                   timeout,
+			DisableHeaderNamesNormalizing: true,
+			DisablePathNormalizing:        true,
+			NoDefaultUserAgentHeader:      true,
+			StreamResponseBody:            stream,
+		})
+	}
+	return clients
+}
+
+// tracedDialFunc turns dial into a fasthttp.DialFunc whose connections report
+// their reads and writes to trace. For TLS it also does the handshake, which
+// fasthttp then skips, so that it can be timed, and reports only the reads and
+// writes of application data.
+func tracedDialFunc(
+	dial dialFunc,
+	trace *requestTrace,
+	isTLS bool,
+	tlsConfig *tls.Config,
+	timeout time.Duration,
+) fasthttp.DialFunc {
+	return func(addr string) (net.Conn, error) {
+		conn, err := dial(fasthttp.AddMissingPort(addr, isTLS), trace)
+		if err != nil {
+			return nil, err
+		}
+		if !isTLS {
+			return &tracedConn{Conn: conn, trace: trace}, nil
+		}
+
+		start := time.Now()
+		tlsConn := tls.Client(conn, tlsConfig)
+		err = tlsHandshake(tlsConn, start.Add(timeout))
+		trace.handshakenIn(time.Since(start))
+		if err != nil {
+			conn.Close() //nolint:errcheck,gosec
+			return nil, err
+		}
+		trace.handshook(tlsConn.ConnectionState().DidResume)
+		return &tracedTLSConn{Conn: tlsConn, trace: trace}, nil
+	}
+}
+
+// tlsHandshake runs the handshake of conn by deadline, reporting a timeout the
+// same way fasthttp does.
+func tlsHandshake(conn *tls.Conn, deadline time.Time) error {
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err //nolint:wrapcheck
+	}
+	if err := conn.Handshake(); err != nil {
+		if netErr, ok := errors.AsType[net.Error](err); ok && netErr.Timeout() {
+			return fasthttp.ErrTLSHandshakeTimeout
+		}
+		return err //nolint:wrapcheck
+	}
+	return conn.SetDeadline(time.Time{}) //nolint:wrapcheck
+}
+
+// newDirectDialFunc creates a dial function that resolves the host itself
+// through res, so the lookup can be timed, and connects from local to the first
+// of its addresses that accepts the connection. Looked up hosts connect over
+// IPv4.
+// The returned dial function can return the following errors:
+//   - types.HostResolveError
+//   - types.LocalAddressFamilyError
+//   - types.LocalAddressBindError
+func newDirectDialFunc(ctx context.Context, timeout time.Duration, res *resolver, local *localAddrs) dialFunc {
+	return func(addr string, trace *requestTrace) (net.Conn, error) {
+		dialCtx, dialCancel := context.WithTimeout(ctx, timeout)
+		defer dialCancel()
+
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err //nolint:wrapcheck
+		}
+
+		ips, err := res.resolve(dialCtx, "ip4", host, port, trace)
+		if err != nil {
+			return nil, err
+		}
+		if len(ips) == 0 {
+			return nil, types.NewHostResolveError(host)
+		}
+
+		start := time.Now()
+		defer func() { trace.connectedIn(time.Since(start)) }()
+
+		for _, ip := range ips {
+			var conn net.Conn
+			conn, err = local.DialContext(dialCtx, "tcp", net.JoinHostPort(ip.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			if dialCtx.Err() != nil {
+				break
+			}
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, fasthttp.ErrDialTimeout
+		}
+		return nil, err //nolint:wrapcheck
+	}
+}
+
+// newProxyDialFunc creates a dial function for the given proxy URL, which
+// connects to the proxy from local. Only socks5 proxies have the target
+// resolved locally, through res; the others resolve it themselves.
 // It can return the following errors:
 //   - types.ProxyUnsupportedSchemeError
-func NewProxyDialFunc(ctx context.Context, proxyURL *url.URL, timeout time.Duration) (fasthttp.DialFunc, error) {
+func newProxyDialFunc(ctx context.Context, proxyURL *url.URL, timeout time.Duration, res *resolver, local *localAddrs) (dialFunc, error) {
 	var (
-		dialer fasthttp.DialFunc
+		dialer dialFunc
 		err    error
 	)
 
 	switch proxyURL.Scheme {
 	case "socks5":
-		dialer, err = fasthttpSocksDialerDualStackTimeout(ctx, proxyURL, timeout, true)
+		dialer, err = fasthttpSocksDialerDualStackTimeout(ctx, proxyURL, timeout, res, local)
 		if err != nil {
 			return nil, err
 		}
 	case "socks5h":
-		dialer, err = fasthttpSocksDialerDualStackTimeout(ctx, proxyURL, timeout, false)
+		dialer, err = fasthttpSocksDialerDualStackTimeout(ctx, proxyURL, timeout, nil, local)
 		if err != nil {
 			return nil, err
 		}
 	case "http":
-		dialer = fasthttpproxy.FasthttpHTTPDialerDualStackTimeout(proxyURL.String(), timeout)
+		// The tunnel is set up inside fasthttpproxy, so all of it counts as
+		// connecting.
+		httpDialer := local.httpProxyDialFunc(proxyURL.String(), proxyURL.Hostname(), timeout)
+		dialer = func(addr string, trace *requestTrace) (net.Conn, error) {
+			start := time.Now()
+			defer func() { trace.connectedIn(time.Since(start)) }()
+			return httpDialer(addr)
+		}
 	case "https":
-		dialer = fasthttpHTTPSDialerDualStackTimeout(proxyURL, timeout)
+		dialer = fasthttpHTTPSDialerDualStackTimeout(proxyURL, timeout, local)
 	default:
 		return nil, types.NewProxyUnsupportedSchemeError(proxyURL.Scheme)
 	}
@@ -125,11 +444,20 @@ func NewProxyDialFunc(ctx context.Context, proxyURL *url.URL, timeout time.Durat
 	return dialer, nil
 }
 
-// The returned dial function can return the following errors:
+// The returned dial function resolves the target through res and asks the
+// proxy for each of its addresses in turn until one connects, unless res is
+// nil, in which case the proxy resolves it. It times the local lookup as DNS,
+// and reaching the target through the proxy as connecting. The proxy is
+// connected to from local.
+// It can return the following errors:
 //   - types.ProxyDialError
-func fasthttpSocksDialerDualStackTimeout(ctx context.Context, proxyURL *url.URL, timeout time.Duration, resolveLocally bool) (fasthttp.DialFunc, error) {
-	netDialer := &net.Dialer{}
-
+func fasthttpSocksDialerDualStackTimeout(
+	ctx context.Context,
+	proxyURL *url.URL,
+	timeout time.Duration,
+	res *resolver,
+	local *localAddrs,
+) (dialFunc, error) {
 	// Parse auth from proxy URL if present
 	var auth *proxy.Auth
 	if proxyURL.User != nil {
@@ -141,8 +469,8 @@ func fasthttpSocksDialerDualStackTimeout(ctx context.Context, proxyURL *url.URL,
 		}
 	}
 
-	// Create SOCKS5 dialer with net.Dialer as forward dialer
-	socksDialer, err := proxy.SOCKS5("tcp", proxyURL.Host, auth, netDialer)
+	// Create SOCKS5 dialer that connects to the proxy from the local addresses
+	socksDialer, err := proxy.SOCKS5("tcp", proxyURL.Host, auth, local)
 	if err != nil {
 		return nil, err
 	}
@@ -152,9 +480,11 @@ func fasthttpSocksDialerDualStackTimeout(ctx context.Context, proxyURL *url.URL,
 	// Assert to ContextDialer for timeout support
 	contextDialer, ok := socksDialer.(proxy.ContextDialer)
 	if !ok {
-		// Fallback without timeout (should not happen with net.Dialer)
-		return func(addr string) (net.Conn, error) {
+		// Fallback without timeout (should not happen, proxy.SOCKS5 returns a ContextDialer)
+		return func(addr string, trace *requestTrace) (net.Conn, error) {
+			start := time.Now()
 			conn, err := socksDialer.Dial("tcp", addr)
+			trace.connectedIn(time.Since(start))
 			if err != nil {
 				return nil, types.NewProxyDialError(proxyStr, err)
 			}
@@ -163,17 +493,18 @@ func fasthttpSocksDialerDualStackTimeout(ctx context.Context, proxyURL *url.URL,
 	}
 
 	// Return dial function that uses context with timeout
-	return func(addr string) (net.Conn, error) {
+	return func(addr string, trace *requestTrace) (net.Conn, error) {
 		deadline := time.Now().Add(timeout)
 
-		if resolveLocally {
+		addrs := []string{addr}
+		if res != nil {
 			host, port, err := net.SplitHostPort(addr)
 			if err != nil {
 				return nil, types.NewProxyDialError(proxyStr, err)
 			}
 
 			dnsCtx, dnsCancel := context.WithTimeout(ctx, timeout)
-			ips, err := net.DefaultResolver.LookupIP(dnsCtx, "ip", host)
+			ips, err := res.resolve(dnsCtx, "ip", host, port, trace)
 			dnsCancel()
 			if err != nil {
 				return nil, types.NewProxyDialError(proxyStr, err)
@@ -182,8 +513,10 @@ func fasthttpSocksDialerDualStackTimeout(ctx context.Context, proxyURL *url.URL,
 				return nil, types.NewProxyDialError(proxyStr, types.NewProxyResolveError(host))
 			}
 
-			// Use the first resolved IP
-			addr = net.JoinHostPort(ips[0].String(), port)
+			addrs = make([]string, len(ips))
+			for i, ip := range ips {
+				addrs[i] = net.JoinHostPort(ip.String(), port)
+			}
 		}
 
 		// Use remaining time for dial
@@ -195,17 +528,45 @@ func fasthttpSocksDialerDualStackTimeout(ctx context.Context, proxyURL *url.URL,
 		dialCtx, dialCancel := context.WithTimeout(ctx, remaining)
 		defer dialCancel()
 
-		conn, err := contextDialer.DialContext(dialCtx, "tcp", addr)
-		if err != nil {
-			return nil, types.NewProxyDialError(proxyStr, err)
+		dialStart := time.Now()
+		defer func() { trace.connectedIn(time.Since(dialStart)) }()
+
+		var err error
+		for _, addr := range addrs {
+			var conn net.Conn
+			conn, err = contextDialer.DialContext(dialCtx, "tcp", addr)
+			if err == nil {
+				if target, err := netip.ParseAddrPort(addr); err == nil {
+					conn = &targetConn{Conn: conn, target: net.TCPAddrFromAddrPort(target)}
+				}
+				return conn, nil
+			}
+			if dialCtx.Err() != nil {
+				break
+			}
 		}
-		return conn, nil
+		return nil, types.NewProxyDialError(proxyStr, err)
 	}, nil
 }
 
-// The returned dial function can return the following errors:
+// targetConn is a connection through a proxy to a target whose address is
+// known, which it reports as its remote address instead of that of the proxy.
+type targetConn struct {
+	net.Conn
+
+	target net.Addr
+}
+
+func (conn *targetConn) RemoteAddr() net.Addr {
+	return conn.target
+}
+
+// The returned dial function times everything up to an established tunnel,
+// including the TLS handshake with the proxy, as connecting. The proxy is
+// connected to from local.
+// It can return the following errors:
 //   - types.ProxyDialError
-func fasthttpHTTPSDialerDualStackTimeout(proxyURL *url.URL, timeout time.Duration) fasthttp.DialFunc {
+func fasthttpHTTPSDialerDualStackTimeout(proxyURL *url.URL, timeout time.Duration, local *localAddrs) dialFunc {
 	proxyAddr := proxyURL.Host
 	if proxyURL.Port() == "" {
 		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), "443")
@@ -222,10 +583,11 @@ func fasthttpHTTPSDialerDualStackTimeout(proxyURL *url.URL, timeout time.Duratio
 
 	proxyStr := proxyURL.String()
 
-	return func(addr string) (net.Conn, error) {
+	return func(addr string, trace *requestTrace) (net.Conn, error) {
 		// Establish TCP connection to proxy with timeout
 		start := time.Now()
-		conn, err := fasthttp.DialDualStackTimeout(proxyAddr, timeout)
+		defer func() { trace.connectedIn(time.Since(start)) }()
+		conn, err := local.dialTimeout(proxyAddr, timeout)
 		if err != nil {
 			return nil, types.NewProxyDialError(proxyStr, err)
 		}
@@ -310,14 +672,28 @@ func NewHostClientGenerator(clients ...*fasthttp.HostClient) HostClientGenerator
 	switch len(clients) {
 	case 0:
 		hostClient := &fasthttp.HostClient{}
-		return func() *fasthttp.HostClient {
+		return func() HostClient {
 			return hostClient
 		}
 	case 1:
-		return func() *fasthttp.HostClient {
+		return func() HostClient {
 			return clients[0]
 		}
 	default:
-		return utilsSlice.RandomCycle(nil, clients...)
+		next := utilsSlice.RandomCycle(nil, clients...)
+		return func() HostClient {
+			return next()
+		}
+	}
+}
+
+// cycleHostClients returns a generator that cycles through clients in order
+// from a random start, like NewHostClientGenerator.
+func cycleHostClients(clients []HostClient) HostClientGenerator {
+	if len(clients) == 1 {
+		client := clients[0]
+		return func() HostClient { return client }
 	}
+	next := utilsSlice.RandomCycle(nil, clients...)
+	return func() HostClient { return next() }
 }
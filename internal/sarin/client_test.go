@@ -0,0 +1,84 @@
+package sarin
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// newTestHostClientPool returns an HTTP/1.1 pool whose clients never connect.
+func newTestHostClientPool(t *testing.T) *hostClientPool {
+	t.Helper()
+
+	tlsSettings, err := newClientTLS(
+		NewFileCache(time.Second), true, nil, nil, TLSRotationWorker,
+		"", "", nil, nil, "", nil, false,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dial := func(string, *requestTrace) (net.Conn, error) { return nil, net.ErrClosed }
+	return newHostClientPool([]dialFunc{dial}, time.Second, tlsSettings, &requestTrace{}, nil, nil, nil, false, nil, nil)
+}
+
+// poolClient returns the client that pool hands out for a request to rawURL.
+func poolClient(pool *hostClientPool, rawURL string) HostClient {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.SetRequestURI(rawURL)
+	return pool.forRequest(req)()
+}
+
+func TestHostClientPoolReusesClients(t *testing.T) {
+	t.Parallel()
+
+	pool := newTestHostClientPool(t)
+	client := poolClient(pool, "http://example.com/a")
+	if got := poolClient(pool, "http://example.com/b?page=2"); got != client {
+		t.Error("got a new client for a host that has one")
+	}
+	if got := poolClient(pool, "https://example.com/"); got == client {
+		t.Error("got the http client for https")
+	}
+	if got := poolClient(pool, "http://example.com:8080/"); got == client {
+		t.Error("got the client of another port")
+	}
+}
+
+func TestHostClientPoolDropsLeastRecentHost(t *testing.T) {
+	t.Parallel()
+
+	pool := newTestHostClientPool(t)
+	first := poolClient(pool, "http://host-0.internal/")
+	second := poolClient(pool, "https://host-1.internal/")
+	for i := 2; i < maxPoolHosts; i++ {
+		poolClient(pool, fmt.Sprintf("http://host-%d.internal/", i))
+	}
+	// host-0 is now more recent than host-1, which is dropped first.
+	poolClient(pool, "http://host-0.internal/")
+
+	for i := range 10 {
+		poolClient(pool, fmt.Sprintf("http://other-%d.internal/", i))
+		if hosts := len(pool.hosts[0]) + len(pool.hosts[1]); hosts != maxPoolHosts {
+			t.Fatalf("got %d hosts in the pool, want %d", hosts, maxPoolHosts)
+		}
+	}
+
+	if _, ok := pool.hosts[1]["host-1.internal"]; ok {
+		t.Error("the least recent host wasn't dropped")
+	}
+	for i := 2; i < 11; i++ {
+		if _, ok := pool.hosts[0][fmt.Sprintf("host-%d.internal", i)]; ok {
+			t.Errorf("host-%d wasn't dropped", i)
+		}
+	}
+	if got := poolClient(pool, "http://host-0.internal/"); got != first {
+		t.Error("a recent host was dropped")
+	}
+	if got := poolClient(pool, "https://host-1.internal/"); got == second {
+		t.Error("got the client of a dropped host")
+	}
+}
@@ -0,0 +1,264 @@
+package sarin
+
+import (
+	"bytes"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// jarCookie is a cookie stored in a cookieJar.
+type jarCookie struct {
+	name  string
+	value string
+	// domain is lowercase and without a leading dot. A host-only cookie is
+	// sent to that exact host, any other cookie to its subdomains too.
+	domain   string
+	hostOnly bool
+	path     string
+	secure   bool
+	// expires is zero for a cookie that lasts until the jar is reset.
+	expires time.Time
+}
+
+// matches reports whether the cookie is sent with a request to path on host
+// at now.
+func (cookie *jarCookie) matches(host, path string, isTLS bool, now time.Time) bool {
+	if cookie.secure && !isTLS {
+		return false
+	}
+	if !cookie.expires.IsZero() && !now.Before(cookie.expires) {
+		return false
+	}
+	if cookie.hostOnly {
+		if host != cookie.domain {
+			return false
+		}
+	} else if !domainMatches(host, cookie.domain) {
+		return false
+	}
+	return pathMatches(path, cookie.path)
+}
+
+// cookieJar keeps the cookies that the responses to a worker set, and sends
+// them back with its following requests, the way a browser would for a single
+// user. It is NOT safe for concurrent use.
+// A nil jar keeps nothing.
+type cookieJar struct {
+	cookies []jarCookie
+	// resetEvery empties the jar at the start of every resetEvery-th
+	// iteration. Zero keeps the cookies for the whole run.
+	resetEvery uint
+	iterations uint
+}
+
+// newCookieJar returns nil when the jar is disabled.
+func newCookieJar(enabled bool, resetEvery uint) *cookieJar {
+	if !enabled {
+		return nil
+	}
+	return &cookieJar{resetEvery: resetEvery}
+}
+
+// startIteration counts an iteration: a request, or a pass through the flow.
+func (jar *cookieJar) startIteration() {
+	if jar == nil || jar.resetEvery == 0 {
+		return
+	}
+	if jar.iterations == jar.resetEvery {
+		jar.cookies = jar.cookies[:0]
+		jar.iterations = 0
+	}
+	jar.iterations++
+}
+
+// store keeps the cookies resp sets, replacing those with the same name, domain
+// and path, and drops the ones it expires. Cookies for a domain the request's
+// host doesn't belong to are ignored.
+func (jar *cookieJar) store(req *fasthttp.Request, resp *fasthttp.Response) {
+	if jar == nil {
+		return
+	}
+
+	host := cookieHost(string(req.Host()))
+	requestPath := string(req.URI().Path())
+	now := time.Now()
+
+	cookie := fasthttp.AcquireCookie()
+	defer fasthttp.ReleaseCookie(cookie)
+	for _, header := range resp.Header.Cookies() {
+		value, maxAge, hasMaxAge := cookieMaxAge(header)
+		cookie.Reset()
+		if err := cookie.ParseBytes(value); err != nil {
+			continue
+		}
+
+		stored := jarCookie{
+			name:     string(cookie.Key()),
+			value:    string(cookie.Value()),
+			domain:   host,
+			hostOnly: true,
+			path:     string(cookie.Path()),
+			secure:   cookie.Secure(),
+		}
+		if domain := strings.ToLower(strings.TrimPrefix(string(cookie.Domain()), ".")); domain != "" {
+			if !domainMatches(host, domain) {
+				continue
+			}
+			stored.domain = domain
+			stored.hostOnly = false
+		}
+		if !strings.HasPrefix(stored.path, "/") {
+			stored.path = defaultCookiePath(requestPath)
+		}
+
+		expired := false
+		switch {
+		case hasMaxAge:
+			// A Max-Age of zero or less expires the cookie right away.
+			expired = maxAge <= 0
+			stored.expires = now.Add(time.Duration(min(maxAge, maxCookieMaxAge)) * time.Second)
+		case cookie.Expire() != fasthttp.CookieExpireUnlimited:
+			stored.expires = cookie.Expire()
+			expired = !now.Before(stored.expires)
+		}
+
+		jar.cookies = deleteJarCookie(jar.cookies, stored)
+		if !expired {
+			jar.cookies = append(jar.cookies, stored)
+		}
+	}
+}
+
+// maxCookieMaxAge is the longest Max-Age, in seconds, that a cookie is kept
+// for, so that its expiry time doesn't overflow.
+const maxCookieMaxAge = math.MaxInt64 / int64(time.Second)
+
+// cookieMaxAge takes the Max-Age attributes out of a Set-Cookie header value,
+// since fasthttp reads a Max-Age of zero as none and fails on a negative one.
+// It returns the value without them, and the seconds of the last one that is
+// an integer, if any. A Max-Age that isn't an integer is ignored, as RFC 6265
+// says.
+func cookieMaxAge(value []byte) ([]byte, int64, bool) {
+	if !hasCookieMaxAge(value) {
+		return value, 0, false
+	}
+
+	var (
+		rest   []byte
+		maxAge int64
+		found  bool
+	)
+	first := true
+	for attr := range bytes.SplitSeq(value, []byte(";")) {
+		if !first {
+			name, seconds, _ := bytes.Cut(attr, []byte("="))
+			if bytes.EqualFold(bytes.TrimSpace(name), []byte("max-age")) {
+				seconds = bytes.TrimSpace(seconds)
+				if parsed, err := strconv.ParseInt(string(seconds), 10, 64); err == nil && seconds[0] != '+' {
+					maxAge, found = parsed, true
+				}
+				continue
+			}
+			rest = append(rest, ';')
+		}
+		rest = append(rest, attr...)
+		first = false
+	}
+	return rest, maxAge, found
+}
+
+// hasCookieMaxAge reports whether a Set-Cookie header value has a Max-Age
+// attribute, without allocating.
+func hasCookieMaxAge(value []byte) bool {
+	first := true
+	for attr := range bytes.SplitSeq(value, []byte(";")) {
+		name, _, _ := bytes.Cut(attr, []byte("="))
+		if !first && bytes.EqualFold(bytes.TrimSpace(name), []byte("max-age")) {
+			return true
+		}
+		first = false
+	}
+	return false
+}
+
+// appendCookies writes the cookies of the jar that go with a request to path
+// on host to sb, in the format of a Cookie header, skipping those named in
+// skip.
+func (jar *cookieJar) appendCookies(sb *strings.Builder, host, path string, isTLS bool, skip map[string][]string) {
+	if jar == nil || len(jar.cookies) == 0 {
+		return
+	}
+
+	host = cookieHost(host)
+	path, _, _ = strings.Cut(path, "?")
+	now := time.Now()
+	for i := range jar.cookies {
+		cookie := &jar.cookies[i]
+		if _, ok := skip[cookie.name]; ok || !cookie.matches(host, path, isTLS, now) {
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteString("; ")
+		}
+		sb.WriteString(cookie.name)
+		sb.WriteByte('=')
+		sb.WriteString(cookie.value)
+	}
+}
+
+// deleteJarCookie removes the cookie with the same name, domain and path as
+// cookie from cookies.
+func deleteJarCookie(cookies []jarCookie, cookie jarCookie) []jarCookie {
+	for i := range cookies {
+		if cookies[i].name == cookie.name && cookies[i].domain == cookie.domain && cookies[i].path == cookie.path {
+			return append(cookies[:i], cookies[i+1:]...)
+		}
+	}
+	return cookies
+}
+
+// cookieHost returns the lowercase host of a Host header, without the port.
+func cookieHost(host string) string {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	return strings.ToLower(host)
+}
+
+// domainMatches reports whether host is domain or one of its subdomains. An IP
+// address has no subdomains.
+func domainMatches(host, domain string) bool {
+	if host == domain {
+		return true
+	}
+	return strings.HasSuffix(host, "."+domain) && net.ParseIP(host) == nil
+}
+
+// pathMatches reports whether a cookie for cookiePath is sent with a request
+// to requestPath.
+func pathMatches(requestPath, cookiePath string) bool {
+	if requestPath == "" {
+		requestPath = "/"
+	}
+	if !strings.HasPrefix(requestPath, cookiePath) {
+		return false
+	}
+	return len(requestPath) == len(cookiePath) ||
+		strings.HasSuffix(cookiePath, "/") ||
+		requestPath[len(cookiePath)] == '/'
+}
+
+// defaultCookiePath returns the path of a cookie set without one: the
+// directory of the request path.
+func defaultCookiePath(requestPath string) string {
+	i := strings.LastIndexByte(requestPath, '/')
+	if i <= 0 {
+		return "/"
+	}
+	return requestPath[:i]
+}
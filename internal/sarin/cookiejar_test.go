@@ -0,0 +1,150 @@
+package sarin
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// storeCookies stores in jar the cookies that a response to requestURI sets
+// with the given Set-Cookie headers.
+func storeCookies(t *testing.T, jar *cookieJar, requestURI string, setCookies ...string) {
+	t.Helper()
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(requestURI)
+	for _, setCookie := range setCookies {
+		resp.Header.Add(fasthttp.HeaderSetCookie, setCookie)
+	}
+	jar.store(req, resp)
+}
+
+// jarCookieHeader returns the Cookie header that jar sends with a request to
+// path on host.
+func jarCookieHeader(jar *cookieJar, host, path string, isTLS bool) string {
+	var sb strings.Builder
+	jar.appendCookies(&sb, host, path, isTLS, nil)
+	return sb.String()
+}
+
+func TestCookieJarExpiry(t *testing.T) {
+	t.Parallel()
+
+	past := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	future := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+
+	tests := []struct {
+		name      string
+		setCookie string
+		kept      bool
+	}{
+		{"session", "id=2", true},
+		{"max-age", "id=2; Max-Age=60", true},
+		{"max-age zero", "id=2; Max-Age=0", false},
+		{"negative max-age", "id=2; Max-Age=-1", false},
+		{"max-age over expires", "id=2; Expires=" + future + "; Max-Age=0", false},
+		{"last max-age", "id=2; Max-Age=0; Max-Age=60", true},
+		{"invalid max-age", "id=2; Max-Age=soon", true},
+		{"lowercase max-age", "id=2; max-age=0", false},
+		{"expires in the future", "id=2; Expires=" + future, true},
+		{"expires in the past", "id=2; Expires=" + past, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			jar := newCookieJar(true, 0)
+			storeCookies(t, jar, "http://example.com/", "id=1")
+			storeCookies(t, jar, "http://example.com/", test.setCookie)
+
+			want := ""
+			if test.kept {
+				want = "id=2"
+			}
+			if got := jarCookieHeader(jar, "example.com", "/", false); got != want {
+				t.Errorf("got cookies %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestCookieJarMatching(t *testing.T) {
+	t.Parallel()
+
+	jar := newCookieJar(true, 0)
+	storeCookies(t, jar, "https://www.example.com/app/login",
+		"host=1",
+		"domain=2; Domain=.Example.com; Path=/",
+		"root=3; Path=/",
+		"api=4; Path=/api",
+		"secure=5; Secure; Path=/",
+		"foreign=6; Domain=other.com",
+	)
+
+	tests := []struct {
+		name  string
+		host  string
+		path  string
+		isTLS bool
+		want  string
+	}{
+		{"default path", "www.example.com", "/app/home", false, "host=1; domain=2; root=3"},
+		{"default path prefix only", "www.example.com", "/application", false, "domain=2; root=3"},
+		{"host with port", "www.example.com:8080", "/app", false, "host=1; domain=2; root=3"},
+		{"host-only on the parent domain", "example.com", "/app", false, "domain=2"},
+		{"host-only on a subdomain", "api.www.example.com", "/api/users", false, "domain=2"},
+		{"path", "www.example.com", "/api/users?page=2", false, "domain=2; root=3; api=4"},
+		{"secure", "www.example.com", "/", true, "domain=2; root=3; secure=5"},
+		{"other domain", "other.com", "/", true, ""},
+		{"domain suffix", "notexample.com", "/", true, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := jarCookieHeader(jar, test.host, test.path, test.isTLS); got != test.want {
+				t.Errorf("got cookies %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCookieMaxAge(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		value  string
+		rest   string
+		maxAge int64
+		found  bool
+	}{
+		{"id=1", "id=1", 0, false},
+		{"id=1; Path=/", "id=1; Path=/", 0, false},
+		{"id=1; Max-Age=0; Path=/", "id=1; Path=/", 0, true},
+		{"id=1; Path=/; MAX-AGE = -5", "id=1; Path=/", -5, true},
+		{"id=1; Max-Age=+5", "id=1", 0, false},
+		{"id=1; Max-Age=", "id=1", 0, false},
+		{"max-age=1", "max-age=1", 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			t.Parallel()
+
+			rest, maxAge, found := cookieMaxAge([]byte(test.value))
+			if string(rest) != test.rest || maxAge != test.maxAge || found != test.found {
+				t.Errorf("got (%q, %d, %v), want (%q, %d, %v)",
+					rest, maxAge, found, test.rest, test.maxAge, test.found)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package sarin
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/valyala/fasthttp"
+	"go.aykhans.me/sarin/internal/types"
+)
+
+// workerFlow walks a worker through the steps of the flow, one step per
+// request, and keeps the values extracted along the way. A step that fails
+// sends the worker back to the first step.
+// A nil flow does nothing.
+type workerFlow struct {
+	steps []requestScenario
+	// values holds the values extracted in the current pass through the
+	// flow. The request generators of the steps read it.
+	values map[string]string
+	// current is the step of the request in flight, and next the step of the
+	// request after it.
+	current int
+	next    int
+}
+
+func newWorkerFlow(steps []requestScenario) *workerFlow {
+	return &workerFlow{
+		steps:  steps,
+		values: make(map[string]string),
+	}
+}
+
+// start moves on to the next step and returns its index. Every pass through
+// the flow starts without any extracted values.
+func (flow *workerFlow) start() int {
+	if flow.next == 0 {
+		clear(flow.values)
+	}
+	flow.current = flow.next
+	flow.next = (flow.next + 1) % len(flow.steps)
+	return flow.current
+}
+
+// fail sends the worker back to the first step.
+func (flow *workerFlow) fail() {
+	if flow != nil {
+		flow.next = 0
+	}
+}
+
+// extract runs the extractors of the current step on resp. It returns the
+// response key of the first value that couldn't be extracted, along with a log
+// line describing why. An empty key means every value was extracted.
+func (flow *workerFlow) extract(resp *fasthttp.Response) (string, string) {
+	if flow == nil {
+		return "", ""
+	}
+
+	extractors := flow.steps[flow.current].extract
+	if len(extractors) == 0 {
+		return "", ""
+	}
+
+	// The body is decoded, and parsed as JSON, only if an extractor needs it.
+	var (
+		body     []byte
+		bodyErr  error
+		document any
+		jsonErr  error
+	)
+	readsBody := func(extractor types.Extractor) bool {
+		return extractor.Source == types.ExtractorSourceRegex || extractor.Source == types.ExtractorSourceJSON
+	}
+	readsJSON := func(extractor types.Extractor) bool { return extractor.Source == types.ExtractorSourceJSON }
+	if slices.ContainsFunc(extractors, readsBody) {
+		body, bodyErr = resp.BodyUncompressed()
+	}
+	if bodyErr == nil && slices.ContainsFunc(extractors, readsJSON) {
+		jsonErr = json.Unmarshal(body, &document)
+	}
+
+	for _, extractor := range extractors {
+		key := "extraction failed: " + extractor.Name
+		if readsBody(extractor) && bodyErr != nil {
+			return key, key + ", body could not be decoded: " + bodyErr.Error()
+		}
+
+		switch extractor.Source {
+		case types.ExtractorSourceHeader:
+			value := resp.Header.Peek(extractor.Expression)
+			if value == nil {
+				return key, fmt.Sprintf("%s, header %q missing", key, extractor.Expression)
+			}
+			flow.values[extractor.Name] = string(value)
+
+		case types.ExtractorSourceCookie:
+			cookie := fasthttp.AcquireCookie()
+			cookie.SetKey(extractor.Expression)
+			found := resp.Header.Cookie(cookie)
+			value := string(cookie.Value())
+			fasthttp.ReleaseCookie(cookie)
+			if !found {
+				return key, fmt.Sprintf("%s, cookie %q missing", key, extractor.Expression)
+			}
+			flow.values[extractor.Name] = value
+
+		case types.ExtractorSourceRegex:
+			match := extractor.Pattern.FindSubmatch(body)
+			if match == nil {
+				return key, fmt.Sprintf("%s, body doesn't match %q, got %s", key, extractor.Expression, bodySnippet(body, assertionValueLen))
+			}
+			// The first capture group, or the whole match without one.
+			flow.values[extractor.Name] = string(match[min(1, len(match)-1)])
+
+		case types.ExtractorSourceJSON:
+			if jsonErr != nil {
+				return key, key + ", body is not valid JSON, got " + bodySnippet(body, assertionValueLen)
+			}
+			value, found := lookupJSONPath(document, extractor.Expression)
+			if !found {
+				return key, fmt.Sprintf("%s, json %q missing", key, extractor.Expression)
+			}
+			if text, ok := value.(string); ok {
+				flow.values[extractor.Name] = text
+			} else {
+				encoded, _ := json.Marshal(value)
+				flow.values[extractor.Name] = string(encoded)
+			}
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,329 @@
+package sarin
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"go.aykhans.me/sarin/internal/types"
+)
+
+func TestWorkerFlowExtract(t *testing.T) {
+	t.Parallel()
+
+	const jsonBody = `{"data": {"token": "abc", "id": 7, "tags": ["a", "b"]}}`
+
+	tests := []struct {
+		name       string
+		extract    []types.Extractor
+		body       string
+		encoding   string
+		want       map[string]string
+		wantKey    string
+		wantDetail string
+	}{
+		{
+			name: "Every source",
+			extract: []types.Extractor{
+				{Name: "token", Source: types.ExtractorSourceJSON, Expression: "data.token"},
+				{Name: "session", Source: types.ExtractorSourceCookie, Expression: "SESSION"},
+				{Name: "request", Source: types.ExtractorSourceHeader, Expression: "X-Request-Id"},
+				{Name: "id", Source: types.ExtractorSourceRegex, Expression: `"id": (\d+)`, Pattern: regexp.MustCompile(`"id": (\d+)`)},
+			},
+			body: jsonBody,
+			want: map[string]string{"token": "abc", "session": "s1", "request": "r1", "id": "7"},
+		},
+		{
+			name: "JSON values other than strings",
+			extract: []types.Extractor{
+				{Name: "id", Source: types.ExtractorSourceJSON, Expression: "data.id"},
+				{Name: "tags", Source: types.ExtractorSourceJSON, Expression: "data.tags"},
+				{Name: "count", Source: types.ExtractorSourceJSON, Expression: "data.tags.#"},
+			},
+			body: jsonBody,
+			want: map[string]string{"id": "7", "tags": `["a","b"]`, "count": "2"},
+		},
+		{
+			name:    "Regex without a group",
+			extract: []types.Extractor{{Name: "match", Source: types.ExtractorSourceRegex, Expression: `"id": \d+`, Pattern: regexp.MustCompile(`"id": \d+`)}},
+			body:    jsonBody,
+			want:    map[string]string{"match": `"id": 7`},
+		},
+		{
+			name:     "Compressed body",
+			extract:  []types.Extractor{{Name: "token", Source: types.ExtractorSourceJSON, Expression: "data.token"}},
+			body:     jsonBody,
+			encoding: "gzip",
+			want:     map[string]string{"token": "abc"},
+		},
+		{
+			name: "Header missing",
+			extract: []types.Extractor{
+				{Name: "token", Source: types.ExtractorSourceJSON, Expression: "data.token"},
+				{Name: "next", Source: types.ExtractorSourceHeader, Expression: "Location"},
+			},
+			body:       jsonBody,
+			want:       map[string]string{"token": "abc"},
+			wantKey:    "extraction failed: next",
+			wantDetail: `extraction failed: next, header "Location" missing`,
+		},
+		{
+			name:       "Cookie missing",
+			extract:    []types.Extractor{{Name: "csrf", Source: types.ExtractorSourceCookie, Expression: "CSRF"}},
+			want:       map[string]string{},
+			wantKey:    "extraction failed: csrf",
+			wantDetail: `extraction failed: csrf, cookie "CSRF" missing`,
+		},
+		{
+			name:       "Regex without a match",
+			extract:    []types.Extractor{{Name: "csrf", Source: types.ExtractorSourceRegex, Expression: `csrf=(\w+)`, Pattern: regexp.MustCompile(`csrf=(\w+)`)}},
+			body:       "no token here",
+			want:       map[string]string{},
+			wantKey:    "extraction failed: csrf",
+			wantDetail: `extraction failed: csrf, body doesn't match "csrf=(\\w+)", got no token here`,
+		},
+		{
+			name:       "JSON field missing",
+			extract:    []types.Extractor{{Name: "token", Source: types.ExtractorSourceJSON, Expression: "data.jwt"}},
+			body:       jsonBody,
+			want:       map[string]string{},
+			wantKey:    "extraction failed: token",
+			wantDetail: `extraction failed: token, json "data.jwt" missing`,
+		},
+		{
+			name:       "Invalid JSON",
+			extract:    []types.Extractor{{Name: "token", Source: types.ExtractorSourceJSON, Expression: "data.token"}},
+			body:       "<html>",
+			want:       map[string]string{},
+			wantKey:    "extraction failed: token",
+			wantDetail: "extraction failed: token, body is not valid JSON, got <html>",
+		},
+		{
+			name: "Body that can't be decoded",
+			extract: []types.Extractor{
+				{Name: "request", Source: types.ExtractorSourceHeader, Expression: "X-Request-Id"},
+				{Name: "token", Source: types.ExtractorSourceJSON, Expression: "data.token"},
+			},
+			body:     jsonBody,
+			encoding: "br",
+			want:     map[string]string{"request": "r1"},
+			wantKey:  "extraction failed: token",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			resp := fasthttp.AcquireResponse()
+			defer fasthttp.ReleaseResponse(resp)
+			resp.Header.Set("X-Request-Id", "r1")
+			cookie := fasthttp.AcquireCookie()
+			defer fasthttp.ReleaseCookie(cookie)
+			cookie.SetKey("SESSION")
+			cookie.SetValue("s1")
+			resp.Header.SetCookie(cookie)
+			if test.encoding == "gzip" {
+				resp.SetBody(fasthttp.AppendGzipBytes(nil, []byte(test.body)))
+			} else {
+				resp.SetBodyString(test.body)
+			}
+			if test.encoding != "" {
+				resp.Header.SetContentEncoding(test.encoding)
+			}
+
+			flow := newWorkerFlow([]requestScenario{{extract: test.extract}})
+			flow.start()
+			key, detail := flow.extract(resp)
+			if key != test.wantKey {
+				t.Errorf("got key %q, want %q", key, test.wantKey)
+			}
+			if test.wantDetail != "" && detail != test.wantDetail {
+				t.Errorf("got detail %q, want %q", detail, test.wantDetail)
+			}
+			if !maps.Equal(flow.values, test.want) {
+				t.Errorf("got values %v, want %v", flow.values, test.want)
+			}
+		})
+	}
+}
+
+func TestWorkerFlowSteps(t *testing.T) {
+	t.Parallel()
+
+	flow := newWorkerFlow(make([]requestScenario, 3))
+	var got []int
+	for i := range 8 {
+		got = append(got, flow.start())
+		flow.values["step"] = strconv.Itoa(got[len(got)-1])
+		// The second step fails in the second pass.
+		if i == 4 {
+			flow.fail()
+		}
+		if i == 5 && len(flow.values) != 1 {
+			t.Errorf("got values %v after the flow started over", flow.values)
+		}
+	}
+	want := []int{0, 1, 2, 0, 1, 0, 1, 2}
+	if !slices.Equal(got, want) {
+		t.Errorf("got steps %v, want %v", got, want)
+	}
+
+	// A nil flow does nothing.
+	var nilFlow *workerFlow
+	nilFlow.fail()
+	if key, _ := nilFlow.extract(nil); key != "" {
+		t.Errorf("got key %q from a nil flow", key)
+	}
+}
+
+// flowRequest is a request the flow test server received.
+type flowRequest struct {
+	path  string
+	token string
+	ok    bool
+}
+
+func TestFlowRun(t *testing.T) {
+	t.Parallel()
+
+	// Every third login returns no token, and every fourth profile request
+	// fails, which sends the worker back to the login.
+	var (
+		mu       sync.Mutex
+		received []flowRequest
+		logins   int
+		profiles int
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		request := flowRequest{path: r.URL.Path, ok: true}
+		switch r.URL.Path {
+		case "/login":
+			logins++
+			if logins%3 == 0 {
+				request.ok = false
+				w.Write([]byte(`{"error": "try again"}`)) //nolint:errcheck
+				break
+			}
+			request.token = fmt.Sprintf("t%d", logins)
+			w.Header().Set("X-Session", "s"+request.token)
+			fmt.Fprintf(w, `{"data": {"token": %q}}`, request.token)
+		case "/profile":
+			profiles++
+			request.token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if profiles%4 == 0 || r.URL.Query().Get("session") != "s"+request.token {
+				request.ok = false
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		case "/logout":
+			request.token = r.URL.Query().Get("token")
+		}
+		received = append(received, request)
+	}))
+	defer server.Close()
+
+	parseURL := func(path string) *url.URL {
+		parsed, err := url.Parse(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	srn, err := NewSarin(t.Context(), Options{
+		Methods:  []string{"GET"},
+		URL:      parseURL("/"),
+		Timeout:  5 * time.Second,
+		Workers:  1,
+		Requests: new(uint64(60)),
+		Flow: types.Flow{
+			{
+				Name:    "login",
+				Request: types.Request{URL: parseURL("/login"), Methods: []string{"POST"}},
+				Extract: []types.Extractor{
+					{Name: "token", Source: types.ExtractorSourceJSON, Expression: "data.token"},
+					{Name: "session", Source: types.ExtractorSourceHeader, Expression: "X-Session"},
+				},
+			},
+			{
+				Name: "profile",
+				Request: types.Request{
+					URL:     parseURL("/profile"),
+					Params:  types.Params{{Key: "session", Value: []string{"{{ .Values.session }}"}}},
+					Headers: types.Headers{{Key: "Authorization", Value: []string{"Bearer {{ .Values.token }}"}}},
+				},
+			},
+			{
+				Name: "logout",
+				Request: types.Request{
+					URL:    parseURL("/logout"),
+					Params: types.Params{{Key: "token", Value: []string{"{{ .Values.token }}"}}},
+				},
+			},
+		},
+		Assertions:   &types.Assertions{Status: []types.StatusPattern{"2xx"}},
+		CollectStats: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	srn.Start(ctx, NewStopController(cancel))
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 60 {
+		t.Fatalf("got %d requests, want 60", len(received))
+	}
+
+	// Each step follows the one before it in the same pass, with the values
+	// extracted in that pass, and a failed step sends the worker back to
+	// the login.
+	want, token := "/login", ""
+	failedLogins := 0
+	for i, request := range received {
+		if request.path != want {
+			t.Fatalf("request %d: got %s, want %s after %v", i, request.path, want, received[max(i-3, 0):i])
+		}
+		switch {
+		case request.path == "/login" && request.ok:
+			want, token = "/profile", request.token
+		case request.path == "/login":
+			failedLogins++
+			want = "/login"
+		case request.token != token:
+			t.Fatalf("request %d: got token %q on %s, want %q", i, request.token, request.path, token)
+		case request.path == "/profile" && request.ok:
+			want = "/logout"
+		default:
+			want = "/login"
+		}
+	}
+
+	data := srn.GetResponses()
+	data.Lock()
+	defer data.Unlock()
+	data.collect()
+	extractionFailed, ok := data.scenarios["login"]["extraction failed: token"]
+	if !ok || extractionFailed.durations.total != uint64(failedLogins) {
+		t.Errorf("got login stats %v, want %d failed extractions", slices.Sorted(maps.Keys(data.scenarios["login"])), failedLogins)
+	}
+	if _, ok := data.scenarios["profile"]["assertion failed: status 503"]; !ok {
+		t.Errorf("got no failed assertions for the profile step, got keys %v", slices.Sorted(maps.Keys(data.scenarios["profile"])))
+	}
+}
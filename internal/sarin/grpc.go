@@ -0,0 +1,699 @@
+package sarin
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/valyala/fasthttp"
+	"go.aykhans.me/sarin/internal/types"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// grpcStatusHeader is the trailer, or the header of a response without a
+// body, that carries the status of a gRPC call. Responses are keyed by it.
+const grpcStatusHeader = "Grpc-Status"
+
+// grpcCodeNames are the names of the gRPC status codes, indexed by code.
+var grpcCodeNames = [...]string{
+	"OK",
+	"CANCELLED",
+	"UNKNOWN",
+	"INVALID_ARGUMENT",
+	"DEADLINE_EXCEEDED",
+	"NOT_FOUND",
+	"ALREADY_EXISTS",
+	"PERMISSION_DENIED",
+	"RESOURCE_EXHAUSTED",
+	"FAILED_PRECONDITION",
+	"ABORTED",
+	"OUT_OF_RANGE",
+	"UNIMPLEMENTED",
+	"INTERNAL",
+	"UNAVAILABLE",
+	"DATA_LOSS",
+	"UNAUTHENTICATED",
+}
+
+const (
+	grpcCodeOK               = 0
+	grpcCodeUnknown          = 2
+	grpcCodePermissionDenied = 7
+	grpcCodeUnimplemented    = 12
+	grpcCodeInternal         = 13
+	grpcCodeUnavailable      = 14
+	grpcCodeUnauthenticated  = 16
+)
+
+// grpcReservedHeaders are the request headers that gRPC sets itself, so they
+// are left out of the metadata of a call.
+var grpcReservedHeaders = []string{
+	fasthttp.HeaderHost,
+	fasthttp.HeaderContentLength,
+	fasthttp.HeaderContentType,
+	fasthttp.HeaderConnection,
+	fasthttp.HeaderTransferEncoding,
+	fasthttp.HeaderTE,
+	"Grpc-Timeout",
+	"Grpc-Encoding",
+	"Grpc-Accept-Encoding",
+}
+
+// isGRPCStatusKey reports whether a response key is the name of a gRPC status.
+func isGRPCStatusKey(key string) bool {
+	return slices.Contains(grpcCodeNames[:], key)
+}
+
+// grpcCodeName returns the name of a gRPC status code. Codes gRPC doesn't
+// define are reported as UNKNOWN.
+func grpcCodeName(code int) string {
+	if code < 0 || code >= len(grpcCodeNames) {
+		return grpcCodeNames[grpcCodeUnknown]
+	}
+	return grpcCodeNames[code]
+}
+
+// grpcStatusKey returns the name of the status of a response read by a
+// grpcWorkerClient.
+func grpcStatusKey(resp *fasthttp.Response) string {
+	code, err := strconv.Atoi(string(resp.Header.Peek(grpcStatusHeader)))
+	if err != nil {
+		return grpcCodeNames[grpcCodeUnknown]
+	}
+	return grpcCodeName(code)
+}
+
+// grpcMethod is the gRPC method a run calls, resolved before the run starts.
+type grpcMethod struct {
+	// path is the request path of the method, /package.Service/Method.
+	path       string
+	descriptor protoreflect.MethodDescriptor
+	// types resolves the message types of google.protobuf.Any fields.
+	types *dynamicpb.Types
+}
+
+// resolveGRPCMethod finds method, named as package.Service/Method, in the proto
+// files or, without any, through the server reflection service of the host of
+// requestURL, which it calls with the first value of each header.
+// It can return the following errors:
+//   - types.GRPCMethodResolveError
+func resolveGRPCMethod(
+	ctx context.Context,
+	method string,
+	protoFiles []string,
+	importPaths []string,
+	requestURL *url.URL,
+	headers types.Headers,
+	h2 *h2ClientPool,
+	timeout time.Duration,
+) (*grpcMethod, error) {
+	serviceName, methodName, _ := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+
+	var (
+		files *protoregistry.Files
+		err   error
+	)
+	if len(protoFiles) > 0 {
+		files, err = compileGRPCFiles(ctx, protoFiles, importPaths)
+	} else {
+		metadata := make(http.Header)
+		for _, header := range headers {
+			if len(header.Value) > 0 {
+				metadata.Add(header.Key, header.Value[0])
+			}
+		}
+		reflection := &grpcReflection{
+			client:      h2.get(requestURL.Scheme == "https", requestURL.Host, h2.tls.firstCert()),
+			scheme:      requestURL.Scheme,
+			host:        requestURL.Host,
+			metadata:    metadata,
+			timeout:     timeout,
+			descriptors: make(map[string]*descriptorpb.FileDescriptorProto),
+		}
+		files, err = reflection.filesOf(serviceName)
+	}
+	if err != nil {
+		return nil, types.NewGRPCMethodResolveError(method, err)
+	}
+
+	descriptor, err := files.FindDescriptorByName(protoreflect.FullName(serviceName))
+	service, ok := descriptor.(protoreflect.ServiceDescriptor)
+	if err != nil || !ok {
+		return nil, types.NewGRPCMethodResolveError(method, types.ErrGRPCServiceNotFound)
+	}
+	methodDescriptor := service.Methods().ByName(protoreflect.Name(methodName))
+	if methodDescriptor == nil {
+		return nil, types.NewGRPCMethodResolveError(method, types.ErrGRPCMethodNotFound)
+	}
+
+	return &grpcMethod{
+		path:       "/" + serviceName + "/" + methodName,
+		descriptor: methodDescriptor,
+		types:      dynamicpb.NewTypes(files),
+	}, nil
+}
+
+// compileGRPCFiles compiles the proto files, looking them and their imports up
+// in the import paths, or relative to the working directory without any. The
+// well-known types are built in.
+func compileGRPCFiles(ctx context.Context, protoFiles []string, importPaths []string) (*protoregistry.Files, error) {
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{ImportPaths: importPaths}),
+	}
+	compiled, err := compiler.Compile(ctx, protoFiles...)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	files := new(protoregistry.Files)
+	for _, file := range compiled {
+		if err := registerGRPCFile(files, file); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// registerGRPCFile adds file to files, after the files it imports.
+func registerGRPCFile(files *protoregistry.Files, file protoreflect.FileDescriptor) error {
+	if _, err := files.FindFileByPath(file.Path()); err == nil {
+		return nil
+	}
+
+	imports := file.Imports()
+	for i := range imports.Len() {
+		if err := registerGRPCFile(files, imports.Get(i).FileDescriptor); err != nil {
+			return err
+		}
+	}
+	return files.RegisterFile(file) //nolint:wrapcheck
+}
+
+// Field numbers of the server reflection messages, which are encoded by hand
+// since only a few of their fields are needed.
+const (
+	reflectionRequestFileByFilename       protowire.Number = 3
+	reflectionRequestFileContainingSymbol protowire.Number = 4
+	reflectionResponseFileDescriptors     protowire.Number = 4
+	reflectionResponseError               protowire.Number = 7
+	reflectionFileDescriptorProto         protowire.Number = 1
+	reflectionErrorCode                   protowire.Number = 1
+	reflectionErrorMessage                protowire.Number = 2
+)
+
+// grpcReflectionPaths are the methods of the server reflection service, newest
+// version first. Both versions encode their messages the same way.
+var grpcReflectionPaths = [...]string{
+	"/grpc.reflection.v1.ServerReflection/ServerReflectionInfo",
+	"/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo",
+}
+
+// grpcReflection fetches the proto files of a server from its server
+// reflection service.
+type grpcReflection struct {
+	client   *h2HostClient
+	scheme   string
+	host     string
+	metadata http.Header
+	timeout  time.Duration
+	// version indexes grpcReflectionPaths. It moves on to the older version
+	// once the server turns out not to implement the newer one.
+	version int
+	// descriptors holds the files the server sent, by name.
+	descriptors map[string]*descriptorpb.FileDescriptorProto
+}
+
+// filesOf returns the file that defines symbol, along with the files it
+// imports.
+// It can return the following errors:
+//   - types.GRPCReflectionError
+//   - types.ErrGRPCReflectionFileMissing
+func (reflection *grpcReflection) filesOf(symbol string) (*protoregistry.Files, error) {
+	names, err := reflection.fetch(reflectionRequestFileContainingSymbol, symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	files := new(protoregistry.Files)
+	for _, name := range names {
+		if err := reflection.register(files, name); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// register adds the file name to files, after the files it imports, asking the
+// server for any it didn't send yet. Files the server doesn't have, such as the
+// well-known types, are taken from the ones built in.
+func (reflection *grpcReflection) register(files *protoregistry.Files, name string) error {
+	if _, err := files.FindFileByPath(name); err == nil {
+		return nil
+	}
+
+	descriptor, ok := reflection.descriptors[name]
+	if !ok {
+		_, err := reflection.fetch(reflectionRequestFileByFilename, name)
+		if err != nil {
+			if file, globalErr := protoregistry.GlobalFiles.FindFileByPath(name); globalErr == nil {
+				return registerGRPCFile(files, file)
+			}
+			return err
+		}
+		if descriptor, ok = reflection.descriptors[name]; !ok {
+			return types.ErrGRPCReflectionFileMissing
+		}
+	}
+
+	for _, dependency := range descriptor.GetDependency() {
+		if err := reflection.register(files, dependency); err != nil {
+			return err
+		}
+	}
+	file, err := protodesc.NewFile(descriptor, files)
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+	return files.RegisterFile(file) //nolint:wrapcheck
+}
+
+// fetch sends a server reflection request that sets the field to value, keeps
+// the files the server sends back and returns their names.
+func (reflection *grpcReflection) fetch(field protowire.Number, value string) ([]string, error) {
+	request := protowire.AppendTag(nil, field, protowire.BytesType)
+	request = protowire.AppendString(request, value)
+	body := appendGRPCMessage(nil, request)
+
+	for {
+		var (
+			messages [][]byte
+			code     int
+			message  string
+		)
+		err := reflection.client.roundTrip(
+			reflection.timeout,
+			nil,
+			func(ctx context.Context) (*http.Request, error) {
+				return newGRPCRequest(ctx, reflection.scheme, reflection.host, grpcReflectionPaths[reflection.version], reflection.metadata, body)
+			},
+			func(httpResp *http.Response) error {
+				var err error
+				messages, err = readGRPCMessages(httpResp)
+				code, message = grpcStatus(httpResp)
+				return err
+			},
+		)
+		switch {
+		case err != nil:
+			return nil, err
+		case code == grpcCodeUnimplemented && reflection.version+1 < len(grpcReflectionPaths):
+			reflection.version++
+			continue
+		case code != grpcCodeOK:
+			return nil, types.NewGRPCReflectionError(grpcCodeName(code), message)
+		}
+
+		var names []string
+		for _, response := range messages {
+			encodedFiles, err := parseReflectionResponse(response)
+			if err != nil {
+				return nil, err
+			}
+			for _, encoded := range encodedFiles {
+				descriptor := &descriptorpb.FileDescriptorProto{}
+				if err := proto.Unmarshal(encoded, descriptor); err != nil {
+					return nil, err //nolint:wrapcheck
+				}
+				if _, ok := reflection.descriptors[descriptor.GetName()]; !ok {
+					reflection.descriptors[descriptor.GetName()] = descriptor
+				}
+				names = append(names, descriptor.GetName())
+			}
+		}
+		return names, nil
+	}
+}
+
+// parseReflectionResponse returns the encoded files in a server reflection
+// response, or the error it reports.
+// It can return the following errors:
+//   - types.GRPCReflectionError
+func parseReflectionResponse(response []byte) ([][]byte, error) {
+	fields, err := parseProtoFields(response)
+	if err != nil {
+		return nil, err
+	}
+
+	var files [][]byte
+	for _, field := range fields {
+		switch field.number {
+		case reflectionResponseFileDescriptors:
+			descriptorFields, err := parseProtoFields(field.bytes)
+			if err != nil {
+				return nil, err
+			}
+			for _, descriptorField := range descriptorFields {
+				if descriptorField.number == reflectionFileDescriptorProto {
+					files = append(files, descriptorField.bytes)
+				}
+			}
+		case reflectionResponseError:
+			errorFields, err := parseProtoFields(field.bytes)
+			if err != nil {
+				return nil, err
+			}
+			var (
+				code    int
+				message string
+			)
+			for _, errorField := range errorFields {
+				switch errorField.number {
+				case reflectionErrorCode:
+					code = int(int32(errorField.varint)) //nolint:gosec
+				case reflectionErrorMessage:
+					message = string(errorField.bytes)
+				}
+			}
+			return nil, types.NewGRPCReflectionError(grpcCodeName(code), message)
+		}
+	}
+	return files, nil
+}
+
+// protoField is a field of an encoded protobuf message. varint holds the
+// value of a varint field, and bytes the value of a length-delimited one.
+type protoField struct {
+	number protowire.Number
+	varint uint64
+	bytes  []byte
+}
+
+// parseProtoFields splits an encoded protobuf message into its fields. Fields
+// of other wire types are returned without their value.
+func parseProtoFields(message []byte) ([]protoField, error) {
+	var fields []protoField
+	for len(message) > 0 {
+		number, wireType, n := protowire.ConsumeTag(message)
+		if n < 0 {
+			return nil, protowire.ParseError(n) //nolint:wrapcheck
+		}
+		message = message[n:]
+
+		field := protoField{number: number}
+		switch wireType {
+		case protowire.VarintType:
+			field.varint, n = protowire.ConsumeVarint(message)
+		case protowire.BytesType:
+			field.bytes, n = protowire.ConsumeBytes(message)
+		default:
+			n = protowire.ConsumeFieldValue(number, wireType, message)
+		}
+		if n < 0 {
+			return nil, protowire.ParseError(n) //nolint:wrapcheck
+		}
+		message = message[n:]
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// newGRPCRequest creates the request of a call to the method at path on host,
+// with the metadata, apart from the headers gRPC sets itself, and the encoded
+// messages in body.
+func newGRPCRequest(ctx context.Context, scheme, host, path string, metadata http.Header, body []byte) (*http.Request, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, scheme+"://"+host+path, nil)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+	// Like gRPC clients, the body is sent without a length, so it ends with
+	// a DATA frame even when the method streams no messages. Servers don't
+	// all accept a request that ends with its headers.
+	httpReq.Body = io.NopCloser(bytes.NewReader(body))
+	httpReq.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+
+	for key, values := range metadata {
+		if slices.ContainsFunc(grpcReservedHeaders, func(reserved string) bool { return strings.EqualFold(key, reserved) }) {
+			continue
+		}
+		for _, value := range values {
+			httpReq.Header.Add(key, value)
+		}
+	}
+	httpReq.Header.Set(fasthttp.HeaderContentType, "application/grpc")
+	httpReq.Header.Set(fasthttp.HeaderTE, "trailers")
+	if deadline, ok := ctx.Deadline(); ok {
+		httpReq.Header.Set("Grpc-Timeout", grpcTimeout(time.Until(deadline)))
+	}
+	return httpReq, nil
+}
+
+// grpcTimeout formats timeout as the value of the Grpc-Timeout header, which
+// allows at most 8 digits.
+func grpcTimeout(timeout time.Duration) string {
+	if milliseconds := timeout.Milliseconds(); milliseconds < 1e8 {
+		return strconv.FormatInt(max(milliseconds, 1), 10) + "m"
+	}
+	return strconv.FormatInt(min(int64(timeout.Seconds()), 1e8-1), 10) + "S"
+}
+
+// appendGRPCMessage appends message to body, prefixed the way gRPC frames an
+// uncompressed message.
+func appendGRPCMessage(body, message []byte) []byte {
+	body = append(body, 0)
+	body = binary.BigEndian.AppendUint32(body, uint32(len(message))) //nolint:gosec
+	return append(body, message...)
+}
+
+// readGRPCMessages reads the body of a gRPC response and splits it into its
+// messages. The body of a response with an HTTP status other than 200 isn't
+// gRPC, so it holds no messages.
+// It can return the following errors:
+//   - types.ErrGRPCMessageCompressed
+//   - types.ErrGRPCMessageTruncated
+func readGRPCMessages(httpResp *http.Response) ([][]byte, error) {
+	body, err := io.ReadAll(httpResp.Body)
+	if err != nil || httpResp.StatusCode != http.StatusOK {
+		return nil, err //nolint:wrapcheck
+	}
+
+	var messages [][]byte
+	for len(body) > 0 {
+		if len(body) < 5 {
+			return nil, types.ErrGRPCMessageTruncated
+		}
+		if body[0] != 0 {
+			return nil, types.ErrGRPCMessageCompressed
+		}
+		length := binary.BigEndian.Uint32(body[1:5])
+		if uint64(len(body)-5) < uint64(length) {
+			return nil, types.ErrGRPCMessageTruncated
+		}
+		messages = append(messages, body[5:5+length])
+		body = body[5+length:]
+	}
+	return messages, nil
+}
+
+// grpcStatus returns the status code and message of a gRPC response whose
+// body was read. A response without a status gets the one its HTTP status maps
+// to.
+func grpcStatus(httpResp *http.Response) (int, string) {
+	value := httpResp.Trailer.Get(grpcStatusHeader)
+	message := httpResp.Trailer.Get("Grpc-Message")
+	// A response without messages may carry the status in its headers.
+	if value == "" {
+		value = httpResp.Header.Get(grpcStatusHeader)
+		message = httpResp.Header.Get("Grpc-Message")
+	}
+	if unescaped, err := url.PathUnescape(message); err == nil {
+		message = unescaped
+	}
+
+	if value == "" {
+		switch httpResp.StatusCode {
+		case http.StatusOK, http.StatusBadRequest:
+			return grpcCodeInternal, message
+		case http.StatusUnauthorized:
+			return grpcCodeUnauthenticated, message
+		case http.StatusForbidden:
+			return grpcCodePermissionDenied, message
+		case http.StatusNotFound:
+			return grpcCodeUnimplemented, message
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return grpcCodeUnavailable, message
+		default:
+			return grpcCodeUnknown, message
+		}
+	}
+
+	code, err := strconv.Atoi(value)
+	if err != nil || code < 0 || code >= len(grpcCodeNames) {
+		return grpcCodeUnknown, message
+	}
+	return code, message
+}
+
+// encodeRequest encodes the JSON messages in body, one after the other, as the
+// body of a call. An empty body is a single empty message, unless the method
+// streams requests.
+// It can return the following errors:
+//   - types.GRPCMessageEncodeError
+//   - types.ErrGRPCMessageCount
+func (method *grpcMethod) encodeRequest(body []byte) ([]byte, error) {
+	var (
+		frames    = []byte{}
+		count     int
+		decoder   = json.NewDecoder(bytes.NewReader(body))
+		unmarshal = protojson.UnmarshalOptions{Resolver: method.types}
+	)
+	for {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, types.NewGRPCMessageEncodeError(err)
+		}
+
+		message := dynamicpb.NewMessage(method.descriptor.Input())
+		if err := unmarshal.Unmarshal(raw, message); err != nil {
+			return nil, types.NewGRPCMessageEncodeError(err)
+		}
+		encoded, err := proto.Marshal(message)
+		if err != nil {
+			return nil, types.NewGRPCMessageEncodeError(err)
+		}
+		frames = appendGRPCMessage(frames, encoded)
+		count++
+	}
+
+	switch {
+	case method.descriptor.IsStreamingClient():
+	case count == 0:
+		frames = appendGRPCMessage(frames, nil)
+	case count > 1:
+		return nil, types.ErrGRPCMessageCount
+	}
+	return frames, nil
+}
+
+// readResponse reads the response of a call into resp: its headers and
+// trailers as headers, with the status code in Grpc-Status, and its messages as
+// JSON in the body. The body is the message itself, unless the method streams
+// responses, in which case it is an array of them.
+// It can return the following errors:
+//   - types.GRPCMessageDecodeError
+//   - types.ErrGRPCMessageCompressed
+//   - types.ErrGRPCMessageTruncated
+func (method *grpcMethod) readResponse(httpResp *http.Response, resp *fasthttp.Response) error {
+	messages, err := readGRPCMessages(httpResp)
+	if err != nil {
+		return err
+	}
+
+	resp.Reset()
+	resp.SetStatusCode(httpResp.StatusCode)
+	for _, header := range []http.Header{httpResp.Header, httpResp.Trailer} {
+		for key, values := range header {
+			if key == fasthttp.HeaderContentLength {
+				continue
+			}
+			for _, value := range values {
+				resp.Header.Add(key, value)
+			}
+		}
+	}
+	code, _ := grpcStatus(httpResp)
+	resp.Header.Set(grpcStatusHeader, strconv.Itoa(code))
+
+	marshal := protojson.MarshalOptions{Resolver: method.types, EmitUnpopulated: true}
+	decoded := make([][]byte, len(messages))
+	for i, encoded := range messages {
+		message := dynamicpb.NewMessage(method.descriptor.Output())
+		if err := proto.Unmarshal(encoded, message); err != nil {
+			return types.NewGRPCMessageDecodeError(err)
+		}
+		if decoded[i], err = marshal.Marshal(message); err != nil {
+			return types.NewGRPCMessageDecodeError(err)
+		}
+	}
+
+	switch {
+	case method.descriptor.IsStreamingServer():
+		resp.SetBody(slices.Concat([]byte{'['}, bytes.Join(decoded, []byte{','}), []byte{']'}))
+	case len(decoded) > 0:
+		resp.SetBody(decoded[0])
+	}
+	resp.Header.SetContentLength(len(resp.Body()))
+	return nil
+}
+
+// grpcWorkerClient is a worker's handle on a shared h2HostClient, which calls
+// the run's gRPC method with the JSON messages in the body of each request,
+// and reports the phases of the calls to the worker's trace.
+type grpcWorkerClient struct {
+	client *h2HostClient
+	method *grpcMethod
+	trace  *requestTrace
+
+	// body and frames are the last request body and its encoding, which are
+	// reused as long as the bodies don't change.
+	body   []byte
+	frames []byte
+}
+
+// DoTimeout calls the method with the messages in the body of req and reads
+// the response into resp within timeout. The headers of req are sent as the
+// metadata of the call.
+// It can return the following errors:
+//   - types.GRPCMessageEncodeError
+//   - types.GRPCMessageDecodeError
+//   - types.ErrGRPCMessageCount
+//   - types.ErrGRPCMessageCompressed
+//   - types.ErrGRPCMessageTruncated
+func (worker *grpcWorkerClient) DoTimeout(req *fasthttp.Request, resp *fasthttp.Response, timeout time.Duration) error {
+	if worker.frames == nil || !bytes.Equal(req.Body(), worker.body) {
+		frames, err := worker.method.encodeRequest(req.Body())
+		if err != nil {
+			return err
+		}
+		// The transport may still be reading the previous frames, so they
+		// are replaced rather than overwritten.
+		worker.body, worker.frames = bytes.Clone(req.Body()), frames
+	}
+
+	metadata := make(http.Header)
+	for key, value := range req.Header.All() {
+		metadata.Add(string(key), string(value))
+	}
+	scheme, host := string(req.URI().Scheme()), string(req.Host())
+
+	return worker.client.roundTrip(
+		timeout,
+		worker.trace,
+		func(ctx context.Context) (*http.Request, error) {
+			return newGRPCRequest(ctx, scheme, host, worker.method.path, metadata, worker.frames)
+		},
+		func(httpResp *http.Response) error { return worker.method.readResponse(httpResp, resp) },
+	)
+}
+
+// CloseIdleConnections does nothing, since the connections are shared with the
+// other workers. The pool closes them at the end of the run.
+func (*grpcWorkerClient) CloseIdleConnections() {}
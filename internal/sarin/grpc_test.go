@@ -0,0 +1,652 @@
+package sarin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"go.aykhans.me/sarin/internal/types"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const greeterProto = `syntax = "proto3";
+
+package test;
+
+import "google/protobuf/timestamp.proto";
+
+message HelloRequest {
+  string name = 1;
+  int32 times = 2;
+  google.protobuf.Timestamp at = 3;
+}
+
+message HelloReply {
+  string message = 1;
+}
+
+service Greeter {
+  rpc SayHello(HelloRequest) returns (HelloReply);
+  rpc SayHellos(HelloRequest) returns (stream HelloReply);
+  rpc Collect(stream HelloRequest) returns (HelloReply);
+  rpc Fail(HelloRequest) returns (HelloReply);
+}
+`
+
+// greeterToken is the metadata the test server wants with every call.
+const greeterToken = "secret"
+
+// writeGreeterProto writes greeterProto to a temporary directory and returns
+// the directory and the name of the file in it.
+func writeGreeterProto(t *testing.T) (string, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "greeter.proto"), []byte(greeterProto), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return dir, "greeter.proto"
+}
+
+// resolveGreeterMethod resolves method of greeterProto from the proto file.
+func resolveGreeterMethod(t *testing.T, method string) *grpcMethod {
+	t.Helper()
+
+	dir, file := writeGreeterProto(t)
+	resolved, err := resolveGRPCMethod(t.Context(), method, []string{file}, []string{dir}, nil, nil, nil, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resolved
+}
+
+// startGRPCServer serves the Greeter service of greeterProto over h2c, with
+// version v1alpha of the server reflection service. Calls without the
+// X-Token metadata are rejected.
+func startGRPCServer(t *testing.T) *h2Server {
+	t.Helper()
+
+	dir, file := writeGreeterProto(t)
+	files, err := compileGRPCFiles(t.Context(), []string{file}, []string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	descriptor, err := files.FindDescriptorByName("test.Greeter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := descriptor.(protoreflect.ServiceDescriptor) //nolint:forcetypeassert
+
+	return startH2Server(t, false, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(fasthttp.HeaderContentType, "application/grpc")
+		if r.Header.Get(fasthttp.HeaderContentType) != "application/grpc" || r.Header.Get(fasthttp.HeaderTE) != "trailers" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		requests, err := readGRPCMessages(&http.Response{StatusCode: http.StatusOK, Body: r.Body})
+		if err != nil {
+			writeGRPCStatus(w, 3, err.Error())
+			return
+		}
+		if r.Header.Get("X-Token") != greeterToken {
+			writeGRPCStatus(w, grpcCodeUnauthenticated, "")
+			return
+		}
+
+		serviceName, methodName, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		switch serviceName {
+		case "grpc.reflection.v1alpha.ServerReflection":
+			serveGRPCReflection(w, files, requests)
+			return
+		case string(service.FullName()):
+		default:
+			writeGRPCStatus(w, grpcCodeUnimplemented, "")
+			return
+		}
+		method := service.Methods().ByName(protoreflect.Name(methodName))
+		if method == nil {
+			writeGRPCStatus(w, grpcCodeUnimplemented, "")
+			return
+		}
+
+		var names []string
+		var times int64
+		for _, request := range requests {
+			message := dynamicpb.NewMessage(method.Input())
+			if err := proto.Unmarshal(request, message); err != nil {
+				writeGRPCStatus(w, 3, err.Error())
+				return
+			}
+			names = append(names, message.Get(method.Input().Fields().ByName("name")).String())
+			times = message.Get(method.Input().Fields().ByName("times")).Int()
+		}
+
+		var replies []string
+		switch methodName {
+		case "SayHello", "Collect":
+			replies = []string{"hello " + strings.Join(names, ", ")}
+		case "SayHellos":
+			for i := range times {
+				replies = append(replies, "hello "+names[0]+" #"+strconv.FormatInt(i+1, 10))
+			}
+		case "Fail":
+			writeGRPCStatus(w, 5, url.PathEscape("no user: "+names[0]))
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		for _, reply := range replies {
+			message := dynamicpb.NewMessage(method.Output())
+			message.Set(method.Output().Fields().ByName("message"), protoreflect.ValueOfString(reply))
+			encoded, _ := proto.Marshal(message)
+			w.Write(appendGRPCMessage(nil, encoded)) //nolint:errcheck
+		}
+		w.Header().Set(http.TrailerPrefix+grpcStatusHeader, "0")
+	}))
+}
+
+// writeGRPCStatus ends a call with a response without messages, which carries
+// its status in the headers.
+func writeGRPCStatus(w http.ResponseWriter, code int, message string) {
+	w.Header().Set(grpcStatusHeader, strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set("Grpc-Message", message)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// serveGRPCReflection answers server reflection requests for the files, which
+// don't include the well-known types.
+func serveGRPCReflection(w http.ResponseWriter, files *protoregistry.Files, requests [][]byte) {
+	w.WriteHeader(http.StatusOK)
+	for _, request := range requests {
+		fields, _ := parseProtoFields(request)
+
+		var (
+			file protoreflect.FileDescriptor
+			err  error
+		)
+		switch fields[0].number {
+		case reflectionRequestFileByFilename:
+			file, err = files.FindFileByPath(string(fields[0].bytes))
+		case reflectionRequestFileContainingSymbol:
+			var descriptor protoreflect.Descriptor
+			if descriptor, err = files.FindDescriptorByName(protoreflect.FullName(fields[0].bytes)); err == nil {
+				file = descriptor.ParentFile()
+			}
+		}
+
+		var response []byte
+		if err != nil {
+			errorResponse := protowire.AppendTag(nil, reflectionErrorCode, protowire.VarintType)
+			errorResponse = protowire.AppendVarint(errorResponse, 5)
+			errorResponse = protowire.AppendTag(errorResponse, reflectionErrorMessage, protowire.BytesType)
+			errorResponse = protowire.AppendString(errorResponse, "not found")
+			response = protowire.AppendTag(response, reflectionResponseError, protowire.BytesType)
+			response = protowire.AppendBytes(response, errorResponse)
+		} else {
+			encoded, _ := proto.Marshal(protodesc.ToFileDescriptorProto(file))
+			descriptors := protowire.AppendTag(nil, reflectionFileDescriptorProto, protowire.BytesType)
+			descriptors = protowire.AppendBytes(descriptors, encoded)
+			response = protowire.AppendTag(response, reflectionResponseFileDescriptors, protowire.BytesType)
+			response = protowire.AppendBytes(response, descriptors)
+		}
+		w.Write(appendGRPCMessage(nil, response)) //nolint:errcheck
+	}
+	w.Header().Set(http.TrailerPrefix+grpcStatusHeader, "0")
+}
+
+func TestResolveGRPCMethod(t *testing.T) {
+	t.Parallel()
+
+	server := startGRPCServer(t)
+	pool := newTestH2ClientPool(t, 1, 1)
+	requestURL := &url.URL{Scheme: "http", Host: server.addr}
+	headers := types.Headers{{Key: "X-Token", Value: []string{greeterToken}}}
+	dir, file := writeGreeterProto(t)
+
+	tests := []struct {
+		name       string
+		method     string
+		wantPath   string
+		wantInput  protoreflect.FullName
+		wantErr    error
+		reflectErr bool
+	}{
+		{name: "Unary", method: "test.Greeter/SayHello", wantPath: "/test.Greeter/SayHello", wantInput: "test.HelloRequest"},
+		{name: "Leading slash", method: "/test.Greeter/SayHellos", wantPath: "/test.Greeter/SayHellos", wantInput: "test.HelloRequest"},
+		{name: "Unknown method", method: "test.Greeter/SayBye", wantErr: types.ErrGRPCMethodNotFound},
+		{name: "Message as service", method: "test.HelloRequest/SayHello", wantErr: types.ErrGRPCServiceNotFound},
+		// The server reflection service has no file for a symbol it doesn't
+		// know, so the method is left unresolved with the error it reports.
+		{name: "Unknown service", method: "test.Farewell/SayBye", wantErr: types.ErrGRPCServiceNotFound, reflectErr: true},
+	}
+
+	for _, source := range []string{"proto files", "reflection"} {
+		t.Run(source, func(t *testing.T) {
+			t.Parallel()
+
+			for _, test := range tests {
+				t.Run(test.name, func(t *testing.T) {
+					t.Parallel()
+
+					var (
+						method *grpcMethod
+						err    error
+					)
+					if source == "reflection" {
+						method, err = resolveGRPCMethod(t.Context(), test.method, nil, nil, requestURL, headers, pool, 5*time.Second)
+					} else {
+						method, err = resolveGRPCMethod(t.Context(), test.method, []string{file}, []string{dir}, nil, nil, nil, 0)
+					}
+
+					if test.wantErr == nil {
+						if err != nil {
+							t.Fatalf("got error %v", err)
+						}
+						if method.path != test.wantPath {
+							t.Errorf("got path %q, want %q", method.path, test.wantPath)
+						}
+						if got := method.descriptor.Input().FullName(); got != test.wantInput {
+							t.Errorf("got input %s, want %s", got, test.wantInput)
+						}
+						return
+					}
+
+					resolveErr, ok := errors.AsType[types.GRPCMethodResolveError](err)
+					if !ok {
+						t.Fatalf("got error %v, want a types.GRPCMethodResolveError", err)
+					}
+					if resolveErr.Method != test.method {
+						t.Errorf("got method %q, want %q", resolveErr.Method, test.method)
+					}
+					if source == "reflection" && test.reflectErr {
+						if _, ok := errors.AsType[types.GRPCReflectionError](err); !ok {
+							t.Errorf("got error %v, want a types.GRPCReflectionError", err)
+						}
+						return
+					}
+					if !errors.Is(err, test.wantErr) {
+						t.Errorf("got error %v, want %v", err, test.wantErr)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestResolveGRPCMethodInvalidProto(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "broken.proto"), []byte("syntax = \"proto3\";\nmessage {"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := resolveGRPCMethod(t.Context(), "test.Greeter/SayHello", []string{"broken.proto"}, []string{dir}, nil, nil, nil, 0)
+	if _, ok := errors.AsType[types.GRPCMethodResolveError](err); !ok {
+		t.Errorf("got error %v, want a types.GRPCMethodResolveError", err)
+	}
+}
+
+func TestGRPCEncodeRequest(t *testing.T) {
+	t.Parallel()
+
+	// helloRequest is a decoded test.HelloRequest.
+	type helloRequest struct {
+		name      string
+		times     int64
+		atSeconds int64
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		body    string
+		want    []helloRequest
+		wantErr error
+		// wantEncodeErr is set when the body can't be encoded as the input
+		// message.
+		wantEncodeErr bool
+	}{
+		{
+			name:   "JSON names and well-known types",
+			method: "test.Greeter/SayHello",
+			body:   `{"name": "ada", "times": 2, "at": "2024-01-02T03:04:05Z"}`,
+			want:   []helloRequest{{name: "ada", times: 2, atSeconds: 1704164645}},
+		},
+		{name: "Empty body", method: "test.Greeter/SayHello", body: "", want: []helloRequest{{}}},
+		{name: "Empty object", method: "test.Greeter/SayHello", body: "{}", want: []helloRequest{{}}},
+		{name: "Empty body to a request stream", method: "test.Greeter/Collect", body: "", want: nil},
+		{
+			name:   "Messages of a request stream",
+			method: "test.Greeter/Collect",
+			body:   "{\"name\": \"ada\"}\n{\"name\": \"bob\"}",
+			want:   []helloRequest{{name: "ada"}, {name: "bob"}},
+		},
+		{name: "Messages of a unary request", method: "test.Greeter/SayHello", body: `{"name": "ada"} {"name": "bob"}`, wantErr: types.ErrGRPCMessageCount},
+		{name: "Invalid JSON", method: "test.Greeter/SayHello", body: `{"name": `, wantEncodeErr: true},
+		{name: "Unknown field", method: "test.Greeter/SayHello", body: `{"nickname": "ada"}`, wantEncodeErr: true},
+		{name: "Wrong type", method: "test.Greeter/SayHello", body: `{"times": "two"}`, wantEncodeErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			method := resolveGreeterMethod(t, test.method)
+			frames, err := method.encodeRequest([]byte(test.body))
+			switch {
+			case test.wantErr != nil:
+				if !errors.Is(err, test.wantErr) {
+					t.Errorf("got error %v, want %v", err, test.wantErr)
+				}
+				return
+			case test.wantEncodeErr:
+				if _, ok := errors.AsType[types.GRPCMessageEncodeError](err); !ok {
+					t.Errorf("got error %v, want a types.GRPCMessageEncodeError", err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("got error %v", err)
+			}
+
+			messages, err := readGRPCMessages(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(frames))})
+			if err != nil {
+				t.Fatal(err)
+			}
+			input := method.descriptor.Input()
+			at := input.Fields().ByName("at").Message()
+			var got []helloRequest
+			for _, encoded := range messages {
+				message := dynamicpb.NewMessage(input)
+				if err := proto.Unmarshal(encoded, message); err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, helloRequest{
+					name:      message.Get(input.Fields().ByName("name")).String(),
+					times:     message.Get(input.Fields().ByName("times")).Int(),
+					atSeconds: message.Get(input.Fields().ByName("at")).Message().Get(at.Fields().ByName("seconds")).Int(),
+				})
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got messages %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestReadGRPCMessages(t *testing.T) {
+	t.Parallel()
+
+	frame := func(message string) []byte { return appendGRPCMessage(nil, []byte(message)) }
+
+	tests := []struct {
+		name    string
+		status  int
+		body    []byte
+		want    [][]byte
+		wantErr error
+	}{
+		{name: "No messages", status: http.StatusOK, body: nil, want: nil},
+		{name: "Empty message", status: http.StatusOK, body: frame(""), want: [][]byte{{}}},
+		{name: "Messages", status: http.StatusOK, body: bytes.Join([][]byte{frame("a"), frame("bc")}, nil), want: [][]byte{[]byte("a"), []byte("bc")}},
+		{name: "Length prefix", status: http.StatusOK, body: []byte{0, 0, 0, 0, 2, 'a', 'b', 0, 0, 0, 0, 0}, want: [][]byte{[]byte("ab"), {}}},
+		{name: "Truncated prefix", status: http.StatusOK, body: append(frame("a"), 0, 0, 0), wantErr: types.ErrGRPCMessageTruncated},
+		{name: "Truncated message", status: http.StatusOK, body: frame("abc")[:7], wantErr: types.ErrGRPCMessageTruncated},
+		{name: "Compressed message", status: http.StatusOK, body: []byte{1, 0, 0, 0, 1, 'a'}, wantErr: types.ErrGRPCMessageCompressed},
+		{name: "Not gRPC", status: http.StatusNotFound, body: []byte("404 page not found"), want: nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := readGRPCMessages(&http.Response{StatusCode: test.status, Body: io.NopCloser(bytes.NewReader(test.body))})
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("got error %v, want %v", err, test.wantErr)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got messages %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAppendGRPCMessage(t *testing.T) {
+	t.Parallel()
+
+	message := bytes.Repeat([]byte{'a'}, 300)
+	got := appendGRPCMessage([]byte("prefix"), message)
+	if !bytes.HasPrefix(got, []byte("prefix")) {
+		t.Fatal("the body before the message was lost")
+	}
+	got = got[len("prefix"):]
+	if got[0] != 0 {
+		t.Errorf("got compressed flag %d, want 0", got[0])
+	}
+	if length := binary.BigEndian.Uint32(got[1:5]); length != 300 {
+		t.Errorf("got length %d, want 300", length)
+	}
+	if !bytes.Equal(got[5:], message) {
+		t.Error("got a different message")
+	}
+}
+
+func TestGRPCStatus(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		status      int
+		header      http.Header
+		trailer     http.Header
+		wantCode    int
+		wantMessage string
+	}{
+		{name: "Trailer", status: http.StatusOK, trailer: http.Header{"Grpc-Status": {"0"}}, wantCode: grpcCodeOK},
+		{
+			name:        "Escaped message",
+			status:      http.StatusOK,
+			trailer:     http.Header{"Grpc-Status": {"5"}, "Grpc-Message": {"no%20user%3A%20%C3%BC"}},
+			wantCode:    5,
+			wantMessage: "no user: ü",
+		},
+		{name: "Invalid escape", status: http.StatusOK, trailer: http.Header{"Grpc-Status": {"13"}, "Grpc-Message": {"100%"}}, wantCode: grpcCodeInternal, wantMessage: "100%"},
+		{
+			name:        "Header without messages",
+			status:      http.StatusOK,
+			header:      http.Header{"Grpc-Status": {"16"}, "Grpc-Message": {"no%20token"}},
+			wantCode:    grpcCodeUnauthenticated,
+			wantMessage: "no token",
+		},
+		{
+			name:        "Trailer over header",
+			status:      http.StatusOK,
+			header:      http.Header{"Grpc-Status": {"14"}, "Grpc-Message": {"header"}},
+			trailer:     http.Header{"Grpc-Status": {"0"}},
+			wantCode:    grpcCodeOK,
+			wantMessage: "",
+		},
+		{name: "Out of range", status: http.StatusOK, trailer: http.Header{"Grpc-Status": {"17"}}, wantCode: grpcCodeUnknown},
+		{name: "Not a number", status: http.StatusOK, trailer: http.Header{"Grpc-Status": {"OK"}}, wantCode: grpcCodeUnknown},
+		{name: "No status", status: http.StatusOK, wantCode: grpcCodeInternal},
+		{name: "HTTP 400", status: http.StatusBadRequest, wantCode: grpcCodeInternal},
+		{name: "HTTP 401", status: http.StatusUnauthorized, wantCode: grpcCodeUnauthenticated},
+		{name: "HTTP 403", status: http.StatusForbidden, wantCode: grpcCodePermissionDenied},
+		{name: "HTTP 404", status: http.StatusNotFound, wantCode: grpcCodeUnimplemented},
+		{name: "HTTP 429", status: http.StatusTooManyRequests, wantCode: grpcCodeUnavailable},
+		{name: "HTTP 503", status: http.StatusServiceUnavailable, wantCode: grpcCodeUnavailable},
+		{name: "HTTP 500", status: http.StatusInternalServerError, wantCode: grpcCodeUnknown},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			code, message := grpcStatus(&http.Response{StatusCode: test.status, Header: test.header, Trailer: test.trailer})
+			if code != test.wantCode || message != test.wantMessage {
+				t.Errorf("got %s %q, want %s %q", grpcCodeName(code), message, grpcCodeName(test.wantCode), test.wantMessage)
+			}
+		})
+	}
+}
+
+func TestGRPCTimeout(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		timeout time.Duration
+		want    string
+	}{
+		{timeout: 0, want: "1m"},
+		{timeout: 500 * time.Microsecond, want: "1m"},
+		{timeout: 1500 * time.Millisecond, want: "1500m"},
+		{timeout: (1e8 - 1) * time.Millisecond, want: "99999999m"},
+		{timeout: 1e8 * time.Millisecond, want: "100000S"},
+		{timeout: 1e9 * time.Second, want: "99999999S"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.timeout.String(), func(t *testing.T) {
+			t.Parallel()
+
+			if got := grpcTimeout(test.timeout); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestGRPCWorkerClient(t *testing.T) {
+	t.Parallel()
+
+	server := startGRPCServer(t)
+	pool := newTestH2ClientPool(t, 1, 1)
+
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		token      string
+		wantStatus string
+		wantBody   string
+	}{
+		{name: "Unary", method: "test.Greeter/SayHello", body: `{"name": "ada"}`, token: greeterToken, wantStatus: "OK", wantBody: `{"message": "hello ada"}`},
+		{
+			name:       "Response stream",
+			method:     "test.Greeter/SayHellos",
+			body:       `{"name": "ada", "times": 2}`,
+			token:      greeterToken,
+			wantStatus: "OK",
+			wantBody:   `[{"message": "hello ada #1"}, {"message": "hello ada #2"}]`,
+		},
+		{name: "Empty response stream", method: "test.Greeter/SayHellos", body: `{"name": "ada"}`, token: greeterToken, wantStatus: "OK", wantBody: `[]`},
+		{
+			name:       "Request stream",
+			method:     "test.Greeter/Collect",
+			body:       `{"name": "ada"} {"name": "bob"}`,
+			token:      greeterToken,
+			wantStatus: "OK",
+			wantBody:   `{"message": "hello ada, bob"}`,
+		},
+		{name: "Error status", method: "test.Greeter/Fail", body: `{"name": "ada"}`, token: greeterToken, wantStatus: "NOT_FOUND"},
+		{name: "Without metadata", method: "test.Greeter/SayHello", body: `{"name": "ada"}`, wantStatus: "UNAUTHENTICATED"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			client := &grpcWorkerClient{
+				client: pool.get(false, server.addr, nil),
+				method: resolveGreeterMethod(t, test.method),
+				trace:  &requestTrace{},
+			}
+			req := fasthttp.AcquireRequest()
+			defer fasthttp.ReleaseRequest(req)
+			resp := fasthttp.AcquireResponse()
+			defer fasthttp.ReleaseResponse(resp)
+
+			req.SetRequestURI("http://" + server.addr + "/")
+			req.SetBodyString(test.body)
+			if test.token != "" {
+				req.Header.Set("X-Token", test.token)
+			}
+			// The second call reuses the frames of the first.
+			for range 2 {
+				if err := client.DoTimeout(req, resp, 5*time.Second); err != nil {
+					t.Fatal(err)
+				}
+				if got := grpcStatusKey(resp); got != test.wantStatus {
+					t.Errorf("got status %s, want %s", got, test.wantStatus)
+				}
+				if test.wantBody == "" {
+					if len(resp.Body()) != 0 {
+						t.Errorf("got body %q, want none", resp.Body())
+					}
+					continue
+				}
+
+				var got, want any
+				if err := json.Unmarshal(resp.Body(), &got); err != nil {
+					t.Fatalf("got body %q: %v", resp.Body(), err)
+				}
+				if err := json.Unmarshal([]byte(test.wantBody), &want); err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("got body %s, want %s", resp.Body(), test.wantBody)
+				}
+			}
+		})
+	}
+}
+
+func TestGRPCStatusKey(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "0", want: "OK"},
+		{value: "5", want: "NOT_FOUND"},
+		{value: "16", want: "UNAUTHENTICATED"},
+		{value: "17", want: "UNKNOWN"},
+		{value: "-1", want: "UNKNOWN"},
+		{value: "", want: "UNKNOWN"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.want+"/"+test.value, func(t *testing.T) {
+			t.Parallel()
+
+			resp := fasthttp.AcquireResponse()
+			defer fasthttp.ReleaseResponse(resp)
+			if test.value != "" {
+				resp.Header.Set(grpcStatusHeader, test.value)
+			}
+			if got := grpcStatusKey(resp); got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package sarin
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// histogramSubBucketBits sets the precision of histogram. Values below
+// 2^histogramSubBucketBits nanoseconds are stored exactly; every power-of-two
+// range above that is split into 2^(histogramSubBucketBits-1) linear buckets
+// reported by their midpoint, so a reported value is within 0.2% of the
+// recorded one.
+const histogramSubBucketBits = 9
+
+const (
+	histogramExactValues   = 1 << histogramSubBucketBits
+	histogramSubBucketHalf = histogramSubBucketBits - 1
+)
+
+// histogram is a log-bucketed (HDR-style) latency histogram. Its memory is
+// bounded by the largest value recorded, not by the number of distinct values,
+// and two histograms can be merged by adding their buckets. Count, min, max
+// and sum are tracked exactly; percentiles have the bucket precision.
+// It is not safe for concurrent use.
+type histogram struct {
+	// counts is indexed by histogramBucket and grown on demand.
+	counts []uint64
+	total  uint64
+	min    time.Duration
+	max    time.Duration
+	// sumHigh and sumLow hold the 128-bit sum of all recorded values, which
+	// can't overflow on any realistic run.
+	sumHigh uint64
+	sumLow  uint64
+}
+
+// histogramBucket returns the bucket index for a value in nanoseconds.
+func histogramBucket(value uint64) int {
+	if value < histogramExactValues {
+		return int(value)
+	}
+	shift := bits.Len64(value) - histogramSubBucketBits
+	return shift<<histogramSubBucketHalf + int(value>>shift)
+}
+
+// histogramBucketValue returns the value that represents a bucket: the value
+// itself for exact buckets, otherwise the middle of the bucket's range.
+func histogramBucketValue(index int) uint64 {
+	if index < histogramExactValues {
+		return uint64(index)
+	}
+	shift := index>>histogramSubBucketHalf - 1
+	top := uint64(index&(1<<histogramSubBucketHalf-1) + 1<<histogramSubBucketHalf)
+	return top<<shift + (uint64(1)<<shift)/2
+}
+
+func (h *histogram) record(value time.Duration) {
+	value = max(value, 0)
+
+	index := histogramBucket(uint64(value))
+	if index >= len(h.counts) {
+		h.counts = append(h.counts, make([]uint64, index+1-len(h.counts))...)
+	}
+	h.counts[index]++
+
+	if h.total == 0 || value < h.min {
+		h.min = value
+	}
+	if value > h.max {
+		h.max = value
+	}
+	h.total++
+
+	var carry uint64
+	h.sumLow, carry = bits.Add64(h.sumLow, uint64(value), 0)
+	h.sumHigh += carry
+}
+
+// reset empties h, keeping its buckets for reuse.
+func (h *histogram) reset() {
+	clear(h.counts)
+	*h = histogram{counts: h.counts}
+}
+
+// merge adds every value recorded in other to h.
+func (h *histogram) merge(other *histogram) {
+	if other.total == 0 {
+		return
+	}
+
+	if len(other.counts) > len(h.counts) {
+		h.counts = append(h.counts, make([]uint64, len(other.counts)-len(h.counts))...)
+	}
+	for i, count := range other.counts {
+		h.counts[i] += count
+	}
+
+	if h.total == 0 || other.min < h.min {
+		h.min = other.min
+	}
+	h.max = max(h.max, other.max)
+	h.total += other.total
+
+	var carry uint64
+	h.sumLow, carry = bits.Add64(h.sumLow, other.sumLow, 0)
+	h.sumHigh += other.sumHigh + carry
+}
+
+// mean returns the average of the recorded values, rounded to the nearest
+// nanosecond.
+func (h *histogram) mean() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	// Every value fits in 63 bits, so the quotient does too and Div64 can't
+	// overflow.
+	quotient, remainder := bits.Div64(h.sumHigh, h.sumLow, h.total)
+	if remainder >= h.total-remainder {
+		quotient++
+	}
+	return time.Duration(quotient)
+}
+
+// percentile returns the smallest recorded value (at bucket precision) that at
+// least percentile% of the values are less than or equal to.
+func (h *histogram) percentile(percentile float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+
+	target := uint64(math.Ceil(float64(h.total) * percentile / 100))
+	target = min(max(target, 1), h.total)
+
+	var cumulative uint64
+	for i, count := range h.counts {
+		cumulative += count
+		if cumulative >= target {
+			value := time.Duration(histogramBucketValue(i))
+			return min(max(value, h.min), h.max)
+		}
+	}
+	return h.max
+}
@@ -0,0 +1,232 @@
+package sarin
+
+import (
+	"math"
+	"math/rand/v2"
+	"slices"
+	"testing"
+	"time"
+)
+
+// histogramPrecision is how far a value reported by a histogram may be from
+// the recorded one, relative to it.
+const histogramPrecision = 0.002
+
+func TestHistogramBucket(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		value uint64
+		index int
+		// bucketValue is the value reported for the bucket.
+		bucketValue uint64
+	}{
+		{0, 0, 0},
+		{1, 1, 1},
+		{511, 511, 511},
+		// From 512 on, buckets cover 2, 4, 8... values and report their
+		// middle.
+		{512, 512, 513},
+		{513, 512, 513},
+		{1023, 767, 1023},
+		{1024, 768, 1026},
+		{1027, 768, 1026},
+		{1028, 769, 1030},
+		{math.MaxInt64, 14335, 1<<63 - 1<<53},
+	}
+
+	for _, test := range tests {
+		if got := histogramBucket(test.value); got != test.index {
+			t.Errorf("bucket of %d: got %d, want %d", test.value, got, test.index)
+		}
+		if got := histogramBucketValue(test.index); got != test.bucketValue {
+			t.Errorf("value of bucket %d: got %d, want %d", test.index, got, test.bucketValue)
+		}
+	}
+}
+
+func TestHistogramBucketValueIsInItsBucket(t *testing.T) {
+	t.Parallel()
+
+	for index := range histogramBucket(math.MaxInt64) + 1 {
+		value := histogramBucketValue(index)
+		if got := histogramBucket(value); got != index {
+			t.Fatalf("value %d of bucket %d is in bucket %d", value, index, got)
+		}
+	}
+}
+
+func TestHistogramPercentilePrecision(t *testing.T) {
+	t.Parallel()
+
+	//nolint:gosec // G404: Using non-cryptographic rand for load testing, not security
+	random := rand.New(rand.NewPCG(1, 2))
+	values := make([]time.Duration, 100000)
+	var h histogram
+	for i := range values {
+		// Spread the values from about a microsecond to a minute.
+		values[i] = time.Duration(math.Exp(random.Float64()*18) * float64(time.Microsecond))
+		h.record(values[i])
+	}
+	slices.Sort(values)
+
+	for _, percentile := range []float64{0, 1, 25, 50, 75, 90, 99, 99.9, 99.99, 100} {
+		rank := max(int(math.Ceil(float64(len(values))*percentile/100)), 1)
+		want := values[rank-1]
+		got := h.percentile(percentile)
+		if diff := math.Abs(float64(got-want)) / float64(want); diff > histogramPrecision {
+			t.Errorf("p%v: got %v, want %v (%.3f%% off)", percentile, got, want, diff*100)
+		}
+	}
+	if got := h.percentile(0); got < values[0] {
+		t.Errorf("p0: got %v, below the min %v", got, values[0])
+	}
+	if got := h.percentile(100); got > values[len(values)-1] {
+		t.Errorf("p100: got %v, above the max %v", got, values[len(values)-1])
+	}
+}
+
+func TestHistogramEdgeValues(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		values []time.Duration
+		min    time.Duration
+		max    time.Duration
+		mean   time.Duration
+		p50    time.Duration
+	}{
+		{"empty", nil, 0, 0, 0, 0},
+		{"zero", []time.Duration{0}, 0, 0, 0, 0},
+		{"negative", []time.Duration{-time.Second}, 0, 0, 0, 0},
+		{"one nanosecond", []time.Duration{1, 1}, 1, 1, 1, 1},
+		{"zero and one", []time.Duration{0, 1}, 0, 1, 1, 0},
+		{"max duration", []time.Duration{math.MaxInt64}, math.MaxInt64, math.MaxInt64, math.MaxInt64, math.MaxInt64},
+		{"min and max duration", []time.Duration{0, math.MaxInt64}, 0, math.MaxInt64, 1 << 62, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			var h histogram
+			for _, value := range test.values {
+				h.record(value)
+			}
+			if h.total != uint64(len(test.values)) {
+				t.Errorf("count: got %d, want %d", h.total, len(test.values))
+			}
+			if h.min != test.min {
+				t.Errorf("min: got %v, want %v", h.min, test.min)
+			}
+			if h.max != test.max {
+				t.Errorf("max: got %v, want %v", h.max, test.max)
+			}
+			if got := h.mean(); got != test.mean {
+				t.Errorf("mean: got %v, want %v", got, test.mean)
+			}
+			if got := h.percentile(50); got != test.p50 {
+				t.Errorf("p50: got %v, want %v", got, test.p50)
+			}
+		})
+	}
+}
+
+func TestHistogramSum(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		value   time.Duration
+		count   int
+		sumHigh uint64
+		sumLow  uint64
+	}{
+		{"no carry", time.Second, 3, 0, 3 * uint64(time.Second)},
+		{"one carry", math.MaxInt64, 3, 1, math.MaxInt64 - 2},
+		{"many carries", math.MaxInt64, 1000, 499, math.MaxUint64 - 999},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			var h histogram
+			for range test.count {
+				h.record(test.value)
+			}
+			if h.sumHigh != test.sumHigh || h.sumLow != test.sumLow {
+				t.Errorf("got a sum of %d:%d, want %d:%d", h.sumHigh, h.sumLow, test.sumHigh, test.sumLow)
+			}
+			if got := h.mean(); got != test.value {
+				t.Errorf("mean: got %v, want %v", got, test.value)
+			}
+		})
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	t.Parallel()
+
+	//nolint:gosec // G404: Using non-cryptographic rand for load testing, not security
+	random := rand.New(rand.NewPCG(3, 4))
+	var all histogram
+	shards := make([]histogram, 4)
+	for i := range 10000 {
+		value := time.Duration(random.Int64N(int64(time.Second)))
+		all.record(value)
+		shards[i%len(shards)].record(value)
+	}
+	// Shards that recorded nothing, or only the largest value, merge too.
+	shards = append(shards, histogram{})
+	all.record(math.MaxInt64)
+	var largest histogram
+	largest.record(math.MaxInt64)
+	shards = append(shards, largest)
+
+	var merged histogram
+	for i := range shards {
+		merged.merge(&shards[i])
+	}
+
+	if !slices.Equal(merged.counts, all.counts) {
+		t.Error("the merged buckets differ from those of a single histogram")
+	}
+	if merged.total != all.total || merged.min != all.min || merged.max != all.max {
+		t.Errorf("got count %d, min %v and max %v, want %d, %v and %v",
+			merged.total, merged.min, merged.max, all.total, all.min, all.max)
+	}
+	if merged.sumHigh != all.sumHigh || merged.sumLow != all.sumLow {
+		t.Errorf("got a sum of %d:%d, want %d:%d", merged.sumHigh, merged.sumLow, all.sumHigh, all.sumLow)
+	}
+	for _, percentile := range []float64{50, 90, 99, 99.99} {
+		if got, want := merged.percentile(percentile), all.percentile(percentile); got != want {
+			t.Errorf("p%v: got %v, want %v", percentile, got, want)
+		}
+	}
+}
+
+func TestHistogramReset(t *testing.T) {
+	t.Parallel()
+
+	var h histogram
+	h.record(time.Second)
+	buckets := cap(h.counts)
+	h.reset()
+
+	if h.total != 0 || h.min != 0 || h.max != 0 || h.sumHigh != 0 || h.sumLow != 0 {
+		t.Errorf("reset left %+v", h)
+	}
+	if cap(h.counts) != buckets {
+		t.Error("reset dropped the buckets")
+	}
+	if slices.ContainsFunc(h.counts, func(count uint64) bool { return count != 0 }) {
+		t.Error("reset left counts in the buckets")
+	}
+
+	h.record(time.Millisecond)
+	if h.min != time.Millisecond || h.max != time.Millisecond || h.total != 1 {
+		t.Errorf("got %+v after recording into a reset histogram", h)
+	}
+}
@@ -0,0 +1,390 @@
+package sarin
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// h2ClientPool holds the HTTP/2 clients of a run, one per scheme, host and
+// client certificate. The workers share them, so that their requests are multiplexed over the same
+// connections. It is safe for concurrent use.
+// A nil pool holds nothing.
+type h2ClientPool struct {
+	dials       []dialFunc
+	timeout     time.Duration
+	tls         *clientTLS
+	maxStreams  uint
+	connections uint
+
+	mu      sync.Mutex
+	clients map[h2ClientKey]*h2HostClient
+}
+
+type h2ClientKey struct {
+	isTLS bool
+	host  string
+	cert  *tls.Certificate
+}
+
+// newH2ClientPool returns nil unless the protocol is HTTP/2.
+func newH2ClientPool(
+	protocol Protocol,
+	dials []dialFunc,
+	timeout time.Duration,
+	tlsSettings *clientTLS,
+	maxStreams uint,
+	connections uint,
+) *h2ClientPool {
+	if protocol != ProtocolHTTP2 {
+		return nil
+	}
+	return &h2ClientPool{
+		dials:       dials,
+		timeout:     timeout,
+		tls:         tlsSettings,
+		maxStreams:  max(maxStreams, 1),
+		connections: max(connections, 1),
+		clients:     make(map[h2ClientKey]*h2HostClient),
+	}
+}
+
+// get returns the client for host that presents cert, creating it if this is
+// the first request to that host with cert. A nil cert presents none.
+func (pool *h2ClientPool) get(isTLS bool, host string, cert *tls.Certificate) *h2HostClient {
+	key := h2ClientKey{isTLS: isTLS, host: host, cert: cert}
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	client, ok := pool.clients[key]
+	if !ok {
+		client = pool.newHostClient(isTLS, host, cert)
+		pool.clients[key] = client
+	}
+	return client
+}
+
+// newHostClient creates a client with one transport per connection, each
+// dialing through the next of the pool's dial functions. Its connections only
+// offer h2 in ALPN, since they speak nothing else; config validation rejects
+// the ALPN setting with this protocol.
+func (pool *h2ClientPool) newHostClient(isTLS bool, host string, cert *tls.Certificate) *h2HostClient {
+	tlsConfig := pool.tls.config(host, cert)
+	tlsConfig.NextProtos = []string{"h2"}
+
+	var protocols http.Protocols
+	if isTLS {
+		protocols.SetHTTP2(true)
+	} else {
+		protocols.SetUnencryptedHTTP2(true)
+	}
+
+	client := &h2HostClient{conns: make([]h2Conn, pool.connections)}
+	for i := range client.conns {
+		// A transport adjusts the NextProtos of its TLS config when it
+		// first sends a request, so every transport gets its own.
+		connTLSConfig := tlsConfig.Clone()
+		dial := pool.tracedDialFunc(pool.dials[i%len(pool.dials)], isTLS, connTLSConfig)
+		client.conns[i] = h2Conn{
+			transport: &http.Transport{
+				DialContext:        dial,
+				DialTLSContext:     dial,
+				TLSClientConfig:    connTLSConfig,
+				ForceAttemptHTTP2:  true,
+				DisableCompression: true,
+				IdleConnTimeout:    pool.timeout,
+				Protocols:          &protocols,
+				// Requests wait for a free stream, or for the connection
+				// being dialed, instead of opening another connection, so
+				// the transport keeps a single one.
+				MaxConnsPerHost: 1,
+				HTTP2:           &http.HTTP2Config{StrictMaxConcurrentRequests: true},
+			},
+			streams: make(chan struct{}, pool.maxStreams),
+		}
+	}
+	return client
+}
+
+// tracedDialFunc turns dial into a dial function for an http.Transport. The
+// connection is opened on behalf of the request that needed it, so its phases
+// are reported to that request's streamTrace, if there is one.
+func (pool *h2ClientPool) tracedDialFunc(
+	dial dialFunc,
+	isTLS bool,
+	tlsConfig *tls.Config,
+) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, _, addr string) (net.Conn, error) {
+		var connTrace requestTrace
+		if stream, ok := ctx.Value(streamTraceKey{}).(*streamTrace); ok {
+			defer stream.addConnection(&connTrace)
+		}
+
+		conn, err := dial(addr, &connTrace)
+		if err != nil || !isTLS {
+			return conn, err
+		}
+
+		start := time.Now()
+		tlsConn := tls.Client(conn, tlsConfig)
+		err = tlsHandshake(tlsConn, start.Add(pool.timeout))
+		connTrace.handshakenIn(time.Since(start))
+		if err != nil {
+			conn.Close() //nolint:errcheck,gosec
+			return nil, err
+		}
+		connTrace.handshook(tlsConn.ConnectionState().DidResume)
+		return tlsConn, nil
+	}
+}
+
+// closeIdleConnections closes the idle connections of every client in the
+// pool. It must be called only once the workers stopped.
+func (pool *h2ClientPool) closeIdleConnections() {
+	if pool == nil {
+		return
+	}
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	for _, client := range pool.clients {
+		for i := range client.conns {
+			client.conns[i].transport.CloseIdleConnections()
+		}
+	}
+}
+
+// h2HostClient sends requests to a single host over a fixed number of HTTP/2
+// connections, with at most a fixed number of requests in flight on each.
+type h2HostClient struct {
+	conns []h2Conn
+	next  atomic.Uint64
+}
+
+// h2Conn is a transport that keeps a single connection. streams holds a token
+// for every request in flight on it.
+type h2Conn struct {
+	transport *http.Transport
+	streams   chan struct{}
+}
+
+// acquire takes a stream on the first connection with one free, starting from
+// the next one in turn, and waits for one on that connection when all of them
+// are busy.
+func (client *h2HostClient) acquire(ctx context.Context) (*h2Conn, error) {
+	start := client.next.Add(1)
+	count := uint64(len(client.conns))
+	for i := range count {
+		conn := &client.conns[(start+i)%count]
+		select {
+		case conn.streams <- struct{}{}:
+			return conn, nil
+		default:
+		}
+	}
+
+	conn := &client.conns[start%count]
+	select {
+	case conn.streams <- struct{}{}:
+		return conn, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (conn *h2Conn) release() {
+	<-conn.streams
+}
+
+// do sends req and reads the whole response into resp within timeout, and
+// reports the phases of the request to trace.
+func (client *h2HostClient) do(
+	req *fasthttp.Request,
+	resp *fasthttp.Response,
+	timeout time.Duration,
+	trace *requestTrace,
+) error {
+	return client.roundTrip(
+		timeout,
+		trace,
+		func(ctx context.Context) (*http.Request, error) { return newHTTPRequest(ctx, req) },
+		func(httpResp *http.Response) error { return copyHTTPResponse(httpResp, resp) },
+	)
+}
+
+// roundTrip sends the request that newRequest creates and hands its response
+// to readResponse within timeout, and reports the phases of the request to
+// trace. Errors are reported the way fasthttp reports them, so stats key them
+// the same for every protocol.
+func (client *h2HostClient) roundTrip(
+	timeout time.Duration,
+	trace *requestTrace,
+	newRequest func(ctx context.Context) (*http.Request, error),
+	readResponse func(httpResp *http.Response) error,
+) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	stream := &streamTrace{}
+	defer stream.copyTo(trace)
+	ctx = httptrace.WithClientTrace(context.WithValue(ctx, streamTraceKey{}, stream), &httptrace.ClientTrace{
+		GotConn:              stream.gotConn,
+		WroteRequest:         func(httptrace.WroteRequestInfo) { stream.record((*requestTrace).wrote) },
+		GotFirstResponseByte: func() { stream.record((*requestTrace).read) },
+	})
+
+	httpReq, err := newRequest(ctx)
+	if err != nil {
+		return err
+	}
+
+	conn, err := client.acquire(ctx)
+	if err != nil {
+		return fasthttpError(err)
+	}
+	defer conn.release()
+
+	httpResp, err := conn.transport.RoundTrip(httpReq)
+	if err != nil {
+		return fasthttpError(err)
+	}
+	defer httpResp.Body.Close() //nolint:errcheck
+
+	return fasthttpError(readResponse(httpResp))
+}
+
+// newHTTPRequest converts req to a net/http request. The headers that HTTP/2
+// and HTTP/3 don't allow, or that net/http sets itself, are left out.
+func newHTTPRequest(ctx context.Context, req *fasthttp.Request) (*http.Request, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, string(req.Header.Method()), req.URI().String(), bytes.NewReader(req.Body()))
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	httpReq.Host = string(req.Host())
+	for key, value := range req.Header.All() {
+		switch string(key) {
+		case fasthttp.HeaderHost, fasthttp.HeaderContentLength, fasthttp.HeaderConnection, fasthttp.HeaderTransferEncoding:
+			continue
+		}
+		httpReq.Header.Add(string(key), string(value))
+	}
+	return httpReq, nil
+}
+
+// copyHTTPResponse reads httpResp, with its body as it was sent, into resp.
+func copyHTTPResponse(httpResp *http.Response, resp *fasthttp.Response) error {
+	copyHTTPHeader(httpResp, resp)
+	if _, err := io.Copy(resp.BodyWriter(), httpResp.Body); err != nil {
+		return err //nolint:wrapcheck
+	}
+	resp.Header.SetContentLength(len(resp.Body()))
+	return nil
+}
+
+// copyHTTPHeader resets resp to the status and headers of httpResp.
+func copyHTTPHeader(httpResp *http.Response, resp *fasthttp.Response) {
+	resp.Reset()
+	resp.SetStatusCode(httpResp.StatusCode)
+	for key, values := range httpResp.Header {
+		if key == fasthttp.HeaderContentLength {
+			continue
+		}
+		for _, value := range values {
+			resp.Header.Add(key, value)
+		}
+	}
+}
+
+// fasthttpError reports a request that ran out of time as fasthttp.ErrTimeout.
+func fasthttpError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fasthttp.ErrTimeout
+	}
+	return err
+}
+
+// h2WorkerClient is a worker's handle on a shared h2HostClient, which reports
+// the phases of the worker's requests to its trace. In stream mode, it reads
+// each response body as a stream of events until the server ends it or the
+// timeout, which is the stream hold, passes.
+type h2WorkerClient struct {
+	client *h2HostClient
+	trace  *requestTrace
+	stream bool
+}
+
+func (worker h2WorkerClient) DoTimeout(req *fasthttp.Request, resp *fasthttp.Response, timeout time.Duration) error {
+	if !worker.stream {
+		return worker.client.do(req, resp, timeout, worker.trace)
+	}
+
+	deadline := time.Now().Add(timeout)
+	return worker.client.roundTrip(
+		timeout,
+		worker.trace,
+		func(ctx context.Context) (*http.Request, error) { return newHTTPRequest(ctx, req) },
+		func(httpResp *http.Response) error {
+			return copyHTTPStream(httpResp, resp, deadline, worker.trace.streamEvents())
+		},
+	)
+}
+
+// CloseIdleConnections does nothing, since the connections are shared with the
+// other workers. The pool closes them at the end of the run.
+func (h2WorkerClient) CloseIdleConnections() {}
+
+type streamTraceKey struct{}
+
+// streamTrace collects the phases of a single HTTP/2 or HTTP/3 request. Its
+// connection may be dialed, and its response read, by goroutines other than
+// the worker's, even after the request timed out. So they report here, and the
+// worker copies the phases into its own trace once the request is done.
+type streamTrace struct {
+	mu    sync.Mutex
+	trace requestTrace
+}
+
+func (stream *streamTrace) record(event func(*requestTrace)) {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	event(&stream.trace)
+}
+
+// gotConn records the connection the request is sent over.
+func (stream *streamTrace) gotConn(info httptrace.GotConnInfo) {
+	remote := info.Conn.RemoteAddr()
+	stream.record(func(trace *requestTrace) { trace.sentOver(remote) })
+}
+
+func (stream *streamTrace) addConnection(conn *requestTrace) {
+	stream.record(func(trace *requestTrace) { trace.addConnection(conn) })
+}
+
+// copyTo replaces trace with the phases collected so far, keeping the stream
+// events it follows. A nil trace is left as it is.
+func (stream *streamTrace) copyTo(trace *requestTrace) {
+	if trace == nil {
+		return
+	}
+
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	events := trace.events
+	*trace = stream.trace
+	trace.events = events
+}
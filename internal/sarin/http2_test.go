@@ -0,0 +1,264 @@
+package sarin
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// h2Server is an HTTP/2 server on a free TCP port of 127.0.0.1 that counts
+// the connections opened to it.
+type h2Server struct {
+	addr        string
+	connections atomic.Int64
+}
+
+// startH2Server serves handler over HTTP/2 only, with TLS or with prior
+// knowledge (h2c), until the test ends.
+func startH2Server(t *testing.T, isTLS bool, handler http.Handler) *h2Server {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &h2Server{addr: listener.Addr().String()}
+
+	var protocols http.Protocols
+	if isTLS {
+		protocols.SetHTTP2(true)
+	} else {
+		protocols.SetUnencryptedHTTP2(true)
+	}
+	httpServer := &http.Server{
+		Handler:           handler,
+		Protocols:         &protocols,
+		ReadHeaderTimeout: 5 * time.Second,
+		ConnState: func(_ net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				server.connections.Add(1)
+			}
+		},
+	}
+	if isTLS {
+		httpServer.TLSConfig = &tls.Config{
+			Certificates: []tls.Certificate{newSelfSignedCert(t)},
+			MinVersion:   tls.VersionTLS12,
+		}
+		go httpServer.ServeTLS(listener, "", "") //nolint:errcheck
+	} else {
+		go httpServer.Serve(listener) //nolint:errcheck
+	}
+	t.Cleanup(func() { httpServer.Close() }) //nolint:errcheck
+	return server
+}
+
+// newTestH2ClientPool returns an HTTP/2 pool that trusts any certificate.
+func newTestH2ClientPool(t *testing.T, maxStreams, connections uint) *h2ClientPool {
+	t.Helper()
+
+	tlsSettings, err := newClientTLS(
+		NewFileCache(time.Second), true, nil, nil, TLSRotationWorker,
+		"", "", nil, nil, "", nil, false,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, err := newLocalAddrs(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dial := newDirectDialFunc(t.Context(), 5*time.Second, newResolver(nil, "", false, IPStrategyFirst), local)
+	pool := newH2ClientPool(ProtocolHTTP2, []dialFunc{dial}, 5*time.Second, tlsSettings, maxStreams, connections)
+	t.Cleanup(pool.closeIdleConnections)
+	return pool
+}
+
+// h2Get sends a GET request to rawURL through client and returns the status
+// and body of the response.
+func h2Get(client HostClient, rawURL string, timeout time.Duration) (int, string, error) {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(rawURL)
+	if err := client.DoTimeout(req, resp, timeout); err != nil {
+		return 0, "", err
+	}
+	return resp.StatusCode(), string(resp.Body()), nil
+}
+
+func TestH2HostClientStreamsAndConnections(t *testing.T) {
+	t.Parallel()
+
+	const (
+		maxStreams  = 2
+		connections = 3
+		requests    = 3 * maxStreams * connections
+	)
+
+	for _, isTLS := range []bool{false, true} {
+		name := "h2c"
+		if isTLS {
+			name = "tls"
+		}
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				mu          sync.Mutex
+				inFlight    = make(map[string]int)
+				maxByRemote = make(map[string]int)
+				protoErr    error
+			)
+			arrived := make(chan struct{}, requests)
+			release := make(chan struct{})
+			server := startH2Server(t, isTLS, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				switch {
+				case r.ProtoMajor != 2:
+					protoErr = errors.New("got a request over " + r.Proto)
+				case isTLS && r.TLS.NegotiatedProtocol != "h2":
+					protoErr = errors.New("negotiated " + r.TLS.NegotiatedProtocol + " with ALPN")
+				}
+				inFlight[r.RemoteAddr]++
+				maxByRemote[r.RemoteAddr] = max(maxByRemote[r.RemoteAddr], inFlight[r.RemoteAddr])
+				mu.Unlock()
+
+				arrived <- struct{}{}
+				<-release
+
+				mu.Lock()
+				inFlight[r.RemoteAddr]--
+				mu.Unlock()
+				w.Write([]byte("ok")) //nolint:errcheck
+			}))
+
+			pool := newTestH2ClientPool(t, maxStreams, connections)
+			client := h2WorkerClient{client: pool.get(isTLS, server.addr, nil)}
+			scheme := "http://"
+			if isTLS {
+				scheme = "https://"
+			}
+
+			var wg sync.WaitGroup
+			errs := make(chan error, requests)
+			for range requests {
+				wg.Go(func() {
+					status, body, err := h2Get(client, scheme+server.addr+"/", 10*time.Second)
+					switch {
+					case err != nil:
+						errs <- err
+					case status != http.StatusOK || body != "ok":
+						errs <- errors.New("got status " + http.StatusText(status) + " and body " + body)
+					}
+				})
+			}
+
+			// The requests fill every stream of every connection, and no
+			// more go out until one of them is answered.
+			deadline := time.After(5 * time.Second)
+			for range maxStreams * connections {
+				select {
+				case <-arrived:
+				case <-deadline:
+					t.Fatal("the requests didn't fill the streams of the connections")
+				}
+			}
+			time.Sleep(100 * time.Millisecond)
+			if got := len(arrived); got != 0 {
+				t.Errorf("got %d requests over the stream cap", got)
+			}
+			close(release)
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Error(err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if protoErr != nil {
+				t.Error(protoErr)
+			}
+			if got := server.connections.Load(); got != connections {
+				t.Errorf("got %d connections, want %d", got, connections)
+			}
+			if len(maxByRemote) != connections {
+				t.Errorf("got requests from %d connections, want %d", len(maxByRemote), connections)
+			}
+			for remote, got := range maxByRemote {
+				if got > maxStreams {
+					t.Errorf("got %d requests in flight on %s, want at most %d", got, remote, maxStreams)
+				}
+			}
+		})
+	}
+}
+
+func TestH2ClientPoolSharesClients(t *testing.T) {
+	t.Parallel()
+
+	pool := newTestH2ClientPool(t, 1, 1)
+	client := pool.get(false, "example.com", nil)
+	if got := pool.get(false, "example.com", nil); got != client {
+		t.Error("got a new client for a host that has one")
+	}
+	if got := pool.get(true, "example.com", nil); got == client {
+		t.Error("got the h2c client for TLS")
+	}
+}
+
+func TestH2WorkerClientTimeoutTrace(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	answered := make(chan struct{})
+	server := startH2Server(t, false, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		defer close(answered)
+		<-release
+		w.Write([]byte("late")) //nolint:errcheck
+	}))
+	pool := newTestH2ClientPool(t, 1, 1)
+	trace := &requestTrace{}
+	client := h2WorkerClient{client: pool.get(false, server.addr, nil), trace: trace}
+
+	_, _, err := h2Get(client, "http://"+server.addr+"/", 200*time.Millisecond)
+	if !errors.Is(err, fasthttp.ErrTimeout) {
+		t.Fatalf("got error %v, want %v", err, fasthttp.ErrTimeout)
+	}
+
+	// The connection was opened and the request written, but no response
+	// came back in time.
+	copied := *trace
+	phases := trace.phases(time.Now())
+	if !phases.measured[requestPhaseConnect] {
+		t.Error("the connection wasn't recorded")
+	}
+	if trace.remote == nil || trace.remote.String() != server.addr {
+		t.Errorf("got remote address %v, want %s", trace.remote, server.addr)
+	}
+	if trace.wroteAt.IsZero() {
+		t.Error("the request wasn't recorded as written")
+	}
+	if phases.measured[requestPhaseTTFB] {
+		t.Error("a first byte was recorded for a request that timed out")
+	}
+
+	// Whatever the transport still reports for the request doesn't reach the
+	// worker's trace, which has moved on.
+	close(release)
+	<-answered
+	time.Sleep(50 * time.Millisecond)
+	if *trace != copied {
+		t.Error("the trace changed after the request timed out")
+	}
+}
@@ -0,0 +1,225 @@
+package sarin
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/valyala/fasthttp"
+	"go.aykhans.me/sarin/internal/types"
+)
+
+// h3ClientPool holds the HTTP/3 transports of a run, one per host and client
+// certificate. The workers share them, so that their requests are multiplexed
+// over the same QUIC connection. It is safe for concurrent use.
+// A nil pool holds nothing.
+type h3ClientPool struct {
+	timeout  time.Duration
+	tls      *clientTLS
+	resolver *resolver
+	local    *localAddrs
+
+	mu         sync.Mutex
+	transports map[h3TransportKey]*http3.Transport
+}
+
+type h3TransportKey struct {
+	host string
+	cert *tls.Certificate
+}
+
+// newH3ClientPool returns nil unless the protocol is HTTP/3.
+func newH3ClientPool(
+	protocol Protocol,
+	timeout time.Duration,
+	tlsSettings *clientTLS,
+	res *resolver,
+	local *localAddrs,
+) *h3ClientPool {
+	if protocol != ProtocolHTTP3 {
+		return nil
+	}
+	return &h3ClientPool{
+		timeout:    timeout,
+		tls:        tlsSettings,
+		resolver:   res,
+		local:      local,
+		transports: make(map[h3TransportKey]*http3.Transport),
+	}
+}
+
+// get returns the transport for host that presents cert, creating it if this
+// is the first request to that host with cert. A nil cert presents none.
+func (pool *h3ClientPool) get(host string, cert *tls.Certificate) *http3.Transport {
+	key := h3TransportKey{host: host, cert: cert}
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	transport, ok := pool.transports[key]
+	if !ok {
+		transport = &http3.Transport{
+			TLSClientConfig: pool.tls.config(host, cert),
+			QUICConfig: &quic.Config{
+				HandshakeIdleTimeout: pool.timeout,
+				MaxIdleTimeout:       pool.timeout,
+			},
+			DisableCompression: true,
+			Dial:               pool.dialQUIC,
+		}
+		pool.transports[key] = transport
+	}
+	return transport
+}
+
+// close closes the connections of every transport in the pool. It must be
+// called only once the workers stopped.
+func (pool *h3ClientPool) close() {
+	if pool == nil {
+		return
+	}
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	for _, transport := range pool.transports {
+		transport.Close() //nolint:errcheck,gosec
+	}
+}
+
+// dialQUIC opens a QUIC connection to addr, which always has a port, for an
+// http3.Transport. It looks the host up itself, through the pool's resolver,
+// so that the lookup can be timed, and connects to the first of its addresses
+// that completes the handshake. Looked up hosts connect over IPv4. The
+// connection is opened on behalf of the request that needed it, so its phases
+// are reported to that request's streamTrace, if there is one.
+// It can return the following errors:
+//   - types.HostResolveError
+//   - types.LocalAddressFamilyError
+//   - types.LocalAddressBindError
+func (pool *h3ClientPool) dialQUIC(ctx context.Context, addr string, tlsConfig *tls.Config, quicConfig *quic.Config) (*quic.Conn, error) {
+	var connTrace requestTrace
+	if stream, ok := ctx.Value(streamTraceKey{}).(*streamTrace); ok {
+		defer stream.addConnection(&connTrace)
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	ips, err := pool.resolver.resolve(ctx, "ip4", host, port, &connTrace)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, types.NewHostResolveError(host)
+	}
+
+	// QUIC sets the connection up and does the TLS handshake in a single
+	// exchange, so all of it is timed as the QUIC phase.
+	start := time.Now()
+	defer func() { connTrace.quicHandshakenIn(time.Since(start)) }()
+
+	for _, ip := range ips {
+		var conn *quic.Conn
+		conn, err = pool.dialQUICAddr(ctx, net.JoinHostPort(ip.String(), port), tlsConfig, quicConfig)
+		if err == nil {
+			connTrace.handshook(conn.ConnectionState().TLS.DidResume)
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, err //nolint:wrapcheck
+}
+
+// dialQUICAddr opens a QUIC connection to addr, an IP address and port, from
+// the next of the pool's local addresses, if it has any.
+// It can return the following errors:
+//   - types.LocalAddressFamilyError
+//   - types.LocalAddressBindError
+func (pool *h3ClientPool) dialQUICAddr(ctx context.Context, addr string, tlsConfig *tls.Config, quicConfig *quic.Config) (*quic.Conn, error) {
+	if pool.local == nil {
+		return quic.DialAddr(ctx, addr, tlsConfig, quicConfig) //nolint:wrapcheck
+	}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+	local, err := pool.local.next(udpAddr.IP.String())
+	if err != nil {
+		return nil, err
+	}
+	packetConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: local.ip})
+	if err != nil {
+		return nil, local.failed(err)
+	}
+
+	conn, err := quic.Dial(ctx, packetConn, udpAddr, tlsConfig, quicConfig)
+	if err != nil {
+		packetConn.Close() //nolint:errcheck,gosec
+		return nil, err
+	}
+	local.opened()
+	// quic.Dial leaves the socket to its caller, so it is closed along with
+	// the connection.
+	go func() {
+		<-conn.Context().Done()
+		packetConn.Close() //nolint:errcheck,gosec
+	}()
+	return conn, nil
+}
+
+// h3WorkerClient is a worker's handle on a shared http3.Transport, which
+// reports the phases of the worker's requests to its trace. In stream mode,
+// it reads each response body as a stream of events, like h2WorkerClient.
+type h3WorkerClient struct {
+	transport *http3.Transport
+	trace     *requestTrace
+	stream    bool
+}
+
+// DoTimeout sends req and reads the whole response into resp within timeout.
+// Errors are reported the way fasthttp reports them, so stats key them the
+// same for every protocol.
+func (worker h3WorkerClient) DoTimeout(req *fasthttp.Request, resp *fasthttp.Response, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	stream := &streamTrace{}
+	defer stream.copyTo(worker.trace)
+	ctx = httptrace.WithClientTrace(context.WithValue(ctx, streamTraceKey{}, stream), &httptrace.ClientTrace{
+		GotConn:              stream.gotConn,
+		WroteRequest:         func(httptrace.WroteRequestInfo) { stream.record((*requestTrace).wrote) },
+		GotFirstResponseByte: func() { stream.record((*requestTrace).read) },
+	})
+
+	httpReq, err := newHTTPRequest(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	httpResp, err := worker.transport.RoundTrip(httpReq)
+	if err != nil {
+		return fasthttpError(err)
+	}
+	defer httpResp.Body.Close() //nolint:errcheck
+
+	if worker.stream {
+		return fasthttpError(copyHTTPStream(httpResp, resp, deadline, worker.trace.streamEvents()))
+	}
+	return fasthttpError(copyHTTPResponse(httpResp, resp))
+}
+
+// CloseIdleConnections does nothing, since the connections are shared with the
+// other workers. The pool closes them at the end of the run.
+func (h3WorkerClient) CloseIdleConnections() {}
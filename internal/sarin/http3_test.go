@@ -0,0 +1,163 @@
+package sarin
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/valyala/fasthttp"
+)
+
+// newSelfSignedCert returns a certificate for 127.0.0.1 that signs itself.
+func newSelfSignedCert(t *testing.T) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// startH3Server serves HTTP/3 on a free UDP port of 127.0.0.1 until the test
+// ends, answering every request with "ok". It returns the server's address.
+func startH3Server(t *testing.T) string {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http3.Server{
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{
+			Certificates: []tls.Certificate{newSelfSignedCert(t)},
+			MinVersion:   tls.VersionTLS13,
+		}),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Write([]byte("ok")) //nolint:errcheck
+		}),
+	}
+	go server.Serve(conn) //nolint:errcheck
+	t.Cleanup(func() {
+		server.Close() //nolint:errcheck
+		conn.Close()   //nolint:errcheck
+	})
+	return conn.LocalAddr().String()
+}
+
+// newTestH3ClientPool returns an HTTP/3 pool that trusts any certificate and
+// binds the given local addresses.
+func newTestH3ClientPool(t *testing.T, localAddresses []string) *h3ClientPool {
+	t.Helper()
+
+	tlsSettings, err := newClientTLS(
+		NewFileCache(time.Second), true, nil, nil, TLSRotationWorker,
+		"", "", nil, nil, "", nil, false,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, err := newLocalAddrs(localAddresses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := newH3ClientPool(ProtocolHTTP3, 5*time.Second, tlsSettings, newResolver(nil, "", false, IPStrategyFirst), local)
+	t.Cleanup(pool.close)
+	return pool
+}
+
+func TestH3WorkerClientDoTimeout(t *testing.T) {
+	t.Parallel()
+
+	addr := startH3Server(t)
+	pool := newTestH3ClientPool(t, nil)
+	trace := &requestTrace{}
+	client := h3WorkerClient{transport: pool.get(addr, nil), trace: trace}
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI("https://" + addr + "/")
+
+	if err := client.DoTimeout(req, resp, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.StatusCode(); got != http.StatusOK {
+		t.Errorf("got status %d, want %d", got, http.StatusOK)
+	}
+	if got := string(resp.Body()); got != "ok" {
+		t.Errorf("got body %q, want %q", got, "ok")
+	}
+
+	phases := trace.phases(time.Now())
+	if !phases.measured[requestPhaseQUIC] {
+		t.Error("the QUIC handshake wasn't recorded")
+	} else if phases.durations[requestPhaseQUIC] <= 0 {
+		t.Errorf("got a QUIC handshake of %v", phases.durations[requestPhaseQUIC])
+	}
+	if trace.handshakes != 1 {
+		t.Errorf("got %d handshakes, want 1", trace.handshakes)
+	}
+}
+
+func TestDialQUICAddrClosesLocalSocket(t *testing.T) {
+	t.Parallel()
+
+	addr := startH3Server(t)
+	pool := newTestH3ClientPool(t, []string{"127.0.0.1"})
+
+	conn, err := pool.dialQUICAddr(
+		t.Context(), addr,
+		&tls.Config{InsecureSkipVerify: true, NextProtos: []string{http3.NextProtoH3}}, //nolint:gosec
+		&quic.Config{HandshakeIdleTimeout: 5 * time.Second},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	localAddr := conn.LocalAddr().(*net.UDPAddr)
+	if !localAddr.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("got local address %v, want 127.0.0.1", localAddr.IP)
+	}
+	if got := pool.local.stats(); len(got) != 1 || got[0].Connections != 1 {
+		t.Errorf("got local address stats %+v, want one connection", got)
+	}
+
+	// The socket is closed once the connection is, which frees its port.
+	conn.CloseWithError(0, "") //nolint:errcheck
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		socket, err := net.ListenUDP("udp", localAddr)
+		if err == nil {
+			socket.Close() //nolint:errcheck
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the local socket wasn't closed with the connection: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,305 @@
+package sarin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttpproxy"
+	"go.aykhans.me/sarin/internal/types"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// maxLocalRangeBits limits a CIDR range of local addresses to 65536 addresses,
+// since each of them is kept for the run.
+const maxLocalRangeBits = 16
+
+// ParseLocalAddress returns the addresses that a local address stands for:
+// itself if it is an IP address, every address of a CIDR range but the network
+// and broadcast addresses of an IPv4 range, or the addresses of a network
+// interface, without its IPv6 link-local ones.
+// It can return the following errors:
+//   - types.LocalAddressParseError
+func ParseLocalAddress(value string) ([]net.IP, error) {
+	if ip := net.ParseIP(value); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	if strings.Contains(value, "/") {
+		ips, err := localRange(value)
+		if err != nil {
+			return nil, types.NewLocalAddressParseError(value, err)
+		}
+		return ips, nil
+	}
+
+	iface, err := net.InterfaceByName(value)
+	if err != nil {
+		return nil, types.NewLocalAddressParseError(value, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, types.NewLocalAddressParseError(value, err)
+	}
+	var ips []net.IP
+	for _, addr := range addrs {
+		// Link-local addresses can only be bound with their zone.
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLinkLocalUnicast() {
+			ips = append(ips, ipNet.IP)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, types.NewLocalAddressParseError(value, errors.New("interface has no addresses to bind"))
+	}
+	return ips, nil
+}
+
+// localRange returns the addresses of a CIDR range, without the network and
+// broadcast addresses of an IPv4 range.
+func localRange(value string) ([]net.IP, error) {
+	prefix, err := netip.ParsePrefix(value)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+	prefix = prefix.Masked()
+
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	if hostBits > maxLocalRangeBits {
+		return nil, fmt.Errorf("range has more than %d addresses", 1<<maxLocalRangeBits)
+	}
+
+	addr, count := prefix.Addr(), 1<<hostBits
+	if addr.Is4() && hostBits > 1 {
+		addr, count = addr.Next(), count-2
+	}
+	ips := make([]net.IP, 0, count)
+	for range count {
+		ips = append(ips, net.IP(addr.AsSlice()))
+		addr = addr.Next()
+	}
+	return ips, nil
+}
+
+// localAddrs holds the local addresses that connections are opened from. Each
+// new connection takes the next address, in turn, of the IP version of the
+// address it connects to. It is safe for concurrent use.
+// A nil localAddrs leaves the local address to the system.
+type localAddrs struct {
+	// all holds every address once, in the order they were given.
+	all    []*localAddr
+	v4, v6 []*localAddr
+	// next4 and next6 count the connections opened from v4 and v6, which
+	// decides the address of the next one.
+	next4, next6 atomic.Uint64
+}
+
+// localAddr is a local address, with the connections opened from it.
+type localAddr struct {
+	ip net.IP
+	// connections counts the connections opened from the address. unavailable
+	// counts those that couldn't bind it because it isn't an address of this
+	// host (EADDRNOTAVAIL), and portsExhausted those that couldn't because it
+	// had no free port left (EADDRINUSE).
+	connections, unavailable, portsExhausted atomic.Uint64
+}
+
+// newLocalAddrs resolves the local addresses that values stand for, dropping
+// duplicates. It returns nil without values.
+// It can return the following errors:
+//   - types.LocalAddressParseError
+func newLocalAddrs(values []string) (*localAddrs, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	addrs := &localAddrs{}
+	seen := make(map[string]bool)
+	for _, value := range values {
+		ips, err := ParseLocalAddress(value)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			if seen[ip.String()] {
+				continue
+			}
+			seen[ip.String()] = true
+
+			addr := &localAddr{ip: ip}
+			addrs.all = append(addrs.all, addr)
+			if ip.To4() != nil {
+				addrs.v4 = append(addrs.v4, addr)
+			} else {
+				addrs.v6 = append(addrs.v6, addr)
+			}
+		}
+	}
+	return addrs, nil
+}
+
+// Dial is DialContext without a context. Together they make addrs a
+// proxy.ContextDialer, to connect to SOCKS proxies with.
+func (addrs *localAddrs) Dial(network, addr string) (net.Conn, error) {
+	return addrs.DialContext(context.Background(), network, addr)
+}
+
+// DialContext connects to addr, a host and port, from the next local address,
+// like net.Dialer.DialContext.
+// It can return the following errors:
+//   - types.LocalAddressFamilyError
+//   - types.LocalAddressBindError
+func (addrs *localAddrs) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if addrs == nil {
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+	local, err := addrs.next(host)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{LocalAddr: &net.TCPAddr{IP: local.ip}}
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, local.failed(err)
+	}
+	local.opened()
+	return conn, nil
+}
+
+// next returns the local address for the next connection to host. Host names
+// are connected to over IPv4, unless there are only IPv6 local addresses.
+// It can return the following errors:
+//   - types.LocalAddressFamilyError
+func (addrs *localAddrs) next(host string) (*localAddr, error) {
+	pool, turns := addrs.v4, &addrs.next4
+	if ip := net.ParseIP(host); (ip != nil && ip.To4() == nil) || (ip == nil && len(addrs.v4) == 0) {
+		pool, turns = addrs.v6, &addrs.next6
+	}
+	if len(pool) == 0 {
+		return nil, types.NewLocalAddressFamilyError(host)
+	}
+	return pool[(turns.Add(1)-1)%uint64(len(pool))], nil
+}
+
+// dialTimeout connects to addr from the next local address within timeout,
+// reporting a timeout the same way fasthttp does.
+// It can return the following errors:
+//   - types.LocalAddressFamilyError
+//   - types.LocalAddressBindError
+func (addrs *localAddrs) dialTimeout(addr string, timeout time.Duration) (net.Conn, error) {
+	if addrs == nil {
+		return fasthttp.DialDualStackTimeout(addr, timeout) //nolint:wrapcheck
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	conn, err := addrs.DialContext(ctx, "tcp", addr)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, fasthttp.ErrDialTimeout
+	}
+	return conn, err
+}
+
+// httpProxyDialFunc returns a dial function that tunnels through the HTTP
+// proxy, connecting to it from the next local address. A fasthttpproxy dialer
+// binds a single local address, so there is one for each of them, created the
+// first time it is used.
+// The returned dial function can return the following errors:
+//   - types.LocalAddressFamilyError
+//   - types.LocalAddressBindError
+func (addrs *localAddrs) httpProxyDialFunc(proxy, proxyHost string, timeout time.Duration) fasthttp.DialFunc {
+	if addrs == nil {
+		return fasthttpproxy.FasthttpHTTPDialerDualStackTimeout(proxy, timeout)
+	}
+
+	var mu sync.Mutex
+	dials := make(map[*localAddr]fasthttp.DialFunc)
+	return func(addr string) (net.Conn, error) {
+		local, err := addrs.next(proxyHost)
+		if err != nil {
+			return nil, err
+		}
+
+		mu.Lock()
+		dial, ok := dials[local]
+		if !ok {
+			dialer := &fasthttpproxy.Dialer{
+				TCPDialer:      fasthttp.TCPDialer{LocalAddr: &net.TCPAddr{IP: local.ip}},
+				Config:         httpproxy.Config{HTTPProxy: proxy, HTTPSProxy: proxy},
+				DialDualStack:  true,
+				Timeout:        timeout,
+				ConnectTimeout: timeout,
+			}
+			dial, err = dialer.GetDialFunc(false)
+			if err == nil {
+				dials[local] = dial
+			}
+		}
+		mu.Unlock()
+		if err != nil {
+			return nil, err //nolint:wrapcheck
+		}
+
+		conn, err := dial(addr)
+		if err != nil {
+			return nil, local.failed(err)
+		}
+		local.opened()
+		return conn, nil
+	}
+}
+
+// stats returns what happened to the connections from each local address, for
+// the addresses that any connection was opened, or failed to open, from.
+func (addrs *localAddrs) stats() []localAddressStat {
+	if addrs == nil {
+		return nil
+	}
+
+	var stats []localAddressStat
+	for _, addr := range addrs.all {
+		stat := localAddressStat{
+			Address:        addr.ip.String(),
+			Connections:    addr.connections.Load(),
+			Unavailable:    addr.unavailable.Load(),
+			PortsExhausted: addr.portsExhausted.Load(),
+		}
+		if stat.Connections > 0 || stat.Unavailable > 0 || stat.PortsExhausted > 0 {
+			stats = append(stats, stat)
+		}
+	}
+	return stats
+}
+
+// opened counts a connection opened from addr.
+func (addr *localAddr) opened() {
+	addr.connections.Add(1)
+}
+
+// failed counts a connection from addr that failed to open with err. It
+// returns err, as a types.LocalAddressBindError if addr couldn't be bound.
+func (addr *localAddr) failed(err error) error {
+	switch {
+	case errors.Is(err, syscall.EADDRNOTAVAIL):
+		addr.unavailable.Add(1)
+		return types.NewLocalAddressBindError(addr.ip.String(), syscall.EADDRNOTAVAIL)
+	case errors.Is(err, syscall.EADDRINUSE):
+		addr.portsExhausted.Add(1)
+		return types.NewLocalAddressBindError(addr.ip.String(), syscall.EADDRINUSE)
+	}
+	return err
+}
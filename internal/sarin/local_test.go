@@ -0,0 +1,306 @@
+package sarin
+
+import (
+	"errors"
+	"net"
+	"os"
+	"slices"
+	"syscall"
+	"testing"
+
+	"go.aykhans.me/sarin/internal/types"
+)
+
+func TestParseLocalAddress(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		value string
+		// want holds the first addresses, and wantCount the number of them.
+		want      []string
+		wantCount int
+		wantErr   bool
+	}{
+		{value: "10.0.0.7", want: []string{"10.0.0.7"}, wantCount: 1},
+		{value: "2001:db8::7", want: []string{"2001:db8::7"}, wantCount: 1},
+		{value: "10.0.0.7/32", want: []string{"10.0.0.7"}, wantCount: 1},
+		// An IPv4 range has no network and broadcast addresses to leave
+		// out until it has more than two addresses.
+		{value: "10.0.0.6/31", want: []string{"10.0.0.6", "10.0.0.7"}, wantCount: 2},
+		{value: "10.0.0.0/30", want: []string{"10.0.0.1", "10.0.0.2"}, wantCount: 2},
+		{value: "10.0.0.5/29", want: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5", "10.0.0.6"}, wantCount: 6},
+		// An IPv6 range keeps all of its addresses.
+		{value: "2001:db8::/126", want: []string{"2001:db8::", "2001:db8::1", "2001:db8::2", "2001:db8::3"}, wantCount: 4},
+		{value: "2001:db8::/128", want: []string{"2001:db8::"}, wantCount: 1},
+		// Up to 16 host bits.
+		{value: "10.0.0.0/16", want: []string{"10.0.0.1", "10.0.0.2"}, wantCount: 1<<16 - 2},
+		{value: "2001:db8::/112", want: []string{"2001:db8::", "2001:db8::1"}, wantCount: 1 << 16},
+		{value: "10.0.0.0/15", wantErr: true},
+		{value: "2001:db8::/111", wantErr: true},
+		{value: "10.0.0.0/33", wantErr: true},
+		{value: "10.0.0/24", wantErr: true},
+		{value: "not-an-interface0", wantErr: true},
+		{value: "", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			t.Parallel()
+
+			ips, err := ParseLocalAddress(test.value)
+			if test.wantErr {
+				parseErr, ok := errors.AsType[types.LocalAddressParseError](err)
+				if !ok || parseErr.Value != test.value {
+					t.Errorf("got %v, %v, want a types.LocalAddressParseError for %q", ipStrings(ips), err, test.value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ips) != test.wantCount {
+				t.Errorf("got %d addresses, want %d", len(ips), test.wantCount)
+			}
+			if got := ipStrings(ips[:min(len(ips), len(test.want))]); !slices.Equal(got, test.want) {
+				t.Errorf("got addresses starting with %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseLocalAddressInterface(t *testing.T) {
+	t.Parallel()
+
+	iface, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skip("no lo interface:", err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLinkLocalUnicast() {
+			want = append(want, ipNet.IP.String())
+		}
+	}
+
+	ips, err := ParseLocalAddress("lo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ipStrings(ips); !slices.Equal(got, want) || !slices.Contains(got, "127.0.0.1") {
+		t.Errorf("got %v, want %v with 127.0.0.1", got, want)
+	}
+}
+
+func TestNewLocalAddrs(t *testing.T) {
+	t.Parallel()
+
+	addrs, err := newLocalAddrs(nil)
+	if addrs != nil || err != nil {
+		t.Errorf("got %v, %v without local addresses, want nil", addrs, err)
+	}
+
+	// Duplicates are dropped, and the addresses are split by IP version.
+	addrs, err = newLocalAddrs([]string{"10.0.0.0/30", "10.0.0.2", "2001:db8::1", "10.0.0.9", "2001:db8::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	localIPs := func(pool []*localAddr) []string {
+		var ips []string
+		for _, addr := range pool {
+			ips = append(ips, addr.ip.String())
+		}
+		return ips
+	}
+	for _, check := range []struct {
+		name string
+		pool []*localAddr
+		want []string
+	}{
+		{name: "all", pool: addrs.all, want: []string{"10.0.0.1", "10.0.0.2", "2001:db8::1", "10.0.0.9"}},
+		{name: "IPv4", pool: addrs.v4, want: []string{"10.0.0.1", "10.0.0.2", "10.0.0.9"}},
+		{name: "IPv6", pool: addrs.v6, want: []string{"2001:db8::1"}},
+	} {
+		if got := localIPs(check.pool); !slices.Equal(got, check.want) {
+			t.Errorf("got %s addresses %v, want %v", check.name, got, check.want)
+		}
+	}
+
+	if _, err := newLocalAddrs([]string{"10.0.0.1", "10.0.0.0/8"}); err == nil {
+		t.Error("got no error for a range that is too large")
+	}
+}
+
+func TestLocalAddrsNext(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		values []string
+		// hosts are connected to in order, each from the address in want,
+		// or "" for a types.LocalAddressFamilyError.
+		hosts []string
+		want  []string
+	}{
+		{
+			name:   "Each IP version in turn",
+			values: []string{"10.0.0.1", "2001:db8::1", "10.0.0.2", "2001:db8::2", "10.0.0.3"},
+			hosts:  []string{"192.0.2.1", "2001:db8:ff::1", "192.0.2.1", "example.com", "2001:db8:ff::1", "192.0.2.2", "2001:db8:ff::1", "192.0.2.1"},
+			want:   []string{"10.0.0.1", "2001:db8::1", "10.0.0.2", "10.0.0.3", "2001:db8::2", "10.0.0.1", "2001:db8::1", "10.0.0.2"},
+		},
+		{
+			name:   "Only IPv4",
+			values: []string{"10.0.0.1"},
+			hosts:  []string{"example.com", "2001:db8:ff::1", "192.0.2.1"},
+			want:   []string{"10.0.0.1", "", "10.0.0.1"},
+		},
+		{
+			// Host names connect over IPv6 when there are no IPv4
+			// addresses to connect from.
+			name:   "Only IPv6",
+			values: []string{"2001:db8::1", "2001:db8::2"},
+			hosts:  []string{"example.com", "192.0.2.1", "2001:db8:ff::1"},
+			want:   []string{"2001:db8::1", "", "2001:db8::2"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			addrs, err := newLocalAddrs(test.values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, host := range test.hosts {
+				local, err := addrs.next(host)
+				if test.want[i] == "" {
+					if familyErr, ok := errors.AsType[types.LocalAddressFamilyError](err); !ok || familyErr.Target != host {
+						t.Errorf("connection %d to %s: got %v, want a types.LocalAddressFamilyError", i, host, err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("connection %d to %s: %v", i, host, err)
+				}
+				if got := local.ip.String(); got != test.want[i] {
+					t.Errorf("connection %d to %s: got %s, want %s", i, host, got, test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLocalAddrFailed(t *testing.T) {
+	t.Parallel()
+
+	// dialErr wraps errno the way a failed dial does.
+	dialErr := func(errno syscall.Errno) error {
+		return &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", errno)}
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		// wantErrno is the errno of the types.LocalAddressBindError that
+		// err is returned as, or 0 if it is returned as it is.
+		wantErrno          syscall.Errno
+		wantUnavailable    uint64
+		wantPortsExhausted uint64
+	}{
+		{name: "Address not available", err: dialErr(syscall.EADDRNOTAVAIL), wantErrno: syscall.EADDRNOTAVAIL, wantUnavailable: 1},
+		{name: "Address in use", err: dialErr(syscall.EADDRINUSE), wantErrno: syscall.EADDRINUSE, wantPortsExhausted: 1},
+		{name: "Connection refused", err: dialErr(syscall.ECONNREFUSED)},
+		{name: "Other error", err: errors.New("i/o timeout")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			addr := &localAddr{ip: net.ParseIP("10.0.0.1")}
+			err := addr.failed(test.err)
+			bindErr, isBind := errors.AsType[types.LocalAddressBindError](err)
+			switch {
+			case test.wantErrno == 0 && err != test.err: //nolint:errorlint
+				t.Errorf("got error %v, want %v as it is", err, test.err)
+			case test.wantErrno != 0 && (!isBind || bindErr.Addr != "10.0.0.1" || !errors.Is(err, test.wantErrno)):
+				t.Errorf("got error %v, want a types.LocalAddressBindError for 10.0.0.1 with %v", err, test.wantErrno)
+			}
+			if got := addr.unavailable.Load(); got != test.wantUnavailable {
+				t.Errorf("got %d unavailable, want %d", got, test.wantUnavailable)
+			}
+			if got := addr.portsExhausted.Load(); got != test.wantPortsExhausted {
+				t.Errorf("got %d with ports exhausted, want %d", got, test.wantPortsExhausted)
+			}
+			if got := addr.connections.Load(); got != 0 {
+				t.Errorf("got %d connections from a failed dial", got)
+			}
+		})
+	}
+}
+
+func TestLocalAddrsDialContext(t *testing.T) {
+	t.Parallel()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close() //nolint:errcheck
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close() //nolint:errcheck
+		}
+	}()
+
+	// 192.0.2.1 is reserved for documentation, so it is no address of this
+	// host and can't be bound.
+	addrs, err := newLocalAddrs([]string{"127.0.0.1", "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		conn, err := addrs.DialContext(t.Context(), "tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := conn.LocalAddr().(*net.TCPAddr).IP.String(); got != "127.0.0.1" {
+			t.Errorf("got connected from %s, want 127.0.0.1", got)
+		}
+		conn.Close() //nolint:errcheck
+
+		_, err = addrs.DialContext(t.Context(), "tcp", listener.Addr().String())
+		if bindErr, ok := errors.AsType[types.LocalAddressBindError](err); !ok || !errors.Is(err, syscall.EADDRNOTAVAIL) {
+			t.Fatalf("got %v, want a types.LocalAddressBindError with EADDRNOTAVAIL", bindErr)
+		}
+	}
+
+	// Only the addresses that were used show up in the stats.
+	addrs.all = append(addrs.all, &localAddr{ip: net.ParseIP("127.0.0.2")})
+	want := []localAddressStat{
+		{Address: "127.0.0.1", Connections: 2},
+		{Address: "192.0.2.1", Unavailable: 2},
+	}
+	if got := addrs.stats(); !slices.Equal(got, want) {
+		t.Errorf("got stats %+v, want %+v", got, want)
+	}
+
+	// Without local addresses, the system picks one.
+	var nilAddrs *localAddrs
+	conn, err := nilAddrs.DialContext(t.Context(), "tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close() //nolint:errcheck
+	if nilAddrs.stats() != nil {
+		t.Error("got stats without local addresses")
+	}
+}
@@ -27,6 +27,11 @@ type valuesData struct {
 // with the specified configuration. The returned RequestGenerator is NOT safe for concurrent
 // use by multiple goroutines.
 //
+// extracted holds the values a flow extracted from earlier responses, which
+// override the configured values of the same name. It is nil outside of flows.
+// jar adds the cookies it holds for the request to the configured ones, which
+// take precedence. It is nil when the cookie jar is disabled.
+//
 // Note: Scripts must be validated before calling this function (e.g., in NewSarin).
 // The caller is responsible for managing the scriptTransformer lifecycle.
 func NewRequestGenerator(
@@ -37,6 +42,8 @@ func NewRequestGenerator(
 	cookies types.Cookies,
 	bodies []string,
 	values []string,
+	extracted map[string]string,
+	jar *cookieJar,
 	fileCache *FileCache,
 	scriptTransformer *script.Transformer,
 ) (RequestGenerator, bool) {
@@ -54,6 +61,7 @@ func NewRequestGenerator(
 		return templateRoot
 	}
 
+	hostGenerator, isHostGeneratorDynamic := createTemplateFunc(requestURL.Host, lazyTemplateRoot)
 	pathGenerator, isPathGeneratorDynamic := createTemplateFunc(requestURL.Path, lazyTemplateRoot)
 	methodGenerator, isMethodGeneratorDynamic := NewMethodGeneratorFunc(localRand, methods, lazyTemplateRoot)
 	paramsGenerator, isParamsGeneratorDynamic, paramKeysAreStatic := NewParamsGeneratorFunc(localRand, params, lazyTemplateRoot)
@@ -70,11 +78,10 @@ func NewRequestGenerator(
 	}
 	bodyGenerator, isBodyGeneratorDynamic := NewBodyGeneratorFunc(localRand, bodies, lazyBodyTemplateRoot)
 
-	valuesGenerator := NewValuesGeneratorFunc(values, lazyTemplateRoot)
+	valuesGenerator := NewValuesGeneratorFunc(values, extracted, lazyTemplateRoot)
 
 	hasScripts := scriptTransformer != nil && !scriptTransformer.IsEmpty()
 
-	host := requestURL.Host
 	scheme := requestURL.Scheme
 
 	reqData := &script.RequestData{
@@ -93,6 +100,7 @@ func NewRequestGenerator(
 
 	var (
 		data valuesData
+		host string
 		path string
 		err  error
 	)
@@ -101,6 +109,7 @@ func NewRequestGenerator(
 			resetStringSliceMap(reqData.Params, reuseParamSlices)
 			resetStringSliceMap(reqData.Cookies, reuseCookieSlices)
 			reqData.Method = ""
+			reqData.Host = ""
 			reqData.Path = ""
 			reqData.Body = ""
 
@@ -109,6 +118,12 @@ func NewRequestGenerator(
 				return err
 			}
 
+			host, err = hostGenerator(data)
+			if err != nil {
+				return err
+			}
+			reqData.Host = host
+
 			path, err = pathGenerator(data)
 			if err != nil {
 				return err
@@ -144,10 +159,11 @@ func NewRequestGenerator(
 				}
 			}
 
-			applyRequestDataToFastHTTP(reqData, req, host, scheme)
+			applyRequestDataToFastHTTP(reqData, req, scheme, jar)
 
 			return nil
-		}, isPathGeneratorDynamic ||
+		}, isHostGeneratorDynamic ||
+			isPathGeneratorDynamic ||
 			isMethodGeneratorDynamic ||
 			isParamsGeneratorDynamic ||
 			isHeadersGeneratorDynamic ||
@@ -169,8 +185,8 @@ func resetStringSliceMap(m map[string][]string, reuse bool) {
 	}
 }
 
-func applyRequestDataToFastHTTP(reqData *script.RequestData, req *fasthttp.Request, host, scheme string) {
-	req.Header.SetHost(host)
+func applyRequestDataToFastHTTP(reqData *script.RequestData, req *fasthttp.Request, scheme string, jar *cookieJar) {
+	req.Header.SetHost(reqData.Host)
 	req.SetRequestURI(reqData.Path)
 	req.Header.SetMethod(reqData.Method)
 	req.SetBodyString(reqData.Body)
@@ -190,7 +206,7 @@ func applyRequestDataToFastHTTP(reqData *script.RequestData, req *fasthttp.Reque
 		}
 	}
 
-	if len(reqData.Cookies) > 0 {
+	if len(reqData.Cookies) > 0 || jar != nil {
 		var sb strings.Builder
 		for k, values := range reqData.Cookies {
 			for _, v := range values {
@@ -202,11 +218,14 @@ func applyRequestDataToFastHTTP(reqData *script.RequestData, req *fasthttp.Reque
 				sb.WriteString(v)
 			}
 		}
-		req.Header.Add("Cookie", sb.String())
+		jar.appendCookies(&sb, reqData.Host, reqData.Path, scheme == "https" || scheme == "wss", reqData.Cookies)
+		if sb.Len() > 0 {
+			req.Header.Add("Cookie", sb.String())
+		}
 	}
 
-	if scheme == "https" {
-		req.URI().SetScheme("https")
+	if scheme != "http" {
+		req.URI().SetScheme(scheme)
 	}
 }
 
@@ -321,7 +340,29 @@ func NewCookiesGeneratorFunc(localRand *rand.Rand, cookies types.Cookies, lazyRo
 	}, isDynamic, keysAreStatic
 }
 
-func NewValuesGeneratorFunc(values []string, lazyRoot func() *template.Template) func() (valuesData, error) {
+// NewValuesGeneratorFunc creates a generator of the template values. The
+// contents of extracted, if it isn't nil, are read on every call and override
+// the configured values.
+func NewValuesGeneratorFunc(values []string, extracted map[string]string, lazyRoot func() *template.Template) func() (valuesData, error) {
+	generate := newConfiguredValuesGeneratorFunc(values, lazyRoot)
+	if extracted == nil {
+		return generate
+	}
+
+	return func() (valuesData, error) {
+		data, err := generate()
+		if err != nil {
+			return valuesData{}, err
+		}
+
+		result := make(map[string]string, len(data.Values)+len(extracted))
+		maps.Copy(result, data.Values)
+		maps.Copy(result, extracted)
+		return valuesData{Values: result}, nil
+	}
+}
+
+func newConfiguredValuesGeneratorFunc(values []string, lazyRoot func() *template.Template) func() (valuesData, error) {
 	// No values configured: hand back one shared empty map instead of allocating a
 	// fresh one for every request. Nothing ever writes to it.
 	if len(values) == 0 {
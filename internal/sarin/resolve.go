@@ -0,0 +1,251 @@
+package sarin
+
+import (
+	"context"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.aykhans.me/sarin/internal/types"
+)
+
+// ParseDNSServer maps a DNS server, given as host or host:port, to the address
+// to send the queries to, on port 53 unless another one is given, and reports
+// whether it is valid. IPv6 addresses may be written with or without brackets
+// when there is no port.
+func ParseDNSServer(server string) (string, bool) {
+	host, port, err := net.SplitHostPort(server)
+	if err != nil {
+		host, port = server, "53"
+		bracketed := strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]")
+		if bracketed {
+			host = host[1 : len(host)-1]
+		}
+		// Only IPv6 addresses may be in brackets, or have colons, here.
+		if strings.ContainsAny(host, "[]") || ((bracketed || strings.Contains(host, ":")) && net.ParseIP(host) == nil) {
+			return "", false
+		}
+	}
+	if portNumber, err := strconv.ParseUint(port, 10, 16); host == "" || err != nil || portNumber == 0 {
+		return "", false
+	}
+	return net.JoinHostPort(host, port), true
+}
+
+// IPStrategy decides which of the addresses of a host a new connection goes
+// to. The other addresses are only tried if that one refuses the connection.
+type IPStrategy uint8
+
+const (
+	// IPStrategyFirst connects to the addresses in the order they were
+	// resolved, so every connection goes to the first one that accepts it.
+	IPStrategyFirst IPStrategy = iota
+	// IPStrategyRoundRobin starts every new connection to a host at its next
+	// address in turn.
+	IPStrategyRoundRobin
+	// IPStrategyRandom starts every new connection at a random address.
+	IPStrategyRandom
+	// IPStrategyWorker gives each worker one address of every host, in turn,
+	// so the workers spread over the addresses evenly. The connections that
+	// the workers share, with HTTP/2 and HTTP/3, take turns like with
+	// IPStrategyRoundRobin.
+	IPStrategyWorker
+)
+
+// ParseIPStrategy maps a config value to an IPStrategy. Unknown values fall
+// back to IPStrategyFirst; config validation rejects them before they get
+// here.
+func ParseIPStrategy(strategy string) IPStrategy {
+	switch strategy {
+	case "round-robin":
+		return IPStrategyRoundRobin
+	case "random":
+		return IPStrategyRandom
+	case "worker":
+		return IPStrategyWorker
+	default:
+		return IPStrategyFirst
+	}
+}
+
+// resolver looks up the addresses that the dial functions connect to. The
+// resolve overrides come first; other host names are looked up through the
+// DNS server, or the system resolver without one. The strategy then picks the
+// address to start with. It is safe for concurrent use.
+type resolver struct {
+	// overrides is keyed by host:port, with the host lowercased.
+	overrides map[string][]net.IP
+	dns       *net.Resolver
+	strategy  IPStrategy
+	// worker is the worker that dials through the resolver, with
+	// IPStrategyWorker. It is -1 for the resolver of the run, whose
+	// connections the workers share.
+	worker int
+	// shared is what the resolvers of the workers share with that of the run.
+	shared *resolverState
+}
+
+type resolverState struct {
+	mu sync.Mutex
+	// cache holds the lookups of the run, so that each host is looked up only
+	// once. It is nil when every connection looks its host up again.
+	cache map[dnsCacheKey]*dnsLookup
+	// turns holds the address, by host:port, that the next connection
+	// starts with when they take turns.
+	turns map[string]uint64
+	// nextWorker is the worker that the next worker resolver dials for.
+	nextWorker atomic.Uint64
+}
+
+type dnsCacheKey struct {
+	network string
+	host    string
+}
+
+// dnsLookup is a lookup in the cache. done is closed once ips and err are set.
+type dnsLookup struct {
+	done chan struct{}
+	ips  []net.IP
+	err  error
+}
+
+// newResolver creates a resolver that sends its queries to dnsServer, unless
+// it is empty. An invalid server is ignored; config validation rejects it
+// before it gets here.
+func newResolver(overrides types.ResolveOverrides, dnsServer string, cache bool, strategy IPStrategy) *resolver {
+	res := &resolver{
+		overrides: make(map[string][]net.IP, len(overrides)),
+		dns:       net.DefaultResolver,
+		strategy:  strategy,
+		worker:    -1,
+		shared:    &resolverState{turns: make(map[string]uint64)},
+	}
+	for _, override := range overrides {
+		key := net.JoinHostPort(strings.ToLower(override.Host), override.Port)
+		res.overrides[key] = append(res.overrides[key], override.IPs...)
+	}
+
+	if serverAddr, ok := ParseDNSServer(dnsServer); ok {
+		dialer := &net.Dialer{}
+		res.dns = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, serverAddr)
+			},
+		}
+	}
+
+	if cache {
+		res.shared.cache = make(map[dnsCacheKey]*dnsLookup)
+	}
+	return res
+}
+
+// forWorker returns a resolver for the next worker, which shares everything
+// but the worker with res.
+func (res *resolver) forWorker() *resolver {
+	workerRes := *res
+	workerRes.worker = int(res.shared.nextWorker.Add(1) - 1)
+	return &workerRes
+}
+
+// resolve returns the addresses to connect to for host on port, in the order
+// to try them: host itself if it is an IP address, or else its overrides if it
+// has any, or its addresses of network, ip4 or ip, starting with the one the
+// strategy picks. The time spent on a lookup is reported to trace as DNS.
+// The returned slice may be shared, so it must not be modified.
+func (res *resolver) resolve(ctx context.Context, network, host, port string, trace *requestTrace) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	key := net.JoinHostPort(strings.ToLower(host), port)
+	ips, ok := res.overrides[key]
+	if !ok {
+		var err error
+		ips, err = res.lookup(ctx, network, host, trace)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res.spread(key, ips), nil
+}
+
+// lookup looks host up, or takes it from the cache. A lookup already in the
+// cache takes no time.
+func (res *resolver) lookup(ctx context.Context, network, host string, trace *requestTrace) ([]net.IP, error) {
+	start := time.Now()
+	if res.shared.cache == nil {
+		ips, err := res.dns.LookupIP(ctx, network, host)
+		trace.resolvedIn(time.Since(start))
+		return ips, err //nolint:wrapcheck
+	}
+
+	key := dnsCacheKey{network: network, host: host}
+	res.shared.mu.Lock()
+	lookup, cached := res.shared.cache[key]
+	if !cached {
+		lookup = &dnsLookup{done: make(chan struct{})}
+		res.shared.cache[key] = lookup
+	}
+	res.shared.mu.Unlock()
+
+	if !cached {
+		lookup.ips, lookup.err = res.dns.LookupIP(ctx, network, host)
+		if lookup.err != nil || len(lookup.ips) == 0 {
+			// Failed lookups aren't kept, so the next connection tries again.
+			res.shared.mu.Lock()
+			delete(res.shared.cache, key)
+			res.shared.mu.Unlock()
+		}
+		close(lookup.done)
+		trace.resolvedIn(time.Since(start))
+		return lookup.ips, lookup.err
+	}
+
+	// Connections that need the host while it is being looked up wait for
+	// that lookup, and count the wait as DNS.
+	select {
+	case <-lookup.done:
+		return lookup.ips, lookup.err
+	default:
+	}
+	select {
+	case <-lookup.done:
+		trace.resolvedIn(time.Since(start))
+		return lookup.ips, lookup.err
+	case <-ctx.Done():
+		trace.resolvedIn(time.Since(start))
+		return nil, ctx.Err() //nolint:wrapcheck
+	}
+}
+
+// spread returns ips rotated to start at the address that the strategy picks
+// for the next connection to key, which is host:port.
+func (res *resolver) spread(key string, ips []net.IP) []net.IP {
+	if len(ips) < 2 || res.strategy == IPStrategyFirst {
+		return ips
+	}
+
+	var first int
+	switch {
+	case res.strategy == IPStrategyRandom:
+		first = rand.IntN(len(ips)) //nolint:gosec // G404: Using non-cryptographic rand for load testing, not security
+	case res.strategy == IPStrategyWorker && res.worker >= 0:
+		first = res.worker % len(ips)
+	default:
+		res.shared.mu.Lock()
+		turn := res.shared.turns[key]
+		res.shared.turns[key]++
+		res.shared.mu.Unlock()
+		first = int(turn % uint64(len(ips)))
+	}
+
+	spread := make([]net.IP, 0, len(ips))
+	spread = append(spread, ips[first:]...)
+	return append(spread, ips[:first]...)
+}
@@ -0,0 +1,578 @@
+package sarin
+
+import (
+	"context"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.aykhans.me/sarin/internal/types"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// fakeDNS is a DNS server on a free UDP port of 127.0.0.1 that answers the A
+// and AAAA queries for its records, and counts the A queries by name.
+type fakeDNS struct {
+	addr    string
+	records map[string][]net.IP
+	// delay holds every answer back, so lookups can overlap.
+	delay time.Duration
+
+	mu      sync.Mutex
+	queries map[string]int
+}
+
+// startFakeDNS serves records, keyed by host name, until the test ends.
+func startFakeDNS(t *testing.T, records map[string][]net.IP, delay time.Duration) *fakeDNS {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() }) //nolint:errcheck
+
+	server := &fakeDNS{addr: conn.LocalAddr().String(), records: records, delay: delay, queries: make(map[string]int)}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if answer := server.answer(buf[:n]); answer != nil {
+				go func() {
+					time.Sleep(server.delay)
+					conn.WriteTo(answer, addr) //nolint:errcheck
+				}()
+			}
+		}
+	}()
+	return server
+}
+
+// answer builds the answer to query, or returns nil if it can't be parsed.
+func (server *fakeDNS) answer(query []byte) []byte {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(query)
+	if err != nil {
+		return nil
+	}
+	question, err := parser.Question()
+	if err != nil {
+		return nil
+	}
+	name := strings.TrimSuffix(strings.ToLower(question.Name.String()), ".")
+
+	if question.Type == dnsmessage.TypeA {
+		server.mu.Lock()
+		server.queries[name]++
+		server.mu.Unlock()
+	}
+	ips, known := server.records[name]
+
+	header.Response = true
+	header.Authoritative = true
+	if !known {
+		header.RCode = dnsmessage.RCodeNameError
+	}
+	builder := dnsmessage.NewBuilder(nil, header)
+	builder.EnableCompression()
+	builder.StartQuestions()   //nolint:errcheck
+	builder.Question(question) //nolint:errcheck
+	builder.StartAnswers()     //nolint:errcheck
+	resource := dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET, TTL: 60}
+	for _, ip := range ips {
+		switch ip4 := ip.To4(); {
+		case question.Type == dnsmessage.TypeA && ip4 != nil:
+			builder.AResource(resource, dnsmessage.AResource{A: [4]byte(ip4)}) //nolint:errcheck
+		case question.Type == dnsmessage.TypeAAAA && ip4 == nil:
+			builder.AAAAResource(resource, dnsmessage.AAAAResource{AAAA: [16]byte(ip.To16())}) //nolint:errcheck
+		}
+	}
+	answer, err := builder.Finish()
+	if err != nil {
+		return nil
+	}
+	return answer
+}
+
+// queriesFor returns the number of A queries for name so far.
+func (server *fakeDNS) queriesFor(name string) int {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return server.queries[name]
+}
+
+// parseIPs parses each of the raw IP addresses.
+func parseIPs(rawIPs ...string) []net.IP {
+	ips := make([]net.IP, 0, len(rawIPs))
+	for _, rawIP := range rawIPs {
+		ips = append(ips, net.ParseIP(rawIP))
+	}
+	return ips
+}
+
+// ipStrings formats ips, for comparing them.
+func ipStrings(ips []net.IP) []string {
+	formatted := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		formatted = append(formatted, ip.String())
+	}
+	return formatted
+}
+
+func TestParseDNSServer(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		server string
+		want   string
+		valid  bool
+	}{
+		{server: "1.1.1.1", want: "1.1.1.1:53", valid: true},
+		{server: "1.1.1.1:5353", want: "1.1.1.1:5353", valid: true},
+		{server: "dns.example", want: "dns.example:53", valid: true},
+		{server: "dns.example:853", want: "dns.example:853", valid: true},
+		{server: "2606:4700::1111", want: "[2606:4700::1111]:53", valid: true},
+		{server: "[2606:4700::1111]", want: "[2606:4700::1111]:53", valid: true},
+		{server: "[2606:4700::1111]:5353", want: "[2606:4700::1111]:5353", valid: true},
+		{server: "", valid: false},
+		{server: ":53", valid: false},
+		{server: "1.1.1.1:0", valid: false},
+		{server: "1.1.1.1:65536", valid: false},
+		{server: "1.1.1.1:dns", valid: false},
+		{server: "[dns.example]", valid: false},
+		{server: "dns:example", valid: false},
+		{server: "[2606:4700::1111", valid: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.server, func(t *testing.T) {
+			t.Parallel()
+
+			got, valid := ParseDNSServer(test.server)
+			if got != test.want || valid != test.valid {
+				t.Errorf("got %q, %v, want %q, %v", got, valid, test.want, test.valid)
+			}
+		})
+	}
+}
+
+func TestResolverResolve(t *testing.T) {
+	t.Parallel()
+
+	records := map[string][]net.IP{
+		"api.test":   parseIPs("10.0.0.1", "10.0.0.2", "fd00::1"),
+		"other.test": parseIPs("10.0.1.1"),
+	}
+	overrides := types.ResolveOverrides{
+		{Host: "api.test", Port: "443", IPs: parseIPs("192.0.2.1")},
+		{Host: "Cutover.Test", Port: "443", IPs: parseIPs("192.0.2.10")},
+		{Host: "cutover.test", Port: "443", IPs: parseIPs("192.0.2.11", "2001:db8::1")},
+	}
+
+	// lookup is a host to resolve, with the addresses it should resolve to.
+	type lookup struct {
+		network string
+		host    string
+		port    string
+		want    []string
+		wantErr bool
+	}
+	tests := []struct {
+		name    string
+		cache   bool
+		lookups []lookup
+		// wantQueries is the number of A queries for each host.
+		wantQueries map[string]int
+	}{
+		{
+			name: "IP addresses",
+			lookups: []lookup{
+				{network: "ip4", host: "10.9.9.9", port: "80", want: []string{"10.9.9.9"}},
+				{network: "ip", host: "2001:db8::9", port: "80", want: []string{"2001:db8::9"}},
+			},
+		},
+		{
+			name: "Overrides",
+			lookups: []lookup{
+				{network: "ip4", host: "api.test", port: "443", want: []string{"192.0.2.1"}},
+				// Host names are matched without regard to case, and the
+				// overrides of the same host and port add up.
+				{network: "ip4", host: "CUTOVER.test", port: "443", want: []string{"192.0.2.10", "192.0.2.11", "2001:db8::1"}},
+			},
+		},
+		{
+			name: "Overrides only for their port",
+			lookups: []lookup{
+				{network: "ip4", host: "api.test", port: "80", want: []string{"10.0.0.1", "10.0.0.2"}},
+				{network: "ip", host: "api.test", port: "80", want: []string{"10.0.0.1", "10.0.0.2", "fd00::1"}},
+			},
+			wantQueries: map[string]int{"api.test": 2},
+		},
+		{
+			name: "Lookups without the cache",
+			lookups: []lookup{
+				{network: "ip4", host: "other.test", port: "80", want: []string{"10.0.1.1"}},
+				{network: "ip4", host: "other.test", port: "80", want: []string{"10.0.1.1"}},
+				{network: "ip4", host: "other.test", port: "443", want: []string{"10.0.1.1"}},
+			},
+			wantQueries: map[string]int{"other.test": 3},
+		},
+		{
+			name:  "Lookups with the cache",
+			cache: true,
+			lookups: []lookup{
+				{network: "ip4", host: "other.test", port: "80", want: []string{"10.0.1.1"}},
+				{network: "ip4", host: "other.test", port: "80", want: []string{"10.0.1.1"}},
+				{network: "ip4", host: "other.test", port: "443", want: []string{"10.0.1.1"}},
+				// The cache is kept by network as well as by host.
+				{network: "ip", host: "api.test", port: "80", want: []string{"10.0.0.1", "10.0.0.2", "fd00::1"}},
+				{network: "ip4", host: "api.test", port: "80", want: []string{"10.0.0.1", "10.0.0.2"}},
+				{network: "ip4", host: "api.test", port: "80", want: []string{"10.0.0.1", "10.0.0.2"}},
+			},
+			wantQueries: map[string]int{"other.test": 1, "api.test": 2},
+		},
+		{
+			name:  "Failed lookups aren't cached",
+			cache: true,
+			lookups: []lookup{
+				{network: "ip4", host: "missing.test", port: "80", wantErr: true},
+				{network: "ip4", host: "missing.test", port: "80", wantErr: true},
+			},
+			wantQueries: map[string]int{"missing.test": 2},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			dns := startFakeDNS(t, records, 0)
+			res := newResolver(overrides, dns.addr, test.cache, IPStrategyFirst)
+			for _, lookup := range test.lookups {
+				ips, err := res.resolve(t.Context(), lookup.network, lookup.host, lookup.port, nil)
+				if (err != nil) != lookup.wantErr {
+					t.Fatalf("%s %s:%s: got error %v, want error %v", lookup.network, lookup.host, lookup.port, err, lookup.wantErr)
+				}
+				if got := ipStrings(ips); !slices.Equal(got, lookup.want) && !(len(got) == 0 && lookup.want == nil) {
+					t.Errorf("%s %s:%s: got %v, want %v", lookup.network, lookup.host, lookup.port, got, lookup.want)
+				}
+			}
+			for _, host := range []string{"api.test", "other.test", "missing.test", "cutover.test"} {
+				if got := dns.queriesFor(host); got != test.wantQueries[host] {
+					t.Errorf("got %d queries for %s, want %d", got, host, test.wantQueries[host])
+				}
+			}
+		})
+	}
+}
+
+func TestResolverCachedLookupOnce(t *testing.T) {
+	t.Parallel()
+
+	// Connections that need a host while it is being looked up wait for that
+	// lookup, and count the wait as DNS.
+	const delay = 50 * time.Millisecond
+	dns := startFakeDNS(t, map[string][]net.IP{"api.test": parseIPs("10.0.0.1")}, delay)
+	res := newResolver(nil, dns.addr, true, IPStrategyFirst)
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Go(func() {
+			trace := &requestTrace{}
+			ips, err := res.resolve(t.Context(), "ip4", "api.test", "80", trace)
+			if err != nil || len(ips) != 1 {
+				t.Errorf("got %v, %v, want 10.0.0.1", ips, err)
+			}
+			if trace.dns < delay/2 {
+				t.Errorf("got %s of DNS, want at least %s", trace.dns, delay/2)
+			}
+		})
+	}
+	wg.Wait()
+	if got := dns.queriesFor("api.test"); got != 1 {
+		t.Errorf("got %d queries, want 1", got)
+	}
+
+	// A lookup already in the cache takes no time.
+	trace := &requestTrace{}
+	if _, err := res.resolve(t.Context(), "ip4", "api.test", "80", trace); err != nil {
+		t.Fatal(err)
+	}
+	if trace.resolved {
+		t.Errorf("got %s of DNS for a cached lookup", trace.dns)
+	}
+
+	// A wait that is cancelled gives up.
+	slow := newResolver(nil, dns.addr, true, IPStrategyFirst)
+	go slow.resolve(context.Background(), "ip4", "api.test", "80", nil) //nolint:errcheck
+	time.Sleep(delay / 5)
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if _, err := slow.resolve(ctx, "ip4", "api.test", "80", nil); err == nil {
+		t.Error("got no error from a cancelled wait")
+	}
+}
+
+func TestParseIPStrategy(t *testing.T) {
+	t.Parallel()
+
+	for strategy, want := range map[string]IPStrategy{
+		"first":       IPStrategyFirst,
+		"round-robin": IPStrategyRoundRobin,
+		"random":      IPStrategyRandom,
+		"worker":      IPStrategyWorker,
+		"":            IPStrategyFirst,
+		"roundrobin":  IPStrategyFirst,
+	} {
+		if got := ParseIPStrategy(strategy); got != want {
+			t.Errorf("%q: got %d, want %d", strategy, got, want)
+		}
+	}
+}
+
+func TestResolverSpread(t *testing.T) {
+	t.Parallel()
+
+	overrides := types.ResolveOverrides{
+		{Host: "api.test", Port: "443", IPs: parseIPs("10.0.0.1", "10.0.0.2", "10.0.0.3")},
+		{Host: "api.test", Port: "80", IPs: parseIPs("10.0.0.1", "10.0.0.2", "10.0.0.3")},
+		{Host: "single.test", Port: "443", IPs: parseIPs("10.0.1.1")},
+	}
+
+	// connection is a connection to open, through the resolver of the run or
+	// of a worker, with the address it should start with.
+	type connection struct {
+		worker int
+		host   string
+		port   string
+		want   string
+	}
+	tests := []struct {
+		name     string
+		strategy IPStrategy
+		// workers is the number of worker resolvers; connection.worker -1
+		// stands for the resolver of the run.
+		workers     int
+		connections []connection
+	}{
+		{
+			name:     "First",
+			strategy: IPStrategyFirst,
+			workers:  2,
+			connections: []connection{
+				{worker: -1, host: "api.test", port: "443", want: "10.0.0.1"},
+				{worker: -1, host: "api.test", port: "443", want: "10.0.0.1"},
+				{worker: 1, host: "api.test", port: "443", want: "10.0.0.1"},
+			},
+		},
+		{
+			name:     "Round-robin",
+			strategy: IPStrategyRoundRobin,
+			workers:  2,
+			connections: []connection{
+				{worker: -1, host: "api.test", port: "443", want: "10.0.0.1"},
+				{worker: 0, host: "api.test", port: "443", want: "10.0.0.2"},
+				{worker: 1, host: "api.test", port: "443", want: "10.0.0.3"},
+				{worker: 0, host: "api.test", port: "443", want: "10.0.0.1"},
+				// Each host and port takes turns of its own.
+				{worker: 0, host: "api.test", port: "80", want: "10.0.0.1"},
+				{worker: 0, host: "API.test", port: "80", want: "10.0.0.2"},
+				{worker: 0, host: "single.test", port: "443", want: "10.0.1.1"},
+				{worker: 1, host: "api.test", port: "443", want: "10.0.0.2"},
+			},
+		},
+		{
+			name:     "Worker",
+			strategy: IPStrategyWorker,
+			workers:  4,
+			connections: []connection{
+				{worker: 0, host: "api.test", port: "443", want: "10.0.0.1"},
+				{worker: 1, host: "api.test", port: "443", want: "10.0.0.2"},
+				{worker: 2, host: "api.test", port: "443", want: "10.0.0.3"},
+				{worker: 3, host: "api.test", port: "443", want: "10.0.0.1"},
+				{worker: 1, host: "api.test", port: "443", want: "10.0.0.2"},
+				{worker: 1, host: "api.test", port: "80", want: "10.0.0.2"},
+				{worker: 2, host: "single.test", port: "443", want: "10.0.1.1"},
+				// The connections the workers share take turns.
+				{worker: -1, host: "api.test", port: "443", want: "10.0.0.1"},
+				{worker: -1, host: "api.test", port: "443", want: "10.0.0.2"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			res := newResolver(overrides, "", false, test.strategy)
+			workers := make([]*resolver, test.workers)
+			for i := range workers {
+				workers[i] = res.forWorker()
+			}
+			for i, connection := range test.connections {
+				connRes := res
+				if connection.worker >= 0 {
+					connRes = workers[connection.worker]
+				}
+				ips, err := connRes.resolve(t.Context(), "ip4", connection.host, connection.port, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := ips[0].String(); got != connection.want {
+					t.Errorf("connection %d, worker %d to %s:%s: got %s first, want %s",
+						i, connection.worker, connection.host, connection.port, got, connection.want)
+				}
+				// The other addresses follow in order, to fall back on.
+				want := ipStrings(overrides[0].IPs)
+				if connection.host == "single.test" {
+					want = []string{"10.0.1.1"}
+				}
+				first := slices.Index(want, connection.want)
+				want = append(want[first:], want[:first]...)
+				if got := ipStrings(ips); !slices.Equal(got, want) {
+					t.Errorf("connection %d: got %v, want %v", i, got, want)
+				}
+			}
+		})
+	}
+
+	t.Run("Random", func(t *testing.T) {
+		t.Parallel()
+
+		res := newResolver(overrides, "", false, IPStrategyRandom)
+		counts := make(map[string]int)
+		for range 3000 {
+			ips, err := res.resolve(t.Context(), "ip4", "api.test", "443", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			counts[ips[0].String()]++
+		}
+		for _, ip := range ipStrings(overrides[0].IPs) {
+			if counts[ip] < 800 {
+				t.Errorf("got %s first %d times out of 3000, want about 1000", ip, counts[ip])
+			}
+		}
+	})
+
+	// The addresses of a lookup are spread too, and those in the cache
+	// aren't changed by it.
+	t.Run("Looked up", func(t *testing.T) {
+		t.Parallel()
+
+		dns := startFakeDNS(t, map[string][]net.IP{"api.test": parseIPs("10.0.0.1", "10.0.0.2")}, 0)
+		res := newResolver(nil, dns.addr, true, IPStrategyRoundRobin)
+		for _, want := range [][]string{{"10.0.0.1", "10.0.0.2"}, {"10.0.0.2", "10.0.0.1"}, {"10.0.0.1", "10.0.0.2"}} {
+			ips, err := res.resolve(t.Context(), "ip4", "api.test", "80", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ipStrings(ips); !slices.Equal(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		}
+		if got := dns.queriesFor("api.test"); got != 1 {
+			t.Errorf("got %d queries, want 1", got)
+		}
+	})
+}
+
+func TestRemoteIPStats(t *testing.T) {
+	t.Parallel()
+
+	data := NewSarinResponseData(nil, nil, true)
+	shard := data.NewShard()
+	remotes := []net.Addr{
+		&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 443},
+		&net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 443},
+		&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 8443},
+		&net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443},
+	}
+	for _, remote := range remotes {
+		shard.Add("200", 10*time.Millisecond, time.Time{}, time.Time{}, &sentRequest{received: true, remote: remote})
+	}
+	// A slow backend shows up on its own.
+	shard.Add("200", time.Second, time.Time{}, time.Time{}, &sentRequest{received: true, remote: remotes[1]})
+	// Requests that weren't sent over a connection aren't counted by IP.
+	shard.Add("dial timeout", time.Second, time.Time{}, time.Time{}, &sentRequest{})
+	shard.Add("200", 10*time.Millisecond, time.Time{}, time.Time{}, nil)
+
+	data.Lock()
+	defer data.Unlock()
+	data.collect()
+	want := map[string]struct {
+		count uint64
+		max   time.Duration
+	}{
+		"10.0.0.1":    {count: 2, max: 10 * time.Millisecond},
+		"10.0.0.2":    {count: 2, max: time.Second},
+		"2001:db8::1": {count: 1, max: 10 * time.Millisecond},
+	}
+	if got := slices.Sorted(maps.Keys(data.remoteIPs)); !slices.Equal(got, slices.Sorted(maps.Keys(want))) {
+		t.Fatalf("got stats for %v, want them for %v", got, slices.Sorted(maps.Keys(want)))
+	}
+	for ip, want := range want {
+		response := data.remoteIPs[ip]["200"]
+		if response == nil || response.durations.total != want.count || response.durations.max != want.max {
+			t.Errorf("%s: got %+v, want %d responses up to %s", ip, response, want.count, want.max)
+		}
+	}
+	if got := data.Responses["200"].durations.total; got != 6 {
+		t.Errorf("got %d responses in total, want 6", got)
+	}
+
+	// Without remote IP stats, nothing is kept by IP.
+	data = NewSarinResponseData(nil, nil, false)
+	data.NewShard().Add("200", time.Millisecond, time.Time{}, time.Time{}, &sentRequest{received: true, remote: remotes[0]})
+	data.Lock()
+	defer data.Unlock()
+	data.collect()
+	if len(data.remoteIPs) != 0 {
+		t.Errorf("got stats by remote IP %v without remote IP stats", slices.Collect(maps.Keys(data.remoteIPs)))
+	}
+}
+
+func TestDirectDialFuncOverride(t *testing.T) {
+	t.Parallel()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close() //nolint:errcheck
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close() //nolint:errcheck
+		}
+	}()
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first address refuses the connection, so the dial moves on to
+	// the next one.
+	res := newResolver(types.ResolveOverrides{
+		{Host: "app.test", Port: port, IPs: parseIPs("127.0.0.2", "127.0.0.1")},
+	}, "", false, IPStrategyFirst)
+	dial := newDirectDialFunc(t.Context(), time.Second, res, nil)
+	conn, err := dial(net.JoinHostPort("app.test", port), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close() //nolint:errcheck
+	if got, want := conn.RemoteAddr().String(), listener.Addr().String(); got != want {
+		t.Errorf("got connected to %s, want %s", got, want)
+	}
+}
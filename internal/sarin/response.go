@@ -1,20 +1,26 @@
 package sarin
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
 	"math/big"
+	"net"
 	"os"
 	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"charm.land/lipgloss/v2"
 	"charm.land/lipgloss/v2/table"
+	"go.aykhans.me/sarin/internal/types"
 	"go.yaml.in/yaml/v4"
 )
 
-const DefaultResponseDurationAccuracy uint32 = 1
 const DefaultResponseColumnMaxWidth = 50
 
 // Duration wraps time.Duration to provide consistent JSON/YAML marshaling as human-readable strings.
@@ -63,49 +69,438 @@ func (b BigInt) String() string {
 }
 
 type Response struct {
-	durations map[time.Duration]uint64
+	durations histogram
+	// corrected holds the response times measured from each request's
+	// scheduled send time. It is filled only for rate-scheduled requests.
+	corrected histogram
+	// phases holds the time spent in each phase of the requests, for those
+	// requests in which the phase was measured.
+	phases [requestPhaseCount]histogram
+	// requestsSent and responsesReceived count the requests that reached
+	// the client and the responses read back, and requestBytes and
+	// responseBytes add up their sizes.
+	requestsSent      uint64
+	requestBytes      uint64
+	responsesReceived uint64
+	responseBytes     uint64
+	// streams counts the responses read as streams of events, events adds
+	// up their events, and minEvents and maxEvents are the fewest and the
+	// most events a stream had. firstEvent holds the time from writing each
+	// request to the first event of its response, and eventGaps the time
+	// between consecutive events of a response.
+	streams    uint64
+	events     uint64
+	minEvents  uint64
+	maxEvents  uint64
+	firstEvent histogram
+	eventGaps  histogram
+	// handshakes counts the TLS handshakes of the connections opened for the
+	// requests, and resumedHandshakes those that resumed a session.
+	handshakes        uint64
+	resumedHandshakes uint64
+}
+
+func (response *Response) merge(other *Response) {
+	response.durations.merge(&other.durations)
+	response.corrected.merge(&other.corrected)
+	for phase := range response.phases {
+		response.phases[phase].merge(&other.phases[phase])
+	}
+	response.requestsSent += other.requestsSent
+	response.requestBytes += other.requestBytes
+	response.responsesReceived += other.responsesReceived
+	response.responseBytes += other.responseBytes
+	if other.streams > 0 {
+		if response.streams == 0 || other.minEvents < response.minEvents {
+			response.minEvents = other.minEvents
+		}
+		response.maxEvents = max(response.maxEvents, other.maxEvents)
+		response.streams += other.streams
+		response.events += other.events
+	}
+	response.firstEvent.merge(&other.firstEvent)
+	response.eventGaps.merge(&other.eventGaps)
+	response.handshakes += other.handshakes
+	response.resumedHandshakes += other.resumedHandshakes
+}
+
+// reset empties the response, keeping the buckets of its histograms for reuse.
+func (response *Response) reset() {
+	emptied := Response{
+		durations:  response.durations,
+		corrected:  response.corrected,
+		phases:     response.phases,
+		firstEvent: response.firstEvent,
+		eventGaps:  response.eventGaps,
+	}
+	emptied.durations.reset()
+	emptied.corrected.reset()
+	for phase := range emptied.phases {
+		emptied.phases[phase].reset()
+	}
+	emptied.firstEvent.reset()
+	emptied.eventGaps.reset()
+	*response = emptied
+}
+
+// record adds a single request to the response. scheduledAt is zero and
+// correctedTime unused for requests that weren't rate-scheduled.
+func (response *Response) record(serviceTime, correctedTime time.Duration, scheduledAt time.Time, sent *sentRequest) {
+	response.durations.record(serviceTime)
+	if !scheduledAt.IsZero() {
+		response.corrected.record(correctedTime)
+	}
+	if sent != nil {
+		for phase, measured := range sent.phases.measured {
+			if measured {
+				response.phases[phase].record(sent.phases.durations[phase])
+			}
+		}
+		response.requestsSent++
+		response.requestBytes += sent.requestBytes
+		response.handshakes += sent.handshakes
+		response.resumedHandshakes += sent.resumedHandshakes
+		if sent.received {
+			response.responsesReceived++
+			response.responseBytes += sent.responseBytes
+		}
+		if sent.stream != nil {
+			response.recordStream(sent)
+		}
+	}
+}
+
+// recordStream adds the events of a response that was read as a stream.
+func (response *Response) recordStream(sent *sentRequest) {
+	count := sent.stream.count
+	if response.streams == 0 || count < response.minEvents {
+		response.minEvents = count
+	}
+	response.maxEvents = max(response.maxEvents, count)
+	response.streams++
+	response.events += count
+	if count > 0 {
+		response.firstEvent.record(sent.firstEvent)
+	}
+	response.eventGaps.merge(&sent.stream.gaps)
+}
+
+// sentRequest describes a request that was handed to the client.
+type sentRequest struct {
+	phases requestPhases
+	// requestBytes is the size of the request, headers included.
+	requestBytes uint64
+	// responseBytes is the size of the response, headers included, or
+	// zero when no response was received.
+	responseBytes uint64
+	received      bool
+	// stream holds the events of the response in stream mode, and is nil
+	// otherwise or when no response was received. firstEvent is the time
+	// from writing the request to the first of them.
+	stream     *streamEvents
+	firstEvent time.Duration
+	// handshakes counts the TLS handshakes of the connections opened for the
+	// request, and resumedHandshakes those that resumed a session.
+	handshakes        uint64
+	resumedHandshakes uint64
+	// remote is the address of the server the request was sent to, or nil
+	// when it wasn't sent over a connection.
+	remote net.Addr
+}
+
+// statsShard holds the responses recorded by a single worker, so workers don't
+// contend on a shared lock. Its mutex is only ever contended by collect, which
+// runs at stage boundaries and when the stats are printed.
+type statsShard struct {
+	mu        sync.Mutex
+	responses map[string]*Response
+	// scenario is the name of the scenario the worker's current request
+	// belongs to. Responses of a named scenario are also recorded in
+	// scenarioResponses, by scenario name and then response key. It is only
+	// accessed by the worker that owns the shard.
+	scenario          string
+	scenarioResponses map[string]map[string]*Response
+	// remoteIPResponses holds the responses by the IP address they came from
+	// and then response key, when the run breaks them down by remote IP. It
+	// is nil otherwise.
+	remoteIPResponses map[string]map[string]*Response
+	// disconnects counts the WebSocket connections the worker lost, by
+	// reason.
+	disconnects map[string]uint64
+	// recorded is set when anything was recorded since the previous drain.
+	recorded bool
+}
+
+// setScenario makes the following responses count towards the named scenario.
+// It does nothing on a nil shard.
+func (shard *statsShard) setScenario(name string) {
+	if shard != nil {
+		shard.scenario = name
+	}
+}
+
+// disconnected counts a WebSocket connection that was lost for reason. It does
+// nothing on a nil shard.
+func (shard *statsShard) disconnected(reason string) {
+	if shard == nil {
+		return
+	}
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	shard.disconnects[reason]++
+	shard.recorded = true
+}
+
+// Add records a response that ended at end. serviceTime is measured from the
+// moment the request was actually sent. For a rate-scheduled request
+// scheduledAt is its intended send time, and the time from then to end is
+// recorded as well, so that waiting for a free worker shows up in the
+// corrected figures instead of being omitted. sent is nil when the request
+// was never sent.
+func (shard *statsShard) Add(responseKey string, serviceTime time.Duration, scheduledAt, end time.Time, sent *sentRequest) {
+	var correctedTime time.Duration
+	if !scheduledAt.IsZero() {
+		correctedTime = max(end.Sub(scheduledAt), serviceTime)
+	}
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	shard.recorded = true
+	responseFor(shard.responses, responseKey).record(serviceTime, correctedTime, scheduledAt, sent)
+	if shard.scenario != "" {
+		responses := groupFor(shard.scenarioResponses, shard.scenario)
+		responseFor(responses, responseKey).record(serviceTime, correctedTime, scheduledAt, sent)
+	}
+	if shard.remoteIPResponses != nil && sent != nil && sent.remote != nil {
+		responses := groupFor(shard.remoteIPResponses, remoteIP(sent.remote))
+		responseFor(responses, responseKey).record(serviceTime, correctedTime, scheduledAt, sent)
+	}
+}
+
+// drain hands the responses recorded since the previous drain to merge, along
+// with the same responses by scenario and by remote IP, and the disconnects
+// counted since then. It then empties them, keeping their keys and the buckets
+// of their histograms for the responses that follow, so merge must not keep
+// them. merge runs with the shard locked and is skipped when nothing was
+// recorded.
+func (shard *statsShard) drain(merge func(
+	responses map[string]*Response,
+	scenarioResponses map[string]map[string]*Response,
+	remoteIPResponses map[string]map[string]*Response,
+	disconnects map[string]uint64,
+)) {
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if !shard.recorded {
+		return
+	}
+	merge(shard.responses, shard.scenarioResponses, shard.remoteIPResponses, shard.disconnects)
+
+	resetAll := func(responses map[string]*Response) {
+		for _, response := range responses {
+			response.reset()
+		}
+	}
+	resetAll(shard.responses)
+	for _, responses := range shard.scenarioResponses {
+		resetAll(responses)
+	}
+	for _, responses := range shard.remoteIPResponses {
+		resetAll(responses)
+	}
+	clear(shard.disconnects)
+	shard.recorded = false
+}
+
+// remoteIP returns the IP address of remote, or all of it if it has no port.
+func remoteIP(remote net.Addr) string {
+	host, _, err := net.SplitHostPort(remote.String())
+	if err != nil {
+		return remote.String()
+	}
+	return host
+}
+
+// groupFor returns the responses grouped under name, adding an empty group if
+// there is none yet.
+func groupFor(groups map[string]map[string]*Response, name string) map[string]*Response {
+	responses, ok := groups[name]
+	if !ok {
+		responses = make(map[string]*Response)
+		groups[name] = responses
+	}
+	return responses
+}
+
+// responseFor returns the response recorded under key, adding an empty one if
+// there is none yet.
+func responseFor(responses map[string]*Response, key string) *Response {
+	response, ok := responses[key]
+	if !ok {
+		response = &Response{}
+		responses[key] = response
+	}
+	return response
 }
 
 type SarinResponseData struct {
 	sync.Mutex
 
+	// Responses holds everything collected from the shards so far.
 	Responses map[string]*Response
+	// scenarios holds the same responses by scenario name, for the runs that
+	// have named scenarios. The steps of a flow are recorded as scenarios too.
+	scenarios map[string]map[string]*Response
+	// flowSteps holds the names of the flow's steps in order, for runs with a
+	// flow.
+	flowSteps []string
+	// remoteIPs holds the same responses by the IP address they came from,
+	// for the runs that break them down by remote IP. It is nil otherwise.
+	remoteIPs map[string]map[string]*Response
+	// disconnects counts the WebSocket connections lost during the run, by
+	// reason.
+	disconnects map[string]uint64
+	// localAddrs counts the connections opened from each local address, for
+	// the runs that bind them. It is nil otherwise.
+	localAddrs *localAddrs
+
+	shards []*statsShard
+
+	// percentiles are the latency percentiles to report, sorted ascending.
+	percentiles []float64
+
+	thresholds types.Thresholds
 
-	// accuracy is the time bucket size in nanoseconds for storing response durations.
-	// Larger values (e.g., 1000) save memory but reduce accuracy by grouping more durations together.
-	// Smaller values (e.g., 10) improve accuracy but increase memory usage.
-	// Minimum value is 1 (most accurate, highest memory usage).
-	// Default value is 1.
-	accuracy time.Duration
+	// rate is set only for rate-limited runs.
+	rate *rateStat
+
+	// duration is the wall-clock time of the run, from starting the workers
+	// until the last of them finished.
+	duration time.Duration
+
+	// stages holds the responses of each finished stage. While stages are
+	// running, everything collected is also added to stageResponses, which is
+	// handed over to stages when the current stage ends.
+	stages         []stageResponses
+	stageResponses map[string]*Response
+	stageStartedAt time.Time
+
+	// timeline holds the summary of each finished interval. While the
+	// timeline is running, everything collected is also added to window.
+	timeline        []timelineWindow
+	timelineStarted time.Time
+	window          map[string]*Response
+	windowStartedAt time.Time
+
+	// abortSteps holds the responses of each step of the abort window,
+	// oldest first. Everything collected is added to the last step.
+	abortSteps  []map[string]*Response
+	abortReason string
 }
 
-func NewSarinResponseData(accuracy uint32) *SarinResponseData {
-	if accuracy == 0 {
-		accuracy = DefaultResponseDurationAccuracy
+type stageResponses struct {
+	index       int
+	duration    time.Duration
+	rate        *uint
+	concurrency *uint
+	responses   map[string]*Response
+}
+
+// NewSarinResponseData creates an empty response store that reports the given
+// percentiles and checks the given thresholds. Duplicate percentiles are
+// dropped and their order doesn't matter. With remoteIPStats set, the
+// responses are also broken down by the IP address they came from.
+func NewSarinResponseData(percentiles []float64, thresholds types.Thresholds, remoteIPStats bool) *SarinResponseData {
+	percentiles = slices.Clone(percentiles)
+	slices.Sort(percentiles)
+
+	data := &SarinResponseData{
+		Responses:   make(map[string]*Response),
+		scenarios:   make(map[string]map[string]*Response),
+		disconnects: make(map[string]uint64),
+		percentiles: slices.Compact(percentiles),
+		thresholds:  thresholds,
+	}
+	if remoteIPStats {
+		data.remoteIPs = make(map[string]map[string]*Response)
 	}
+	return data
+}
 
-	return &SarinResponseData{
-		Responses: make(map[string]*Response),
-		accuracy:  time.Duration(accuracy),
+// NewShard creates a stats shard for one worker. Responses added to it are
+// merged into data whenever the stats are collected.
+func (data *SarinResponseData) NewShard() *statsShard {
+	data.Lock()
+	defer data.Unlock()
+
+	shard := &statsShard{
+		responses:         make(map[string]*Response),
+		scenarioResponses: make(map[string]map[string]*Response),
+		disconnects:       make(map[string]uint64),
+	}
+	if data.remoteIPs != nil {
+		shard.remoteIPResponses = make(map[string]map[string]*Response)
 	}
+	data.shards = append(data.shards, shard)
+	return shard
 }
 
-func (data *SarinResponseData) Add(responseKey string, responseTime time.Duration) {
+// SetRateStats records the outcome of a rate-limited run: the target rate, how
+// many requests were scheduled and how many of them found no idle worker.
+func (data *SarinResponseData) SetRateStats(target uint, scheduled, missed uint64) {
 	data.Lock()
 	defer data.Unlock()
 
-	response, ok := data.Responses[responseKey]
-	if !ok {
-		data.Responses[responseKey] = &Response{
-			durations: map[time.Duration]uint64{
-				responseTime / data.accuracy: 1,
-			},
-		}
-	} else {
-		response.durations[responseTime/data.accuracy]++
+	data.rate = &rateStat{
+		Target:    target,
+		Scheduled: scheduled,
+		Missed:    missed,
 	}
 }
 
+// SetDuration records the wall-clock time of the run.
+func (data *SarinResponseData) SetDuration(duration time.Duration) {
+	data.Lock()
+	defer data.Unlock()
+
+	data.duration = duration
+}
+
+// StartStages starts recording per-stage stats and the clock of the first
+// stage.
+func (data *SarinResponseData) StartStages() {
+	data.Lock()
+	defer data.Unlock()
+
+	data.collect()
+	data.stageResponses = make(map[string]*Response)
+	data.stageStartedAt = time.Now()
+}
+
+// MarkStage closes the current stage: every response recorded since the
+// previous mark is attributed to the stage with the given index and target.
+// A response is counted in the stage in which it completed.
+func (data *SarinResponseData) MarkStage(index int, rate, concurrency *uint) {
+	data.Lock()
+	defer data.Unlock()
+
+	data.collect()
+	now := time.Now()
+	data.stages = append(data.stages, stageResponses{
+		index:       index,
+		duration:    now.Sub(data.stageStartedAt),
+		rate:        rate,
+		concurrency: concurrency,
+		responses:   data.stageResponses,
+	})
+	data.stageResponses = make(map[string]*Response)
+	data.stageStartedAt = now
+}
+
 func (data *SarinResponseData) PrintTable() {
 	data.Lock()
 	defer data.Unlock()
@@ -120,45 +515,218 @@ func (data *SarinResponseData) PrintTable() {
 	cellStyle := lipgloss.NewStyle().
 		Padding(0, 1)
 
-	rows := make([][]string, 0, len(output.Responses)+1)
-	for key, stats := range output.Responses {
-		rows = append(rows, []string{
-			wrapText(key, DefaultResponseColumnMaxWidth),
+	percentileHeaders := make([]string, len(data.percentiles))
+	for i, percentile := range data.percentiles {
+		percentileHeaders[i] = "P" + formatPercentile(percentile)
+	}
+
+	newTable := func(headers []string, rows [][]string) *table.Table {
+		return table.New().
+			Border(lipgloss.NormalBorder()).
+			BorderStyle(lipgloss.NewStyle().Foreground(lipgloss.Color("240"))).
+			BorderRow(true).
+			Headers(headers...).
+			Rows(rows...).
+			StyleFunc(func(row, col int) lipgloss.Style {
+				if row == table.HeaderRow {
+					return headerStyle
+				}
+				return cellStyle
+			})
+	}
+
+	statCells := func(stats responseStat) []string {
+		cells := []string{
 			stats.Count.String(),
 			stats.Min.String(),
 			stats.Max.String(),
 			stats.Average.String(),
-			stats.P90.String(),
-			stats.P95.String(),
-			stats.P99.String(),
-		})
+		}
+		for _, percentile := range stats.Percentiles {
+			cells = append(cells, percentile.Value.String())
+		}
+		return cells
 	}
 
-	rows = append(rows, []string{
-		"Total",
-		output.Total.Count.String(),
-		output.Total.Min.String(),
-		output.Total.Max.String(),
-		output.Total.Average.String(),
-		output.Total.P90.String(),
-		output.Total.P95.String(),
-		output.Total.P99.String(),
-	})
+	statHeaders := append([]string{"Count", "Min", "Max", "Average"}, percentileHeaders...)
+
+	rows := make([][]string, 0, len(output.Responses)+1)
+	for _, key := range slices.Sorted(maps.Keys(output.Responses)) {
+		rows = append(rows, append([]string{wrapText(key, DefaultResponseColumnMaxWidth)}, statCells(output.Responses[key])...))
+	}
+	rows = append(rows, append([]string{"Total"}, statCells(output.Total)...))
+
+	lipgloss.Println(newTable(append([]string{"Response"}, statHeaders...), rows))
 
-	tbl := table.New().
-		Border(lipgloss.NormalBorder()).
-		BorderStyle(lipgloss.NewStyle().Foreground(lipgloss.Color("240"))).
-		BorderRow(true).
-		Headers("Response", "Count", "Min", "Max", "Average", "P90", "P95", "P99").
-		Rows(rows...).
-		StyleFunc(func(row, col int) lipgloss.Style {
-			if row == table.HeaderRow {
-				return headerStyle
+	if output.Total.Corrected != nil {
+		correctedRows := make([][]string, 0, len(output.Responses)+1)
+		for _, key := range slices.Sorted(maps.Keys(output.Responses)) {
+			corrected := output.Responses[key].Corrected
+			if corrected == nil {
+				continue
 			}
-			return cellStyle
-		})
+			correctedRows = append(correctedRows, append([]string{wrapText(key, DefaultResponseColumnMaxWidth)}, statCells(*corrected)...))
+		}
+		correctedRows = append(correctedRows, append([]string{"Total"}, statCells(*output.Total.Corrected)...))
+
+		lipgloss.Println(headerStyle.Render("Response time corrected for queueing (from scheduled send time):"))
+		lipgloss.Println(newTable(append([]string{"Corrected"}, statHeaders...), correctedRows))
+	}
+
+	printScenarios := func(label string, scenarios []scenarioStat) {
+		if len(scenarios) == 0 {
+			return
+		}
+
+		scenarioRows := make([][]string, 0, len(scenarios))
+		for _, scenario := range scenarios {
+			name := wrapText(scenario.Name, DefaultResponseColumnMaxWidth)
+			for _, key := range slices.Sorted(maps.Keys(scenario.Responses)) {
+				scenarioRows = append(scenarioRows, append([]string{name, wrapText(key, DefaultResponseColumnMaxWidth)}, statCells(scenario.Responses[key])...))
+			}
+			scenarioRows = append(scenarioRows, append([]string{name, "Total"}, statCells(scenario.Total)...))
+		}
+
+		lipgloss.Println(newTable(append([]string{label, "Response"}, statHeaders...), scenarioRows))
+	}
+	printScenarios("Scenario", output.Scenarios)
+	printScenarios("Step", output.Steps)
+	printScenarios("Remote IP", output.RemoteIPs)
+
+	if len(output.Phases) > 0 {
+		phaseRows := make([][]string, 0, len(output.Phases))
+		for _, phase := range output.Phases {
+			phaseRows = append(phaseRows, append([]string{phase.Phase}, statCells(phase.Stats)...))
+		}
+
+		lipgloss.Println(newTable(append([]string{"Phase"}, statHeaders...), phaseRows))
+	}
+
+	if output.Stream != nil {
+		var streamRows [][]string
+		if output.Stream.FirstEvent != nil {
+			streamRows = append(streamRows, append([]string{"First Event"}, statCells(*output.Stream.FirstEvent)...))
+		}
+		if output.Stream.EventGap != nil {
+			streamRows = append(streamRows, append([]string{"Event Gap"}, statCells(*output.Stream.EventGap)...))
+		}
+		if len(streamRows) > 0 {
+			lipgloss.Println(newTable(append([]string{"Stream"}, statHeaders...), streamRows))
+		}
+	}
+
+	if len(output.Disconnects) > 0 {
+		disconnectRows := make([][]string, 0, len(output.Disconnects))
+		for _, reason := range slices.Sorted(maps.Keys(output.Disconnects)) {
+			disconnectRows = append(disconnectRows, []string{
+				wrapText(reason, DefaultResponseColumnMaxWidth),
+				strconv.FormatUint(output.Disconnects[reason], 10),
+			})
+		}
+
+		lipgloss.Println(newTable([]string{"Disconnect", "Count"}, disconnectRows))
+	}
+
+	if len(output.LocalAddrs) > 0 {
+		localRows := make([][]string, 0, len(output.LocalAddrs))
+		for _, local := range output.LocalAddrs {
+			localRows = append(localRows, []string{
+				local.Address,
+				strconv.FormatUint(local.Connections, 10),
+				strconv.FormatUint(local.Unavailable, 10),
+				strconv.FormatUint(local.PortsExhausted, 10),
+			})
+		}
+
+		lipgloss.Println(newTable([]string{"Local Address", "Connections", "Unavailable", "Ports Exhausted"}, localRows))
+	}
+
+	if len(output.Stages) > 0 {
+		stageRows := make([][]string, 0, len(output.Stages))
+		for _, stage := range output.Stages {
+			target := ""
+			switch {
+			case stage.Rate != nil:
+				target = strconv.FormatUint(uint64(*stage.Rate), 10) + " req/s"
+			case stage.Concurrency != nil:
+				target = strconv.FormatUint(uint64(*stage.Concurrency), 10) + " workers"
+			}
+			stageRows = append(stageRows, append([]string{strconv.Itoa(stage.Stage), target, stage.Duration.String()}, statCells(stage.Total)...))
+		}
+
+		lipgloss.Println(newTable(append([]string{"Stage", "Target", "Duration"}, statHeaders...), stageRows))
+	}
 
-	lipgloss.Println(tbl)
+	if output.Throughput != nil {
+		lipgloss.Println(
+			headerStyle.Render("Throughput:") + fmt.Sprintf(
+				"%s req/s over %s, sent %s (avg %s), received %s (avg %s)",
+				strconv.FormatFloat(output.Throughput.RPS, 'f', 2, 64), output.Throughput.Duration,
+				formatBytes(output.Throughput.BytesSent), formatBytes(output.Throughput.AverageRequestSize),
+				formatBytes(output.Throughput.BytesReceived), formatBytes(output.Throughput.AverageResponseSize),
+			),
+		)
+	}
+
+	if output.Stream != nil {
+		eventRate := ""
+		if output.Stream.EventsPerSecond > 0 {
+			eventRate = " (" + strconv.FormatFloat(output.Stream.EventsPerSecond, 'f', 2, 64) + " events/s)"
+		}
+		lipgloss.Println(
+			headerStyle.Render("Events:") + fmt.Sprintf(
+				"%d in %d streams%s, per stream min %d, avg %s, max %d",
+				output.Stream.Events, output.Stream.Streams, eventRate,
+				output.Stream.EventsPerStream.Min,
+				strconv.FormatFloat(output.Stream.EventsPerStream.Average, 'f', 2, 64),
+				output.Stream.EventsPerStream.Max,
+			),
+		)
+	}
+
+	if output.TLS != nil {
+		lipgloss.Println(
+			headerStyle.Render("TLS:") + fmt.Sprintf(
+				"%d handshakes, %d resumed (%s%%)",
+				output.TLS.Handshakes, output.TLS.Resumed,
+				strconv.FormatFloat(float64(output.TLS.Resumed)/float64(output.TLS.Handshakes)*100, 'f', 2, 64),
+			),
+		)
+	}
+
+	if output.Rate != nil {
+		target := "staged"
+		if output.Rate.Target > 0 {
+			target = strconv.FormatUint(uint64(output.Rate.Target), 10) + " req/s"
+		}
+		lipgloss.Println(
+			headerStyle.Render("Rate:") + fmt.Sprintf(
+				"target %s, scheduled %d, missed %d",
+				target, output.Rate.Scheduled, output.Rate.Missed,
+			),
+		)
+	}
+
+	if len(output.Thresholds) > 0 {
+		passStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("42"))
+		failStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("196"))
+
+		thresholdRows := make([][]string, 0, len(output.Thresholds))
+		for _, threshold := range output.Thresholds {
+			result := passStyle.Render("pass")
+			if !threshold.Passed {
+				result = failStyle.Render("FAIL")
+			}
+			thresholdRows = append(thresholdRows, []string{threshold.Threshold, threshold.Actual, result})
+		}
+
+		lipgloss.Println(newTable([]string{"Threshold", "Actual", "Result"}, thresholdRows))
+	}
+
+	if output.Aborted != "" {
+		abortStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("196"))
+		lipgloss.Println(abortStyle.Render("Aborted: ") + output.Aborted)
+	}
 }
 
 func (data *SarinResponseData) PrintJSON() {
@@ -185,147 +753,470 @@ func (data *SarinResponseData) PrintYAML() {
 	}
 }
 
-type responseStat struct {
-	Count   BigInt   `json:"count"   yaml:"count"`
-	Min     Duration `json:"min"     yaml:"min"`
-	Max     Duration `json:"max"     yaml:"max"`
-	Average Duration `json:"average" yaml:"average"`
-	P90     Duration `json:"p90"     yaml:"p90"`
-	P95     Duration `json:"p95"     yaml:"p95"`
-	P99     Duration `json:"p99"     yaml:"p99"`
+// collect merges what the shards recorded since the last collect into
+// Responses, its scenario and remote IP breakdowns and the disconnects, and,
+// while they are running, into the current stage and timeline window.
+// The caller must hold data's lock.
+func (data *SarinResponseData) collect() {
+	// A shard keeps the keys it has drained, so responses with nothing
+	// recorded since are skipped.
+	merge := func(into, from map[string]*Response) {
+		for key, response := range from {
+			if response.durations.total > 0 {
+				responseFor(into, key).merge(response)
+			}
+		}
+	}
+
+	for _, shard := range data.shards {
+		shard.drain(func(
+			responses map[string]*Response,
+			scenarioResponses map[string]map[string]*Response,
+			remoteIPResponses map[string]map[string]*Response,
+			disconnects map[string]uint64,
+		) {
+			merge(data.Responses, responses)
+			for reason, count := range disconnects {
+				data.disconnects[reason] += count
+			}
+			for name, responses := range scenarioResponses {
+				merge(groupFor(data.scenarios, name), responses)
+			}
+			for ip, responses := range remoteIPResponses {
+				merge(groupFor(data.remoteIPs, ip), responses)
+			}
+			if data.stageResponses != nil {
+				merge(data.stageResponses, responses)
+			}
+			if data.window != nil {
+				merge(data.window, responses)
+			}
+			if len(data.abortSteps) > 0 {
+				merge(data.abortSteps[len(data.abortSteps)-1], responses)
+			}
+		})
+	}
 }
 
-type responseStats map[string]responseStat
+// formatPercentile formats a percentile the way it appears in output keys,
+// e.g. 99.9 -> "99.9" and 50 -> "50".
+func formatPercentile(percentile float64) string {
+	return strconv.FormatFloat(percentile, 'f', -1, 64)
+}
 
-type outputData struct {
-	Responses map[string]responseStat `json:"responses" yaml:"responses"`
-	Total     responseStat            `json:"total"     yaml:"total"`
+// formatBytes formats a byte count with a binary unit, e.g. 1536 -> "1.5 KiB".
+func formatBytes(bytes uint64) string {
+	const unit = 1024
+	if bytes < unit {
+		return strconv.FormatUint(bytes, 10) + " B"
+	}
+
+	value := float64(bytes) / unit
+	prefix := 0
+	for value >= unit && prefix < len("KMGTPE")-1 {
+		value /= unit
+		prefix++
+	}
+	return strconv.FormatFloat(value, 'f', 1, 64) + " " + string("KMGTPE"[prefix]) + "iB"
 }
 
-func (data *SarinResponseData) prepareOutputData() outputData {
-	switch len(data.Responses) {
-	case 0:
-		return outputData{
-			Responses: make(map[string]responseStat),
-			Total:     responseStat{},
-		}
-	case 1:
-		var (
-			responseKey string
-			stats       responseStat
-		)
-		for key, response := range data.Responses {
-			stats = calculateStats(response.durations, data.accuracy)
-			responseKey = key
+type percentileStat struct {
+	Percentile float64
+	Value      Duration
+}
+
+// responseStat marshals its percentiles as keys next to the other fields
+// (e.g. "p99.9"), in ascending order, so it implements the marshalers by hand.
+type responseStat struct {
+	Count       BigInt
+	Min         Duration
+	Max         Duration
+	Average     Duration
+	Percentiles []percentileStat
+	// Corrected is the same response measured from the scheduled send time.
+	// It is set only for rate-scheduled runs.
+	Corrected *responseStat
+}
+
+type statField struct {
+	key   string
+	value any
+}
+
+func (stat responseStat) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range stat.fields() {
+		if i > 0 {
+			buf.WriteByte(',')
 		}
-		return outputData{
-			Responses: responseStats{
-				responseKey: stats,
-			},
-			Total: stats,
+		key, err := json.Marshal(field.key)
+		if err != nil {
+			return nil, err //nolint:wrapcheck
 		}
-	default:
-		// Calculate stats for each response
-		allStats := make(responseStats)
-		var totalDurations = make(map[time.Duration]uint64)
+		value, err := json.Marshal(field.value)
+		if err != nil {
+			return nil, err //nolint:wrapcheck
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
 
-		for key, response := range data.Responses {
-			stats := calculateStats(response.durations, data.accuracy)
-			allStats[key] = stats
+func (stat responseStat) MarshalYAML() (any, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for _, field := range stat.fields() {
+		valueNode := &yaml.Node{}
+		if err := valueNode.Encode(field.value); err != nil {
+			return nil, err //nolint:wrapcheck
+		}
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: field.key}, valueNode)
+	}
+	return node, nil
+}
 
-			// Aggregate for total row
-			for duration, count := range response.durations {
-				totalDurations[duration] += count
-			}
+func (stat responseStat) fields() []statField {
+	fields := make([]statField, 0, 5+len(stat.Percentiles))
+	fields = append(fields,
+		statField{"count", stat.Count},
+		statField{"min", stat.Min},
+		statField{"max", stat.Max},
+		statField{"average", stat.Average},
+	)
+	for _, percentile := range stat.Percentiles {
+		fields = append(fields, statField{"p" + formatPercentile(percentile.Percentile), percentile.Value})
+	}
+	if stat.Corrected != nil {
+		fields = append(fields, statField{"corrected", stat.Corrected})
+	}
+	return fields
+}
+
+type responseStats map[string]responseStat
+
+type phaseStat struct {
+	Phase string
+	Stats responseStat
+}
+
+// phaseStats marshals as a mapping from phase name to its stats, in the order
+// the phases happen.
+type phaseStats []phaseStat
+
+func (stats phaseStats) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, stat := range stats {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(strings.ToLower(stat.Phase))
+		if err != nil {
+			return nil, err //nolint:wrapcheck
 		}
+		value, err := json.Marshal(stat.Stats)
+		if err != nil {
+			return nil, err //nolint:wrapcheck
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
 
-		return outputData{
-			Responses: allStats,
-			Total:     calculateStats(totalDurations, data.accuracy),
+func (stats phaseStats) MarshalYAML() (any, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for _, stat := range stats {
+		valueNode := &yaml.Node{}
+		if err := valueNode.Encode(stat.Stats); err != nil {
+			return nil, err //nolint:wrapcheck
 		}
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: strings.ToLower(stat.Phase)}, valueNode)
 	}
+	return node, nil
+}
+
+type rateStat struct {
+	Target    uint   `json:"target,omitempty" yaml:"target,omitempty"`
+	Scheduled uint64 `json:"scheduled"        yaml:"scheduled"`
+	Missed    uint64 `json:"missed"           yaml:"missed"`
+}
+
+type throughputStat struct {
+	Duration            Duration `json:"duration"            yaml:"duration"`
+	RPS                 float64  `json:"rps"                 yaml:"rps"`
+	BytesSent           uint64   `json:"bytesSent"           yaml:"bytesSent"`
+	BytesReceived       uint64   `json:"bytesReceived"       yaml:"bytesReceived"`
+	AverageRequestSize  uint64   `json:"averageRequestSize"  yaml:"averageRequestSize"`
+	AverageResponseSize uint64   `json:"averageResponseSize" yaml:"averageResponseSize"`
+}
+
+type eventCountStat struct {
+	Min     uint64  `json:"min"     yaml:"min"`
+	Max     uint64  `json:"max"     yaml:"max"`
+	Average float64 `json:"average" yaml:"average"`
+}
+
+type streamStat struct {
+	Streams         uint64         `json:"streams"                   yaml:"streams"`
+	Events          uint64         `json:"events"                    yaml:"events"`
+	EventsPerSecond float64        `json:"eventsPerSecond,omitempty" yaml:"eventsPerSecond,omitempty"`
+	EventsPerStream eventCountStat `json:"eventsPerStream"           yaml:"eventsPerStream"`
+	FirstEvent      *responseStat  `json:"firstEvent,omitempty"      yaml:"firstEvent,omitempty"`
+	EventGap        *responseStat  `json:"eventGap,omitempty"        yaml:"eventGap,omitempty"`
+}
+
+type tlsStat struct {
+	Handshakes uint64 `json:"handshakes" yaml:"handshakes"`
+	Resumed    uint64 `json:"resumed"    yaml:"resumed"`
+}
+
+type localAddressStat struct {
+	Address        string `json:"address"        yaml:"address"`
+	Connections    uint64 `json:"connections"    yaml:"connections"`
+	Unavailable    uint64 `json:"unavailable"    yaml:"unavailable"`
+	PortsExhausted uint64 `json:"portsExhausted" yaml:"portsExhausted"`
 }
 
-func calculateStats(durations map[time.Duration]uint64, accuracy time.Duration) responseStat {
-	if len(durations) == 0 {
-		return responseStat{}
+type stageStat struct {
+	Stage       int                     `json:"stage"                 yaml:"stage"`
+	Rate        *uint                   `json:"rate,omitempty"        yaml:"rate,omitempty"`
+	Concurrency *uint                   `json:"concurrency,omitempty" yaml:"concurrency,omitempty"`
+	Duration    Duration                `json:"duration"              yaml:"duration"`
+	Responses   map[string]responseStat `json:"responses"             yaml:"responses"`
+	Total       responseStat            `json:"total"                 yaml:"total"`
+}
+
+type scenarioStat struct {
+	Name      string                  `json:"name"      yaml:"name"`
+	Responses map[string]responseStat `json:"responses" yaml:"responses"`
+	Total     responseStat            `json:"total"     yaml:"total"`
+}
+
+type outputData struct {
+	Responses   map[string]responseStat `json:"responses"                yaml:"responses"`
+	Total       responseStat            `json:"total"                    yaml:"total"`
+	Scenarios   []scenarioStat          `json:"scenarios,omitempty"      yaml:"scenarios,omitempty"`
+	Steps       []scenarioStat          `json:"steps,omitempty"          yaml:"steps,omitempty"`
+	RemoteIPs   []scenarioStat          `json:"remoteIPs,omitempty"      yaml:"remoteIPs,omitempty"`
+	Throughput  *throughputStat         `json:"throughput,omitempty"     yaml:"throughput,omitempty"`
+	Phases      phaseStats              `json:"phases,omitempty"         yaml:"phases,omitempty"`
+	Stream      *streamStat             `json:"stream,omitempty"         yaml:"stream,omitempty"`
+	TLS         *tlsStat                `json:"tls,omitempty"            yaml:"tls,omitempty"`
+	Disconnects map[string]uint64       `json:"disconnects,omitempty"    yaml:"disconnects,omitempty"`
+	LocalAddrs  []localAddressStat      `json:"localAddresses,omitempty" yaml:"localAddresses,omitempty"`
+	Rate        *rateStat               `json:"rate,omitempty"           yaml:"rate,omitempty"`
+	Stages      []stageStat             `json:"stages,omitempty"         yaml:"stages,omitempty"`
+	Timeline    []timelineWindow        `json:"timeline,omitempty"       yaml:"timeline,omitempty"`
+	Thresholds  []thresholdStat         `json:"thresholds,omitempty"     yaml:"thresholds,omitempty"`
+	Aborted     string                  `json:"aborted,omitempty"        yaml:"aborted,omitempty"`
+}
+
+func (data *SarinResponseData) prepareOutputData() outputData {
+	data.collect()
+	responses, total := data.prepareResponseStats(data.Responses)
+	output := outputData{
+		Responses:   responses,
+		Total:       total,
+		Throughput:  data.prepareThroughputStats(data.Responses),
+		Phases:      data.preparePhaseStats(data.Responses),
+		Stream:      data.prepareStreamStats(data.Responses),
+		TLS:         data.prepareTLSStats(data.Responses),
+		Disconnects: data.disconnects,
+		LocalAddrs:  data.localAddrs.stats(),
+		Rate:        data.rate,
+		Timeline:    data.timeline,
+		Thresholds:  data.evaluateThresholds(),
+		Aborted:     data.abortReason,
+	}
+
+	// Flow steps are listed in the order they run, and scenarios by name.
+	names := data.flowSteps
+	if names == nil {
+		names = slices.Sorted(maps.Keys(data.scenarios))
+	}
+	var scenarios []scenarioStat
+	for _, name := range names {
+		responses, ok := data.scenarios[name]
+		if !ok {
+			continue
+		}
+		scenarioResponses, scenarioTotal := data.prepareResponseStats(responses)
+		scenarios = append(scenarios, scenarioStat{
+			Name:      name,
+			Responses: scenarioResponses,
+			Total:     scenarioTotal,
+		})
+	}
+	if data.flowSteps != nil {
+		output.Steps = scenarios
+	} else {
+		output.Scenarios = scenarios
+	}
+
+	for _, ip := range slices.Sorted(maps.Keys(data.remoteIPs)) {
+		ipResponses, ipTotal := data.prepareResponseStats(data.remoteIPs[ip])
+		output.RemoteIPs = append(output.RemoteIPs, scenarioStat{
+			Name:      ip,
+			Responses: ipResponses,
+			Total:     ipTotal,
+		})
 	}
 
-	// Extract and sort unique durations
-	sortedDurations := make([]time.Duration, 0, len(durations))
-	for duration := range durations {
-		sortedDurations = append(sortedDurations, duration)
+	for _, stage := range data.stages {
+		stageResponses, stageTotal := data.prepareResponseStats(stage.responses)
+		output.Stages = append(output.Stages, stageStat{
+			Stage:       stage.index + 1,
+			Rate:        stage.rate,
+			Concurrency: stage.concurrency,
+			Duration:    Duration(stage.duration),
+			Responses:   stageResponses,
+			Total:       stageTotal,
+		})
 	}
-	slices.Sort(sortedDurations)
 
-	sum := new(big.Int)
-	totalCount := new(big.Int)
-	minDuration := sortedDurations[0] * accuracy
-	maxDuration := sortedDurations[len(sortedDurations)-1] * accuracy
+	return output
+}
 
-	for _, duration := range sortedDurations {
-		actualDuration := duration * accuracy
-		count := durations[duration]
+func (data *SarinResponseData) prepareResponseStats(responses map[string]*Response) (responseStats, responseStat) {
+	allStats := make(responseStats, len(responses))
+	total := &Response{}
 
-		totalCount.Add(
-			totalCount,
-			new(big.Int).SetUint64(count),
-		)
+	for key, response := range responses {
+		allStats[key] = data.calculateResponseStats(response)
+		// Aggregate for total row
+		total.merge(response)
+	}
 
-		sum.Add(
-			sum,
-			new(big.Int).Mul(
-				new(big.Int).SetInt64(int64(actualDuration)),
-				new(big.Int).SetUint64(count),
-			),
-		)
+	return allStats, data.calculateResponseStats(total)
+}
+
+// prepareThroughputStats calculates the request rate and transfer volume of
+// the run, or returns nil before its duration is known.
+func (data *SarinResponseData) prepareThroughputStats(responses map[string]*Response) *throughputStat {
+	if data.duration <= 0 {
+		return nil
 	}
 
-	// Calculate percentiles
-	p90 := calculatePercentile(sortedDurations, durations, totalCount, 90, accuracy)
-	p95 := calculatePercentile(sortedDurations, durations, totalCount, 95, accuracy)
-	p99 := calculatePercentile(sortedDurations, durations, totalCount, 99, accuracy)
+	total := &Response{}
+	for _, response := range responses {
+		total.merge(response)
+	}
 
-	return responseStat{
-		Count:   BigInt{totalCount},
-		Min:     Duration(minDuration),
-		Max:     Duration(maxDuration),
-		Average: Duration(div(sum, totalCount).Int64()),
-		P90:     p90,
-		P95:     p95,
-		P99:     p99,
+	stats := &throughputStat{
+		Duration:      Duration(data.duration),
+		RPS:           math.Round(float64(total.durations.total)/data.duration.Seconds()*100) / 100,
+		BytesSent:     total.requestBytes,
+		BytesReceived: total.responseBytes,
+	}
+	if total.requestsSent > 0 {
+		stats.AverageRequestSize = total.requestBytes / total.requestsSent
+	}
+	if total.responsesReceived > 0 {
+		stats.AverageResponseSize = total.responseBytes / total.responsesReceived
 	}
+	return stats
 }
 
-func calculatePercentile(sortedDurations []time.Duration, durations map[time.Duration]uint64, totalCount *big.Int, percentile int, accuracy time.Duration) Duration {
-	// Calculate the target position for the percentile
-	// Using ceiling method: position = ceil(totalCount * percentile / 100)
-	target := new(big.Int).Mul(totalCount, big.NewInt(int64(percentile)))
-	target.Add(target, big.NewInt(99)) // Add 99 to achieve ceiling division by 100
-	target.Div(target, big.NewInt(100))
-
-	// Accumulate counts until we reach the target position
-	cumulative := new(big.Int)
-	for _, duration := range sortedDurations {
-		count := durations[duration]
-		cumulative.Add(cumulative, new(big.Int).SetUint64(count))
+// preparePhaseStats calculates the stats of every phase that was measured in
+// any of the responses.
+func (data *SarinResponseData) preparePhaseStats(responses map[string]*Response) phaseStats {
+	var phases [requestPhaseCount]histogram
+	for _, response := range responses {
+		for phase := range phases {
+			phases[phase].merge(&response.phases[phase])
+		}
+	}
 
-		if cumulative.Cmp(target) >= 0 {
-			return Duration(duration * accuracy)
+	var stats phaseStats
+	for phase := range phases {
+		if phases[phase].total == 0 {
+			continue
 		}
+		stats = append(stats, phaseStat{
+			Phase: requestPhaseNames[phase],
+			Stats: data.calculateStats(&phases[phase]),
+		})
 	}
+	return stats
+}
 
-	// Fallback to the last duration (shouldn't happen with valid data)
-	return Duration(sortedDurations[len(sortedDurations)-1] * accuracy)
+// prepareStreamStats calculates the event stats of the responses that were
+// read as streams, or returns nil if there were none.
+func (data *SarinResponseData) prepareStreamStats(responses map[string]*Response) *streamStat {
+	total := &Response{}
+	for _, response := range responses {
+		total.merge(response)
+	}
+	if total.streams == 0 {
+		return nil
+	}
+
+	stats := &streamStat{
+		Streams: total.streams,
+		Events:  total.events,
+		EventsPerStream: eventCountStat{
+			Min:     total.minEvents,
+			Max:     total.maxEvents,
+			Average: math.Round(float64(total.events)/float64(total.streams)*100) / 100,
+		},
+	}
+	if data.duration > 0 {
+		stats.EventsPerSecond = math.Round(float64(total.events)/data.duration.Seconds()*100) / 100
+	}
+	if total.firstEvent.total > 0 {
+		stats.FirstEvent = new(data.calculateStats(&total.firstEvent))
+	}
+	if total.eventGaps.total > 0 {
+		stats.EventGap = new(data.calculateStats(&total.eventGaps))
+	}
+	return stats
 }
 
-// div performs division with rounding to the nearest integer.
-func div(x, y *big.Int) *big.Int {
-	quotient, remainder := new(big.Int).DivMod(x, y, new(big.Int))
-	if remainder.Mul(remainder, big.NewInt(2)).Cmp(y) >= 0 {
-		quotient.Add(quotient, big.NewInt(1))
+// prepareTLSStats counts the TLS handshakes of the run and how many of them
+// resumed a session, or returns nil if there were none.
+func (data *SarinResponseData) prepareTLSStats(responses map[string]*Response) *tlsStat {
+	stats := &tlsStat{}
+	for _, response := range responses {
+		stats.Handshakes += response.handshakes
+		stats.Resumed += response.resumedHandshakes
+	}
+	if stats.Handshakes == 0 {
+		return nil
+	}
+	return stats
+}
+
+// calculateResponseStats calculates the service time stats of response, along
+// with the corrected ones when it has any.
+func (data *SarinResponseData) calculateResponseStats(response *Response) responseStat {
+	stats := data.calculateStats(&response.durations)
+	if response.corrected.total > 0 {
+		stats.Corrected = new(data.calculateStats(&response.corrected))
+	}
+	return stats
+}
+
+func (data *SarinResponseData) calculateStats(durations *histogram) responseStat {
+	stats := responseStat{
+		Count:       BigInt{new(big.Int).SetUint64(durations.total)},
+		Min:         Duration(durations.min),
+		Max:         Duration(durations.max),
+		Average:     Duration(durations.mean()),
+		Percentiles: make([]percentileStat, len(data.percentiles)),
+	}
+	for i, percentile := range data.percentiles {
+		stats.Percentiles[i] = percentileStat{
+			Percentile: percentile,
+			Value:      Duration(durations.percentile(percentile)),
+		}
 	}
-	return quotient
+	return stats
 }
 
 // wrapText wraps a string to multiple lines if it exceeds maxWidth.
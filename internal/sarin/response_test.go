@@ -0,0 +1,141 @@
+package sarin
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCollectReusesShardHistograms(t *testing.T) {
+	t.Parallel()
+
+	data := NewSarinResponseData(nil, nil, false)
+	shard := data.NewShard()
+
+	shard.Add("200", time.Second, time.Time{}, time.Time{}, nil)
+	data.collect()
+	buckets := &shard.responses["200"].durations.counts[0]
+
+	data.StartStages()
+	shard.Add("200", 2*time.Second, time.Time{}, time.Time{}, nil)
+	shard.Add("500", time.Millisecond, time.Time{}, time.Time{}, nil)
+	data.collect()
+
+	if got := &shard.responses["200"].durations.counts[0]; got != buckets {
+		t.Error("collect replaced the buckets of the shard's histogram")
+	}
+	if got := data.Responses["200"].durations.total; got != 2 {
+		t.Errorf("got %d 200 responses, want 2", got)
+	}
+	if got := data.Responses["200"].durations.max; got != 2*time.Second {
+		t.Errorf("got a max of %v, want 2s", got)
+	}
+	if got := data.Responses["500"].durations.total; got != 1 {
+		t.Errorf("got %d 500 responses, want 1", got)
+	}
+
+	// Keys the shard recorded before aren't carried into the stage unless
+	// they were recorded again.
+	data.MarkStage(0, nil, nil)
+	shard.Add("500", time.Millisecond, time.Time{}, time.Time{}, nil)
+	data.collect()
+	if _, ok := data.stageResponses["200"]; ok {
+		t.Error("a response with nothing recorded was merged into the stage")
+	}
+	if got := data.stageResponses["500"].durations.total; got != 1 {
+		t.Errorf("got %d 500 responses in the stage, want 1", got)
+	}
+}
+
+func TestAddCorrectedTime(t *testing.T) {
+	t.Parallel()
+
+	scheduledAt := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name        string
+		serviceTime time.Duration
+		scheduledAt time.Time
+		end         time.Time
+		corrected   time.Duration
+		measured    bool
+	}{
+		// The corrected time runs to the end of the response, not to when
+		// it is recorded.
+		{"sent late", time.Second, scheduledAt, scheduledAt.Add(3 * time.Second), 3 * time.Second, true},
+		{"sent on time", time.Second, scheduledAt, scheduledAt.Add(time.Second), time.Second, true},
+		// It is never shorter than the service time, even if the clock
+		// stepped back.
+		{"sent early", time.Second, scheduledAt, scheduledAt.Add(time.Millisecond), time.Second, true},
+		{"not scheduled", time.Second, time.Time{}, scheduledAt, 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			data := NewSarinResponseData(nil, nil, false)
+			data.NewShard().Add("200", test.serviceTime, test.scheduledAt, test.end, nil)
+			data.collect()
+
+			response := data.Responses["200"]
+			if got := response.durations.max; got != test.serviceTime {
+				t.Errorf("got a service time of %v, want %v", got, test.serviceTime)
+			}
+			if measured := response.corrected.total > 0; measured != test.measured {
+				t.Fatalf("got a corrected time measured %v, want %v", measured, test.measured)
+			}
+			if got := response.corrected.max; got != test.corrected {
+				t.Errorf("got a corrected time of %v, want %v", got, test.corrected)
+			}
+		})
+	}
+}
+
+// benchmarkDuration spreads the recorded values over a few buckets, the way
+// response times are.
+func benchmarkDuration(i int) time.Duration {
+	return time.Duration(i%1000) * time.Microsecond
+}
+
+// mapResponseData is how responses used to be recorded: every worker counted
+// its response times in per-key buckets behind a lock they all shared.
+type mapResponseData struct {
+	sync.Mutex
+
+	responses map[string]map[time.Duration]uint64
+	// accuracy is the bucket width; 1 was the default.
+	accuracy time.Duration
+}
+
+func (data *mapResponseData) add(responseKey string, responseTime time.Duration) {
+	data.Lock()
+	defer data.Unlock()
+
+	durations, ok := data.responses[responseKey]
+	if !ok {
+		data.responses[responseKey] = map[time.Duration]uint64{responseTime / data.accuracy: 1}
+	} else {
+		durations[responseTime/data.accuracy]++
+	}
+}
+
+func BenchmarkAdd(b *testing.B) {
+	b.Run("shared map buckets", func(b *testing.B) {
+		data := &mapResponseData{responses: make(map[string]map[time.Duration]uint64), accuracy: 1}
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				data.add("200", benchmarkDuration(i))
+			}
+		})
+	})
+
+	b.Run("sharded histogram", func(b *testing.B) {
+		data := NewSarinResponseData(nil, nil, false)
+		b.RunParallel(func(pb *testing.PB) {
+			shard := data.NewShard()
+			for i := 0; pb.Next(); i++ {
+				shard.Add("200", benchmarkDuration(i), time.Time{}, time.Time{}, nil)
+			}
+		})
+	})
+}
@@ -8,6 +8,7 @@ import (
 	"log"
 	"net/url"
 	"os"
+	"regexp"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -179,30 +180,156 @@ func bodySnippet(body []byte, maxLen int) string {
 	return s
 }
 
+// RateOverflowPolicy decides what the rate-limited dispatcher does with a
+// scheduled request when every worker is still busy.
+type RateOverflowPolicy uint8
+
+const (
+	// RateOverflowDrop skips the request and counts it as missed.
+	RateOverflowDrop RateOverflowPolicy = iota
+	// RateOverflowQueue waits for the next free worker; the schedule then
+	// catches up, so late requests go out in a burst.
+	RateOverflowQueue
+	// RateOverflowSpawn starts an extra worker to take the request, so the pool
+	// grows until it can keep up with the rate. Once it has the most workers
+	// allowed, requests wait for a free worker as with RateOverflowQueue.
+	RateOverflowSpawn
+)
+
+// ParseRateOverflowPolicy maps a config value to a RateOverflowPolicy.
+// Unknown values fall back to RateOverflowDrop; config validation rejects them
+// before they get here.
+func ParseRateOverflowPolicy(policy string) RateOverflowPolicy {
+	switch policy {
+	case "queue":
+		return RateOverflowQueue
+	case "spawn":
+		return RateOverflowSpawn
+	default:
+		return RateOverflowDrop
+	}
+}
+
 type sarin struct {
-	workers        uint
-	requestURL     *url.URL
-	methods        []string
-	params         types.Params
-	headers        types.Headers
-	cookies        types.Cookies
-	bodies         []string
-	totalRequests  *uint64
-	totalDuration  *time.Duration
-	timeout        time.Duration
-	showProgress   bool
-	skipCertVerify bool
-	values         []string
-	collectStats   bool
-	dryRun         bool
-	logInfo        bool
-	logError       bool
-	logFile        string
-
-	hostClients []*fasthttp.HostClient
-	responses   *SarinResponseData
-	fileCache   *FileCache
-	scriptChain *script.Chain
+	workers          uint
+	scenarios        []requestScenario
+	flow             bool
+	cookieJar        bool
+	cookieJarReset   uint
+	totalRequests    *uint64
+	totalDuration    *time.Duration
+	rate             uint
+	rateOverflow     RateOverflowPolicy
+	maxWorkers       uint
+	stages           *loadProfile
+	sendInterval     time.Duration
+	timeout          time.Duration
+	showProgress     bool
+	values           []string
+	collectStats     bool
+	timelineInterval time.Duration
+	dryRun           bool
+	logInfo          bool
+	logError         bool
+	logFile          string
+
+	// webSocket is set when the requests are sent as WebSocket messages.
+	webSocket bool
+	wsMatch   *regexp.Regexp
+	// grpc is set when the requests call a gRPC method.
+	grpc *grpcMethod
+	// stream is set when response bodies are read as streams of events.
+	stream bool
+
+	// workerDialFuncs returns the dial functions of a new worker.
+	workerDialFuncs func() []dialFunc
+	tls             *clientTLS
+	h2Clients       *h2ClientPool
+	h3Clients       *h3ClientPool
+	responseChecker *responseChecker
+	abort           *abortPolicy
+	responses       *SarinResponseData
+	fileCache       *FileCache
+	scriptChain     *script.Chain
+}
+
+// Options are the settings of a load test, which the config package has
+// validated and filled in with defaults.
+type Options struct {
+	Methods []string
+	URL     *url.URL
+	Timeout time.Duration
+	// Workers is how many workers send requests, at least one.
+	Workers uint
+	// Requests and Duration end the run after that many requests or that
+	// long, whichever comes first. Nil doesn't limit the run.
+	Requests     *uint64
+	Duration     *time.Duration
+	Rate         *uint
+	RateOverflow RateOverflowPolicy
+	MaxWorkers   uint
+	Stages       types.Stages
+	SendInterval time.Duration
+	ShowProgress bool
+
+	SkipCertVerify  bool
+	TLSCAFiles      []string
+	TLSClientCerts  []types.TLSClientCert
+	TLSRotation     TLSRotation
+	TLSMinVersion   string
+	TLSMaxVersion   string
+	TLSCipherSuites []string
+	TLSCurves       []string
+	TLSServerName   string
+	TLSALPN         []string
+	TLSSessionReuse bool
+
+	Protocol      Protocol
+	H2MaxStreams  uint
+	H2Connections uint
+	WSMatch       *regexp.Regexp
+	// GRPCMethod is the gRPC method the requests call, as
+	// package.Service/Method. Empty sends HTTP requests.
+	GRPCMethod      string
+	GRPCProto       []string
+	GRPCImportPaths []string
+	Stream          bool
+	StreamHold      time.Duration
+
+	Params         types.Params
+	Headers        types.Headers
+	Cookies        types.Cookies
+	Bodies         []string
+	Scenarios      types.Scenarios
+	Flow           types.Flow
+	CookieJar      bool
+	CookieJarReset uint
+
+	Proxies        types.Proxies
+	Resolve        types.ResolveOverrides
+	DNSServer      string
+	DNSCache       bool
+	IPStrategy     IPStrategy
+	LocalAddresses []string
+	Values         []string
+
+	// CollectStats records the responses, which the output, the timeline,
+	// the thresholds and the abort conditions all need.
+	CollectStats     bool
+	Percentiles      []float64
+	RemoteIPStats    bool
+	TimelineInterval time.Duration
+	Thresholds       types.Thresholds
+	Assertions       *types.Assertions
+	AbortOn          types.Thresholds
+	AbortWindow      time.Duration
+	AbortErrors      uint
+
+	DryRun     bool
+	LogLevel   string
+	LogFile    string
+	LuaScripts []string
+	JSScripts  []string
 }
 
 // NewSarin creates a new sarin instance for load testing.
@@ -210,36 +337,16 @@ type sarin struct {
 //   - types.ProxyDialError
 //   - types.ErrScriptEmpty
 //   - types.ScriptLoadError
-func NewSarin(
-	ctx context.Context,
-	methods []string,
-	requestURL *url.URL,
-	timeout time.Duration,
-	workers uint,
-	totalRequests *uint64,
-	totalDuration *time.Duration,
-	showProgress bool,
-	skipCertVerify bool,
-	params types.Params,
-	headers types.Headers,
-	cookies types.Cookies,
-	bodies []string,
-	proxies types.Proxies,
-	values []string,
-	collectStats bool,
-	dryRun bool,
-	logLevel string,
-	logFile string,
-	luaScripts []string,
-	jsScripts []string,
-) (*sarin, error) {
-	if workers == 0 {
-		workers = 1
-	}
+//   - types.GRPCMethodResolveError
+//   - types.TLSLoadError
+//   - types.LocalAddressParseError
+func NewSarin(ctx context.Context, opts Options) (*sarin, error) {
+	workers := max(opts.Workers, 1)
+	totalDuration := opts.Duration
 
 	// Resolve which log levels are enabled once, up front.
 	var logInfo, logError bool
-	for _, level := range SplitLogLevels(logLevel) {
+	for _, level := range SplitLogLevels(opts.LogLevel) {
 		switch level {
 		case "info":
 			logInfo = true
@@ -248,50 +355,142 @@ func NewSarin(
 		}
 	}
 
-	hostClients, err := newHostClients(ctx, timeout, proxies, workers, requestURL, skipCertVerify)
+	var targetRate uint
+	if opts.Rate != nil {
+		targetRate = *opts.Rate
+	}
+
+	var profile *loadProfile
+	if len(opts.Stages) > 0 {
+		profile = newLoadProfile(opts.Stages)
+		// The run ends with the last stage unless the duration cuts it short.
+		if totalDuration == nil || *totalDuration == 0 || *totalDuration > profile.totalDuration() {
+			totalDuration = new(profile.totalDuration())
+		}
+		// Concurrency stages run enough workers for their peak and let the
+		// gate decide how many of them are active.
+		if !profile.byRate {
+			workers = max(profile.maxTarget(), 1)
+		}
+	}
+
+	proxiesRaw := make([]url.URL, len(opts.Proxies))
+	for i, proxy := range opts.Proxies {
+		proxiesRaw[i] = url.URL(proxy)
+	}
+	res := newResolver(opts.Resolve, opts.DNSServer, opts.DNSCache, opts.IPStrategy)
+	local, err := newLocalAddrs(opts.LocalAddresses)
 	if err != nil {
 		return nil, err
 	}
+	dialFuncs, err := newDialFuncs(ctx, opts.Timeout, proxiesRaw, res, local)
+	if err != nil {
+		return nil, err
+	}
+	// With the worker strategy, every worker dials through a resolver of its
+	// own, which pins it to its addresses.
+	workerDialFuncs := func() []dialFunc { return dialFuncs }
+	if opts.IPStrategy == IPStrategyWorker {
+		workerDialFuncs = func() []dialFunc {
+			// The same proxies were set up above, so this can't fail.
+			dials, _ := newDialFuncs(ctx, opts.Timeout, proxiesRaw, res.forWorker(), local)
+			return dials
+		}
+	}
 
 	// Load script sources
-	luaSources, err := script.LoadSources(ctx, luaScripts, script.EngineTypeLua)
+	luaSources, err := script.LoadSources(ctx, opts.LuaScripts, script.EngineTypeLua)
 	if err != nil {
 		return nil, err
 	}
 
-	jsSources, err := script.LoadSources(ctx, jsScripts, script.EngineTypeJavaScript)
+	jsSources, err := script.LoadSources(ctx, opts.JSScripts, script.EngineTypeJavaScript)
 	if err != nil {
 		return nil, err
 	}
 
 	scriptChain := script.NewChain(luaSources, jsSources)
 
+	fileCache := NewFileCache(time.Second * 10)
+	tlsSettings, err := newClientTLS(
+		fileCache, opts.SkipCertVerify, opts.TLSCAFiles, opts.TLSClientCerts, opts.TLSRotation,
+		opts.TLSMinVersion, opts.TLSMaxVersion, opts.TLSCipherSuites, opts.TLSCurves, opts.TLSServerName, opts.TLSALPN, opts.TLSSessionReuse,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Config validation doesn't let WebSocket and HTTP URLs be mixed, so the
+	// first scenario tells which of them the run uses.
+	requestScenarios := newRequestScenarios(
+		opts.Scenarios, opts.Flow, opts.Methods, opts.URL, opts.Params, opts.Headers, opts.Cookies, opts.Bodies,
+	)
+
+	h2Clients := newH2ClientPool(opts.Protocol, dialFuncs, opts.Timeout, tlsSettings, opts.H2MaxStreams, opts.H2Connections)
+
+	// The method is resolved up front, through server reflection on the host
+	// of the first scenario unless proto files are given. A dry run sends
+	// nothing, so it skips this.
+	var grpc *grpcMethod
+	if opts.GRPCMethod != "" && !opts.DryRun {
+		grpc, err = resolveGRPCMethod(
+			ctx, opts.GRPCMethod, opts.GRPCProto, opts.GRPCImportPaths,
+			requestScenarios[0].url, requestScenarios[0].headers, h2Clients, opts.Timeout,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// In stream mode, a request lasts until its stream ends or has been held
+	// open for the stream hold.
+	requestTimeout := opts.Timeout
+	if opts.Stream {
+		requestTimeout = opts.StreamHold
+	}
+
 	srn := &sarin{
-		workers:        workers,
-		requestURL:     requestURL,
-		methods:        methods,
-		params:         params,
-		headers:        headers,
-		cookies:        cookies,
-		bodies:         bodies,
-		totalRequests:  totalRequests,
-		totalDuration:  totalDuration,
-		timeout:        timeout,
-		showProgress:   showProgress,
-		skipCertVerify: skipCertVerify,
-		values:         values,
-		collectStats:   collectStats,
-		dryRun:         dryRun,
-		logInfo:        logInfo,
-		logError:       logError,
-		logFile:        logFile,
-		hostClients:    hostClients,
-		fileCache:      NewFileCache(time.Second * 10),
-		scriptChain:    scriptChain,
-	}
-
-	if collectStats {
-		srn.responses = NewSarinResponseData(uint32(100))
+		workers:          workers,
+		scenarios:        requestScenarios,
+		flow:             len(opts.Flow) > 0,
+		cookieJar:        opts.CookieJar,
+		cookieJarReset:   opts.CookieJarReset,
+		totalRequests:    opts.Requests,
+		totalDuration:    totalDuration,
+		rate:             targetRate,
+		rateOverflow:     opts.RateOverflow,
+		maxWorkers:       opts.MaxWorkers,
+		stages:           profile,
+		sendInterval:     opts.SendInterval,
+		timeout:          requestTimeout,
+		showProgress:     opts.ShowProgress,
+		values:           opts.Values,
+		collectStats:     opts.CollectStats,
+		timelineInterval: opts.TimelineInterval,
+		dryRun:           opts.DryRun,
+		logInfo:          logInfo,
+		logError:         logError,
+		logFile:          opts.LogFile,
+		webSocket:        isWebSocketScheme(requestScenarios[0].url.Scheme),
+		wsMatch:          opts.WSMatch,
+		grpc:             grpc,
+		stream:           opts.Stream,
+		workerDialFuncs:  workerDialFuncs,
+		tls:              tlsSettings,
+		h2Clients:        h2Clients,
+		h3Clients:        newH3ClientPool(opts.Protocol, requestTimeout, tlsSettings, res, local),
+		responseChecker:  newResponseChecker(opts.Assertions),
+		abort:            newAbortPolicy(opts.AbortOn, opts.AbortWindow, opts.AbortErrors),
+		fileCache:        fileCache,
+		scriptChain:      scriptChain,
+	}
+
+	if opts.CollectStats {
+		srn.responses = NewSarinResponseData(opts.Percentiles, opts.Thresholds, opts.RemoteIPStats)
+		srn.responses.localAddrs = local
+		for _, step := range opts.Flow {
+			srn.responses.flowSteps = append(srn.responses.flowSteps, step.Name)
+		}
 	}
 
 	return srn, nil
@@ -303,9 +502,17 @@ func (s sarin) GetResponses() *SarinResponseData {
 
 func (s sarin) Start(ctx context.Context, stopCtrl *StopController) {
 	jobsCtx, jobsCancel := context.WithCancel(ctx)
+	defer jobsCancel()
 
 	var workersWG sync.WaitGroup
-	jobsCh := make(chan struct{}, max(s.workers, 1))
+	// With a target rate the channel is unbuffered, so a successful send means
+	// an idle worker took the job right at its scheduled time.
+	var jobsCh chan job
+	if s.rateLimited() {
+		jobsCh = make(chan job)
+	} else {
+		jobsCh = make(chan job, max(s.workers, 1))
+	}
 
 	var counter atomic.Uint64
 
@@ -363,28 +570,121 @@ func (s sarin) Start(ctx context.Context, stopCtrl *StopController) {
 		sendLog, sendRespLog = s.newWriterLog(os.Stderr)
 	}
 
+	// Concurrency stages let workers take jobs only while the gate has room.
+	var gate *concurrencyGate
+	if s.stages != nil && !s.stages.byRate {
+		gate = newConcurrencyGate()
+	}
+
+	startWorker := func() {
+		jobs := channelJobs(jobsCh)
+		if gate != nil {
+			jobs = gate.jobs(jobsCh)
+		}
+		if s.sendInterval > 0 {
+			jobs = paceJobs(jobsCtx, jobs, s.sendInterval)
+		}
+		workersWG.Go(func() {
+			s.Worker(jobs, &counter, sendLog, sendRespLog)
+		})
+	}
+
+	var stopAbortWatch func()
+	if s.abort != nil {
+		stopAbortWatch = s.watchAbort(stopCtrl.Cancel)
+	}
+
+	runStart := time.Now()
+
 	// Start workers
-	s.startWorkers(&workersWG, jobsCh, s.hostClients, &counter, sendLog, sendRespLog)
+	for range max(s.workers, 1) {
+		startWorker()
+	}
+
+	stagesStart := time.Now()
+	var status func() string
+	if s.stages != nil {
+		status = func() string { return s.stages.status(time.Since(stagesStart)) }
+	}
 
 	if runTUI {
 		//nolint:contextcheck // streamCtx must remain active until all workers complete to ensure all collected data is streamed
-		go s.streamProgress(streamCtx, stopCtrl, streamCh, totalRequests, &counter, tuiLogChannel, showProgressBar)
+		go s.streamProgress(streamCtx, stopCtrl, streamCh, totalRequests, &counter, tuiLogChannel, showProgressBar, status)
 	}
 
 	// Setup duration-based cancellation
 	s.setupDurationTimeout(ctx, jobsCancel)
+
+	// Follow the stages in the background: adjust the gate and close each
+	// stage's stats as its end passes.
+	var stagesDone chan int
+	if s.stages != nil {
+		if s.collectStats {
+			s.responses.StartStages()
+		}
+		stagesDone = make(chan int, 1)
+		go func() { stagesDone <- s.trackStages(jobsCtx, s.stages, stagesStart, gate) }()
+		if gate != nil {
+			go func() {
+				<-jobsCtx.Done()
+				gate.close()
+			}()
+		}
+	}
+
+	var stopTimeline func()
+	if s.collectStats && s.timelineInterval > 0 {
+		stopTimeline = s.trackTimeline()
+	}
+
 	// Distribute jobs to workers.
 	// This blocks until all jobs are sent or the context is canceled.
-	s.sendJobs(jobsCtx, jobsCh)
+	if s.rateLimited() {
+		schedule := s.constantSchedule
+		if s.stages != nil {
+			schedule = s.stages.dueAt
+		}
+		scheduled, missed := s.sendJobsAtRate(jobsCtx, jobsCh, schedule, startWorker, &counter)
+		if s.collectStats {
+			s.responses.SetRateStats(s.rate, scheduled, missed)
+		}
+	} else {
+		s.sendJobs(jobsCtx, jobsCh)
+	}
 
 	// Close the jobs channel so workers stop after completing their current job
 	close(jobsCh)
 	// Wait until all workers stopped
 	workersWG.Wait()
+	// The workers share the HTTP/2 and HTTP/3 connections, so they are closed
+	// only now.
+	s.h2Clients.closeIdleConnections()
+	s.h3Clients.close()
+	if s.collectStats {
+		s.responses.SetDuration(time.Since(runStart))
+	}
 	if tuiLogChannel != nil {
 		close(tuiLogChannel)
 	}
 
+	if s.stages != nil {
+		jobsCancel()
+		// Whatever completed after the last boundary belongs to the stage
+		// that was running when the test ended.
+		if current := <-stagesDone; current < len(s.stages.stages) {
+			s.markStage(s.stages, current)
+		}
+	}
+	if stopTimeline != nil {
+		stopTimeline()
+	}
+	if stopAbortWatch != nil {
+		stopAbortWatch()
+		if s.collectStats {
+			s.responses.SetAbortReason(s.AbortReason())
+		}
+	}
+
 	if runTUI {
 		// Stop the progress streaming
 		streamCancel()
@@ -393,6 +693,12 @@ func (s sarin) Start(ctx context.Context, stopCtrl *StopController) {
 	}
 }
 
+// rateLimited reports whether jobs are dispatched on a schedule, either at a
+// constant rate or following rate stages.
+func (s sarin) rateLimited() bool {
+	return s.rate > 0 || (s.stages != nil && s.stages.byRate)
+}
+
 // newWriterLog builds the loggers that write formatted lines to w (a log file or
 // stderr). sendLog stays general (it filters by each log's level); sendRespLog
 // only ever emits info, so its decision is baked once into a no-op when off.
@@ -432,40 +738,6 @@ func (s sarin) newChannelLog(ch chan<- runtimeLog) (runtimeLogger, respLogger) {
 	return sendLog, sendRespLog
 }
 
-// newHostClients initializes HTTP clients for the given configuration.
-// It can return the following errors:
-// - types.ProxyDialError
-func newHostClients(
-	ctx context.Context,
-	timeout time.Duration,
-	proxies types.Proxies,
-	workers uint,
-	requestURL *url.URL,
-	skipCertVerify bool,
-) ([]*fasthttp.HostClient, error) {
-	proxiesRaw := make([]url.URL, len(proxies))
-	for i, proxy := range proxies {
-		proxiesRaw[i] = url.URL(proxy)
-	}
-
-	return NewHostClients(
-		ctx,
-		timeout,
-		proxiesRaw,
-		workers,
-		requestURL,
-		skipCertVerify,
-	)
-}
-
-func (s sarin) startWorkers(wg *sync.WaitGroup, jobs <-chan struct{}, hostClients []*fasthttp.HostClient, counter *atomic.Uint64, sendLog runtimeLogger, sendRespLog respLogger) {
-	for range max(s.workers, 1) {
-		wg.Go(func() {
-			s.Worker(jobs, NewHostClientGenerator(hostClients...), counter, sendLog, sendRespLog)
-		})
-	}
-}
-
 func (s sarin) setupDurationTimeout(ctx context.Context, cancel context.CancelFunc) {
 	if s.totalDuration != nil {
 		go func() {
@@ -481,17 +753,106 @@ func (s sarin) setupDurationTimeout(ctx context.Context, cancel context.CancelFu
 	}
 }
 
-func (s sarin) sendJobs(ctx context.Context, jobs chan<- struct{}) {
+// sendJobs hands jobs to the workers as fast as they take them, until
+// s.totalRequests are sent or ctx is done. A send also gives up when ctx is
+// done, since workers held back by a concurrency gate stop taking jobs then.
+func (s sarin) sendJobs(ctx context.Context, jobs chan<- job) {
 	if s.totalRequests != nil && *s.totalRequests > 0 {
 		for range *s.totalRequests {
-			if ctx.Err() != nil {
-				break
+			select {
+			case jobs <- job{}:
+			case <-ctx.Done():
+				return
 			}
-			jobs <- struct{}{}
 		}
 	} else {
-		for ctx.Err() == nil {
-			jobs <- struct{}{}
+		for {
+			select {
+			case jobs <- job{}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// constantSchedule places the n-th job at n/rate seconds after the start.
+func (s sarin) constantSchedule(n uint64) (time.Duration, bool) {
+	return time.Duration(float64(n) * float64(time.Second) / float64(s.rate)), true
+}
+
+// sendJobsAtRate dispatches jobs on a fixed schedule (an open model),
+// independent of how fast the target answers. schedule gives the offset from
+// the start at which the n-th job is due, and false once there are no more, so
+// a dispatcher that falls behind catches up instead of drifting. A job that
+// finds no idle worker at its scheduled time is counted as missed and handled
+// by s.rateOverflow. Spawning stops at s.maxWorkers workers, after which a
+// missed job waits for a free worker. Jobs that never reach a worker, dropped
+// or still waiting when ctx is done, advance counter so the progress bar
+// reaches its total.
+// It returns how many jobs were scheduled and how many of them were missed.
+func (s sarin) sendJobsAtRate(
+	ctx context.Context,
+	jobs chan<- job,
+	schedule func(n uint64) (time.Duration, bool),
+	spawnWorker func(),
+	counter *atomic.Uint64,
+) (uint64, uint64) {
+	var limit uint64
+	if s.totalRequests != nil {
+		limit = *s.totalRequests
+	}
+
+	start := time.Now()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	workers := max(s.workers, 1)
+	var scheduled, missed uint64
+	for limit == 0 || scheduled < limit {
+		offset, ok := schedule(scheduled)
+		if !ok {
+			return scheduled, missed
+		}
+		due := start.Add(offset)
+		if wait := time.Until(due); wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-ctx.Done():
+				return scheduled, missed
+			case <-timer.C:
+			}
+		} else if ctx.Err() != nil {
+			return scheduled, missed
+		}
+		scheduled++
+
+		select {
+		case jobs <- job{scheduledAt: due}:
+			continue
+		default:
+		}
+
+		missed++
+		switch s.rateOverflow {
+		case RateOverflowDrop:
+			counter.Add(1)
+			continue
+		case RateOverflowSpawn:
+			if workers < s.maxWorkers {
+				spawnWorker()
+				workers++
+			}
+		case RateOverflowQueue:
+		}
+
+		select {
+		case jobs <- job{scheduledAt: due}:
+		case <-ctx.Done():
+			counter.Add(1)
+			return scheduled, missed
 		}
 	}
+
+	return scheduled, missed
 }
@@ -0,0 +1,65 @@
+package sarin
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSendJobsAtRateOverflow(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		rateOverflow RateOverflowPolicy
+		maxWorkers   uint
+		// spawned is how many workers the dispatcher starts; each takes one
+		// job and then stays busy.
+		spawned   uint
+		scheduled uint64
+		// unsent counts the jobs that never reached a worker.
+		unsent uint64
+	}{
+		// The job waiting for a worker when the run ends still counts.
+		{"queue", RateOverflowQueue, 0, 0, 1, 1},
+		{"drop", RateOverflowDrop, 0, 0, 10, 10},
+		// Past the cap, a job waits as with queue.
+		{"spawn up to the cap", RateOverflowSpawn, 3, 2, 3, 1},
+		{"spawn without room", RateOverflowSpawn, 1, 0, 1, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+			defer cancel()
+
+			s := sarin{workers: 1, totalRequests: new(uint64(10)), rateOverflow: test.rateOverflow, maxWorkers: test.maxWorkers}
+			jobs := make(chan job)
+			var spawned uint
+			spawnWorker := func() {
+				spawned++
+				go func() {
+					<-jobs
+					<-ctx.Done()
+				}()
+			}
+			var counter atomic.Uint64
+			// Every job is due at the start, while no worker is free.
+			schedule := func(uint64) (time.Duration, bool) { return 0, true }
+
+			scheduled, missed := s.sendJobsAtRate(ctx, jobs, schedule, spawnWorker, &counter)
+			if spawned != test.spawned {
+				t.Errorf("got %d workers spawned, want %d", spawned, test.spawned)
+			}
+			if scheduled != test.scheduled || missed != test.scheduled {
+				t.Errorf("got %d jobs scheduled and %d missed, want %d of both", scheduled, missed, test.scheduled)
+			}
+			if got := counter.Load(); got != test.unsent {
+				t.Errorf("got %d jobs counted without a worker, want %d", got, test.unsent)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package sarin
+
+import (
+	"math/rand/v2"
+	"net/url"
+	"slices"
+
+	"github.com/valyala/fasthttp"
+	"go.aykhans.me/sarin/internal/script"
+	"go.aykhans.me/sarin/internal/types"
+)
+
+// requestScenario is a scenario or flow step with the top-level request
+// settings filled in for everything it leaves unset.
+type requestScenario struct {
+	// name is empty for the implicit scenario of a run without scenarios or a
+	// flow.
+	name    string
+	weight  uint
+	url     *url.URL
+	methods []string
+	params  types.Params
+	headers types.Headers
+	cookies types.Cookies
+	bodies  []string
+	// extract is set only for flow steps.
+	extract []types.Extractor
+}
+
+// newRequestScenarios fills in the scenarios, or the steps of the flow, from
+// the top-level request settings. With neither, the top-level settings are the
+// only scenario.
+func newRequestScenarios(
+	scenarios types.Scenarios,
+	flow types.Flow,
+	methods []string,
+	requestURL *url.URL,
+	params types.Params,
+	headers types.Headers,
+	cookies types.Cookies,
+	bodies []string,
+) []requestScenario {
+	topLevel := requestScenario{
+		weight:  1,
+		url:     requestURL,
+		methods: methods,
+		params:  params,
+		headers: headers,
+		cookies: cookies,
+		bodies:  bodies,
+	}
+
+	switch {
+	case len(flow) > 0:
+		steps := make([]requestScenario, len(flow))
+		for i, step := range flow {
+			steps[i] = topLevel.with(step.Request)
+			steps[i].name = step.Name
+			steps[i].extract = step.Extract
+		}
+		return steps
+	case len(scenarios) > 0:
+		requestScenarios := make([]requestScenario, len(scenarios))
+		for i, scenario := range scenarios {
+			requestScenarios[i] = topLevel.with(scenario.Request)
+			requestScenarios[i].name = scenario.Name
+			if scenario.Weight != nil {
+				requestScenarios[i].weight = *scenario.Weight
+			}
+		}
+		return requestScenarios
+	default:
+		return []requestScenario{topLevel}
+	}
+}
+
+// with returns the scenario with the fields that request sets applied. The
+// URL, methods and bodies of request replace the scenario's, while its params,
+// headers and cookies are added to them, replacing those with the same key.
+func (scenario requestScenario) with(request types.Request) requestScenario {
+	if request.URL != nil {
+		scenario.url = request.URL
+	}
+	if len(request.Methods) > 0 {
+		scenario.methods = request.Methods
+	}
+	if len(request.Bodies) > 0 {
+		scenario.bodies = request.Bodies
+	}
+	scenario.params = overrideKeyValues(scenario.params, request.Params)
+	scenario.headers = overrideKeyValues(scenario.headers, request.Headers)
+	scenario.cookies = overrideKeyValues(scenario.cookies, request.Cookies)
+	return scenario
+}
+
+// overrideKeyValues returns base without the keys that overrides sets,
+// followed by overrides.
+func overrideKeyValues[T ~struct {
+	Key   string
+	Value []string
+}](base, overrides []T) []T {
+	merged := make([]T, 0, len(base)+len(overrides))
+	for _, item := range base {
+		key := types.KeyValue[string, []string](item).Key
+		if !slices.ContainsFunc(overrides, func(override T) bool { return types.KeyValue[string, []string](override).Key == key }) {
+			merged = append(merged, item)
+		}
+	}
+	return append(merged, overrides...)
+}
+
+// newScenarioPicker returns a function that picks the index of a scenario at
+// random from source, in proportion to the scenarios' weights. The returned
+// function is NOT safe for concurrent use.
+func newScenarioPicker(scenarios []requestScenario, source rand.Source) func() int {
+	// cumulative[i] is the total weight of the scenarios up to and including i.
+	cumulative := make([]uint64, len(scenarios))
+	var total uint64
+	for i, scenario := range scenarios {
+		total += uint64(scenario.weight)
+		cumulative[i] = total
+	}
+
+	//nolint:gosec // G404: Using non-cryptographic rand for load testing, not security
+	localRand := rand.New(source)
+	return func() int {
+		index, _ := slices.BinarySearch(cumulative, localRand.Uint64N(total)+1)
+		return index
+	}
+}
+
+// newWorkerGenerators creates a worker's request and host client generators,
+// and returns its flow, if there is one, and the pool of host clients it opens
+// so the worker can close them. Every request is sent through the clients for
+// its own scheme and host, which the request generator may pick per request.
+// With several scenarios, every request is built from one picked at random by
+// weight, and with a flow from its next step. Stats follow the last pick. The
+// request generator is dynamic whenever it may pick a different scenario or
+// step, or sends the cookies of jar.
+func (s sarin) newWorkerGenerators(
+	stats *statsShard,
+	trace *requestTrace,
+	jar *cookieJar,
+	scriptTransformer *script.Transformer,
+) (RequestGenerator, HostClientGenerator, *workerFlow, *hostClientPool, bool) {
+	var (
+		flow      *workerFlow
+		extracted map[string]string
+	)
+	if s.flow {
+		flow = newWorkerFlow(s.scenarios)
+		extracted = flow.values
+	}
+
+	var (
+		requestGenerators = make([]RequestGenerator, len(s.scenarios))
+		isDynamic         = len(s.scenarios) > 1 || flow != nil || jar != nil
+	)
+	for i, scenario := range s.scenarios {
+		requestGenerator, isScenarioDynamic := NewRequestGenerator(
+			scenario.methods, scenario.url, scenario.params, scenario.headers, scenario.cookies, scenario.bodies,
+			s.values, extracted, jar, s.fileCache, scriptTransformer,
+		)
+		requestGenerators[i] = requestGenerator
+		isDynamic = isDynamic || isScenarioDynamic
+	}
+
+	var pickScenario func() int
+	switch {
+	case flow != nil:
+		pickScenario = flow.start
+	case len(s.scenarios) > 1:
+		pickScenario = newScenarioPicker(s.scenarios, NewDefaultRandSource())
+	default:
+		stats.setScenario(s.scenarios[0].name)
+	}
+
+	var (
+		pool    = newHostClientPool(s.workerDialFuncs(), s.timeout, s.tls, trace, stats, s.wsMatch, s.grpc, s.stream, s.h2Clients, s.h3Clients)
+		clients HostClientGenerator
+	)
+	requestGenerator := func(req *fasthttp.Request) error {
+		current := 0
+		if pickScenario != nil {
+			current = pickScenario()
+			stats.setScenario(s.scenarios[current].name)
+		}
+		// With a flow, an iteration is a pass through it.
+		if flow == nil || current == 0 {
+			jar.startIteration()
+		}
+		if err := requestGenerators[current](req); err != nil {
+			return err
+		}
+		clients = pool.forRequest(req)
+		return nil
+	}
+	hostClientGenerator := func() HostClient {
+		return clients()
+	}
+	return requestGenerator, hostClientGenerator, flow, pool, isDynamic
+}
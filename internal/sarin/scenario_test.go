@@ -0,0 +1,149 @@
+package sarin
+
+import (
+	"math"
+	"math/rand/v2"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"go.aykhans.me/sarin/internal/types"
+)
+
+func TestScenarioPicker(t *testing.T) {
+	t.Parallel()
+
+	const picks = 100_000
+
+	tests := []struct {
+		name    string
+		weights []uint
+	}{
+		{name: "Single", weights: []uint{3}},
+		{name: "Equal", weights: []uint{1, 1}},
+		{name: "Uneven", weights: []uint{1, 3}},
+		{name: "Zero weight", weights: []uint{5, 0, 5}},
+		{name: "Many", weights: []uint{1, 2, 7, 10, 80}},
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			scenarios := make([]requestScenario, len(test.weights))
+			var total uint
+			for j, weight := range test.weights {
+				scenarios[j].weight = weight
+				total += weight
+			}
+
+			pick := newScenarioPicker(scenarios, rand.NewPCG(uint64(i), 1))
+			counts := make([]int, len(scenarios))
+			for range picks {
+				counts[pick()]++
+			}
+
+			for j, weight := range test.weights {
+				want := float64(weight) / float64(total)
+				got := float64(counts[j]) / picks
+				switch {
+				case weight == 0 && counts[j] != 0:
+					t.Errorf("scenario %d has no weight but was picked %d times", j, counts[j])
+				case math.Abs(got-want) > 0.01:
+					t.Errorf("scenario %d got %.2f%% of the picks, want %.2f%%", j, got*100, want*100)
+				}
+			}
+		})
+	}
+}
+
+func TestScenarioPickerSeeded(t *testing.T) {
+	t.Parallel()
+
+	// The same seed picks the same scenarios.
+	scenarios := []requestScenario{{weight: 1}, {weight: 2}, {weight: 3}}
+	first := newScenarioPicker(scenarios, rand.NewPCG(7, 7))
+	second := newScenarioPicker(scenarios, rand.NewPCG(7, 7))
+	for i := range 1000 {
+		if a, b := first(), second(); a != b {
+			t.Fatalf("pick %d: got scenarios %d and %d from the same seed", i, a, b)
+		}
+	}
+}
+
+func TestWorkerScenarioStats(t *testing.T) {
+	t.Parallel()
+
+	requestURL, err := url.Parse("http://127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	scenarioURL := func(path string) *url.URL {
+		return requestURL.JoinPath(path)
+	}
+	srn, err := NewSarin(t.Context(), Options{
+		Methods: []string{"GET"},
+		URL:     requestURL,
+		Timeout: time.Second,
+		Workers: 1,
+		Scenarios: types.Scenarios{
+			{Name: "browse", Weight: new(uint(3)), Request: types.Request{URL: scenarioURL("/browse")}},
+			{Name: "buy", Weight: new(uint(1)), Request: types.Request{URL: scenarioURL("/buy")}},
+		},
+		CollectStats: true,
+		DryRun:       true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shard := srn.responses.NewShard()
+	requestGenerator, _, _, pool, isDynamic := srn.newWorkerGenerators(shard, &requestTrace{}, nil, nil)
+	defer pool.closeIdleConnections()
+	if !isDynamic {
+		t.Error("the requests of several scenarios aren't generated per request")
+	}
+
+	// Each scenario answers in a time of its own, so the stats of one can't
+	// end up in the other's unnoticed.
+	serviceTimes := map[string]time.Duration{"/browse": 10 * time.Millisecond, "/buy": 50 * time.Millisecond}
+	sent := make(map[string]uint64)
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	for range 400 {
+		req.Reset()
+		if err := requestGenerator(req); err != nil {
+			t.Fatal(err)
+		}
+		path := string(req.URI().Path())
+		sent[path]++
+		shard.Add("200", serviceTimes[path], time.Time{}, time.Time{}, nil)
+	}
+	if sent["/browse"] <= sent["/buy"] {
+		t.Errorf("got %d requests to browse and %d to buy, want more to browse", sent["/browse"], sent["/buy"])
+	}
+
+	data := srn.responses
+	data.Lock()
+	defer data.Unlock()
+	data.collect()
+
+	if got := data.Responses["200"].durations.total; got != 400 {
+		t.Errorf("got %d responses in total, want 400", got)
+	}
+	for name, path := range map[string]string{"browse": "/browse", "buy": "/buy"} {
+		response, ok := data.scenarios[name]["200"]
+		if !ok {
+			t.Errorf("got no stats for scenario %s", name)
+			continue
+		}
+		if got := response.durations.total; got != sent[path] {
+			t.Errorf("got %d responses for scenario %s, want %d", got, name, sent[path])
+		}
+		if response.durations.min != serviceTimes[path] || response.durations.max != serviceTimes[path] {
+			t.Errorf("got response times from %s to %s for scenario %s, want %s",
+				response.durations.min, response.durations.max, name, serviceTimes[path])
+		}
+	}
+}
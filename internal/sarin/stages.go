@@ -0,0 +1,249 @@
+package sarin
+
+import (
+	"context"
+	"iter"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.aykhans.me/sarin/internal/types"
+)
+
+// stageTrackInterval is how often the stage tracker re-evaluates the
+// concurrency target and checks for stage boundaries.
+const stageTrackInterval = time.Millisecond * 100
+
+// loadProfile turns a list of stages into targets over time. Each stage ramps
+// linearly from the previous stage's target (zero for the first stage) to its
+// own target, so a stage repeating the previous target holds it flat.
+type loadProfile struct {
+	stages types.Stages
+	byRate bool
+	// starts[i] is the offset at which stage i begins; starts[len(stages)] is
+	// the end of the profile.
+	starts []time.Duration
+	from   []float64
+	to     []float64
+	// counts[i] is how many requests a rate profile schedules before stage i.
+	counts []float64
+}
+
+func newLoadProfile(stages types.Stages) *loadProfile {
+	profile := &loadProfile{
+		stages: stages,
+		byRate: stages.ByRate(),
+		starts: make([]time.Duration, len(stages)+1),
+		from:   make([]float64, len(stages)),
+		to:     make([]float64, len(stages)),
+		counts: make([]float64, len(stages)+1),
+	}
+
+	var previous float64
+	for i, stage := range stages {
+		target := stage.Concurrency
+		if profile.byRate {
+			target = stage.Rate
+		}
+
+		profile.from[i] = previous
+		profile.to[i] = float64(*target)
+		profile.starts[i+1] = profile.starts[i] + stage.Duration
+		profile.counts[i+1] = profile.counts[i] + (profile.from[i]+profile.to[i])/2*stage.Duration.Seconds()
+		previous = profile.to[i]
+	}
+
+	return profile
+}
+
+func (p *loadProfile) totalDuration() time.Duration {
+	return p.starts[len(p.stages)]
+}
+
+// stageAt returns the index of the stage active at elapsed, or len(p.stages)
+// once the profile has finished.
+func (p *loadProfile) stageAt(elapsed time.Duration) int {
+	for i := range p.stages {
+		if elapsed < p.starts[i+1] {
+			return i
+		}
+	}
+	return len(p.stages)
+}
+
+// targetAt returns the interpolated rate or concurrency at elapsed.
+func (p *loadProfile) targetAt(elapsed time.Duration) float64 {
+	i := p.stageAt(elapsed)
+	if i == len(p.stages) {
+		return p.to[len(p.stages)-1]
+	}
+
+	progress := float64(elapsed-p.starts[i]) / float64(p.stages[i].Duration)
+	return p.from[i] + (p.to[i]-p.from[i])*progress
+}
+
+// maxTarget returns the highest target any stage reaches.
+func (p *loadProfile) maxTarget() uint {
+	var peak float64
+	for _, target := range p.to {
+		peak = max(peak, target)
+	}
+	return uint(peak)
+}
+
+// dueAt returns the offset at which the n-th request (counting from zero) of a
+// rate profile is due, and false when the profile ends before that. It inverts
+// the cumulative request count, which is quadratic in time within a ramp.
+func (p *loadProfile) dueAt(n uint64) (time.Duration, bool) {
+	count := float64(n)
+	for i, stage := range p.stages {
+		if count >= p.counts[i+1] {
+			continue
+		}
+
+		// Requests into this stage m = a*x + k*x², where x is seconds into the
+		// stage, a the starting rate and k half the rate's slope. The root is
+		// written as 2m / (a + sqrt(a² + 4km)) so it stays stable as k nears zero.
+		m := count - p.counts[i]
+		if m <= 0 {
+			return p.starts[i], true
+		}
+		a := p.from[i]
+		k := (p.to[i] - a) / (2 * stage.Duration.Seconds())
+		x := 2 * m / (a + math.Sqrt(max(a*a+4*k*m, 0)))
+
+		return p.starts[i] + min(time.Duration(x*float64(time.Second)), stage.Duration), true
+	}
+	return 0, false
+}
+
+// status renders the active stage and its current target for the progress view.
+func (p *loadProfile) status(elapsed time.Duration) string {
+	i := p.stageAt(elapsed)
+	if i == len(p.stages) {
+		return "stages done"
+	}
+
+	target := strconv.FormatFloat(p.targetAt(elapsed), 'f', 0, 64)
+	if p.byRate {
+		target += " req/s"
+	} else {
+		target += " workers"
+	}
+	return "stage " + strconv.Itoa(i+1) + "/" + strconv.Itoa(len(p.stages)) + " · " + target
+}
+
+// concurrencyGate caps how many workers may run a request at the same time.
+// The limit can change while workers are running; lowering it lets in-flight
+// requests finish and holds workers back until enough of them are done.
+type concurrencyGate struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	limit    uint
+	inflight uint
+	closed   bool
+}
+
+func newConcurrencyGate() *concurrencyGate {
+	gate := &concurrencyGate{}
+	gate.cond = sync.NewCond(&gate.mu)
+	return gate
+}
+
+func (g *concurrencyGate) setLimit(limit uint) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if limit > g.limit {
+		g.cond.Broadcast()
+	}
+	g.limit = limit
+}
+
+// close releases every waiting worker; acquire fails from then on.
+func (g *concurrencyGate) close() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.closed = true
+	g.cond.Broadcast()
+}
+
+func (g *concurrencyGate) acquire() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for g.inflight >= g.limit && !g.closed {
+		g.cond.Wait()
+	}
+	if g.closed {
+		return false
+	}
+	g.inflight++
+	return true
+}
+
+func (g *concurrencyGate) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.inflight--
+	g.cond.Signal()
+}
+
+// jobs wraps source so that every job is taken only while the gate has room,
+// and holds the slot until the worker finishes the job.
+func (g *concurrencyGate) jobs(source <-chan job) iter.Seq[job] {
+	return func(yield func(job) bool) {
+		for g.acquire() {
+			j, ok := <-source
+			if !ok {
+				g.release()
+				return
+			}
+			cont := yield(j)
+			g.release()
+			if !cont {
+				return
+			}
+		}
+	}
+}
+
+// trackStages follows the profile from start until ctx is done: it keeps the
+// gate (if any) at the interpolated concurrency and closes each stage's stats
+// as its end passes. It returns the index of the stage that was active when it
+// stopped, so the caller can close that last one itself.
+func (s sarin) trackStages(ctx context.Context, profile *loadProfile, start time.Time, gate *concurrencyGate) int {
+	ticker := time.NewTicker(stageTrackInterval)
+	defer ticker.Stop()
+
+	current := 0
+	for {
+		elapsed := time.Since(start)
+		if gate != nil {
+			gate.setLimit(uint(math.Round(profile.targetAt(elapsed))))
+		}
+		for active := profile.stageAt(elapsed); current < active; current++ {
+			s.markStage(profile, current)
+		}
+		if current == len(profile.stages) {
+			return current
+		}
+
+		select {
+		case <-ctx.Done():
+			return current
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s sarin) markStage(profile *loadProfile, index int) {
+	if !s.collectStats {
+		return
+	}
+	stage := profile.stages[index]
+	s.responses.MarkStage(index, stage.Rate, stage.Concurrency)
+}
@@ -0,0 +1,211 @@
+package sarin
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"go.aykhans.me/sarin/internal/types"
+)
+
+// newDryRunSarin returns a sarin that dry-runs GET requests to a local URL
+// through the given stages, collecting stats.
+func newDryRunSarin(t *testing.T, stages types.Stages) *sarin {
+	t.Helper()
+
+	requestURL, err := url.Parse("http://127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srn, err := NewSarin(t.Context(), Options{
+		Methods:      []string{"GET"},
+		URL:          requestURL,
+		Timeout:      time.Second,
+		Workers:      1,
+		Stages:       stages,
+		CollectStats: true,
+		DryRun:       true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srn
+}
+
+func TestStartConcurrencyStagesCompletes(t *testing.T) {
+	t.Parallel()
+
+	srn := newDryRunSarin(t, types.Stages{
+		{Duration: 200 * time.Millisecond, Concurrency: new(uint(2))},
+		{Duration: 200 * time.Millisecond, Concurrency: new(uint(2))},
+		{Duration: 200 * time.Millisecond, Concurrency: new(uint(0))},
+	})
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srn.Start(ctx, NewStopController(cancel))
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("run didn't finish after its stages ended")
+	}
+
+	responses := srn.GetResponses()
+	responses.Lock()
+	defer responses.Unlock()
+
+	responses.collect()
+	if got := len(responses.stages); got != 3 {
+		t.Errorf("got %d stages, want 3", got)
+	}
+	if responses.Responses[dryRunResponseKey] == nil {
+		t.Error("no dry-run responses were recorded")
+	}
+}
+
+func TestNewLoadProfile(t *testing.T) {
+	t.Parallel()
+
+	profile := newLoadProfile(types.Stages{
+		{Duration: 2 * time.Second, Rate: new(uint(10))},
+		{Duration: 2 * time.Second, Rate: new(uint(10))},
+		{Duration: 2 * time.Second, Rate: new(uint(0))},
+	})
+
+	if !profile.byRate {
+		t.Error("rate stages aren't by rate")
+	}
+	if got, want := profile.totalDuration(), 6*time.Second; got != want {
+		t.Errorf("total duration: got %v, want %v", got, want)
+	}
+	if got, want := profile.maxTarget(), uint(10); got != want {
+		t.Errorf("max target: got %d, want %d", got, want)
+	}
+	for i, want := range []float64{0, 10, 30, 40} {
+		if got := profile.counts[i]; got != want {
+			t.Errorf("requests before stage %d: got %v, want %v", i, got, want)
+		}
+	}
+
+	tests := []struct {
+		elapsed time.Duration
+		stage   int
+		target  float64
+	}{
+		{0, 0, 0},
+		{time.Second, 0, 5},
+		{3 * time.Second, 1, 10},
+		{5 * time.Second, 2, 5},
+		{6 * time.Second, 3, 0},
+	}
+	for _, test := range tests {
+		if got := profile.stageAt(test.elapsed); got != test.stage {
+			t.Errorf("stage at %v: got %d, want %d", test.elapsed, got, test.stage)
+		}
+		if got := profile.targetAt(test.elapsed); got != test.target {
+			t.Errorf("target at %v: got %v, want %v", test.elapsed, got, test.target)
+		}
+	}
+}
+
+func TestLoadProfileDueAt(t *testing.T) {
+	t.Parallel()
+
+	// A second of ramping up to 10 req/s, one holding it, one ramping back
+	// down and one without requests. The ramps schedule 5 requests each and
+	// the flat stage 10.
+	rampUpFlatRampDown := types.Stages{
+		{Duration: time.Second, Rate: new(uint(10))},
+		{Duration: time.Second, Rate: new(uint(10))},
+		{Duration: time.Second, Rate: new(uint(0))},
+		{Duration: time.Second, Rate: new(uint(0))},
+	}
+
+	tests := []struct {
+		name   string
+		stages types.Stages
+		n      uint64
+		due    time.Duration
+		ok     bool
+	}{
+		// Ramping up, n requests are due at sqrt(n/5) seconds.
+		{"ramp-up start", rampUpFlatRampDown, 0, 0, true},
+		{"ramp-up first", rampUpFlatRampDown, 1, 447213595 * time.Nanosecond, true},
+		{"ramp-up last", rampUpFlatRampDown, 4, 894427191 * time.Nanosecond, true},
+		// Holding 10 req/s, they are due every 100ms.
+		{"flat start", rampUpFlatRampDown, 5, time.Second, true},
+		{"flat middle", rampUpFlatRampDown, 10, 1500 * time.Millisecond, true},
+		{"flat last", rampUpFlatRampDown, 14, 1900 * time.Millisecond, true},
+		// Ramping down, n requests into the stage are due at
+		// 1 - sqrt(1 - n/5) seconds into it.
+		{"ramp-down start", rampUpFlatRampDown, 15, 2 * time.Second, true},
+		{"ramp-down first", rampUpFlatRampDown, 16, 2105572809 * time.Nanosecond, true},
+		{"ramp-down last", rampUpFlatRampDown, 19, 2552786404 * time.Nanosecond, true},
+		// Nothing is due once the rate has dropped to zero.
+		{"zero rate", rampUpFlatRampDown, 20, 0, false},
+		{
+			"after a zero-rate stage",
+			types.Stages{
+				{Duration: time.Second, Rate: new(uint(0))},
+				{Duration: time.Second, Rate: new(uint(10))},
+			},
+			0, time.Second, true,
+		},
+		{
+			"only zero-rate stages",
+			types.Stages{{Duration: time.Second, Rate: new(uint(0))}},
+			0, 0, false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			due, ok := newLoadProfile(test.stages).dueAt(test.n)
+			if ok != test.ok {
+				t.Fatalf("got ok %v, want %v", ok, test.ok)
+			}
+			if diff := due - test.due; diff < -time.Microsecond || diff > time.Microsecond {
+				t.Errorf("got %v, want %v", due, test.due)
+			}
+		})
+	}
+}
+
+func TestLoadProfileDueAtIsMonotonic(t *testing.T) {
+	t.Parallel()
+
+	profile := newLoadProfile(types.Stages{
+		{Duration: 3 * time.Second, Rate: new(uint(70))},
+		{Duration: 2 * time.Second, Rate: new(uint(70))},
+		{Duration: 3 * time.Second, Rate: new(uint(20))},
+		{Duration: time.Second, Rate: new(uint(0))},
+	})
+
+	var previous time.Duration
+	var n uint64
+	for ; ; n++ {
+		due, ok := profile.dueAt(n)
+		if !ok {
+			break
+		}
+		if due < previous {
+			t.Fatalf("request %d is due at %v, before request %d at %v", n, due, n-1, previous)
+		}
+		if due > profile.totalDuration() {
+			t.Fatalf("request %d is due at %v, after the profile ends", n, due)
+		}
+		previous = due
+	}
+	if want := uint64(profile.counts[len(profile.stages)]); n != want {
+		t.Errorf("got %d requests, want %d", n, want)
+	}
+}
@@ -53,3 +53,14 @@ func (s *StopController) Stop() {
 		os.Exit(forceExitCode)
 	}
 }
+
+// Cancel starts the graceful shutdown, like a first Stop call, unless a
+// shutdown is already under way. It never forces an exit, and reports whether
+// it started the shutdown.
+func (s *StopController) Cancel() bool {
+	if !s.count.CompareAndSwap(0, 1) {
+		return false
+	}
+	s.cancel()
+	return true
+}
@@ -0,0 +1,212 @@
+package sarin
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// sseFieldLength is the length of the longest line prefix the SSE parser has
+// to look at, "data:".
+const sseFieldLength = len("data:")
+
+// streamEvents follows the events of the response a worker is currently
+// reading as a stream. In a text/event-stream response, an event is a
+// server-sent event, which is dispatched by a blank line after at least one
+// data line. In any other response, such as NDJSON, every non-empty line is an
+// event. Every worker in stream mode owns one, kept in its trace, so no
+// synchronisation is needed. A nil streamEvents records nothing.
+type streamEvents struct {
+	count   uint64
+	firstAt time.Time
+	lastAt  time.Time
+	// gaps holds the time between consecutive events.
+	gaps histogram
+
+	// sse is set while reading a text/event-stream response, and data once
+	// the server-sent event being read has a data line. line holds the start
+	// of the line being read and lineLength its full length. afterCR is set
+	// after a carriage return, so that a CRLF pair ends a single line.
+	sse        bool
+	data       bool
+	line       [sseFieldLength]byte
+	lineLength int
+	afterCR    bool
+}
+
+// reset prepares events for the next response, keeping the buckets of its
+// histogram.
+func (events *streamEvents) reset() {
+	if events == nil {
+		return
+	}
+	events.count = 0
+	events.firstAt = time.Time{}
+	events.lastAt = time.Time{}
+	events.gaps.reset()
+	events.start(false)
+}
+
+// start prepares the parser for a response body, which is read as a
+// text/event-stream if sse is set.
+func (events *streamEvents) start(sse bool) {
+	if events == nil {
+		return
+	}
+	events.sse = sse
+	events.data = false
+	events.lineLength = 0
+	events.afterCR = false
+}
+
+// Write parses the next part of the body and records the events it
+// completes. It never fails, so it can sit behind an io.MultiWriter.
+func (events *streamEvents) Write(p []byte) (int, error) {
+	if events == nil {
+		return len(p), nil
+	}
+
+	now := time.Now()
+	for _, b := range p {
+		switch {
+		case b == '\n' && events.afterCR:
+			events.afterCR = false
+		case b == '\n' || b == '\r':
+			events.endLine(now)
+			events.afterCR = b == '\r'
+		default:
+			events.afterCR = false
+			if events.lineLength < sseFieldLength {
+				events.line[events.lineLength] = b
+			}
+			events.lineLength++
+		}
+	}
+	return len(p), nil
+}
+
+// endLine handles the end of the line being read.
+func (events *streamEvents) endLine(now time.Time) {
+	length := events.lineLength
+	events.lineLength = 0
+
+	switch {
+	case !events.sse:
+		if length > 0 {
+			events.record(now)
+		}
+	case length == 0:
+		if events.data {
+			events.data = false
+			events.record(now)
+		}
+	case length == len("data") && string(events.line[:length]) == "data",
+		length >= sseFieldLength && string(events.line[:]) == "data:":
+		events.data = true
+	}
+}
+
+// finish handles the end of the body. A last line without a line break still
+// counts as an event, but an SSE event without the blank line that dispatches
+// it does not.
+func (events *streamEvents) finish() {
+	if events == nil {
+		return
+	}
+	if !events.sse && events.lineLength > 0 {
+		events.record(time.Now())
+	}
+	events.lineLength = 0
+}
+
+func (events *streamEvents) record(now time.Time) {
+	if events.count == 0 {
+		events.firstAt = now
+	} else {
+		events.gaps.record(now.Sub(events.lastAt))
+	}
+	events.lastAt = now
+	events.count++
+}
+
+// isEventStream reports whether contentType is text/event-stream.
+func isEventStream(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "text/event-stream"
+}
+
+// readStream copies body to w as it arrives, recording its events, until the
+// body ends or deadline passes. Reaching the deadline is how a stream that is
+// held open normally ends, so a read that fails at or after it is not an
+// error. It reports whether body was read to its end.
+func readStream(body io.Reader, w io.Writer, deadline time.Time, events *streamEvents) (bool, error) {
+	if events != nil {
+		w = io.MultiWriter(w, events)
+	}
+	if _, err := io.Copy(w, body); err != nil {
+		if !time.Now().Before(deadline) {
+			return false, nil
+		}
+		return false, err //nolint:wrapcheck
+	}
+	events.finish()
+	return true, nil
+}
+
+// streamHostClient sends requests with a fasthttp.HostClient that streams
+// response bodies, and reads each body as a stream of events until the server
+// ends it or the timeout, which is the stream hold, passes. A connection whose
+// stream was cut short is closed rather than reused, since the rest of the
+// body is still on it. It is NOT safe for concurrent use.
+type streamHostClient struct {
+	client *fasthttp.HostClient
+	trace  *requestTrace
+	body   bytes.Buffer
+}
+
+func (client *streamHostClient) DoTimeout(req *fasthttp.Request, resp *fasthttp.Response, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	if err := client.client.DoTimeout(req, resp, timeout); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	events := client.trace.streamEvents()
+	events.start(isEventStream(string(resp.Header.ContentType())))
+
+	// A response without a body, such as one to a HEAD request, has no body
+	// stream.
+	bodyStream := resp.BodyStream()
+	if bodyStream == nil {
+		return nil
+	}
+
+	client.body.Reset()
+	ended, err := readStream(bodyStream, &client.body, deadline, events)
+	if !ended {
+		if closer, ok := bodyStream.(fasthttp.ReadCloserWithError); ok {
+			closer.CloseWithError(context.DeadlineExceeded) //nolint:errcheck
+		}
+	}
+	resp.CloseBodyStream() //nolint:errcheck
+	resp.SetBody(client.body.Bytes())
+	return err
+}
+
+func (client *streamHostClient) CloseIdleConnections() {
+	client.client.CloseIdleConnections()
+}
+
+// copyHTTPStream reads httpResp into resp like copyHTTPResponse, but reads its
+// body as a stream of events until the server ends it or deadline passes.
+func copyHTTPStream(httpResp *http.Response, resp *fasthttp.Response, deadline time.Time, events *streamEvents) error {
+	copyHTTPHeader(httpResp, resp)
+	events.start(isEventStream(httpResp.Header.Get(fasthttp.HeaderContentType)))
+	_, err := readStream(httpResp.Body, resp.BodyWriter(), deadline, events)
+	resp.Header.SetContentLength(len(resp.Body()))
+	return err
+}
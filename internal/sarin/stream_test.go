@@ -0,0 +1,355 @@
+package sarin
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestStreamEvents(t *testing.T) {
+	t.Parallel()
+
+	// Each chunk is a separate Write, so the cases also cover lines and line
+	// breaks that are split between reads.
+	tests := []struct {
+		name   string
+		sse    bool
+		chunks []string
+		want   uint64
+	}{
+		{name: "Lines", chunks: []string{"{\"a\":1}\n{\"a\":2}\n"}, want: 2},
+		{name: "Empty lines", chunks: []string{"\n\none\n\n\ntwo\n\n"}, want: 2},
+		{name: "CRLF", chunks: []string{"one\r\ntwo\r\n\r\n"}, want: 2},
+		{name: "CR", chunks: []string{"one\rtwo\r"}, want: 2},
+		{name: "CRLF split between reads", chunks: []string{"one\r", "\ntwo\r", "\n"}, want: 2},
+		{name: "Line split between reads", chunks: []string{"{\"a\":", "1}\n{\"a\"", ":2}\n"}, want: 2},
+		{name: "Last line without a line break", chunks: []string{"one\ntwo"}, want: 2},
+		{name: "Empty body", want: 0},
+		{name: "SSE", sse: true, chunks: []string{"data: one\n\ndata: two\n\n"}, want: 2},
+		{name: "SSE multi-line data", sse: true, chunks: []string{"data: one\ndata: two\ndata: three\n\n"}, want: 1},
+		{name: "SSE fields around the data", sse: true, chunks: []string{"event: update\nid: 1\ndata: one\nretry: 10\n\n"}, want: 1},
+		{name: "SSE data without a space", sse: true, chunks: []string{"data:one\n\n"}, want: 1},
+		{name: "SSE data without a value", sse: true, chunks: []string{"data\n\ndata:\n\n"}, want: 2},
+		{name: "SSE CRLF", sse: true, chunks: []string{"data: one\r\ndata: two\r\n\r\ndata: three\r\n\r\n"}, want: 2},
+		{name: "SSE CR", sse: true, chunks: []string{"data: one\r\rdata: two\r\r"}, want: 2},
+		{name: "SSE field split between reads", sse: true, chunks: []string{"da", "ta", ": one\n", "\n"}, want: 1},
+		{name: "SSE event without data", sse: true, chunks: []string{"event: ping\nid: 1\n\n"}, want: 0},
+		{name: "SSE comments", sse: true, chunks: []string{": keep-alive\n\n: data: no\n\n"}, want: 0},
+		{name: "SSE field that starts with data", sse: true, chunks: []string{"database: one\n\ndata-x: two\n\n"}, want: 0},
+		{name: "SSE blank lines between events", sse: true, chunks: []string{"\n\ndata: one\n\n\n\ndata: two\n\n"}, want: 2},
+		{name: "SSE event that isn't dispatched", sse: true, chunks: []string{"data: one\n\ndata: two\n"}, want: 1},
+		{name: "SSE last line without a line break", sse: true, chunks: []string{"data: one\n\ndata: two"}, want: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			events := &streamEvents{}
+			events.start(test.sse)
+			for _, chunk := range test.chunks {
+				if n, err := events.Write([]byte(chunk)); n != len(chunk) || err != nil {
+					t.Fatalf("got Write %d, %v, want %d, nil", n, err, len(chunk))
+				}
+			}
+			events.finish()
+			if events.count != test.want {
+				t.Errorf("got %d events, want %d", events.count, test.want)
+			}
+			if got := events.gaps.total; events.count > 0 && got != events.count-1 {
+				t.Errorf("got %d gaps between %d events, want %d", got, events.count, events.count-1)
+			}
+		})
+	}
+}
+
+func TestStreamEventsTiming(t *testing.T) {
+	t.Parallel()
+
+	const interval = 20 * time.Millisecond
+
+	events := &streamEvents{}
+	events.start(true)
+
+	// An event is timed when the line that dispatches it arrives, not when
+	// its data does.
+	events.Write([]byte("data: one\n")) //nolint:errcheck
+	time.Sleep(interval)
+	before := time.Now()
+	events.Write([]byte("\ndata: two\n\n")) //nolint:errcheck
+	time.Sleep(interval)
+	events.Write([]byte("data: three\n\n")) //nolint:errcheck
+	after := time.Now()
+
+	if events.count != 3 {
+		t.Fatalf("got %d events, want 3", events.count)
+	}
+	if events.firstAt.Before(before) || events.lastAt.After(after) {
+		t.Errorf("got events from %s to %s, want them between %s and %s",
+			events.firstAt.Format(time.StampMicro), events.lastAt.Format(time.StampMicro),
+			before.Format(time.StampMicro), after.Format(time.StampMicro))
+	}
+	// The first two events arrive in the same read, so there is no gap
+	// between them.
+	if events.gaps.total != 2 || events.gaps.min != 0 || events.gaps.max < interval {
+		t.Errorf("got %d gaps from %s to %s, want 2 from 0 to at least %s",
+			events.gaps.total, events.gaps.min, events.gaps.max, interval)
+	}
+
+	// reset forgets the events of the last response.
+	events.reset()
+	if events.count != 0 || !events.firstAt.IsZero() || !events.lastAt.IsZero() || events.gaps.total != 0 || events.sse {
+		t.Errorf("got %+v after reset", events)
+	}
+	events.Write([]byte("one\n")) //nolint:errcheck
+	if events.count != 1 || events.gaps.total != 0 {
+		t.Errorf("got %d events and %d gaps after reset, want 1 and 0", events.count, events.gaps.total)
+	}
+
+	// A nil streamEvents records nothing.
+	var nilEvents *streamEvents
+	nilEvents.reset()
+	nilEvents.start(true)
+	if n, err := nilEvents.Write([]byte("one\n")); n != 4 || err != nil {
+		t.Errorf("got Write %d, %v from nil events, want 4, nil", n, err)
+	}
+	nilEvents.finish()
+}
+
+func TestIsEventStream(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		contentType string
+		want        bool
+	}{
+		{contentType: "text/event-stream", want: true},
+		{contentType: "text/event-stream; charset=utf-8", want: true},
+		{contentType: "Text/Event-Stream", want: true},
+		{contentType: "application/x-ndjson", want: false},
+		{contentType: "text/plain", want: false},
+		{contentType: "", want: false},
+		{contentType: "text/event-stream; charset", want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.contentType, func(t *testing.T) {
+			t.Parallel()
+
+			if got := isEventStream(test.contentType); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+// deadlineReader returns the data of r, then fails with err once it runs out.
+type deadlineReader struct {
+	r   io.Reader
+	err error
+}
+
+func (reader deadlineReader) Read(p []byte) (int, error) {
+	n, err := reader.r.Read(p)
+	if errors.Is(err, io.EOF) {
+		return n, reader.err
+	}
+	return n, err //nolint:wrapcheck
+}
+
+func TestReadStream(t *testing.T) {
+	t.Parallel()
+
+	errRead := errors.New("connection reset")
+
+	tests := []struct {
+		name      string
+		err       error
+		deadline  time.Duration
+		wantEnded bool
+		wantErr   error
+		// wantEvents counts the last line, which only counts when the body
+		// ends.
+		wantEvents uint64
+	}{
+		{name: "Body that ends", deadline: time.Hour, wantEnded: true, wantEvents: 3},
+		{name: "Stream held until the deadline", err: errRead, deadline: -time.Millisecond, wantEvents: 2},
+		{name: "Read that fails before the deadline", err: errRead, deadline: time.Hour, wantErr: errRead, wantEvents: 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			const body = "one\ntwo\nthree"
+			var reader io.Reader = strings.NewReader(body)
+			if test.err != nil {
+				reader = deadlineReader{r: reader, err: test.err}
+			}
+			var copied strings.Builder
+			events := &streamEvents{}
+			ended, err := readStream(reader, &copied, time.Now().Add(test.deadline), events)
+			if ended != test.wantEnded || !errors.Is(err, test.wantErr) {
+				t.Errorf("got %v, %v, want %v, %v", ended, err, test.wantEnded, test.wantErr)
+			}
+			if copied.String() != body {
+				t.Errorf("got body %q, want %q", copied.String(), body)
+			}
+			if events.count != test.wantEvents {
+				t.Errorf("got %d events, want %d", events.count, test.wantEvents)
+			}
+		})
+	}
+
+	// Without events, the body is only copied.
+	var copied strings.Builder
+	if ended, err := readStream(strings.NewReader("one\n"), &copied, time.Now().Add(time.Hour), nil); !ended || err != nil || copied.String() != "one\n" {
+		t.Errorf("got %v, %v and body %q without events", ended, err, copied.String())
+	}
+}
+
+func TestStreamHostClient(t *testing.T) {
+	t.Parallel()
+
+	const hold = 200 * time.Millisecond
+
+	var connections atomic.Int64
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/held":
+			// Two events, then the stream stays open until the client
+			// leaves.
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte("data: one\n\n")) //nolint:errcheck
+			w.(http.Flusher).Flush()
+			time.Sleep(hold / 4)
+			w.Write([]byte("data: two\n\n")) //nolint:errcheck
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		case "/ended":
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.Write([]byte("{\"a\":1}\n{\"a\":2}\n{\"a\":3}\n")) //nolint:errcheck
+		}
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connections.Add(1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	trace := &requestTrace{events: &streamEvents{}}
+	client := &streamHostClient{
+		client: &fasthttp.HostClient{
+			Addr:               strings.TrimPrefix(server.URL, "http://"),
+			ReadTimeout:        hold,
+			StreamResponseBody: true,
+		},
+		trace: trace,
+	}
+	defer client.CloseIdleConnections()
+
+	do := func(path string) (*fasthttp.Response, time.Duration) {
+		t.Helper()
+
+		req := fasthttp.AcquireRequest()
+		defer fasthttp.ReleaseRequest(req)
+		req.SetRequestURI(server.URL + path)
+		resp := fasthttp.AcquireResponse()
+		trace.reset()
+		start := time.Now()
+		if err := client.DoTimeout(req, resp, hold); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		return resp, time.Since(start)
+	}
+
+	// A stream the server ends is read to its end, and its connection is
+	// reused.
+	for range 2 {
+		resp, _ := do("/ended")
+		if got, want := string(resp.Body()), "{\"a\":1}\n{\"a\":2}\n{\"a\":3}\n"; got != want {
+			t.Errorf("got body %q, want %q", got, want)
+		}
+		if trace.events.count != 3 || trace.events.sse {
+			t.Errorf("got %d events, read as SSE %v, want 3 lines", trace.events.count, trace.events.sse)
+		}
+		fasthttp.ReleaseResponse(resp)
+	}
+	if got := connections.Load(); got != 1 {
+		t.Errorf("got %d connections for streams that ended, want 1", got)
+	}
+
+	// A stream held open is read until the hold passes, which isn't an
+	// error, and its connection is closed rather than reused.
+	resp, elapsed := do("/held")
+	if elapsed < hold {
+		t.Errorf("got the held stream back after %s, want at least %s", elapsed, hold)
+	}
+	if got, want := string(resp.Body()), "data: one\n\ndata: two\n\n"; got != want {
+		t.Errorf("got body %q, want %q", got, want)
+	}
+	if trace.events.count != 2 || !trace.events.sse {
+		t.Errorf("got %d events, read as SSE %v, want 2 server-sent events", trace.events.count, trace.events.sse)
+	}
+	if gap := trace.events.gaps.max; gap < hold/4 {
+		t.Errorf("got %s between the events, want at least %s", gap, hold/4)
+	}
+	fasthttp.ReleaseResponse(resp)
+
+	resp, _ = do("/ended")
+	fasthttp.ReleaseResponse(resp)
+	// The held stream took the idle connection, so the next request needs a
+	// new one.
+	if got := connections.Load(); got != 2 {
+		t.Errorf("got %d connections, want 2 after a held stream", got)
+	}
+}
+
+func TestStreamStats(t *testing.T) {
+	t.Parallel()
+
+	// newSent returns a received response whose stream has the given lines,
+	// with its first event firstEvent after the request was written.
+	newSent := func(body string, firstEvent time.Duration) *sentRequest {
+		events := &streamEvents{}
+		events.start(false)
+		events.Write([]byte(body)) //nolint:errcheck
+		events.finish()
+		return &sentRequest{received: true, stream: events, firstEvent: firstEvent}
+	}
+
+	data := NewSarinResponseData(nil, nil, false)
+	first, second := data.NewShard(), data.NewShard()
+	first.Add("200", time.Second, time.Time{}, time.Time{}, newSent("one\ntwo\nthree\n", 10*time.Millisecond))
+	first.Add("200", time.Second, time.Time{}, time.Time{}, newSent("", 0))
+	second.Add("200", time.Second, time.Time{}, time.Time{}, newSent("one\ntwo\n", 30*time.Millisecond))
+	// A request without a response has no stream.
+	second.Add("200", time.Second, time.Time{}, time.Time{}, &sentRequest{})
+
+	data.Lock()
+	defer data.Unlock()
+	data.collect()
+	response := data.Responses["200"]
+	if response.streams != 3 || response.events != 5 || response.minEvents != 0 || response.maxEvents != 3 {
+		t.Errorf("got %d streams with %d events, from %d to %d each, want 3 with 5, from 0 to 3",
+			response.streams, response.events, response.minEvents, response.maxEvents)
+	}
+	// A stream without events has no first event, and a stream of n events
+	// has n-1 gaps.
+	if got := response.firstEvent; got.total != 2 || got.min != 10*time.Millisecond || got.max != 30*time.Millisecond {
+		t.Errorf("got %d first events from %s to %s, want 2 from 10ms to 30ms", got.total, got.min, got.max)
+	}
+	if got := response.eventGaps.total; got != 3 {
+		t.Errorf("got %d gaps between events, want 3", got)
+	}
+}
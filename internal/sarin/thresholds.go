@@ -0,0 +1,120 @@
+package sarin
+
+import (
+	"strconv"
+	"time"
+
+	"go.aykhans.me/sarin/internal/types"
+)
+
+type thresholdStat struct {
+	Threshold string `json:"threshold" yaml:"threshold"`
+	Actual    string `json:"actual"    yaml:"actual"`
+	Passed    bool   `json:"passed"    yaml:"passed"`
+}
+
+// FailedThresholds returns how many of the thresholds the final stats fail.
+func (data *SarinResponseData) FailedThresholds() int {
+	data.Lock()
+	defer data.Unlock()
+
+	data.collect()
+	failed := 0
+	for _, stat := range data.evaluateThresholds() {
+		if !stat.Passed {
+			failed++
+		}
+	}
+	return failed
+}
+
+// evaluateThresholds checks every threshold against the collected responses.
+// A latency threshold with no matching responses passes.
+// The caller must hold data's lock.
+func (data *SarinResponseData) evaluateThresholds() []thresholdStat {
+	if len(data.thresholds) == 0 {
+		return nil
+	}
+
+	stats := make([]thresholdStat, 0, len(data.thresholds))
+	for _, threshold := range data.thresholds {
+		actual, samples := measureThreshold(threshold, data.Responses, data.duration)
+		stat := thresholdStat{
+			Threshold: threshold.String(),
+			Actual:    formatThresholdActual(threshold, actual),
+			Passed:    threshold.Check(actual),
+		}
+		if threshold.IsLatency() && samples == 0 {
+			stat.Actual = "-"
+			stat.Passed = true
+		}
+		stats = append(stats, stat)
+	}
+	return stats
+}
+
+// measureThreshold returns the value of a threshold's metric over responses
+// collected during duration, along with the number of responses in its scope.
+// Errors are requests that got no response, a 4xx/5xx one or, for gRPC calls,
+// a status other than OK.
+func measureThreshold(threshold types.Threshold, responses map[string]*Response, duration time.Duration) (float64, uint64) {
+	var requests, failures uint64
+	scoped := &histogram{}
+	for key, response := range responses {
+		count := response.durations.total
+		requests += count
+
+		statusCode, err := strconv.Atoi(key)
+		switch {
+		case err == nil && statusCode >= 400,
+			err != nil && key != dryRunResponseKey && key != grpcCodeNames[grpcCodeOK]:
+			failures += count
+		}
+
+		if threshold.Scope == "" || (err == nil && threshold.InScope(statusCode)) {
+			scoped.merge(&response.durations)
+		}
+	}
+
+	var actual float64
+	switch threshold.Metric {
+	case types.ThresholdMetricCount:
+		actual = float64(scoped.total)
+	case types.ThresholdMetricRPS:
+		if duration > 0 {
+			actual = float64(scoped.total) / duration.Seconds()
+		}
+	case types.ThresholdMetricRate:
+		if requests > 0 {
+			actual = float64(scoped.total) / float64(requests)
+		}
+	case types.ThresholdMetricErrorRate:
+		if requests > 0 {
+			actual = float64(failures) / float64(requests)
+		}
+	case types.ThresholdMetricMin:
+		actual = float64(scoped.min)
+	case types.ThresholdMetricMax:
+		actual = float64(scoped.max)
+	case types.ThresholdMetricAverage:
+		actual = float64(scoped.mean())
+	case types.ThresholdMetricPercentile:
+		actual = float64(scoped.percentile(threshold.Percentile))
+	}
+	return actual, scoped.total
+}
+
+// formatThresholdActual formats a measured value the way the report shows
+// values of its kind.
+func formatThresholdActual(threshold types.Threshold, actual float64) string {
+	switch {
+	case threshold.IsLatency():
+		return Duration(actual).String()
+	case threshold.IsRate():
+		return strconv.FormatFloat(actual*100, 'f', 2, 64) + "%"
+	case threshold.Metric == types.ThresholdMetricRPS:
+		return strconv.FormatFloat(actual, 'f', 2, 64)
+	default:
+		return strconv.FormatFloat(actual, 'f', 0, 64)
+	}
+}
@@ -0,0 +1,125 @@
+package sarin
+
+import (
+	"testing"
+	"time"
+
+	"go.aykhans.me/sarin/internal/types"
+)
+
+// thresholdResponses returns 10 responses collected over 10s: seven 200s
+// taking 10ms to 70ms, two 503s taking 500ms and a timeout after 1s.
+func thresholdResponses() map[string]*Response {
+	responses := map[string]*Response{
+		"200":     {},
+		"503":     {},
+		"timeout": {},
+	}
+	for i := range 7 {
+		responses["200"].durations.record(time.Duration(i+1) * 10 * time.Millisecond)
+	}
+	responses["503"].durations.record(500 * time.Millisecond)
+	responses["503"].durations.record(500 * time.Millisecond)
+	responses["timeout"].durations.record(time.Second)
+	return responses
+}
+
+func TestEvaluateThresholds(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		threshold string
+		actual    string
+		passed    bool
+	}{
+		{"count < 10", "10", false},
+		{"count <= 10", "10", true},
+		{"2xx:count == 7", "7", true},
+		{"rps > 1", "1.00", false},
+		{"rps >= 1", "1.00", true},
+		{"error_rate < 30%", "30.00%", false},
+		{"error_rate <= 30%", "30.00%", true},
+		{"5xx:rate < 0.2", "20.00%", false},
+		{"5xx:rate <= 0.2", "20.00%", true},
+		{"503:rate > 10%", "20.00%", true},
+		{"min > 10ms", "10ms", false},
+		{"min >= 10ms", "10ms", true},
+		{"max < 1s", "1s", false},
+		{"max <= 1s", "1s", true},
+		{"503:avg < 500ms", "500ms", false},
+		{"503:avg <= 500ms", "500ms", true},
+		{"2xx:p100 <= 70ms", "70ms", true},
+		{"p50 < 50ms", "50.004ms", false},
+		{"p50 < 51ms", "50.004ms", true},
+		// A latency threshold without responses to measure passes.
+		{"404:p99 < 1ms", "-", true},
+		{"4xx:max < 1ms", "-", true},
+		{"404:count > 0", "0", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.threshold, func(t *testing.T) {
+			t.Parallel()
+
+			threshold, err := types.ParseThreshold(test.threshold)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data := NewSarinResponseData(nil, types.Thresholds{*threshold}, false)
+			data.Responses = thresholdResponses()
+			data.duration = 10 * time.Second
+
+			stats := data.evaluateThresholds()
+			if len(stats) != 1 {
+				t.Fatalf("got %d threshold stats, want 1", len(stats))
+			}
+			if stats[0].Actual != test.actual || stats[0].Passed != test.passed {
+				t.Errorf("got %q (passed %v), want %q (passed %v)",
+					stats[0].Actual, stats[0].Passed, test.actual, test.passed)
+			}
+		})
+	}
+}
+
+func TestMeasureThresholdErrors(t *testing.T) {
+	t.Parallel()
+
+	// Only requests without a response, 4xx/5xx responses and gRPC calls
+	// that didn't end OK are errors.
+	responses := map[string]*Response{
+		"200":                           {},
+		"302":                           {},
+		"404":                           {},
+		"connection refused":            {},
+		dryRunResponseKey:               {},
+		grpcCodeNames[grpcCodeOK]:       {},
+		grpcCodeNames[grpcCodeInternal]: {},
+	}
+	for _, response := range responses {
+		response.durations.record(time.Millisecond)
+	}
+
+	threshold := types.Threshold{Metric: types.ThresholdMetricErrorRate, Operator: types.ThresholdOperatorLess}
+	actual, _ := measureThreshold(threshold, responses, time.Second)
+	if want := 3.0 / 7; actual != want {
+		t.Errorf("got an error rate of %v, want %v", actual, want)
+	}
+}
+
+func TestFailedThresholds(t *testing.T) {
+	t.Parallel()
+
+	var thresholds types.Thresholds
+	for _, threshold := range []string{"count == 10", "max < 1s", "error_rate < 50%", "p50 < 1ms"} {
+		if err := thresholds.Parse(threshold); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data := NewSarinResponseData(nil, thresholds, false)
+	data.Responses = thresholdResponses()
+	data.duration = 10 * time.Second
+
+	if got := data.FailedThresholds(); got != 2 {
+		t.Errorf("got %d failed thresholds, want 2", got)
+	}
+}
@@ -0,0 +1,216 @@
+package sarin
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.aykhans.me/sarin/internal/types"
+)
+
+// timelineWindow summarises the responses collected during one timeline
+// interval.
+type timelineWindow struct {
+	Time     time.Time         `json:"time"     yaml:"time"`
+	Elapsed  Duration          `json:"elapsed"  yaml:"elapsed"`
+	Duration Duration          `json:"duration" yaml:"duration"`
+	Requests uint64            `json:"requests" yaml:"requests"`
+	RPS      float64           `json:"rps"      yaml:"rps"`
+	Errors   uint64            `json:"errors"   yaml:"errors"`
+	Statuses map[string]uint64 `json:"statuses" yaml:"statuses"`
+	Latency  responseStat      `json:"latency"  yaml:"latency"`
+}
+
+// isStatusCodeKey reports whether a response key is an HTTP status code, or the
+// name of a gRPC status, rather than an error message.
+func isStatusCodeKey(key string) bool {
+	_, err := strconv.Atoi(key)
+	return err == nil || isGRPCStatusKey(key)
+}
+
+// StartTimeline starts collecting responses into timeline windows.
+func (data *SarinResponseData) StartTimeline() {
+	data.Lock()
+	defer data.Unlock()
+
+	data.collect()
+	data.window = make(map[string]*Response)
+	data.timelineStarted = time.Now()
+	data.windowStartedAt = data.timelineStarted
+}
+
+// MarkWindow closes the current timeline window and starts the next one.
+func (data *SarinResponseData) MarkWindow() {
+	data.Lock()
+	defer data.Unlock()
+
+	data.collect()
+	now := time.Now()
+	data.timeline = append(data.timeline, data.summarizeWindow(data.window, data.windowStartedAt, now))
+	data.window = make(map[string]*Response)
+	data.windowStartedAt = now
+}
+
+// WriteTimeline writes the timeline to path, as CSV when the file name ends
+// in .csv and as NDJSON (one window per line) otherwise.
+// It can return the following errors:
+//   - types.FileWriteError
+func (data *SarinResponseData) WriteTimeline(path string) error {
+	data.Lock()
+	defer data.Unlock()
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return types.NewFileWriteError(path, err)
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		err = data.writeTimelineCSV(file)
+	} else {
+		err = data.writeTimelineNDJSON(file)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return types.NewFileWriteError(path, err)
+	}
+	return nil
+}
+
+// summarizeWindow reduces the responses of a window to counts and latency
+// stats, so finished windows don't keep their histograms around.
+func (data *SarinResponseData) summarizeWindow(responses map[string]*Response, start, end time.Time) timelineWindow {
+	window := timelineWindow{
+		Time:     start,
+		Elapsed:  Duration(start.Sub(data.timelineStarted)),
+		Duration: Duration(end.Sub(start)),
+		Statuses: make(map[string]uint64),
+	}
+
+	total := &Response{}
+	for key, response := range responses {
+		count := response.durations.total
+		window.Requests += count
+		switch {
+		case isStatusCodeKey(key):
+			window.Statuses[key] += count
+		case key != dryRunResponseKey:
+			window.Errors += count
+		}
+		total.merge(response)
+	}
+
+	if seconds := end.Sub(start).Seconds(); seconds > 0 {
+		window.RPS = math.Round(float64(window.Requests)/seconds*100) / 100
+	}
+	window.Latency = data.calculateResponseStats(total)
+
+	return window
+}
+
+func (data *SarinResponseData) writeTimelineNDJSON(file *os.File) error {
+	encoder := json.NewEncoder(file)
+	for _, window := range data.timeline {
+		if err := encoder.Encode(window); err != nil {
+			return err //nolint:wrapcheck
+		}
+	}
+	return nil
+}
+
+// writeTimelineCSV writes one row per window. Durations are in seconds and
+// latencies in milliseconds so the file can be charted directly, and every
+// status code seen in any window gets its own column.
+func (data *SarinResponseData) writeTimelineCSV(file *os.File) error {
+	var statuses []string
+	for _, window := range data.timeline {
+		for status := range window.Statuses {
+			if !slices.Contains(statuses, status) {
+				statuses = append(statuses, status)
+			}
+		}
+	}
+	slices.Sort(statuses)
+
+	seconds := func(d Duration) string {
+		return strconv.FormatFloat(time.Duration(d).Seconds(), 'f', 3, 64)
+	}
+	milliseconds := func(d Duration) string {
+		return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+	}
+
+	header := []string{"time", "elapsed_s", "duration_s", "requests", "rps", "errors", "min_ms", "max_ms", "average_ms"}
+	for _, percentile := range data.percentiles {
+		header = append(header, "p"+formatPercentile(percentile)+"_ms")
+	}
+	for _, status := range statuses {
+		header = append(header, "status_"+status)
+	}
+
+	writer := csv.NewWriter(file)
+	if err := writer.Write(header); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	for _, window := range data.timeline {
+		row := []string{
+			window.Time.Format(time.RFC3339Nano),
+			seconds(window.Elapsed),
+			seconds(window.Duration),
+			strconv.FormatUint(window.Requests, 10),
+			strconv.FormatFloat(window.RPS, 'f', 2, 64),
+			strconv.FormatUint(window.Errors, 10),
+			milliseconds(window.Latency.Min),
+			milliseconds(window.Latency.Max),
+			milliseconds(window.Latency.Average),
+		}
+		for _, percentile := range window.Latency.Percentiles {
+			row = append(row, milliseconds(percentile.Value))
+		}
+		for _, status := range statuses {
+			row = append(row, strconv.FormatUint(window.Statuses[status], 10))
+		}
+		if err := writer.Write(row); err != nil {
+			return err //nolint:wrapcheck
+		}
+	}
+
+	writer.Flush()
+	return writer.Error() //nolint:wrapcheck
+}
+
+// trackTimeline starts closing a timeline window every s.timelineInterval.
+// The returned function stops it and closes the last, partial window.
+func (s sarin) trackTimeline() func() {
+	s.responses.StartTimeline()
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(s.timelineInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				s.responses.MarkWindow()
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+		s.responses.MarkWindow()
+	}
+}
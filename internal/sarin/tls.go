@@ -0,0 +1,243 @@
+package sarin
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"maps"
+	"net/url"
+	"slices"
+	"sync/atomic"
+
+	"go.aykhans.me/sarin/internal/types"
+)
+
+// TLSRotation decides how the workers take turns with the client
+// certificates.
+type TLSRotation uint8
+
+const (
+	// TLSRotationWorker gives each worker one certificate, in turn, so every
+	// worker acts as one client.
+	TLSRotationWorker TLSRotation = iota
+	// TLSRotationRequest lets every worker cycle through all the
+	// certificates, a request at a time, like it does through the proxies.
+	TLSRotationRequest
+)
+
+// ParseTLSRotation maps a config value to a TLSRotation. Unknown values fall
+// back to TLSRotationWorker; config validation rejects them before they get
+// here.
+func ParseTLSRotation(rotation string) TLSRotation {
+	if rotation == "request" {
+		return TLSRotationRequest
+	}
+	return TLSRotationWorker
+}
+
+// tlsVersions maps the TLS versions that can be configured to their IDs.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsCurves maps the key exchange mechanisms that can be configured to their
+// IDs.
+var tlsCurves = map[string]tls.CurveID{
+	"X25519":             tls.X25519,
+	"X25519MLKEM768":     tls.X25519MLKEM768,
+	"SecP256r1MLKEM768":  tls.SecP256r1MLKEM768,
+	"SecP384r1MLKEM1024": tls.SecP384r1MLKEM1024,
+	"P-256":              tls.CurveP256,
+	"P-384":              tls.CurveP384,
+	"P-521":              tls.CurveP521,
+}
+
+// ParseTLSVersion maps a TLS version, such as 1.2, to its ID, and reports
+// whether it is known.
+func ParseTLSVersion(version string) (uint16, bool) {
+	id, ok := tlsVersions[version]
+	return id, ok
+}
+
+// ParseTLSCipherSuite maps the IANA name of a cipher suite, such as
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, to its ID, and reports whether it is
+// known. The TLS 1.3 suites aren't configurable, so they are unknown.
+func ParseTLSCipherSuite(name string) (uint16, bool) {
+	for _, suite := range slices.Concat(tls.CipherSuites(), tls.InsecureCipherSuites()) {
+		if suite.Name == name && !slices.Equal(suite.SupportedVersions, []uint16{tls.VersionTLS13}) {
+			return suite.ID, true
+		}
+	}
+	return 0, false
+}
+
+// ParseTLSCurve maps the name of a key exchange mechanism, such as X25519 or
+// P-256, to its ID, and reports whether it is known.
+func ParseTLSCurve(name string) (tls.CurveID, bool) {
+	id, ok := tlsCurves[name]
+	return id, ok
+}
+
+// TLSCurveNames returns the names ParseTLSCurve knows, sorted.
+func TLSCurveNames() []string {
+	return slices.Sorted(maps.Keys(tlsCurves))
+}
+
+// clientTLS holds the TLS settings that every connection to a target shares:
+// whether and against which CAs its certificate is verified, the client
+// certificates to present and how the handshake is negotiated. It is safe for
+// concurrent use.
+type clientTLS struct {
+	skipVerify bool
+	// roots is nil to verify against the system CAs.
+	roots    *x509.CertPool
+	certs    []*tls.Certificate
+	rotation TLSRotation
+	// nextWorker is the certificate the next worker starts with.
+	nextWorker atomic.Uint64
+
+	// A zero version, and empty cipher suites, curves or ALPN protocols,
+	// leave the choice to crypto/tls. serverName replaces the host of the
+	// URL in SNI and in verification, unless it is empty.
+	minVersion   uint16
+	maxVersion   uint16
+	cipherSuites []uint16
+	curves       []tls.CurveID
+	serverName   string
+	alpn         []string
+	// sessions holds a session cache for each client certificate, and for
+	// nil, so that a session is only resumed with the certificate that
+	// started it. It is nil when session reuse is off.
+	sessions map[*tls.Certificate]tls.ClientSessionCache
+}
+
+// newClientTLS loads the CA bundles and client certificates, each from a
+// local path or an HTTP/HTTPS URL. Unknown versions, cipher suites and curves
+// are ignored; config validation rejects them before they get here.
+// It can return the following errors:
+//   - types.TLSLoadError
+func newClientTLS(
+	fileCache *FileCache,
+	skipVerify bool,
+	caFiles []string,
+	clientCerts []types.TLSClientCert,
+	rotation TLSRotation,
+	minVersion string,
+	maxVersion string,
+	cipherSuites []string,
+	curves []string,
+	serverName string,
+	alpn []string,
+	sessionReuse bool,
+) (*clientTLS, error) {
+	settings := &clientTLS{
+		skipVerify: skipVerify,
+		rotation:   rotation,
+		serverName: serverName,
+		alpn:       alpn,
+	}
+	settings.minVersion, _ = ParseTLSVersion(minVersion)
+	settings.maxVersion, _ = ParseTLSVersion(maxVersion)
+	for _, name := range cipherSuites {
+		if id, ok := ParseTLSCipherSuite(name); ok {
+			settings.cipherSuites = append(settings.cipherSuites, id)
+		}
+	}
+	for _, name := range curves {
+		if id, ok := ParseTLSCurve(name); ok {
+			settings.curves = append(settings.curves, id)
+		}
+	}
+
+	if len(caFiles) > 0 {
+		settings.roots = x509.NewCertPool()
+		for _, caFile := range caFiles {
+			file, err := fileCache.GetOrLoad(caFile)
+			if err != nil {
+				return nil, types.NewTLSLoadError(caFile, err)
+			}
+			if !settings.roots.AppendCertsFromPEM(file.Content) {
+				return nil, types.NewTLSLoadError(caFile, types.ErrTLSNoCertificates)
+			}
+		}
+	}
+
+	for _, clientCert := range clientCerts {
+		certFile, err := fileCache.GetOrLoad(clientCert.Cert)
+		if err != nil {
+			return nil, types.NewTLSLoadError(clientCert.Cert, err)
+		}
+		keyFile, err := fileCache.GetOrLoad(clientCert.Key)
+		if err != nil {
+			return nil, types.NewTLSLoadError(clientCert.Key, err)
+		}
+		cert, err := tls.X509KeyPair(certFile.Content, keyFile.Content)
+		if err != nil {
+			return nil, types.NewTLSLoadError(clientCert.Cert, err)
+		}
+		settings.certs = append(settings.certs, &cert)
+	}
+
+	if sessionReuse {
+		settings.sessions = map[*tls.Certificate]tls.ClientSessionCache{nil: tls.NewLRUClientSessionCache(0)}
+		for _, cert := range settings.certs {
+			settings.sessions[cert] = tls.NewLRUClientSessionCache(0)
+		}
+	}
+
+	return settings, nil
+}
+
+// workerCerts returns the client certificates of the next worker: a single
+// one in turn, or all of them, starting from the next in turn, when they
+// rotate per request. Without client certificates, it returns a single nil.
+func (settings *clientTLS) workerCerts() []*tls.Certificate {
+	if len(settings.certs) == 0 {
+		return []*tls.Certificate{nil}
+	}
+
+	first := int(settings.nextWorker.Add(1)-1) % len(settings.certs)
+	if settings.rotation == TLSRotationWorker {
+		return settings.certs[first : first+1]
+	}
+	certs := make([]*tls.Certificate, 0, len(settings.certs))
+	certs = append(certs, settings.certs[first:]...)
+	return append(certs, settings.certs[:first]...)
+}
+
+// firstCert returns the first client certificate, or nil without any.
+func (settings *clientTLS) firstCert() *tls.Certificate {
+	if len(settings.certs) == 0 {
+		return nil
+	}
+	return settings.certs[0]
+}
+
+// config returns a TLS config for connections to host that present cert,
+// unless it is nil.
+func (settings *clientTLS) config(host string, cert *tls.Certificate) *tls.Config {
+	config := &tls.Config{
+		ServerName:         (&url.URL{Host: host}).Hostname(),
+		InsecureSkipVerify: settings.skipVerify, //nolint:gosec
+		RootCAs:            settings.roots,
+		MinVersion:         settings.minVersion, //nolint:gosec
+		MaxVersion:         settings.maxVersion,
+		CipherSuites:       settings.cipherSuites,
+		CurvePreferences:   settings.curves,
+		NextProtos:         settings.alpn,
+	}
+	if settings.serverName != "" {
+		config.ServerName = settings.serverName
+	}
+	if cert != nil {
+		config.Certificates = []tls.Certificate{*cert}
+	}
+	if settings.sessions != nil {
+		config.ClientSessionCache = settings.sessions[cert]
+	} else {
+		config.SessionTicketsDisabled = true
+	}
+	return config
+}
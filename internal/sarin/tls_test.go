@@ -0,0 +1,491 @@
+package sarin
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"go.aykhans.me/sarin/internal/types"
+)
+
+// writeTestCert creates a self-signed certificate for commonName, valid for
+// 127.0.0.1 as both a server and a client, and writes it and its key as PEM
+// files in dir. It returns the certificate and the paths of the files.
+func writeTestCert(t *testing.T, dir, commonName string) (tls.Certificate, types.TLSClientCert) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := types.TLSClientCert{
+		Cert: filepath.Join(dir, commonName+".crt"),
+		Key:  filepath.Join(dir, commonName+".key"),
+	}
+	if err := os.WriteFile(files.Cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(files.Key, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, files
+}
+
+// commonName returns the common name of cert, or "" for nil.
+func commonName(t *testing.T, cert *tls.Certificate) string {
+	t.Helper()
+
+	if cert == nil {
+		return ""
+	}
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.Subject.CommonName
+}
+
+func TestNewClientTLSLoad(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	_, server := writeTestCert(t, dir, "server")
+	_, first := writeTestCert(t, dir, "first")
+	_, second := writeTestCert(t, dir, "second")
+	notPEM := filepath.Join(dir, "not.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing.pem")
+
+	tests := []struct {
+		name       string
+		caFiles    []string
+		certs      []types.TLSClientCert
+		wantSource string
+		wantErr    error
+		wantCerts  []string
+	}{
+		{name: "Nothing to load"},
+		{
+			name:      "CA and client certificates",
+			caFiles:   []string{server.Cert},
+			certs:     []types.TLSClientCert{first, second},
+			wantCerts: []string{"first", "second"},
+		},
+		{name: "Missing CA", caFiles: []string{server.Cert, missing}, wantSource: missing},
+		{name: "CA without certificates", caFiles: []string{notPEM}, wantSource: notPEM, wantErr: types.ErrTLSNoCertificates},
+		{name: "Missing certificate", certs: []types.TLSClientCert{first, {Cert: missing, Key: second.Key}}, wantSource: missing},
+		{name: "Missing key", certs: []types.TLSClientCert{{Cert: first.Cert, Key: missing}}, wantSource: missing},
+		{name: "Key of another certificate", certs: []types.TLSClientCert{{Cert: first.Cert, Key: second.Key}}, wantSource: first.Cert},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			settings, err := newClientTLS(
+				NewFileCache(time.Second), false, test.caFiles, test.certs, TLSRotationWorker,
+				"", "", nil, nil, "", nil, false,
+			)
+			if test.wantSource != "" {
+				loadErr, ok := errors.AsType[types.TLSLoadError](err)
+				if !ok || loadErr.Source != test.wantSource {
+					t.Fatalf("got error %v, want a types.TLSLoadError for %s", err, test.wantSource)
+				}
+				if test.wantErr != nil && !errors.Is(err, test.wantErr) {
+					t.Errorf("got error %v, want %v", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if (settings.roots != nil) != (len(test.caFiles) > 0) {
+				t.Errorf("got roots %v for CA files %v", settings.roots, test.caFiles)
+			}
+			var got []string
+			for _, cert := range settings.certs {
+				got = append(got, commonName(t, cert))
+			}
+			if !slices.Equal(got, test.wantCerts) {
+				t.Errorf("got certificates %v, want %v", got, test.wantCerts)
+			}
+		})
+	}
+}
+
+func TestClientTLSWorkerCerts(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	var certs []types.TLSClientCert
+	for _, name := range []string{"a", "b", "c"} {
+		_, files := writeTestCert(t, dir, name)
+		certs = append(certs, files)
+	}
+
+	tests := []struct {
+		name     string
+		certs    []types.TLSClientCert
+		rotation TLSRotation
+		// want holds the common names of the certificates of each worker in
+		// turn.
+		want [][]string
+	}{
+		{
+			name:     "Per worker",
+			certs:    certs,
+			rotation: TLSRotationWorker,
+			want:     [][]string{{"a"}, {"b"}, {"c"}, {"a"}},
+		},
+		{
+			name:     "Per request",
+			certs:    certs,
+			rotation: TLSRotationRequest,
+			want:     [][]string{{"a", "b", "c"}, {"b", "c", "a"}, {"c", "a", "b"}, {"a", "b", "c"}},
+		},
+		{
+			name:     "Without client certificates",
+			rotation: TLSRotationRequest,
+			want:     [][]string{{""}, {""}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			settings, err := newClientTLS(
+				NewFileCache(time.Second), true, nil, test.certs, test.rotation,
+				"", "", nil, nil, "", nil, false,
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for worker, want := range test.want {
+				var got []string
+				for _, cert := range settings.workerCerts() {
+					got = append(got, commonName(t, cert))
+				}
+				if !slices.Equal(got, want) {
+					t.Errorf("worker %d: got certificates %v, want %v", worker, got, want)
+				}
+			}
+			if got, want := commonName(t, settings.firstCert()), test.want[0][0]; got != want {
+				t.Errorf("got first certificate %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestClientTLSConfig(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	_, first := writeTestCert(t, dir, "first")
+	_, second := writeTestCert(t, dir, "second")
+
+	t.Run("Defaults", func(t *testing.T) {
+		t.Parallel()
+
+		settings, err := newClientTLS(
+			NewFileCache(time.Second), false, nil, nil, TLSRotationWorker,
+			"", "", nil, nil, "", nil, false,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for host, want := range map[string]string{"example.com:443": "example.com", "[::1]:8443": "::1", "example.com": "example.com"} {
+			config := settings.config(host, nil)
+			if config.ServerName != want {
+				t.Errorf("got server name %q for %s, want %q", config.ServerName, host, want)
+			}
+			if config.InsecureSkipVerify || config.RootCAs != nil || config.MinVersion != 0 || config.MaxVersion != 0 ||
+				config.CipherSuites != nil || config.CurvePreferences != nil || config.NextProtos != nil || config.Certificates != nil {
+				t.Errorf("got config %+v, want the choices left to crypto/tls", config)
+			}
+			if !config.SessionTicketsDisabled || config.ClientSessionCache != nil {
+				t.Error("got session resumption without session reuse")
+			}
+		}
+	})
+
+	t.Run("Handshake options", func(t *testing.T) {
+		t.Parallel()
+
+		// Unknown versions, cipher suites and curves, and the TLS 1.3 suites,
+		// are left out.
+		settings, err := newClientTLS(
+			NewFileCache(time.Second), true, nil, nil, TLSRotationWorker,
+			"1.1", "1.2",
+			[]string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_AES_128_GCM_SHA256", "TLS_RSA_WITH_RC4_128_SHA", "bogus"},
+			[]string{"P-256", "X25519MLKEM768", "P-192"},
+			"api.internal", []string{"http/1.1"}, false,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config := settings.config("127.0.0.1:443", nil)
+		if !config.InsecureSkipVerify {
+			t.Error("got certificate verification with skip verify set")
+		}
+		if config.MinVersion != tls.VersionTLS11 || config.MaxVersion != tls.VersionTLS12 {
+			t.Errorf("got versions %s to %s, want TLS 1.1 to TLS 1.2", tls.VersionName(config.MinVersion), tls.VersionName(config.MaxVersion))
+		}
+		wantCiphers := []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_RSA_WITH_RC4_128_SHA}
+		if !slices.Equal(config.CipherSuites, wantCiphers) {
+			t.Errorf("got cipher suites %v, want %v", config.CipherSuites, wantCiphers)
+		}
+		if wantCurves := []tls.CurveID{tls.CurveP256, tls.X25519MLKEM768}; !slices.Equal(config.CurvePreferences, wantCurves) {
+			t.Errorf("got curves %v, want %v", config.CurvePreferences, wantCurves)
+		}
+		if config.ServerName != "api.internal" {
+			t.Errorf("got server name %q, want api.internal", config.ServerName)
+		}
+		if !slices.Equal(config.NextProtos, []string{"http/1.1"}) {
+			t.Errorf("got ALPN protocols %v, want [http/1.1]", config.NextProtos)
+		}
+	})
+
+	t.Run("Session reuse", func(t *testing.T) {
+		t.Parallel()
+
+		settings, err := newClientTLS(
+			NewFileCache(time.Second), true, nil, []types.TLSClientCert{first, second}, TLSRotationRequest,
+			"", "", nil, nil, "", nil, true,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// A session is only resumed with the certificate that started it,
+		// whichever host it was for.
+		caches := make(map[tls.ClientSessionCache]string)
+		for _, cert := range []*tls.Certificate{nil, settings.certs[0], settings.certs[1]} {
+			name := commonName(t, cert)
+			for _, host := range []string{"a.example:443", "b.example:443"} {
+				config := settings.config(host, cert)
+				if config.SessionTicketsDisabled || config.ClientSessionCache == nil {
+					t.Fatalf("got no session cache for certificate %q", name)
+				}
+				if owner, ok := caches[config.ClientSessionCache]; ok && owner != name {
+					t.Errorf("got the session cache of certificate %q for certificate %q", owner, name)
+				}
+				caches[config.ClientSessionCache] = name
+			}
+			if config := settings.config("a.example:443", cert); cert != nil && commonName(t, &config.Certificates[0]) != name {
+				t.Errorf("got certificate %q, want %q", commonName(t, &config.Certificates[0]), name)
+			}
+		}
+		if len(caches) != 3 {
+			t.Errorf("got %d session caches, want 3", len(caches))
+		}
+	})
+}
+
+// acceptedHandshake describes a handshake the test TLS server accepted.
+type acceptedHandshake struct {
+	clientCert string
+	version    uint16
+	cipher     uint16
+	protocol   string
+}
+
+// startTLSHandshakeServer accepts TLS connections on a free TCP port of
+// 127.0.0.1 with cert, asking for a client certificate, until the test ends.
+// It sends the handshakes it completes to the returned channel.
+func startTLSHandshakeServer(t *testing.T, cert tls.Certificate) (string, <-chan acceptedHandshake) {
+	t.Helper()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequestClientCert,
+		NextProtos:   []string{"h2", "http/1.1"},
+		MinVersion:   tls.VersionTLS12,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() }) //nolint:errcheck
+
+	handshakes := make(chan acceptedHandshake, 16)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			tlsConn := conn.(*tls.Conn)
+			if err := tlsConn.Handshake(); err == nil {
+				state := tlsConn.ConnectionState()
+				handshake := acceptedHandshake{version: state.Version, cipher: state.CipherSuite, protocol: state.NegotiatedProtocol}
+				if len(state.PeerCertificates) > 0 {
+					handshake.clientCert = state.PeerCertificates[0].Subject.CommonName
+				}
+				handshakes <- handshake
+			}
+			conn.Close() //nolint:errcheck
+		}
+	}()
+	return listener.Addr().String(), handshakes
+}
+
+func TestClientTLSHandshake(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	serverCert, server := writeTestCert(t, dir, "server")
+	_, first := writeTestCert(t, dir, "first")
+	_, second := writeTestCert(t, dir, "second")
+	addr, handshakes := startTLSHandshakeServer(t, serverCert)
+
+	// handshake connects to the server with the settings and returns what
+	// the server saw.
+	handshake := func(settings *clientTLS, cert *tls.Certificate) (acceptedHandshake, error) {
+		t.Helper()
+
+		conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}, "tcp", addr, settings.config(addr, cert))
+		if err != nil {
+			return acceptedHandshake{}, err //nolint:wrapcheck
+		}
+		defer conn.Close() //nolint:errcheck
+		select {
+		case got := <-handshakes:
+			return got, nil
+		case <-time.After(5 * time.Second):
+			t.Fatal("the server didn't complete the handshake")
+			return acceptedHandshake{}, nil
+		}
+	}
+
+	t.Run("Client certificates", func(t *testing.T) {
+		settings, err := newClientTLS(
+			NewFileCache(time.Second), false, []string{server.Cert}, []types.TLSClientCert{first, second}, TLSRotationWorker,
+			"", "", nil, nil, "", nil, false,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Each worker presents its own certificate, in turn.
+		for _, want := range []string{"first", "second", "first"} {
+			got, err := handshake(settings, settings.workerCerts()[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.clientCert != want {
+				t.Errorf("got client certificate %q, want %q", got.clientCert, want)
+			}
+		}
+	})
+
+	t.Run("Negotiated options", func(t *testing.T) {
+		// The server takes the options the client offers.
+		for _, test := range []struct {
+			minVersion string
+			maxVersion string
+			ciphers    []string
+			alpn       []string
+			want       acceptedHandshake
+		}{
+			{
+				maxVersion: "1.2",
+				ciphers:    []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"},
+				alpn:       []string{"http/1.1"},
+				want:       acceptedHandshake{version: tls.VersionTLS12, cipher: tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, protocol: "http/1.1"},
+			},
+			{
+				maxVersion: "1.2",
+				ciphers:    []string{"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256"},
+				want:       acceptedHandshake{version: tls.VersionTLS12, cipher: tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256},
+			},
+			{
+				// The TLS 1.3 suites aren't configurable, so the cipher
+				// suites only apply up to TLS 1.2.
+				minVersion: "1.3",
+				ciphers:    []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"},
+				alpn:       []string{"http/1.0", "http/1.1"},
+				want:       acceptedHandshake{version: tls.VersionTLS13, protocol: "http/1.1"},
+			},
+		} {
+			settings, err := newClientTLS(
+				NewFileCache(time.Second), false, []string{server.Cert}, nil, TLSRotationWorker,
+				test.minVersion, test.maxVersion, test.ciphers, nil, "", test.alpn, false,
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := handshake(settings, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.want.cipher == 0 {
+				got.cipher = 0
+			}
+			if got != test.want {
+				t.Errorf("got handshake %+v, want %+v", got, test.want)
+			}
+		}
+	})
+
+	t.Run("Verification", func(t *testing.T) {
+		// The server's certificate is trusted through the CA file, for the
+		// name it was issued to.
+		for _, test := range []struct {
+			caFiles    []string
+			serverName string
+			wantErr    bool
+		}{
+			{caFiles: []string{server.Cert}},
+			{caFiles: []string{first.Cert}, wantErr: true},
+			{caFiles: []string{server.Cert}, serverName: "other.example", wantErr: true},
+		} {
+			settings, err := newClientTLS(
+				NewFileCache(time.Second), false, test.caFiles, nil, TLSRotationWorker,
+				"", "", nil, nil, test.serverName, nil, false,
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := handshake(settings, nil)
+			if (err != nil) != test.wantErr {
+				t.Errorf("CA files %v, server name %q: got error %v, want error %v", test.caFiles, test.serverName, err, test.wantErr)
+			}
+			if err == nil && (got.clientCert != "" || got.version != tls.VersionTLS13 || got.protocol != "") {
+				t.Errorf("got handshake %+v, want TLS 1.3 without a client certificate or ALPN", got)
+			}
+		}
+	})
+}
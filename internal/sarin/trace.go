@@ -0,0 +1,254 @@
+package sarin
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+type requestPhase int
+
+const (
+	requestPhaseDNS requestPhase = iota
+	requestPhaseConnect
+	requestPhaseTLS
+	requestPhaseQUIC
+	requestPhaseUpgrade
+	requestPhaseTTFB
+	requestPhaseBody
+	requestPhaseCount
+)
+
+var requestPhaseNames = [requestPhaseCount]string{"DNS", "Connect", "TLS", "QUIC", "Upgrade", "TTFB", "Body"}
+
+// requestPhases holds how long each phase of one request took. The connection
+// phases are measured only when the request had to open a new connection, and
+// DNS only when that connection looked up a host name.
+type requestPhases struct {
+	durations [requestPhaseCount]time.Duration
+	measured  [requestPhaseCount]bool
+}
+
+func (p *requestPhases) set(phase requestPhase, duration time.Duration) {
+	p.durations[phase] = duration
+	p.measured[phase] = true
+}
+
+// requestTrace follows the request a worker is currently sending. Every worker
+// owns one trace and its own host clients, whose dial functions and
+// connections report into it, so no synchronisation is needed.
+// A nil trace records nothing.
+type requestTrace struct {
+	dialed, resolved, handshaken, quicHandshaken, upgraded bool
+	// dns, connect, tls, quic and upgrade add up over every connection opened
+	// for the request, since a failed attempt may be retried on a new
+	// connection.
+	dns, connect, tls, quic, upgrade time.Duration
+	// handshakes counts the TLS handshakes, QUIC ones included, that
+	// completed for the request, and resumed those that resumed a session.
+	handshakes, resumed uint64
+	// remote is the address of the server the request was sent to, and nil
+	// until it is sent.
+	remote net.Addr
+	// wroteAt is when the last write to the connection finished, and
+	// firstByteAt when the first read after that returned data. Over TLS
+	// both only count application data, once the handshake is done.
+	wroteAt     time.Time
+	firstByteAt time.Time
+	// events follows the events of the response in stream mode, and is nil
+	// otherwise.
+	events *streamEvents
+}
+
+// reset prepares the trace for the next request.
+func (t *requestTrace) reset() {
+	*t = requestTrace{events: t.events}
+	t.events.reset()
+}
+
+// streamEvents returns the stream events the trace follows, if any.
+func (t *requestTrace) streamEvents() *streamEvents {
+	if t == nil {
+		return nil
+	}
+	return t.events
+}
+
+func (t *requestTrace) resolvedIn(duration time.Duration) {
+	if t == nil {
+		return
+	}
+	t.resolved = true
+	t.dns += duration
+}
+
+func (t *requestTrace) connectedIn(duration time.Duration) {
+	if t == nil {
+		return
+	}
+	t.dialed = true
+	t.connect += duration
+}
+
+func (t *requestTrace) handshakenIn(duration time.Duration) {
+	if t == nil {
+		return
+	}
+	t.handshaken = true
+	t.tls += duration
+}
+
+// handshook counts a TLS handshake that completed, resuming an earlier
+// session if resumed is set.
+func (t *requestTrace) handshook(resumed bool) {
+	if t == nil {
+		return
+	}
+	t.handshakes++
+	if resumed {
+		t.resumed++
+	}
+}
+
+// quicHandshakenIn records the QUIC handshake of an HTTP/3 connection, which
+// includes its TLS handshake.
+func (t *requestTrace) quicHandshakenIn(duration time.Duration) {
+	if t == nil {
+		return
+	}
+	t.quicHandshaken = true
+	t.quic += duration
+}
+
+// upgradedIn records the HTTP upgrade that turns a connection into a
+// WebSocket connection.
+func (t *requestTrace) upgradedIn(duration time.Duration) {
+	if t == nil {
+		return
+	}
+	t.upgraded = true
+	t.upgrade += duration
+}
+
+// addConnection adds the phases of opening a connection, traced on their own
+// in conn, to the trace.
+func (t *requestTrace) addConnection(conn *requestTrace) {
+	if conn.resolved {
+		t.resolvedIn(conn.dns)
+	}
+	if conn.dialed {
+		t.connectedIn(conn.connect)
+	}
+	if conn.handshaken {
+		t.handshakenIn(conn.tls)
+	}
+	if conn.quicHandshaken {
+		t.quicHandshakenIn(conn.quic)
+	}
+	if conn.upgraded {
+		t.upgradedIn(conn.upgrade)
+	}
+	if t != nil {
+		t.handshakes += conn.handshakes
+		t.resumed += conn.resumed
+	}
+}
+
+// sentOver records the remote address of the connection the request is sent
+// over.
+func (t *requestTrace) sentOver(remote net.Addr) {
+	if t == nil {
+		return
+	}
+	t.remote = remote
+}
+
+func (t *requestTrace) wrote() {
+	if t == nil {
+		return
+	}
+	t.wroteAt = time.Now()
+}
+
+func (t *requestTrace) read() {
+	if t == nil || t.wroteAt.IsZero() {
+		return
+	}
+	if t.firstByteAt.IsZero() || t.firstByteAt.Before(t.wroteAt) {
+		t.firstByteAt = time.Now()
+	}
+}
+
+// phases returns the phase durations of a request that finished at end.
+// Time to first byte runs from the moment the request was written to the
+// first byte of the response, and the body phase from there to end.
+func (t *requestTrace) phases(end time.Time) requestPhases {
+	var phases requestPhases
+	if t.resolved {
+		phases.set(requestPhaseDNS, t.dns)
+	}
+	if t.dialed {
+		phases.set(requestPhaseConnect, t.connect)
+	}
+	if t.handshaken {
+		phases.set(requestPhaseTLS, t.tls)
+	}
+	if t.quicHandshaken {
+		phases.set(requestPhaseQUIC, t.quic)
+	}
+	if t.upgraded {
+		phases.set(requestPhaseUpgrade, t.upgrade)
+	}
+	if !t.wroteAt.IsZero() && t.firstByteAt.After(t.wroteAt) {
+		phases.set(requestPhaseTTFB, t.firstByteAt.Sub(t.wroteAt))
+		phases.set(requestPhaseBody, max(end.Sub(t.firstByteAt), 0))
+	}
+	return phases
+}
+
+// tracedConn reports the reads and writes of a connection to a trace.
+type tracedConn struct {
+	net.Conn
+
+	trace *requestTrace
+}
+
+func (c *tracedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.trace.sentOver(c.Conn.RemoteAddr())
+	c.trace.wrote()
+	return n, err //nolint:wrapcheck
+}
+
+func (c *tracedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.trace.read()
+	}
+	return n, err //nolint:wrapcheck
+}
+
+// tracedTLSConn reports the reads and writes of a TLS connection to a trace.
+// It sits above TLS, so the records of the handshake and those that follow
+// it, like TLS 1.3 session tickets, aren't taken for the response. It keeps
+// the Handshake method, which tells fasthttp the connection is TLS already.
+type tracedTLSConn struct {
+	*tls.Conn
+
+	trace *requestTrace
+}
+
+func (c *tracedTLSConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.trace.sentOver(c.Conn.RemoteAddr())
+	c.trace.wrote()
+	return n, err //nolint:wrapcheck
+}
+
+func (c *tracedTLSConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.trace.read()
+	}
+	return n, err //nolint:wrapcheck
+}
@@ -0,0 +1,121 @@
+package sarin
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// startTLSEchoServer serves TLS 1.3 on a free TCP port of 127.0.0.1 until the
+// test ends. It answers each 4-byte message with the same bytes, delay after
+// reading it. It returns the server's address.
+// The server asks for a client certificate, so it sends its session tickets
+// only once the handshake is done, like most servers do.
+func startTLSEchoServer(t *testing.T, delay time.Duration) string {
+	t.Helper()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{newSelfSignedCert(t)},
+		MinVersion:   tls.VersionTLS13,
+		ClientAuth:   tls.RequestClientCert,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() }) //nolint:errcheck
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close() //nolint:errcheck
+				message := make([]byte, 4)
+				for {
+					if _, err := io.ReadFull(conn, message); err != nil {
+						return
+					}
+					time.Sleep(delay)
+					if _, err := conn.Write(message); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestTracedDialFuncTTFB(t *testing.T) {
+	t.Parallel()
+
+	const delay = 100 * time.Millisecond
+	addr := startTLSEchoServer(t, delay)
+	trace := &requestTrace{}
+	dial := func(addr string, _ *requestTrace) (net.Conn, error) { return net.Dial("tcp", addr) }
+	// With a session cache, the client takes the session tickets of the server.
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: true, //nolint:gosec
+		MinVersion:         tls.VersionTLS13,
+		ClientSessionCache: tls.NewLRUClientSessionCache(1),
+	}
+
+	conn, err := tracedDialFunc(dial, trace, true, tlsConfig, 5*time.Second)(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close() //nolint:errcheck
+	if _, ok := conn.(interface{ Handshake() error }); !ok {
+		t.Fatal("the connection doesn't tell fasthttp it is TLS already")
+	}
+	if !trace.wroteAt.IsZero() || !trace.firstByteAt.IsZero() {
+		t.Error("the handshake was taken for the request")
+	}
+
+	// The server's session tickets wait to be read along with the first
+	// answer; they must not count as its first byte.
+	response := make([]byte, 4)
+	for range 2 {
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadFull(conn, response); err != nil {
+			t.Fatal(err)
+		}
+
+		phases := trace.phases(time.Now())
+		if !phases.measured[requestPhaseTTFB] {
+			t.Fatal("the time to first byte wasn't recorded")
+		}
+		if got := phases.durations[requestPhaseTTFB]; got < delay {
+			t.Errorf("got a time to first byte of %v, want at least %v", got, delay)
+		}
+		trace.reset()
+	}
+}
+
+func TestRequestTraceReadBeforeWrite(t *testing.T) {
+	t.Parallel()
+
+	var trace requestTrace
+	trace.read()
+	if !trace.firstByteAt.IsZero() {
+		t.Error("a read before the request was written was recorded")
+	}
+
+	trace.wrote()
+	time.Sleep(time.Millisecond)
+	trace.read()
+	firstByteAt := trace.firstByteAt
+	trace.read()
+	if trace.firstByteAt != firstByteAt {
+		t.Error("a later read replaced the first byte")
+	}
+	if phases := trace.phases(time.Now()); !phases.measured[requestPhaseTTFB] {
+		t.Error("the time to first byte wasn't recorded")
+	}
+}
@@ -82,6 +82,7 @@ type progressModel struct {
 	counter    *atomic.Uint64
 	maxValue   uint64
 	showBar    bool
+	status     func() string
 	ctx        context.Context //nolint:containedctx
 	stop       func()
 	cancelling bool
@@ -145,6 +146,10 @@ func (m progressModel) View() tea.View {
 		b.WriteString(strconv.FormatUint(m.maxValue, 10))
 		b.WriteString(" - ")
 		b.WriteString(time.Since(m.startTime).Round(time.Second / 10).String())
+		if m.status != nil {
+			b.WriteString(" - ")
+			b.WriteString(m.status())
+		}
 		b.WriteString("\n ")
 		b.WriteString(m.progress.ViewAs(float64(current) / float64(m.maxValue)))
 	}
@@ -168,6 +173,7 @@ type infiniteProgressModel struct {
 	counter    *atomic.Uint64
 	logs       []string
 	showBar    bool
+	status     func() string
 	ctx        context.Context //nolint:containedctx
 	quit       bool
 	stop       func()
@@ -239,6 +245,10 @@ func (m infiniteProgressModel) View() tea.View {
 		b.WriteString(m.spinner.View())
 		b.WriteString("  ")
 		b.WriteString(time.Since(m.startTime).Round(time.Second / 10).String())
+		if m.status != nil {
+			b.WriteString("  ")
+			b.WriteString(m.status())
+		}
 		b.WriteString("\n\n  ")
 		b.WriteString(helpLine(m.cancelling))
 	}
@@ -253,6 +263,7 @@ func (s sarin) streamProgress(
 	counter *atomic.Uint64,
 	logChannel <-chan runtimeLog,
 	showBar bool,
+	status func() string,
 ) {
 	var program *tea.Program
 	if total > 0 {
@@ -266,6 +277,7 @@ func (s sarin) streamProgress(
 			counter:   counter,
 			maxValue:  total,
 			showBar:   showBar,
+			status:    status,
 			ctx:       ctx,
 			stop:      stopCtrl.Stop,
 		}
@@ -295,6 +307,7 @@ func (s sarin) streamProgress(
 			counter:   counter,
 			logs:      make([]string, logBoxLines),
 			showBar:   showBar,
+			status:    status,
 			ctx:       ctx,
 			stop:      stopCtrl.Stop,
 			quit:      false,
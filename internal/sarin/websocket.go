@@ -0,0 +1,303 @@
+package sarin
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/valyala/fasthttp"
+	"go.aykhans.me/sarin/internal/types"
+	utilsSlice "go.aykhans.me/utils/slice"
+)
+
+// wsReadBuffer bounds how many messages the server may send ahead of the
+// worker reading them.
+const wsReadBuffer = 64
+
+// wsHandshakeHeaders are the request headers that are left out of the upgrade
+// request, since the WebSocket handshake sets them itself or they only apply
+// to the body, which is sent as a message instead.
+var wsHandshakeHeaders = []string{
+	fasthttp.HeaderHost,
+	fasthttp.HeaderContentLength,
+	fasthttp.HeaderContentType,
+	fasthttp.HeaderConnection,
+	fasthttp.HeaderTransferEncoding,
+	"Upgrade",
+	"Sec-WebSocket-Key",
+	"Sec-WebSocket-Version",
+	"Sec-WebSocket-Extensions",
+}
+
+// isWebSocketScheme reports whether scheme is ws or wss.
+func isWebSocketScheme(scheme string) bool {
+	return scheme == "ws" || scheme == "wss"
+}
+
+type wsRead struct {
+	message []byte
+	err     error
+}
+
+// wsClient sends the requests of a worker to one host as text messages over a
+// WebSocket connection, which it opens with the first request and again after
+// every disconnect. The path, headers and cookies of the request that opens
+// the connection go into the upgrade request; after that, only the bodies of
+// the requests are sent. A request is answered by the first message from the
+// server that matches the correlation ID of the request, the pattern or,
+// without either, any message. The answer is read into the response as the
+// body of a 101 response with the headers of the upgrade response, so that
+// assertions, extraction and the stats treat it like any other response.
+// It is NOT safe for concurrent use.
+type wsClient struct {
+	nextDial  func() dialFunc
+	isTLS     bool
+	tlsConfig *tls.Config
+	trace     *requestTrace
+	stats     *statsShard
+	// match picks the server's answer to a request. With a capture group,
+	// the group must capture the same correlation ID from the request and
+	// from its answer.
+	match     *regexp.Regexp
+	correlate bool
+
+	conn   *websocket.Conn
+	header http.Header
+	reads  chan wsRead
+	done   chan struct{}
+}
+
+func newWSClient(
+	dials []dialFunc,
+	isTLS bool,
+	tlsConfig *tls.Config,
+	trace *requestTrace,
+	stats *statsShard,
+	match *regexp.Regexp,
+) *wsClient {
+	return &wsClient{
+		nextDial:  utilsSlice.RandomCycle(nil, dials...),
+		isTLS:     isTLS,
+		tlsConfig: tlsConfig,
+		trace:     trace,
+		stats:     stats,
+		match:     match,
+		correlate: match != nil && match.NumSubexp() > 0,
+	}
+}
+
+// DoTimeout sends the body of req as a message and reads the answer into resp
+// within timeout, connecting first if the client isn't connected. A handshake
+// the server refuses is read into resp as it is, so it is keyed by its status.
+// A request that gets no answer in time fails with fasthttp.ErrTimeout and
+// leaves the connection open.
+// It can return the following errors:
+//   - types.ErrWebSocketCorrelationMissing
+func (client *wsClient) DoTimeout(req *fasthttp.Request, resp *fasthttp.Response, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	var id []byte
+	if client.correlate {
+		groups := client.match.FindSubmatch(req.Body())
+		if groups == nil {
+			return types.ErrWebSocketCorrelationMissing
+		}
+		id = groups[1]
+	}
+
+	// Answers that arrived after their request timed out are dropped, so
+	// they aren't taken for the answer to this one. A connection that was
+	// closed in the meantime is noticed here, and the message goes out on a
+	// new one.
+	client.discardReads()
+	if client.conn == nil {
+		refused, err := client.connect(req, resp, deadline)
+		if err != nil || refused {
+			return err
+		}
+	}
+
+	client.trace.sentOver(client.conn.RemoteAddr())
+	if err := client.conn.SetWriteDeadline(deadline); err != nil {
+		return client.disconnect(err)
+	}
+	err := client.conn.WriteMessage(websocket.TextMessage, req.Body())
+	client.trace.wrote()
+	if err != nil {
+		return client.disconnect(err)
+	}
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	for {
+		select {
+		case read := <-client.reads:
+			if read.err != nil {
+				return client.disconnect(read.err)
+			}
+			if !client.answers(read.message, id) {
+				continue
+			}
+			client.trace.read()
+			resp.Reset()
+			resp.SetStatusCode(fasthttp.StatusSwitchingProtocols)
+			for key, values := range client.header {
+				for _, value := range values {
+					resp.Header.Add(key, value)
+				}
+			}
+			resp.SetBody(read.message)
+			return nil
+		case <-timer.C:
+			return fasthttp.ErrTimeout
+		}
+	}
+}
+
+// answers reports whether message is the answer to the request with the
+// correlation ID id.
+func (client *wsClient) answers(message, id []byte) bool {
+	switch {
+	case client.match == nil:
+		return true
+	case client.correlate:
+		groups := client.match.FindSubmatch(message)
+		return groups != nil && bytes.Equal(groups[1], id)
+	default:
+		return client.match.Match(message)
+	}
+}
+
+// connect opens the WebSocket connection with an upgrade request built from
+// req, through the next dial function. It reports whether the server refused
+// the upgrade, in which case its response is read into resp.
+func (client *wsClient) connect(req *fasthttp.Request, resp *fasthttp.Response, deadline time.Time) (bool, error) {
+	dial := client.nextDial()
+
+	// The connection is dialed, and its TLS handshake done, here rather than
+	// in the dialer, so that they can be timed. Everything after that is the
+	// upgrade.
+	var dialedAt time.Time
+	netDial := func(_ context.Context, _, addr string) (net.Conn, error) {
+		conn, err := dial(addr, client.trace)
+		if err != nil {
+			return nil, err
+		}
+		if client.isTLS {
+			start := time.Now()
+			tlsConn := tls.Client(conn, client.tlsConfig)
+			err = tlsHandshake(tlsConn, deadline)
+			client.trace.handshakenIn(time.Since(start))
+			if err != nil {
+				conn.Close() //nolint:errcheck,gosec
+				return nil, err
+			}
+			client.trace.handshook(tlsConn.ConnectionState().DidResume)
+			conn = tlsConn
+		}
+		dialedAt = time.Now()
+		return conn, nil
+	}
+	dialer := websocket.Dialer{
+		NetDialContext:    netDial,
+		NetDialTLSContext: netDial,
+	}
+
+	header := make(http.Header)
+	for key, value := range req.Header.All() {
+		header.Add(string(key), string(value))
+	}
+	for _, key := range wsHandshakeHeaders {
+		header.Del(key)
+	}
+
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	conn, upgrade, err := dialer.DialContext(ctx, string(req.URI().Scheme())+"://"+string(req.Host())+string(req.URI().RequestURI()), header)
+	if !dialedAt.IsZero() {
+		client.trace.upgradedIn(time.Since(dialedAt))
+	}
+	if err != nil {
+		if errors.Is(err, websocket.ErrBadHandshake) && upgrade != nil {
+			defer upgrade.Body.Close() //nolint:errcheck
+			return true, fasthttpError(copyHTTPResponse(upgrade, resp))
+		}
+		return false, fasthttpError(err)
+	}
+
+	client.conn, client.header = conn, upgrade.Header
+	client.reads = make(chan wsRead, wsReadBuffer)
+	client.done = make(chan struct{})
+	go readMessages(conn, client.reads, client.done)
+	return false, nil
+}
+
+// readMessages hands the messages read from conn to reads until reading fails,
+// which it hands over as well, or done is closed.
+func readMessages(conn *websocket.Conn, reads chan<- wsRead, done <-chan struct{}) {
+	for {
+		_, message, err := conn.ReadMessage()
+		select {
+		case reads <- wsRead{message: message, err: err}:
+		case <-done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// discardReads drops the messages read so far, and disconnects if reading
+// failed.
+func (client *wsClient) discardReads() {
+	for client.conn != nil {
+		select {
+		case read := <-client.reads:
+			if read.err != nil {
+				client.disconnect(read.err) //nolint:errcheck,gosec
+			}
+		default:
+			return
+		}
+	}
+}
+
+// disconnect closes the connection, which was lost because of err, counts it
+// in the stats and returns the error to report for it. A close frame from the
+// server is reported by its code alone, without the reason text that comes
+// with it.
+func (client *wsClient) disconnect(err error) error {
+	if closeErr, ok := errors.AsType[*websocket.CloseError](err); ok {
+		err = &websocket.CloseError{Code: closeErr.Code}
+	}
+	client.stats.disconnected(err.Error())
+	client.close()
+	return err
+}
+
+func (client *wsClient) close() {
+	close(client.done)
+	client.conn.Close() //nolint:errcheck,gosec
+	client.conn, client.header, client.reads = nil, nil, nil
+}
+
+// CloseIdleConnections closes the connection normally, if it is open.
+func (client *wsClient) CloseIdleConnections() {
+	if client.conn == nil {
+		return
+	}
+	client.conn.WriteControl( //nolint:errcheck,gosec
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(time.Second),
+	)
+	client.close()
+}
@@ -0,0 +1,315 @@
+package sarin
+
+import (
+	"errors"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/valyala/fasthttp"
+	"go.aykhans.me/sarin/internal/types"
+)
+
+// wsServer is a WebSocket server that counts the connections upgraded by it
+// and keeps the headers of the last upgrade request.
+type wsServer struct {
+	addr     string
+	upgrades atomic.Int64
+
+	mu     sync.Mutex
+	header http.Header
+}
+
+// startWSServer serves WebSocket connections until the test ends. Each text
+// message is passed to answer along with the connection it came over, and a
+// request to /refuse is answered with 403 Forbidden instead of an upgrade.
+func startWSServer(t *testing.T, answer func(conn *websocket.Conn, message string)) *wsServer {
+	t.Helper()
+
+	server := &wsServer{}
+	upgrader := websocket.Upgrader{}
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/refuse" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		server.mu.Lock()
+		server.header = r.Header.Clone()
+		server.mu.Unlock()
+
+		conn, err := upgrader.Upgrade(w, r, http.Header{"X-Server": {"test"}})
+		if err != nil {
+			return
+		}
+		defer conn.Close() //nolint:errcheck
+		server.upgrades.Add(1)
+
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			answer(conn, string(message))
+		}
+	}))
+	t.Cleanup(httpServer.Close)
+	server.addr = strings.TrimPrefix(httpServer.URL, "http://")
+	return server
+}
+
+// newTestWSClient returns a client that connects to ws:// URLs directly, and
+// the stats shard it records its disconnects in.
+func newTestWSClient(t *testing.T, match *regexp.Regexp) (*wsClient, *statsShard) {
+	t.Helper()
+
+	local, err := newLocalAddrs(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dial := newDirectDialFunc(t.Context(), 5*time.Second, newResolver(nil, "", false, IPStrategyFirst), local)
+	stats := NewSarinResponseData(nil, nil, false).NewShard()
+	client := newWSClient([]dialFunc{dial}, false, nil, &requestTrace{}, stats, match)
+	t.Cleanup(client.CloseIdleConnections)
+	return client, stats
+}
+
+// wsSend sends body to rawURL through client and returns the status and body
+// of the response.
+func wsSend(client HostClient, rawURL, body string, timeout time.Duration) (int, string, error) {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(rawURL)
+	req.Header.Set("X-Token", "secret")
+	req.Header.SetContentType("application/json")
+	req.SetBodyString(body)
+	if err := client.DoTimeout(req, resp, timeout); err != nil {
+		return 0, "", err
+	}
+	return resp.StatusCode(), string(resp.Body()), nil
+}
+
+func TestWSClientUpgrade(t *testing.T) {
+	t.Parallel()
+
+	server := startWSServer(t, func(conn *websocket.Conn, message string) {
+		conn.WriteMessage(websocket.TextMessage, []byte("echo "+message)) //nolint:errcheck
+	})
+	client, _ := newTestWSClient(t, nil)
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI("ws://" + server.addr + "/chat?room=1")
+	req.Header.Set("X-Token", "secret")
+	req.Header.SetContentType("application/json")
+
+	for _, body := range []string{"hello", "again"} {
+		req.SetBodyString(body)
+		if err := client.DoTimeout(req, resp, 5*time.Second); err != nil {
+			t.Fatal(err)
+		}
+		if got := resp.StatusCode(); got != fasthttp.StatusSwitchingProtocols {
+			t.Errorf("got status %d, want %d", got, fasthttp.StatusSwitchingProtocols)
+		}
+		if got := string(resp.Header.Peek("X-Server")); got != "test" {
+			t.Errorf("got X-Server header %q from the upgrade response, want %q", got, "test")
+		}
+		if got, want := string(resp.Body()), "echo "+body; got != want {
+			t.Errorf("got body %q, want %q", got, want)
+		}
+	}
+
+	if got := server.upgrades.Load(); got != 1 {
+		t.Errorf("got %d upgrades, want 1", got)
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if got := server.header.Get("X-Token"); got != "secret" {
+		t.Errorf("got X-Token header %q in the upgrade request, want %q", got, "secret")
+	}
+	if got := server.header.Get(fasthttp.HeaderContentType); got != "" {
+		t.Errorf("got Content-Type header %q in the upgrade request, want none", got)
+	}
+}
+
+func TestWSClientRefusedUpgrade(t *testing.T) {
+	t.Parallel()
+
+	server := startWSServer(t, func(*websocket.Conn, string) {})
+	client, stats := newTestWSClient(t, nil)
+
+	status, body, err := wsSend(client, "ws://"+server.addr+"/refuse", "hello", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusForbidden || strings.TrimSpace(body) != "forbidden" {
+		t.Errorf("got status %d and body %q, want the refusal", status, body)
+	}
+	if client.conn != nil {
+		t.Error("got a connection after the upgrade was refused")
+	}
+	if len(stats.disconnects) != 0 {
+		t.Errorf("got disconnects %v for a refused upgrade", stats.disconnects)
+	}
+}
+
+func TestWSClientMatch(t *testing.T) {
+	t.Parallel()
+
+	// The server answers every request with messages for other requests
+	// first, and repeats the ID of the request in its answer.
+	idPattern := regexp.MustCompile(`"id":\s*(\d+)`)
+	answer := func(conn *websocket.Conn, message string) {
+		id := "0"
+		if groups := idPattern.FindStringSubmatch(message); groups != nil {
+			id = groups[1]
+		}
+		for _, reply := range []string{
+			`{"event": "tick"}`,
+			`{"id": 9` + id + `, "result": "other"}`,
+			`{"id": ` + id + `, "result": "ok"}`,
+		} {
+			conn.WriteMessage(websocket.TextMessage, []byte(reply)) //nolint:errcheck
+		}
+	}
+
+	tests := []struct {
+		name     string
+		match    string
+		body     string
+		wantBody string
+		wantErr  error
+	}{
+		{name: "Any message", match: "", body: `{"id": 1}`, wantBody: `{"event": "tick"}`},
+		{name: "Pattern", match: `"result"`, body: `{"id": 2}`, wantBody: `{"id": 92, "result": "other"}`},
+		{name: "Correlation ID", match: `"id":\s*(\d+)`, body: `{"id": 3}`, wantBody: `{"id": 3, "result": "ok"}`},
+		{name: "Correlation ID missing", match: `"id":\s*(\d+)`, body: `{"event": "ping"}`, wantErr: types.ErrWebSocketCorrelationMissing},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			server := startWSServer(t, answer)
+			var match *regexp.Regexp
+			if test.match != "" {
+				match = regexp.MustCompile(test.match)
+			}
+			client, _ := newTestWSClient(t, match)
+
+			// The second request checks that the messages left over from the
+			// first aren't taken for its answer.
+			for range 2 {
+				_, body, err := wsSend(client, "ws://"+server.addr+"/", test.body, 5*time.Second)
+				if !errors.Is(err, test.wantErr) {
+					t.Fatalf("got error %v, want %v", err, test.wantErr)
+				}
+				if body != test.wantBody {
+					t.Errorf("got body %q, want %q", body, test.wantBody)
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+		})
+	}
+}
+
+func TestWSClientTimeoutKeepsConnection(t *testing.T) {
+	t.Parallel()
+
+	server := startWSServer(t, func(conn *websocket.Conn, message string) {
+		if message == "slow" {
+			time.Sleep(300 * time.Millisecond)
+		}
+		conn.WriteMessage(websocket.TextMessage, []byte("echo "+message)) //nolint:errcheck
+	})
+	client, stats := newTestWSClient(t, nil)
+	rawURL := "ws://" + server.addr + "/"
+
+	if _, _, err := wsSend(client, rawURL, "slow", 100*time.Millisecond); !errors.Is(err, fasthttp.ErrTimeout) {
+		t.Fatalf("got error %v, want %v", err, fasthttp.ErrTimeout)
+	}
+	time.Sleep(400 * time.Millisecond)
+
+	// The late answer to the request that timed out is dropped.
+	_, body, err := wsSend(client, rawURL, "fast", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "echo fast" {
+		t.Errorf("got body %q, want %q", body, "echo fast")
+	}
+	if got := server.upgrades.Load(); got != 1 {
+		t.Errorf("got %d upgrades, want 1", got)
+	}
+	if len(stats.disconnects) != 0 {
+		t.Errorf("got disconnects %v after a timeout", stats.disconnects)
+	}
+}
+
+func TestWSClientDisconnect(t *testing.T) {
+	t.Parallel()
+
+	// The server closes the connection instead of answering "bye", with a
+	// reason text that differs every time.
+	var closes atomic.Int64
+	server := startWSServer(t, func(conn *websocket.Conn, message string) {
+		if message != "bye" {
+			conn.WriteMessage(websocket.TextMessage, []byte("echo "+message)) //nolint:errcheck
+			return
+		}
+		reason := "going away #" + strconv.FormatInt(closes.Add(1), 10)
+		conn.WriteControl( //nolint:errcheck
+			websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, reason),
+			time.Now().Add(time.Second),
+		)
+	})
+	client, stats := newTestWSClient(t, nil)
+	rawURL := "ws://" + server.addr + "/"
+
+	for range 2 {
+		_, _, err := wsSend(client, rawURL, "bye", 5*time.Second)
+		closeErr, ok := errors.AsType[*websocket.CloseError](err)
+		if !ok {
+			t.Fatalf("got error %v, want a *websocket.CloseError", err)
+		}
+		if closeErr.Code != websocket.CloseGoingAway || closeErr.Text != "" {
+			t.Errorf("got close error %q, want code %d without its reason text", closeErr, websocket.CloseGoingAway)
+		}
+		if client.conn != nil {
+			t.Error("the connection was kept after the server closed it")
+		}
+	}
+
+	// The next request connects again.
+	_, body, err := wsSend(client, rawURL, "hello", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "echo hello" {
+		t.Errorf("got body %q, want %q", body, "echo hello")
+	}
+	if got := server.upgrades.Load(); got != 3 {
+		t.Errorf("got %d upgrades, want 3", got)
+	}
+
+	// Both closes are counted under the same reason.
+	want := map[string]uint64{"websocket: close 1001 (going away)": 2}
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	if !maps.Equal(stats.disconnects, want) {
+		t.Errorf("got disconnects %v, want %v", stats.disconnects, want)
+	}
+}
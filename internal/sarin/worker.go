@@ -1,6 +1,8 @@
 package sarin
 
 import (
+	"context"
+	"iter"
 	"strconv"
 	"sync/atomic"
 	"time"
@@ -29,9 +31,90 @@ func statusCodeToString(code int) string {
 	return strconv.Itoa(code)
 }
 
+// messageSize returns the size of an HTTP message as written on the wire,
+// excluding any transfer encoding.
+func messageSize(header, body []byte) uint64 {
+	return uint64(len(header)) + uint64(len(body))
+}
+
+// newSentRequest describes a request that finished at end with err. A
+// WebSocket message and its answer have no headers of their own, so only
+// their bodies are counted. In stream mode, the response also carries the
+// events the trace followed.
+func (s sarin) newSentRequest(trace *requestTrace, end time.Time, req *fasthttp.Request, resp *fasthttp.Response, err error) sentRequest {
+	sent := sentRequest{
+		phases:            trace.phases(end),
+		handshakes:        trace.handshakes,
+		resumedHandshakes: trace.resumed,
+		remote:            trace.remote,
+	}
+	if s.webSocket {
+		sent.requestBytes = uint64(len(req.Body()))
+	} else {
+		sent.requestBytes = messageSize(req.Header.Header(), req.Body())
+	}
+	if err == nil {
+		sent.received = true
+		if s.webSocket {
+			sent.responseBytes = uint64(len(resp.Body()))
+		} else {
+			sent.responseBytes = messageSize(resp.Header.Header(), resp.Body())
+		}
+		if events := trace.streamEvents(); events != nil {
+			sent.stream = events
+			sent.firstEvent = events.firstAt.Sub(trace.wroteAt)
+		}
+	}
+	return sent
+}
+
+// job is one unit of work handed to a worker.
+type job struct {
+	// scheduledAt is when the job was meant to be sent. It is set only for
+	// rate-scheduled jobs, where it lets the stats measure latency from the
+	// intended send time rather than the actual one.
+	scheduledAt time.Time
+}
+
+// paceJobs hands the jobs on to a worker at most once every interval, so that
+// it sends its requests, or WebSocket messages, no faster than that. It stops
+// waiting, and taking jobs, once ctx is done.
+func paceJobs(ctx context.Context, jobs iter.Seq[job], interval time.Duration) iter.Seq[job] {
+	return func(yield func(job) bool) {
+		timer := time.NewTimer(interval)
+		timer.Stop()
+		defer timer.Stop()
+
+		for j := range jobs {
+			next := time.Now().Add(interval)
+			if !yield(j) {
+				return
+			}
+			if wait := time.Until(next); wait > 0 {
+				timer.Reset(wait)
+				select {
+				case <-ctx.Done():
+					return
+				case <-timer.C:
+				}
+			}
+		}
+	}
+}
+
+// channelJobs adapts a jobs channel to the sequence workers consume.
+func channelJobs(jobs <-chan job) iter.Seq[job] {
+	return func(yield func(job) bool) {
+		for j := range jobs {
+			if !yield(j) {
+				return
+			}
+		}
+	}
+}
+
 func (s sarin) Worker(
-	jobs <-chan struct{},
-	hostClientGenerator HostClientGenerator,
+	jobs iter.Seq[job],
 	counter *atomic.Uint64,
 	sendLog runtimeLogger,
 	sendRespLog respLogger,
@@ -53,16 +136,33 @@ func (s sarin) Worker(
 		defer scriptTransformer.Close()
 	}
 
-	requestGenerator, isDynamic := NewRequestGenerator(
-		s.methods, s.requestURL, s.params, s.headers, s.cookies, s.bodies, s.values, s.fileCache, scriptTransformer,
+	// Each worker records into its own shard, so stats collection doesn't
+	// serialise the workers. It also has its own clients, whose connections
+	// report the phases of its requests to trace.
+	var (
+		stats *statsShard
+		trace *requestTrace
 	)
+	if s.collectStats {
+		stats = s.responses.NewShard()
+		trace = &requestTrace{}
+		if s.stream {
+			trace.events = &streamEvents{}
+		}
+	}
+
+	// The cookie jar belongs to the worker, the way it would to a single user.
+	jar := newCookieJar(s.cookieJar, s.cookieJarReset)
+
+	requestGenerator, hostClientGenerator, flow, clientPool, isDynamic := s.newWorkerGenerators(stats, trace, jar, scriptTransformer)
+	defer clientPool.closeIdleConnections()
 
 	if s.dryRun {
 		switch {
 		case s.collectStats && isDynamic:
-			s.workerDryRunStatsWithDynamic(jobs, req, requestGenerator, counter, sendLog)
+			s.workerDryRunStatsWithDynamic(jobs, stats, req, requestGenerator, counter, sendLog)
 		case s.collectStats && !isDynamic:
-			s.workerDryRunStatsWithStatic(jobs, req, requestGenerator, counter, sendLog)
+			s.workerDryRunStatsWithStatic(jobs, stats, req, requestGenerator, counter, sendLog)
 		case !s.collectStats && isDynamic:
 			s.workerDryRunNoStatsWithDynamic(jobs, req, requestGenerator, counter, sendLog)
 		default:
@@ -71,11 +171,11 @@ func (s sarin) Worker(
 	} else {
 		switch {
 		case s.collectStats && isDynamic:
-			s.workerStatsWithDynamic(jobs, req, resp, requestGenerator, hostClientGenerator, counter, sendLog, sendRespLog)
+			s.workerStatsWithDynamic(jobs, stats, trace, req, resp, requestGenerator, hostClientGenerator, flow, jar, counter, sendLog, sendRespLog)
 		case s.collectStats && !isDynamic:
-			s.workerStatsWithStatic(jobs, req, resp, requestGenerator, hostClientGenerator, counter, sendLog, sendRespLog)
+			s.workerStatsWithStatic(jobs, stats, trace, req, resp, requestGenerator, hostClientGenerator, counter, sendLog, sendRespLog)
 		case !s.collectStats && isDynamic:
-			s.workerNoStatsWithDynamic(jobs, req, resp, requestGenerator, hostClientGenerator, counter, sendLog, sendRespLog)
+			s.workerNoStatsWithDynamic(jobs, req, resp, requestGenerator, hostClientGenerator, flow, jar, counter, sendLog, sendRespLog)
 		default:
 			s.workerNoStatsWithStatic(jobs, req, resp, requestGenerator, hostClientGenerator, counter, sendLog, sendRespLog)
 		}
@@ -83,33 +183,45 @@ func (s sarin) Worker(
 }
 
 func (s sarin) workerStatsWithDynamic(
-	jobs <-chan struct{},
+	jobs iter.Seq[job],
+	stats *statsShard,
+	trace *requestTrace,
 	req *fasthttp.Request,
 	resp *fasthttp.Response,
 	requestGenerator RequestGenerator,
 	hostClientGenerator HostClientGenerator,
+	flow *workerFlow,
+	jar *cookieJar,
 	counter *atomic.Uint64,
 	sendLog runtimeLogger,
 	sendRespLog respLogger,
 ) {
-	for range jobs {
+	for j := range jobs {
 		req.Reset()
 
 		if err := requestGenerator(req); err != nil {
-			s.responses.Add(err.Error(), 0)
+			flow.fail()
+			stats.Add(err.Error(), 0, j.scheduledAt, time.Now(), nil)
 			sendLog(runtimeLogLevelError, err.Error())
 			counter.Add(1)
 			continue
 		}
 
+		trace.reset()
 		startTime := time.Now()
 		err := hostClientGenerator().DoTimeout(req, resp, s.timeout)
-		respDuration := time.Since(startTime)
+		endTime := time.Now()
+		respDuration := endTime.Sub(startTime)
+		sent := s.newSentRequest(trace, endTime, req, resp, err)
 
 		if err != nil {
-			s.responses.Add(err.Error(), respDuration)
+			s.abort.requestFailed()
+			flow.fail()
+			stats.Add(err.Error(), respDuration, j.scheduledAt, endTime, &sent)
 		} else {
-			s.responses.Add(statusCodeToString(resp.StatusCode()), respDuration)
+			s.abort.responseReceived()
+			jar.store(req, resp)
+			stats.Add(s.responseKey(resp, flow, sendLog), respDuration, j.scheduledAt, endTime, &sent)
 			sendRespLog(respDuration, resp)
 		}
 		counter.Add(1)
@@ -117,7 +229,9 @@ func (s sarin) workerStatsWithDynamic(
 }
 
 func (s sarin) workerStatsWithStatic(
-	jobs <-chan struct{},
+	jobs iter.Seq[job],
+	stats *statsShard,
+	trace *requestTrace,
 	req *fasthttp.Request,
 	resp *fasthttp.Response,
 	requestGenerator RequestGenerator,
@@ -128,22 +242,27 @@ func (s sarin) workerStatsWithStatic(
 ) {
 	if err := requestGenerator(req); err != nil {
 		// Static request generation failed - record all jobs as errors
-		for range jobs {
-			s.responses.Add(err.Error(), 0)
+		for j := range jobs {
+			stats.Add(err.Error(), 0, j.scheduledAt, time.Now(), nil)
 			sendLog(runtimeLogLevelError, err.Error())
 			counter.Add(1)
 		}
 		return
 	}
 
-	for range jobs {
+	for j := range jobs {
+		trace.reset()
 		startTime := time.Now()
 		err := hostClientGenerator().DoTimeout(req, resp, s.timeout)
-		respDuration := time.Since(startTime)
+		endTime := time.Now()
+		respDuration := endTime.Sub(startTime)
+		sent := s.newSentRequest(trace, endTime, req, resp, err)
 		if err != nil {
-			s.responses.Add(err.Error(), respDuration)
+			s.abort.requestFailed()
+			stats.Add(err.Error(), respDuration, j.scheduledAt, endTime, &sent)
 		} else {
-			s.responses.Add(statusCodeToString(resp.StatusCode()), respDuration)
+			s.abort.responseReceived()
+			stats.Add(s.responseKey(resp, nil, sendLog), respDuration, j.scheduledAt, endTime, &sent)
 			sendRespLog(respDuration, resp)
 		}
 		counter.Add(1)
@@ -151,11 +270,13 @@ func (s sarin) workerStatsWithStatic(
 }
 
 func (s sarin) workerNoStatsWithDynamic(
-	jobs <-chan struct{},
+	jobs iter.Seq[job],
 	req *fasthttp.Request,
 	resp *fasthttp.Response,
 	requestGenerator RequestGenerator,
 	hostClientGenerator HostClientGenerator,
+	flow *workerFlow,
+	jar *cookieJar,
 	counter *atomic.Uint64,
 	sendLog runtimeLogger,
 	sendRespLog respLogger,
@@ -163,21 +284,28 @@ func (s sarin) workerNoStatsWithDynamic(
 	for range jobs {
 		req.Reset()
 		if err := requestGenerator(req); err != nil {
+			flow.fail()
 			sendLog(runtimeLogLevelError, err.Error())
 			counter.Add(1)
 			continue
 		}
 		startTime := time.Now()
 		err := hostClientGenerator().DoTimeout(req, resp, s.timeout)
-		if err == nil {
+		if err != nil {
+			s.abort.requestFailed()
+			flow.fail()
+		} else {
+			s.abort.responseReceived()
+			jar.store(req, resp)
 			sendRespLog(time.Since(startTime), resp)
+			s.responseKey(resp, flow, sendLog)
 		}
 		counter.Add(1)
 	}
 }
 
 func (s sarin) workerNoStatsWithStatic(
-	jobs <-chan struct{},
+	jobs iter.Seq[job],
 	req *fasthttp.Request,
 	resp *fasthttp.Response,
 	requestGenerator RequestGenerator,
@@ -199,36 +327,44 @@ func (s sarin) workerNoStatsWithStatic(
 	for range jobs {
 		startTime := time.Now()
 		err := hostClientGenerator().DoTimeout(req, resp, s.timeout)
-		if err == nil {
+		if err != nil {
+			s.abort.requestFailed()
+		} else {
+			s.abort.responseReceived()
 			sendRespLog(time.Since(startTime), resp)
+			s.responseKey(resp, nil, sendLog)
 		}
 		counter.Add(1)
 	}
 }
 
 func (s sarin) workerDryRunStatsWithDynamic(
-	jobs <-chan struct{},
+	jobs iter.Seq[job],
+	stats *statsShard,
 	req *fasthttp.Request,
 	requestGenerator RequestGenerator,
 	counter *atomic.Uint64,
 	sendLog runtimeLogger,
 ) {
-	for range jobs {
+	for j := range jobs {
 		req.Reset()
 		startTime := time.Now()
-		if err := requestGenerator(req); err != nil {
-			s.responses.Add(err.Error(), time.Since(startTime))
+		err := requestGenerator(req)
+		endTime := time.Now()
+		if err != nil {
+			stats.Add(err.Error(), endTime.Sub(startTime), j.scheduledAt, endTime, nil)
 			sendLog(runtimeLogLevelError, err.Error())
 			counter.Add(1)
 			continue
 		}
-		s.responses.Add(dryRunResponseKey, time.Since(startTime))
+		stats.Add(dryRunResponseKey, endTime.Sub(startTime), j.scheduledAt, endTime, nil)
 		counter.Add(1)
 	}
 }
 
 func (s sarin) workerDryRunStatsWithStatic(
-	jobs <-chan struct{},
+	jobs iter.Seq[job],
+	stats *statsShard,
 	req *fasthttp.Request,
 	requestGenerator RequestGenerator,
 	counter *atomic.Uint64,
@@ -236,22 +372,22 @@ func (s sarin) workerDryRunStatsWithStatic(
 ) {
 	if err := requestGenerator(req); err != nil {
 		// Static request generation failed - record all jobs as errors
-		for range jobs {
-			s.responses.Add(err.Error(), 0)
+		for j := range jobs {
+			stats.Add(err.Error(), 0, j.scheduledAt, time.Now(), nil)
 			sendLog(runtimeLogLevelError, err.Error())
 			counter.Add(1)
 		}
 		return
 	}
 
-	for range jobs {
-		s.responses.Add(dryRunResponseKey, 0)
+	for j := range jobs {
+		stats.Add(dryRunResponseKey, 0, j.scheduledAt, time.Now(), nil)
 		counter.Add(1)
 	}
 }
 
 func (s sarin) workerDryRunNoStatsWithDynamic(
-	jobs <-chan struct{},
+	jobs iter.Seq[job],
 	req *fasthttp.Request,
 	requestGenerator RequestGenerator,
 	counter *atomic.Uint64,
@@ -267,7 +403,7 @@ func (s sarin) workerDryRunNoStatsWithDynamic(
 }
 
 func (s sarin) workerDryRunNoStatsWithStatic(
-	jobs <-chan struct{},
+	jobs iter.Seq[job],
 	req *fasthttp.Request,
 	requestGenerator RequestGenerator,
 	counter *atomic.Uint64,
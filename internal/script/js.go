@@ -86,6 +86,7 @@ func (e *JsEngine) requestDataToObject(req *RequestData) goja.Value {
 	obj := e.runtime.NewObject()
 
 	_ = obj.Set("method", req.Method)
+	_ = obj.Set("host", req.Host)
 	_ = obj.Set("path", req.Path)
 	_ = obj.Set("body", req.Body)
 
@@ -129,6 +130,11 @@ func (e *JsEngine) objectToRequestData(val goja.Value, req *RequestData) error {
 		req.Method = v.String()
 	}
 
+	// Host
+	if v := obj.Get("host"); v != nil && !goja.IsUndefined(v) {
+		req.Host = v.String()
+	}
+
 	// Path
 	if v := obj.Get("path"); v != nil && !goja.IsUndefined(v) {
 		req.Path = v.String()
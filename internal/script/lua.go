@@ -90,6 +90,7 @@ func (e *LuaEngine) requestDataToTable(req *RequestData) *lua.LTable {
 	t := L.NewTable()
 
 	t.RawSetString("method", lua.LString(req.Method))
+	t.RawSetString("host", lua.LString(req.Host))
 	t.RawSetString("path", lua.LString(req.Path))
 	t.RawSetString("body", lua.LString(req.Body))
 
@@ -136,6 +137,11 @@ func (e *LuaEngine) tableToRequestData(t *lua.LTable, req *RequestData) {
 		req.Method = string(v.(lua.LString))
 	}
 
+	// Host
+	if v := t.RawGetString("host"); v.Type() == lua.LTString {
+		req.Host = string(v.(lua.LString))
+	}
+
 	// Path
 	if v := t.RawGetString("path"); v.Type() == lua.LTString {
 		req.Path = string(v.(lua.LString))
@@ -17,6 +17,7 @@ import (
 // Headers, Params, and Cookies use []string values to support multiple values per key.
 type RequestData struct {
 	Method  string              `json:"method"`
+	Host    string              `json:"host"`
 	Path    string              `json:"path"`
 	Headers map[string][]string `json:"headers"`
 	Params  map[string][]string `json:"params"`
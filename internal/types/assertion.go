@@ -0,0 +1,41 @@
+package types
+
+import "regexp"
+
+// Assertions are checks every response must pass. A response that fails one
+// is counted as an assertion failure instead of under its status code.
+type Assertions struct {
+	// Status is the set of expected status codes and classes. Empty allows
+	// any status.
+	Status      []StatusPattern
+	Headers     []HeaderAssertion
+	Body        []*regexp.Regexp
+	JSON        []JSONAssertion
+	MaxBodySize *uint64
+}
+
+// IsEmpty reports whether there is nothing to check.
+func (assertions Assertions) IsEmpty() bool {
+	return len(assertions.Status) == 0 &&
+		len(assertions.Headers) == 0 &&
+		len(assertions.Body) == 0 &&
+		len(assertions.JSON) == 0 &&
+		assertions.MaxBodySize == nil
+}
+
+// HeaderAssertion checks a response header. With neither Equals nor Contains
+// set, the header only has to be present.
+type HeaderAssertion struct {
+	Name     string
+	Equals   *string
+	Contains *string
+}
+
+// JSONAssertion checks a field of a JSON response body. Path is a
+// dot-separated list of object keys and array indexes (e.g. "data.items.0.id"),
+// and "#" gives the length of an array. With Equals nil, the field only has to
+// exist; a pointer to nil expects a JSON null.
+type JSONAssertion struct {
+	Path   string
+	Equals *any
+}
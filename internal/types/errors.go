@@ -149,6 +149,26 @@ func (e FileReadError) Unwrap() error {
 	return e.Err
 }
 
+type FileWriteError struct {
+	Path string
+	Err  error
+}
+
+func NewFileWriteError(path string, err error) FileWriteError {
+	if err == nil {
+		err = errNoError
+	}
+	return FileWriteError{path, err}
+}
+
+func (e FileWriteError) Error() string {
+	return fmt.Sprintf("failed to write file %s: %v", e.Path, e.Err)
+}
+
+func (e FileWriteError) Unwrap() error {
+	return e.Err
+}
+
 type HTTPFetchError struct {
 	URL string
 	Err error
@@ -393,6 +413,260 @@ func (e ProxyDialError) Unwrap() error {
 	return e.Err
 }
 
+// ======================================== Dial ========================================
+
+type HostResolveError struct {
+	Host string
+}
+
+func NewHostResolveError(host string) HostResolveError {
+	return HostResolveError{host}
+}
+
+func (e HostResolveError) Error() string {
+	return "no IP addresses found for host: " + e.Host
+}
+
+type ResolveParseError struct {
+	Err error
+}
+
+func NewResolveParseError(err error) ResolveParseError {
+	if err == nil {
+		err = errNoError
+	}
+	return ResolveParseError{err}
+}
+
+func (e ResolveParseError) Error() string {
+	return "failed to parse resolve override: " + e.Err.Error()
+}
+
+func (e ResolveParseError) Unwrap() error {
+	return e.Err
+}
+
+type LocalAddressParseError struct {
+	Value string
+	Err   error
+}
+
+func NewLocalAddressParseError(value string, err error) LocalAddressParseError {
+	if err == nil {
+		err = errNoError
+	}
+	return LocalAddressParseError{value, err}
+}
+
+func (e LocalAddressParseError) Error() string {
+	return "invalid local address \"" + e.Value + "\": " + e.Err.Error()
+}
+
+func (e LocalAddressParseError) Unwrap() error {
+	return e.Err
+}
+
+type LocalAddressBindError struct {
+	Addr string
+	Err  error
+}
+
+func NewLocalAddressBindError(addr string, err error) LocalAddressBindError {
+	if err == nil {
+		err = errNoError
+	}
+	return LocalAddressBindError{addr, err}
+}
+
+func (e LocalAddressBindError) Error() string {
+	return "failed to bind local address " + e.Addr + ": " + e.Err.Error()
+}
+
+func (e LocalAddressBindError) Unwrap() error {
+	return e.Err
+}
+
+type LocalAddressFamilyError struct {
+	Target string
+}
+
+func NewLocalAddressFamilyError(target string) LocalAddressFamilyError {
+	return LocalAddressFamilyError{target}
+}
+
+func (e LocalAddressFamilyError) Error() string {
+	return "no local address of the same IP version as " + e.Target
+}
+
+// ======================================== WebSocket ========================================
+
+var (
+	ErrWebSocketCorrelationMissing = errors.New("message has no correlation ID matching the ws match pattern")
+)
+
+// ======================================== gRPC ========================================
+
+var (
+	ErrGRPCServiceNotFound       = errors.New("service not found")
+	ErrGRPCMethodNotFound        = errors.New("method not found")
+	ErrGRPCMessageCount          = errors.New("request body must hold exactly one message, since the method doesn't stream requests")
+	ErrGRPCMessageCompressed     = errors.New("response message is compressed")
+	ErrGRPCMessageTruncated      = errors.New("response message is truncated")
+	ErrGRPCReflectionFileMissing = errors.New("server reflection didn't return the requested file")
+)
+
+type GRPCMethodResolveError struct {
+	Method string
+	Err    error
+}
+
+func NewGRPCMethodResolveError(method string, err error) GRPCMethodResolveError {
+	if err == nil {
+		err = errNoError
+	}
+	return GRPCMethodResolveError{method, err}
+}
+
+func (e GRPCMethodResolveError) Error() string {
+	return fmt.Sprintf("failed to resolve gRPC method %q: %v", e.Method, e.Err)
+}
+
+func (e GRPCMethodResolveError) Unwrap() error {
+	return e.Err
+}
+
+type GRPCReflectionError struct {
+	Status  string
+	Message string
+}
+
+func NewGRPCReflectionError(status string, message string) GRPCReflectionError {
+	return GRPCReflectionError{status, message}
+}
+
+func (e GRPCReflectionError) Error() string {
+	if e.Message == "" {
+		return "server reflection failed with status " + e.Status
+	}
+	return "server reflection failed with status " + e.Status + ": " + e.Message
+}
+
+type GRPCMessageEncodeError struct {
+	Err error
+}
+
+func NewGRPCMessageEncodeError(err error) GRPCMessageEncodeError {
+	if err == nil {
+		err = errNoError
+	}
+	return GRPCMessageEncodeError{err}
+}
+
+func (e GRPCMessageEncodeError) Error() string {
+	return "invalid gRPC request message: " + e.Err.Error()
+}
+
+func (e GRPCMessageEncodeError) Unwrap() error {
+	return e.Err
+}
+
+type GRPCMessageDecodeError struct {
+	Err error
+}
+
+func NewGRPCMessageDecodeError(err error) GRPCMessageDecodeError {
+	if err == nil {
+		err = errNoError
+	}
+	return GRPCMessageDecodeError{err}
+}
+
+func (e GRPCMessageDecodeError) Error() string {
+	return "invalid gRPC response message: " + e.Err.Error()
+}
+
+func (e GRPCMessageDecodeError) Unwrap() error {
+	return e.Err
+}
+
+// ======================================== TLS ========================================
+
+var (
+	ErrTLSNoCertificates = errors.New("no PEM certificates found")
+)
+
+type TLSLoadError struct {
+	Source string
+	Err    error
+}
+
+func NewTLSLoadError(source string, err error) TLSLoadError {
+	if err == nil {
+		err = errNoError
+	}
+	return TLSLoadError{source, err}
+}
+
+func (e TLSLoadError) Error() string {
+	return fmt.Sprintf("failed to load TLS file %s: %v", e.Source, e.Err)
+}
+
+func (e TLSLoadError) Unwrap() error {
+	return e.Err
+}
+
+// ======================================== Percentile ========================================
+
+type PercentileParseError struct {
+	Value string
+	Err   error
+}
+
+func NewPercentileParseError(value string, err error) PercentileParseError {
+	if err == nil {
+		err = errNoError
+	}
+	return PercentileParseError{value, err}
+}
+
+func (e PercentileParseError) Error() string {
+	return "failed to parse percentile '" + e.Value + "': " + e.Err.Error()
+}
+
+func (e PercentileParseError) Unwrap() error {
+	return e.Err
+}
+
+// ======================================== Threshold ========================================
+
+var (
+	ErrThresholdOperatorMissing = errors.New("expected a comparison (<, <=, >, >=, ==)")
+	ErrThresholdScopeInvalid    = errors.New("scope must be a status code (e.g. 503) or a status class (e.g. 5xx)")
+	ErrThresholdMetricUnknown   = errors.New("unknown metric (possible values: count, rps, rate, error_rate, min, max, avg, pNN)")
+	ErrThresholdPercentileRange = errors.New("percentile must be greater than 0 and at most 100")
+	ErrThresholdErrorRateScoped = errors.New("error_rate can't be limited to a status code")
+)
+
+type ThresholdParseError struct {
+	Value string
+	Err   error
+}
+
+func NewThresholdParseError(value string, err error) ThresholdParseError {
+	if err == nil {
+		err = errNoError
+	}
+	return ThresholdParseError{value, err}
+}
+
+func (e ThresholdParseError) Error() string {
+	return "failed to parse threshold '" + e.Value + "': " + e.Err.Error()
+}
+
+func (e ThresholdParseError) Unwrap() error {
+	return e.Err
+}
+
 // ======================================== Script ========================================
 
 var (
@@ -0,0 +1,47 @@
+package types
+
+import "regexp"
+
+type ExtractorSource string
+
+const (
+	ExtractorSourceJSON   ExtractorSource = "json"
+	ExtractorSourceRegex  ExtractorSource = "regex"
+	ExtractorSourceHeader ExtractorSource = "header"
+	ExtractorSourceCookie ExtractorSource = "cookie"
+)
+
+// Extractor takes a value from a response and stores it under Name in the
+// template values of the following steps.
+type Extractor struct {
+	Name   string
+	Source ExtractorSource
+	// Expression is the JSON path, regular expression, header name or cookie
+	// name, depending on Source. JSON paths are written as in JSONAssertion.
+	Expression string
+	// Pattern is the compiled Expression of a regex extractor. Its first
+	// capture group is extracted, or the whole match if it has none.
+	Pattern *regexp.Regexp
+}
+
+// FlowStep is one request of a flow.
+type FlowStep struct {
+	Name string
+	Request
+	Extract []Extractor
+}
+
+// Flow is a sequence of requests that every worker sends in order, over and
+// over, passing extracted values from each step to the following ones.
+type Flow []FlowStep
+
+// HaveURLs reports whether there are steps and every one of them sets its own
+// URL, in which case no top-level URL is needed.
+func (flow Flow) HaveURLs() bool {
+	for _, step := range flow {
+		if step.URL == nil {
+			return false
+		}
+	}
+	return len(flow) > 0
+}
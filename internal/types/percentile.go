@@ -0,0 +1,36 @@
+package types
+
+import (
+	"strconv"
+	"strings"
+)
+
+type Percentiles []float64
+
+func (percentiles Percentiles) String() string {
+	parts := make([]string, len(percentiles))
+	for i, percentile := range percentiles {
+		parts[i] = strconv.FormatFloat(percentile, 'f', -1, 64)
+	}
+	return strings.Join(parts, ",")
+}
+
+// Parse parses a comma-separated list of percentiles (e.g. "50,99.9") and
+// appends them to the list. Empty items are skipped.
+// It can return the following errors:
+//   - PercentileParseError
+func (percentiles *Percentiles) Parse(rawValue string) error {
+	for part := range strings.SplitSeq(rawValue, ",") {
+		value := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(part), "p"))
+		if value == "" {
+			continue
+		}
+
+		percentile, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return NewPercentileParseError(part, err)
+		}
+		*percentiles = append(*percentiles, percentile)
+	}
+	return nil
+}
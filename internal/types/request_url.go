@@ -0,0 +1,60 @@
+package types
+
+import (
+	"net/url"
+	"strings"
+)
+
+// templateHostPlaceholder stands in for a host with template actions, which
+// net/url doesn't accept, while the rest of the URL is parsed or formatted.
+const templateHostPlaceholder = "sarin-template-host"
+
+// ParseRequestURL parses a request URL like url.Parse, except that its host may
+// contain template actions (e.g. "http://api-{{ .Values.shard }}.internal/").
+// Such a host, along with its port, is kept as it is in the URL's Host.
+func ParseRequestURL(rawURL string) (*url.URL, error) {
+	scheme, rest, found := strings.Cut(rawURL, "://")
+	if !found {
+		return url.Parse(rawURL) //nolint:wrapcheck
+	}
+
+	// The authority ends at the first '/', '?' or '#' outside of an action.
+	authorityEnd := len(rest)
+	for i := 0; i < len(rest); i++ {
+		if strings.HasPrefix(rest[i:], "{{") {
+			if end := strings.Index(rest[i:], "}}"); end != -1 {
+				i += end + 1
+				continue
+			}
+		}
+		if strings.IndexByte("/?#", rest[i]) != -1 {
+			authorityEnd = i
+			break
+		}
+	}
+
+	authority := rest[:authorityEnd]
+	userInfoEnd := strings.LastIndexByte(authority, '@') + 1
+	host := authority[userInfoEnd:]
+	if !strings.Contains(host, "{{") {
+		return url.Parse(rawURL) //nolint:wrapcheck
+	}
+
+	parsed, err := url.Parse(scheme + "://" + authority[:userInfoEnd] + templateHostPlaceholder + rest[authorityEnd:])
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+	parsed.Host = host
+	return parsed, nil
+}
+
+// RequestURLString returns requestURL as a string like requestURL.String(),
+// without escaping the template actions of its host.
+func RequestURLString(requestURL *url.URL) string {
+	if !strings.Contains(requestURL.Host, "{{") {
+		return requestURL.String()
+	}
+	withoutHost := *requestURL
+	withoutHost.Host = templateHostPlaceholder
+	return strings.Replace(withoutHost.String(), templateHostPlaceholder, requestURL.Host, 1)
+}
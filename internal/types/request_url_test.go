@@ -0,0 +1,114 @@
+package types
+
+import "testing"
+
+func TestParseRequestURL(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		rawURL   string
+		scheme   string
+		user     string
+		host     string
+		path     string
+		rawQuery string
+		// str is how the URL is written back by RequestURLString.
+		str string
+	}{
+		{
+			"http://example.com/users?page=2",
+			"http", "", "example.com", "/users", "page=2",
+			"http://example.com/users?page=2",
+		},
+		{
+			"http://api-{{ .Values.shard }}.internal/",
+			"http", "", "api-{{ .Values.shard }}.internal", "/", "",
+			"http://api-{{ .Values.shard }}.internal/",
+		},
+		{
+			"https://{{ .Values.host }}:8443/health?full=1",
+			"https", "", "{{ .Values.host }}:8443", "/health", "full=1",
+			"https://{{ .Values.host }}:8443/health?full=1",
+		},
+		{
+			"http://api.internal:{{ .Values.port }}",
+			"http", "", "api.internal:{{ .Values.port }}", "", "",
+			"http://api.internal:{{ .Values.port }}",
+		},
+		{
+			// A '/' in an action doesn't end the host.
+			"http://{{ index .Values \"a/b\" }}/path",
+			"http", "", "{{ index .Values \"a/b\" }}", "/path", "",
+			"http://{{ index .Values \"a/b\" }}/path",
+		},
+		{
+			"http://user:secret@{{ .Values.host }}:8080/",
+			"http", "user:secret", "{{ .Values.host }}:8080", "/", "",
+			"http://user:secret@{{ .Values.host }}:8080/",
+		},
+		{
+			"http://user@example.com:8080/",
+			"http", "user", "example.com:8080", "/", "",
+			"http://user@example.com:8080/",
+		},
+		{
+			"http://[::1]:8080/users",
+			"http", "", "[::1]:8080", "/users", "",
+			"http://[::1]:8080/users",
+		},
+		{
+			"https://[2001:db8::1]/",
+			"https", "", "[2001:db8::1]", "/", "",
+			"https://[2001:db8::1]/",
+		},
+		{
+			"http://[::1]:8080/{{ .Values.path }}",
+			"http", "", "[::1]:8080", "/{{ .Values.path }}", "",
+			"http://[::1]:8080/%7B%7B%20.Values.path%20%7D%7D",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.rawURL, func(t *testing.T) {
+			t.Parallel()
+
+			parsed, err := ParseRequestURL(test.rawURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parsed.Scheme != test.scheme {
+				t.Errorf("got scheme %q, want %q", parsed.Scheme, test.scheme)
+			}
+			if got := parsed.User.String(); got != test.user {
+				t.Errorf("got user info %q, want %q", got, test.user)
+			}
+			if parsed.Host != test.host {
+				t.Errorf("got host %q, want %q", parsed.Host, test.host)
+			}
+			if parsed.Path != test.path {
+				t.Errorf("got path %q, want %q", parsed.Path, test.path)
+			}
+			if parsed.RawQuery != test.rawQuery {
+				t.Errorf("got query %q, want %q", parsed.RawQuery, test.rawQuery)
+			}
+			if got := RequestURLString(parsed); got != test.str {
+				t.Errorf("got %q written back, want %q", got, test.str)
+			}
+		})
+	}
+}
+
+func TestParseRequestURLErrors(t *testing.T) {
+	t.Parallel()
+
+	for _, rawURL := range []string{
+		"http://[::1/",
+		"http://example.com:port/",
+		"http://user@{{ .Values.host }}/%zz",
+		"http://{{ .Values.host }}/\x7f",
+	} {
+		if _, err := ParseRequestURL(rawURL); err == nil {
+			t.Errorf("%q: got no error", rawURL)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package types
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ResolveOverride pins the addresses that Host resolves to when it is reached
+// on Port, like curl's --resolve.
+type ResolveOverride struct {
+	Host string
+	Port string
+	IPs  []net.IP
+}
+
+// String formats the override the way ParseResolveOverride reads it, with
+// IPv6 addresses in brackets.
+func (override ResolveOverride) String() string {
+	ips := make([]string, len(override.IPs))
+	for i, ip := range override.IPs {
+		if ip.To4() == nil {
+			ips[i] = "[" + ip.String() + "]"
+		} else {
+			ips[i] = ip.String()
+		}
+	}
+	return override.Host + ":" + override.Port + ":" + strings.Join(ips, ",")
+}
+
+type ResolveOverrides []ResolveOverride
+
+func (overrides *ResolveOverrides) Append(override ...ResolveOverride) {
+	*overrides = append(*overrides, override...)
+}
+
+// Parse parses a raw resolve override and appends it to the list.
+// It can return the following errors:
+//   - ResolveParseError
+func (overrides *ResolveOverrides) Parse(rawValue string) error {
+	override, err := ParseResolveOverride(rawValue)
+	if err != nil {
+		return err
+	}
+
+	overrides.Append(*override)
+	return nil
+}
+
+// ParseResolveOverride parses a resolve override in the form
+// host:port:ip[,ip...]. IPv6 addresses may be written in brackets. The host is
+// matched case-insensitively, so it is lowercased.
+// It can return the following errors:
+//   - ResolveParseError
+func ParseResolveOverride(rawValue string) (*ResolveOverride, error) {
+	parts := strings.SplitN(rawValue, ":", 3)
+	if len(parts) != 3 {
+		return nil, NewResolveParseError(errors.New("expected host:port:ip[,ip...]"))
+	}
+
+	host := strings.ToLower(strings.TrimSpace(parts[0]))
+	if host == "" {
+		return nil, NewResolveParseError(errors.New("host cannot be empty"))
+	}
+
+	port := strings.TrimSpace(parts[1])
+	if portNumber, err := strconv.ParseUint(port, 10, 16); err != nil || portNumber == 0 {
+		return nil, NewResolveParseError(errors.New("invalid port: " + port))
+	}
+
+	override := &ResolveOverride{Host: host, Port: port}
+	for rawIP := range strings.SplitSeq(parts[2], ",") {
+		rawIP = strings.TrimSpace(rawIP)
+		ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(rawIP, "["), "]"))
+		if ip == nil {
+			return nil, NewResolveParseError(errors.New("invalid IP address: " + rawIP))
+		}
+		override.IPs = append(override.IPs, ip)
+	}
+	return override, nil
+}
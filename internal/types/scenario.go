@@ -0,0 +1,38 @@
+package types
+
+import "net/url"
+
+// Request holds the request fields that a scenario or a flow step can set.
+// Fields left unset fall back to the top-level config: the URL, methods and
+// bodies replace the top-level ones, while params, headers and cookies are
+// added to them.
+type Request struct {
+	URL     *url.URL
+	Methods []string
+	Params  Params
+	Headers Headers
+	Cookies Cookies
+	Bodies  []string
+}
+
+// Scenario is one kind of request in a weighted mix.
+type Scenario struct {
+	Name string
+	// Weight is the scenario's share of the requests relative to the other
+	// scenarios' weights.
+	Weight *uint
+	Request
+}
+
+type Scenarios []Scenario
+
+// HaveURLs reports whether there are scenarios and every one of them sets its
+// own URL, in which case no top-level URL is needed.
+func (scenarios Scenarios) HaveURLs() bool {
+	for _, scenario := range scenarios {
+		if scenario.URL == nil {
+			return false
+		}
+	}
+	return len(scenarios) > 0
+}
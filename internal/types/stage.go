@@ -0,0 +1,20 @@
+package types
+
+import "time"
+
+// Stage is one step of a staged load profile. Exactly one of Rate or
+// Concurrency is set; the target ramps linearly from the previous stage's
+// target (zero for the first stage) to this one over Duration.
+type Stage struct {
+	Duration    time.Duration
+	Rate        *uint
+	Concurrency *uint
+}
+
+type Stages []Stage
+
+// ByRate reports whether the stages target a request rate rather than a
+// concurrency. Validation guarantees all stages use the same kind of target.
+func (stages Stages) ByRate() bool {
+	return len(stages) > 0 && stages[0].Rate != nil
+}
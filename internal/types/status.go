@@ -0,0 +1,31 @@
+package types
+
+import "strconv"
+
+// StatusPattern matches a status code (e.g. "503") or a status class
+// (e.g. "5xx").
+type StatusPattern string
+
+// IsValid reports whether the pattern is a status code or a status class.
+func (pattern StatusPattern) IsValid() bool {
+	if len(pattern) != 3 || pattern[0] < '1' || pattern[0] > '5' {
+		return false
+	}
+	if pattern[1:] == "xx" {
+		return true
+	}
+	for _, digit := range pattern[1:] {
+		if digit < '0' || digit > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Matches reports whether statusCode is the pattern's code or in its class.
+func (pattern StatusPattern) Matches(statusCode int) bool {
+	if pattern[1:] == "xx" {
+		return strconv.Itoa(statusCode/100) == string(pattern[:1])
+	}
+	return strconv.Itoa(statusCode) == string(pattern)
+}
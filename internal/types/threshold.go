@@ -0,0 +1,209 @@
+package types
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ThresholdMetric string
+
+const (
+	ThresholdMetricCount      ThresholdMetric = "count"
+	ThresholdMetricRPS        ThresholdMetric = "rps"
+	ThresholdMetricRate       ThresholdMetric = "rate"
+	ThresholdMetricErrorRate  ThresholdMetric = "error_rate"
+	ThresholdMetricMin        ThresholdMetric = "min"
+	ThresholdMetricMax        ThresholdMetric = "max"
+	ThresholdMetricAverage    ThresholdMetric = "avg"
+	ThresholdMetricPercentile ThresholdMetric = "p"
+)
+
+type ThresholdOperator string
+
+const (
+	ThresholdOperatorLess         ThresholdOperator = "<"
+	ThresholdOperatorLessEqual    ThresholdOperator = "<="
+	ThresholdOperatorGreater      ThresholdOperator = ">"
+	ThresholdOperatorGreaterEqual ThresholdOperator = ">="
+	ThresholdOperatorEqual        ThresholdOperator = "=="
+)
+
+// thresholdOperators is ordered so that no operator is matched before a longer
+// one that starts with it.
+var thresholdOperators = []ThresholdOperator{
+	ThresholdOperatorLessEqual,
+	ThresholdOperatorGreaterEqual,
+	ThresholdOperatorEqual,
+	ThresholdOperatorLess,
+	ThresholdOperatorGreater,
+}
+
+// Threshold is a pass/fail condition on the final stats, written as
+// "[scope:]metric operator value", e.g. "p95 < 300ms" or "5xx:rate < 1%".
+type Threshold struct {
+	// Scope limits the threshold to the responses with a status code ("503")
+	// or in a status class ("5xx"). Empty means every request.
+	Scope  string
+	Metric ThresholdMetric
+	// Percentile is set for ThresholdMetricPercentile.
+	Percentile float64
+	Operator   ThresholdOperator
+	// Value is in nanoseconds for latency metrics and a fraction for rates.
+	Value float64
+}
+
+// IsLatency reports whether the metric is a response time.
+func (threshold Threshold) IsLatency() bool {
+	switch threshold.Metric {
+	case ThresholdMetricMin, ThresholdMetricMax, ThresholdMetricAverage, ThresholdMetricPercentile:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsRate reports whether the metric is a share of all requests.
+func (threshold Threshold) IsRate() bool {
+	return threshold.Metric == ThresholdMetricRate || threshold.Metric == ThresholdMetricErrorRate
+}
+
+// InScope reports whether responses with the given status code count towards
+// the threshold.
+func (threshold Threshold) InScope(statusCode int) bool {
+	return threshold.Scope == "" || StatusPattern(threshold.Scope).Matches(statusCode)
+}
+
+// Check reports whether actual satisfies the threshold.
+func (threshold Threshold) Check(actual float64) bool {
+	switch threshold.Operator {
+	case ThresholdOperatorLess:
+		return actual < threshold.Value
+	case ThresholdOperatorLessEqual:
+		return actual <= threshold.Value
+	case ThresholdOperatorGreater:
+		return actual > threshold.Value
+	case ThresholdOperatorGreaterEqual:
+		return actual >= threshold.Value
+	default:
+		return actual == threshold.Value
+	}
+}
+
+// FormatValue formats a value of the threshold's metric: a duration for
+// latencies, a percentage for rates and a number otherwise.
+func (threshold Threshold) FormatValue(value float64) string {
+	switch {
+	case threshold.IsLatency():
+		return time.Duration(value).String()
+	case threshold.IsRate():
+		return strconv.FormatFloat(value*100, 'f', -1, 64) + "%"
+	default:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+}
+
+func (threshold Threshold) String() string {
+	metric := string(threshold.Metric)
+	if threshold.Metric == ThresholdMetricPercentile {
+		metric += strconv.FormatFloat(threshold.Percentile, 'f', -1, 64)
+	}
+	if threshold.Scope != "" {
+		metric = threshold.Scope + ":" + metric
+	}
+	return metric + " " + string(threshold.Operator) + " " + threshold.FormatValue(threshold.Value)
+}
+
+type Thresholds []Threshold
+
+func (thresholds Thresholds) Strings() []string {
+	values := make([]string, len(thresholds))
+	for i, threshold := range thresholds {
+		values[i] = threshold.String()
+	}
+	return values
+}
+
+// Parse parses a threshold expression (e.g. "p95 < 300ms") and appends it to
+// the list.
+// It can return the following errors:
+//   - ThresholdParseError
+func (thresholds *Thresholds) Parse(rawValue string) error {
+	threshold, err := ParseThreshold(rawValue)
+	if err != nil {
+		return err
+	}
+	*thresholds = append(*thresholds, *threshold)
+	return nil
+}
+
+// ParseThreshold parses a threshold expression of the form
+// "[scope:]metric operator value".
+// It can return the following errors:
+//   - ThresholdParseError
+func ParseThreshold(rawValue string) (*Threshold, error) {
+	threshold := &Threshold{}
+
+	var left, right string
+	for _, operator := range thresholdOperators {
+		if before, after, found := strings.Cut(rawValue, string(operator)); found {
+			threshold.Operator = operator
+			left, right = strings.TrimSpace(before), strings.TrimSpace(after)
+			break
+		}
+	}
+	if threshold.Operator == "" {
+		return nil, NewThresholdParseError(rawValue, ErrThresholdOperatorMissing)
+	}
+
+	if scope, metric, found := strings.Cut(left, ":"); found {
+		threshold.Scope = strings.ToLower(strings.TrimSpace(scope))
+		left = strings.TrimSpace(metric)
+		if !StatusPattern(threshold.Scope).IsValid() {
+			return nil, NewThresholdParseError(rawValue, ErrThresholdScopeInvalid)
+		}
+	}
+
+	switch metric := strings.ToLower(left); metric {
+	case "count", "rps", "rate", "error_rate", "min", "max", "avg":
+		threshold.Metric = ThresholdMetric(metric)
+	case "average":
+		threshold.Metric = ThresholdMetricAverage
+	default:
+		percentile, err := strconv.ParseFloat(strings.TrimPrefix(metric, "p"), 64)
+		if !strings.HasPrefix(metric, "p") || err != nil {
+			return nil, NewThresholdParseError(rawValue, ErrThresholdMetricUnknown)
+		}
+		if percentile <= 0 || percentile > 100 {
+			return nil, NewThresholdParseError(rawValue, ErrThresholdPercentileRange)
+		}
+		threshold.Metric = ThresholdMetricPercentile
+		threshold.Percentile = percentile
+	}
+
+	if threshold.Metric == ThresholdMetricErrorRate && threshold.Scope != "" {
+		return nil, NewThresholdParseError(rawValue, ErrThresholdErrorRateScoped)
+	}
+
+	var err error
+	switch {
+	case threshold.IsLatency():
+		var duration time.Duration
+		duration, err = time.ParseDuration(right)
+		threshold.Value = float64(duration)
+	case threshold.IsRate():
+		if percent, ok := strings.CutSuffix(right, "%"); ok {
+			threshold.Value, err = strconv.ParseFloat(strings.TrimSpace(percent), 64)
+			threshold.Value /= 100
+		} else {
+			threshold.Value, err = strconv.ParseFloat(right, 64)
+		}
+	default:
+		threshold.Value, err = strconv.ParseFloat(right, 64)
+	}
+	if err != nil {
+		return nil, NewThresholdParseError(rawValue, err)
+	}
+
+	return threshold, nil
+}
@@ -0,0 +1,184 @@
+package types
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseThreshold(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		rawValue string
+		want     Threshold
+		// str is how the threshold is written back.
+		str string
+	}{
+		{
+			"p95 < 300ms",
+			Threshold{Metric: ThresholdMetricPercentile, Percentile: 95, Operator: ThresholdOperatorLess, Value: float64(300 * time.Millisecond)},
+			"p95 < 300ms",
+		},
+		{
+			"p95<=300ms",
+			Threshold{Metric: ThresholdMetricPercentile, Percentile: 95, Operator: ThresholdOperatorLessEqual, Value: float64(300 * time.Millisecond)},
+			"p95 <= 300ms",
+		},
+		{
+			"P99.9 >= 1.5s",
+			Threshold{Metric: ThresholdMetricPercentile, Percentile: 99.9, Operator: ThresholdOperatorGreaterEqual, Value: float64(1500 * time.Millisecond)},
+			"p99.9 >= 1.5s",
+		},
+		{
+			"p100 > 0s",
+			Threshold{Metric: ThresholdMetricPercentile, Percentile: 100, Operator: ThresholdOperatorGreater},
+			"p100 > 0s",
+		},
+		{
+			"avg == 1m",
+			Threshold{Metric: ThresholdMetricAverage, Operator: ThresholdOperatorEqual, Value: float64(time.Minute)},
+			"avg == 1m0s",
+		},
+		{
+			"average < 20ms",
+			Threshold{Metric: ThresholdMetricAverage, Operator: ThresholdOperatorLess, Value: float64(20 * time.Millisecond)},
+			"avg < 20ms",
+		},
+		{
+			"min > 1µs",
+			Threshold{Metric: ThresholdMetricMin, Operator: ThresholdOperatorGreater, Value: float64(time.Microsecond)},
+			"min > 1µs",
+		},
+		{
+			"max <= 2s",
+			Threshold{Metric: ThresholdMetricMax, Operator: ThresholdOperatorLessEqual, Value: float64(2 * time.Second)},
+			"max <= 2s",
+		},
+		{
+			"count >= 1000",
+			Threshold{Metric: ThresholdMetricCount, Operator: ThresholdOperatorGreaterEqual, Value: 1000},
+			"count >= 1000",
+		},
+		{
+			"rps > 99.5",
+			Threshold{Metric: ThresholdMetricRPS, Operator: ThresholdOperatorGreater, Value: 99.5},
+			"rps > 99.5",
+		},
+		{
+			"error_rate < 1%",
+			Threshold{Metric: ThresholdMetricErrorRate, Operator: ThresholdOperatorLess, Value: 0.01},
+			"error_rate < 1%",
+		},
+		{
+			"error_rate <= 0.05",
+			Threshold{Metric: ThresholdMetricErrorRate, Operator: ThresholdOperatorLessEqual, Value: 0.05},
+			"error_rate <= 5%",
+		},
+		{
+			"5XX:rate < 2 %",
+			Threshold{Scope: "5xx", Metric: ThresholdMetricRate, Operator: ThresholdOperatorLess, Value: 0.02},
+			"5xx:rate < 2%",
+		},
+		{
+			" 503 : p50 > 10ms ",
+			Threshold{Scope: "503", Metric: ThresholdMetricPercentile, Percentile: 50, Operator: ThresholdOperatorGreater, Value: float64(10 * time.Millisecond)},
+			"503:p50 > 10ms",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.rawValue, func(t *testing.T) {
+			t.Parallel()
+
+			threshold, err := ParseThreshold(test.rawValue)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *threshold != test.want {
+				t.Errorf("got %+v, want %+v", *threshold, test.want)
+			}
+			if got := threshold.String(); got != test.str {
+				t.Errorf("got %q written back, want %q", got, test.str)
+			}
+		})
+	}
+}
+
+func TestParseThresholdErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		rawValue string
+		// err is the error that ThresholdParseError wraps, or nil for a
+		// value that fails to parse.
+		err error
+	}{
+		{"p95 300ms", ErrThresholdOperatorMissing},
+		{"p95 =< 300ms", ErrThresholdMetricUnknown},
+		{"latency < 1s", ErrThresholdMetricUnknown},
+		{"px < 1s", ErrThresholdMetricUnknown},
+		{"95 < 1s", ErrThresholdMetricUnknown},
+		{"p0 < 1s", ErrThresholdPercentileRange},
+		{"p100.1 < 1s", ErrThresholdPercentileRange},
+		{"p-5 < 1s", ErrThresholdPercentileRange},
+		{"600:rate < 1%", ErrThresholdScopeInvalid},
+		{"5x:rate < 1%", ErrThresholdScopeInvalid},
+		{"5xx:error_rate < 1%", ErrThresholdErrorRateScoped},
+		{"p95 < 300", nil},
+		{"p95 < fast", nil},
+		{"count > many", nil},
+		{"rate < %", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.rawValue, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := ParseThreshold(test.rawValue)
+			var parseErr ThresholdParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("got error %v, want a ThresholdParseError", err)
+			}
+			if parseErr.Value != test.rawValue {
+				t.Errorf("got value %q in the error, want %q", parseErr.Value, test.rawValue)
+			}
+			if test.err != nil && !errors.Is(err, test.err) {
+				t.Errorf("got error %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestThresholdCheck(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		operator ThresholdOperator
+		actual   float64
+		passed   bool
+	}{
+		{ThresholdOperatorLess, 299, true},
+		{ThresholdOperatorLess, 300, false},
+		{ThresholdOperatorLess, 301, false},
+		{ThresholdOperatorLessEqual, 299, true},
+		{ThresholdOperatorLessEqual, 300, true},
+		{ThresholdOperatorLessEqual, 301, false},
+		{ThresholdOperatorGreater, 299, false},
+		{ThresholdOperatorGreater, 300, false},
+		{ThresholdOperatorGreater, 301, true},
+		{ThresholdOperatorGreaterEqual, 299, false},
+		{ThresholdOperatorGreaterEqual, 300, true},
+		{ThresholdOperatorGreaterEqual, 301, true},
+		{ThresholdOperatorEqual, 299, false},
+		{ThresholdOperatorEqual, 300, true},
+		{ThresholdOperatorEqual, 301, false},
+	}
+
+	for _, test := range tests {
+		threshold := Threshold{Metric: ThresholdMetricCount, Operator: test.operator, Value: 300}
+		if got := threshold.Check(test.actual); got != test.passed {
+			t.Errorf("%v with %v: got %v, want %v", threshold, test.actual, got, test.passed)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+package types
+
+// TLSClientCert is a client certificate and its private key, each PEM-encoded
+// in a local file or at an HTTP/HTTPS URL.
+type TLSClientCert struct {
+	Cert string
+	Key  string
+}